@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/hail2skins/the-virtual-armory/internal/config"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/reconcile"
 	_ "github.com/joho/godotenv/autoload"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,6 +20,7 @@ func main() {
 	// Define command flags
 	createAdminCmd := flag.NewFlagSet("create-admin", flag.ExitOnError)
 	deleteUserCmd := flag.NewFlagSet("delete-user", flag.ExitOnError)
+	reconcileBillingCmd := flag.NewFlagSet("reconcile-billing", flag.ExitOnError)
 
 	// create-admin flags
 	createAdminEmail := createAdminCmd.String("email", "", "Email for the admin user")
@@ -24,9 +29,13 @@ func main() {
 	// delete-user flags
 	deleteUserEmail := deleteUserCmd.String("email", "", "Email of the user to delete")
 
+	// reconcile-billing flags
+	reconcileBillingEmail := reconcileBillingCmd.String("email", "", "Only reconcile the user with this email")
+	reconcileBillingFix := reconcileBillingCmd.Bool("fix", false, "Correct local records to match the payment provider")
+
 	// Check if a subcommand is provided
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'create-admin' or 'delete-user' subcommands")
+		fmt.Println("Expected 'create-admin', 'delete-user' or 'reconcile-billing' subcommands")
 		os.Exit(1)
 	}
 
@@ -46,9 +55,12 @@ func main() {
 			os.Exit(1)
 		}
 		deleteUser(*deleteUserEmail)
+	case "reconcile-billing":
+		reconcileBillingCmd.Parse(os.Args[2:])
+		reconcileBilling(*reconcileBillingEmail, *reconcileBillingFix)
 	default:
 		fmt.Printf("Unknown subcommand: %s\n", os.Args[1])
-		fmt.Println("Expected 'create-admin' or 'delete-user' subcommands")
+		fmt.Println("Expected 'create-admin', 'delete-user' or 'reconcile-billing' subcommands")
 		os.Exit(1)
 	}
 }
@@ -137,3 +149,38 @@ func deleteUser(email string) {
 
 	log.Printf("User %s has been deleted", email)
 }
+
+// reconcileBilling compares users' subscriptions and payments with the payment provider,
+// printing any drift and correcting it when fix is set
+func reconcileBilling(email string, fix bool) {
+	// Initialize database
+	db, err := database.InitGORM()
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.CloseDB()
+
+	provider, err := billing.New(config.New())
+	if err != nil {
+		log.Fatalf("Failed to set up payment provider: %v", err)
+	}
+
+	report, err := reconcile.NewReconciler(db, provider).Run(email, fix, time.Now())
+	if report != nil {
+		for _, drift := range report.Drifts {
+			status := "found"
+			if drift.Fixed {
+				status = "fixed"
+			}
+			fmt.Printf("[%s] %s (user %d) %s: %s\n", status, drift.Email, drift.UserID, drift.Kind, drift.Detail)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to reconcile billing: %v", err)
+	}
+
+	log.Printf("Checked %d customers: %d drifts found, %d fixed", report.Checked, len(report.Drifts), report.Fixed())
+	if !fix && len(report.Drifts) > 0 {
+		log.Println("Run again with -fix to correct local records")
+	}
+}
@@ -0,0 +1,95 @@
+// Passkey registration and login. The server sends WebAuthn options as JSON with binary values
+// base64url-encoded, and expects the browser's answer back in the same form.
+(function() {
+	function toBuffer(value) {
+		var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+		while (base64.length % 4) {
+			base64 += '=';
+		}
+		var binary = atob(base64);
+		var bytes = new Uint8Array(binary.length);
+		for (var i = 0; i < binary.length; i++) {
+			bytes[i] = binary.charCodeAt(i);
+		}
+		return bytes.buffer;
+	}
+
+	function toBase64URL(buffer) {
+		var bytes = new Uint8Array(buffer);
+		var binary = '';
+		for (var i = 0; i < bytes.length; i++) {
+			binary += String.fromCharCode(bytes[i]);
+		}
+		return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+	}
+
+	function post(url, body) {
+		return fetch(url, {
+			method: 'POST',
+			credentials: 'same-origin',
+			headers: {'Content-Type': 'application/json'},
+			body: body ? JSON.stringify(body) : null
+		}).then(function(response) {
+			return response.json().then(function(data) {
+				if (!response.ok) {
+					throw new Error(data.error || 'Something went wrong. Please try again.');
+				}
+				return data;
+			});
+		});
+	}
+
+	function supported() {
+		return !!(window.PublicKeyCredential && navigator.credentials);
+	}
+
+	// register creates a passkey on this device and saves it under name
+	function register(name) {
+		return post('/profile/passkeys/register/begin').then(function(options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = toBuffer(publicKey.challenge);
+			publicKey.user.id = toBuffer(publicKey.user.id);
+			(publicKey.excludeCredentials || []).forEach(function(credential) {
+				credential.id = toBuffer(credential.id);
+			});
+			return navigator.credentials.create({publicKey: publicKey});
+		}).then(function(credential) {
+			return post('/profile/passkeys/register/finish?name=' + encodeURIComponent(name), {
+				id: credential.id,
+				rawId: toBase64URL(credential.rawId),
+				type: credential.type,
+				response: {
+					clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+					attestationObject: toBase64URL(credential.response.attestationObject),
+					transports: credential.response.getTransports ? credential.response.getTransports() : []
+				}
+			});
+		});
+	}
+
+	// login asks for any of the site's passkeys and signs in as its owner
+	function login() {
+		return post('/login/passkey/begin').then(function(options) {
+			var publicKey = options.publicKey;
+			publicKey.challenge = toBuffer(publicKey.challenge);
+			(publicKey.allowCredentials || []).forEach(function(credential) {
+				credential.id = toBuffer(credential.id);
+			});
+			return navigator.credentials.get({publicKey: publicKey});
+		}).then(function(credential) {
+			return post('/login/passkey/finish', {
+				id: credential.id,
+				rawId: toBase64URL(credential.rawId),
+				type: credential.type,
+				response: {
+					clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+					authenticatorData: toBase64URL(credential.response.authenticatorData),
+					signature: toBase64URL(credential.response.signature),
+					userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : null
+				}
+			});
+		});
+	}
+
+	window.Passkeys = {supported: supported, register: register, login: login};
+})();
@@ -1,31 +1,45 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/config"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/volatiletech/authboss/v3"
 	"github.com/volatiletech/authboss/v3/defaults"
+	"gorm.io/gorm"
 )
 
 // Auth is a wrapper around Authboss
 type Auth struct {
 	*authboss.Authboss
+	Sessions SessionStore // Where login sessions are kept
+	storer   *SessionStorer
 }
 
 // New creates a new Auth instance
 func New() (*Auth, error) {
 	ab := authboss.New()
+	cfg := config.New()
+
+	// Set up the session store
+	sessions, err := NewSessionStore(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// Set up the storer
 	ab.Config.Storage.Server = NewGORMStorer()
-	ab.Config.Storage.SessionState = NewSessionStorer()
+	storer := NewSessionStorer(sessions, cfg.SessionIdleTimeout)
+	ab.Config.Storage.SessionState = storer
 	ab.Config.Storage.CookieState = NewCookieStorer()
 
 	// Set up the renderers
@@ -65,7 +79,17 @@ func New() (*Auth, error) {
 	log.Println("Authboss initialized with core modules")
 	log.Printf("Mount path: %s", ab.Config.Paths.Mount)
 
-	return &Auth{ab}, nil
+	return &Auth{Authboss: ab, Sessions: sessions, storer: storer}, nil
+}
+
+// NewWithSessionStore creates an Auth that keeps login sessions in store, without the Authboss modules New sets up.
+// It's for handlers and tests that only sign users in and out.
+func NewWithSessionStore(store SessionStore, idleTimeout time.Duration) *Auth {
+	return &Auth{
+		Authboss: authboss.New(),
+		Sessions: store,
+		storer:   NewSessionStorer(store, idleTimeout),
+	}
 }
 
 // LoadAndSave is a middleware that loads and saves the session
@@ -108,25 +132,29 @@ func (a *Auth) Middleware() gin.HandlerFunc {
 	}
 }
 
+// LoadUser is a middleware that finds the user signed in to the browser's session,
+// so pages that don't require a login still know who is looking at them
+func (a *Auth) LoadUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := a.sessionUser(c); err != nil && !errors.Is(err, ErrSessionNotFound) {
+			log.Printf("Failed to load signed in user: %v", err)
+		}
+		c.Next()
+	}
+}
+
 // RequireAuth is a middleware that requires authentication
 func (a *Auth) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Check for the is_logged_in cookie first (our simplified auth)
-		cookie, err := c.Cookie("is_logged_in")
-		if err == nil && cookie == "true" {
-			// User is logged in via cookie
-			c.Next()
-			return
+		user, err := a.sessionUser(c)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			log.Printf("Failed to load signed in user: %v", err)
 		}
-
-		// Fall back to Authboss authentication
-		user, err := a.CurrentUser(c.Request)
-		if err != nil || user == nil {
-			// Set flash message for guest users
-			// Check if the request is for an admin route
-			path := c.Request.URL.Path
-			if strings.HasPrefix(path, "/admin") {
-				c.SetCookie("flash_message", "You do not have permission to access that page", 5, "/", "", false, true)
+		if user == nil {
+			// A session signed out from another device, or expired, no longer counts
+			if cookie, err := c.Cookie(sessionCookieName); err == nil && cookie != "" {
+				ClearLoginCookies(c)
+				c.SetCookie("flash_message", "You have been signed out. Please log in again.", 5, "/", "", false, true)
 			} else {
 				c.SetCookie("flash_message", "You do not have permission to access that page", 5, "/", "", false, true)
 			}
@@ -145,8 +173,8 @@ func (a *Auth) RequireAuth() gin.HandlerFunc {
 func (a *Auth) RequireAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// First check if the user is logged in
-		userEmail, err := c.Cookie("user_email")
-		if err != nil || userEmail == "" {
+		user, err := a.sessionUser(c)
+		if errors.Is(err, ErrSessionNotFound) {
 			// User is not logged in, redirect to login
 			c.SetCookie("flash_message", "You do not have permission to access this page", 5, "/", "", false, true)
 			c.SetCookie("flash_type", "error", 5, "/", "", false, true)
@@ -154,14 +182,9 @@ func (a *Auth) RequireAdmin() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-
-		// User is logged in, check if they are an admin by querying the database
-		db := database.GetDB()
-		var user models.User
-		result := db.Where("email = ?", userEmail).First(&user)
-
-		if result.Error != nil {
+		if err != nil {
 			// Error finding user, redirect to login
+			log.Printf("Failed to load signed in user: %v", err)
 			c.SetCookie("flash_message", "Authentication error. Please log in again.", 5, "/", "", false, true)
 			c.SetCookie("flash_type", "error", 5, "/", "", false, true)
 			c.Redirect(http.StatusFound, "/login")
@@ -179,7 +202,122 @@ func (a *Auth) RequireAdmin() gin.HandlerFunc {
 			return
 		}
 
+		// Admins who must use two-factor authentication set it up before using the admin pages
+		if !user.TOTPEnabled && models.AdminTwoFactorRequired(database.GetDB()) {
+			c.SetCookie("flash_message", "Set up two-factor authentication to use the admin pages", 5, "/", "", false, true)
+			c.SetCookie("flash_type", "error", 5, "/", "", false, true)
+			c.Redirect(http.StatusFound, "/profile/two-factor")
+			c.Abort()
+			return
+		}
+
 		// User is an admin, proceed
 		c.Next()
 	}
 }
+
+// sessionUser returns the user signed in to the browser's session and keeps them for GetCurrentUser.
+// It returns ErrSessionNotFound if the browser has no session, or its session is unknown, expired or revoked.
+func (a *Auth) sessionUser(c *gin.Context) (*models.User, error) {
+	if user, err := GetCurrentUser(c); err == nil {
+		return user, nil
+	}
+
+	session, err := a.CurrentSession(c)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID == 0 {
+		return nil, ErrSessionNotFound
+	}
+
+	var user models.User
+	err = database.GetDB().First(&user, session.UserID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The account has been deleted since the user signed in
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	SetCurrentUser(c, &user)
+	return &user, nil
+}
+
+// StartSession signs the browser in to a new session for the user, which they can see and revoke from their profile.
+// The session is the only thing that identifies the user, so without a session store it returns ErrSessionsUnavailable.
+func (a *Auth) StartSession(c *gin.Context, user *models.User) error {
+	if a == nil || a.storer == nil {
+		return ErrSessionsUnavailable
+	}
+	_, err := a.storer.Start(c.Writer, c.Request, user.ID, c.ClientIP())
+	return err
+}
+
+// SessionCookie starts a new session for the user and returns the cookie that signs a browser in to it.
+// It's for signing users in without a login form, such as in tests.
+func (a *Auth) SessionCookie(user *models.User) (*http.Cookie, error) {
+	if a == nil || a.storer == nil {
+		return nil, ErrSessionsUnavailable
+	}
+	id, _, err := a.storer.create(user.ID, "", "")
+	if err != nil {
+		return nil, err
+	}
+	return &http.Cookie{Name: sessionCookieName, Value: id, Path: "/", HttpOnly: true}, nil
+}
+
+// CurrentSession returns the browser's session, or ErrSessionNotFound
+func (a *Auth) CurrentSession(c *gin.Context) (*models.LoginSession, error) {
+	if a == nil || a.storer == nil {
+		return nil, ErrSessionNotFound
+	}
+	return a.storer.Current(c.Request)
+}
+
+// EndSession signs the browser out of its session
+func (a *Auth) EndSession(c *gin.Context) error {
+	if a == nil || a.storer == nil {
+		return nil
+	}
+	return a.storer.End(c.Writer, c.Request)
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func (a *Auth) ListSessions(userID uint) ([]models.LoginSession, error) {
+	if a == nil || a.Sessions == nil {
+		return nil, nil
+	}
+	return a.Sessions.ListByUser(userID, time.Now())
+}
+
+// RevokeSession signs the user out of one of their sessions, or returns ErrSessionNotFound
+func (a *Auth) RevokeSession(userID, sessionID uint) error {
+	if a == nil || a.Sessions == nil {
+		return ErrSessionNotFound
+	}
+	return a.Sessions.DeleteByUser(userID, sessionID)
+}
+
+// RevokeSessions signs the user out of all of their sessions, keeping the browser's own session if keepCurrent is set
+func (a *Auth) RevokeSessions(c *gin.Context, userID uint, keepCurrent bool) (int64, error) {
+	if a == nil || a.Sessions == nil {
+		return 0, nil
+	}
+	except := ""
+	if keepCurrent {
+		if current, err := a.CurrentSession(c); err == nil {
+			except = current.Token
+		}
+	}
+	return a.Sessions.DeleteAllByUser(userID, except)
+}
+
+// ClearLoginCookies removes the browser's session cookie, along with the cookies older logins left behind
+func ClearLoginCookies(c *gin.Context) {
+	c.SetCookie("is_logged_in", "", -1, "/", "", false, true)
+	c.SetCookie("user_email", "", -1, "/", "", false, true)
+	c.SetCookie("is_admin", "", -1, "/", "", false, true)
+	c.SetCookie(sessionCookieName, "", -1, "/", "", false, true)
+}
@@ -5,4 +5,6 @@ import "errors"
 // Common errors
 var (
 	ErrUserNotFound = errors.New("user not found")
+	ErrTokenInvalid = errors.New("invalid or already used token")
+	ErrTokenExpired = errors.New("token expired")
 )
@@ -4,43 +4,33 @@ import (
 	"errors"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hail2skins/the-virtual-armory/internal/database"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
 )
 
+// currentUserKey is where the signed in user is kept in the request context
+const currentUserKey = "current_user"
+
 // MockUser is used for testing
 var MockUser *models.User
 
-// GetCurrentUser retrieves the current user from the context
+// GetCurrentUser retrieves the current user from the context.
+// The user is found from the browser's session by the LoadUser, RequireAuth or RequireAdmin middleware.
 func GetCurrentUser(ctx *gin.Context) (*models.User, error) {
 	// If we're in a test environment and MockUser is set, return it
 	if MockUser != nil {
 		return MockUser, nil
 	}
 
-	// Check for the is_logged_in cookie first (our simplified auth)
-	cookie, err := ctx.Cookie("is_logged_in")
-	if err == nil && cookie == "true" {
-		// Get the database connection
-		db := database.GetDB()
-
-		// Get the email from the session
-		email, _ := ctx.Cookie("user_email")
-		if email == "" {
-			// For backward compatibility, use a default email if not set
-			email = "admin@example.com"
-		}
-
-		// Retrieve the user from the database
-		var user models.User
-		result := db.Where("email = ?", email).First(&user)
-		if result.Error != nil {
-			return nil, result.Error
+	if value, ok := ctx.Get(currentUserKey); ok {
+		if user, ok := value.(*models.User); ok && user != nil {
+			return user, nil
 		}
-
-		return &user, nil
 	}
 
-	// If we're using the simplified auth and not logged in, return an error
 	return nil, errors.New("user not authenticated")
 }
+
+// SetCurrentUser makes user the signed in user for the rest of the request
+func SetCurrentUser(ctx *gin.Context, user *models.User) {
+	ctx.Set(currentUserKey, user)
+}
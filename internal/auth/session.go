@@ -2,89 +2,242 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/volatiletech/authboss/v3"
 )
 
-// SessionData holds the session data
-type SessionData struct {
-	SessionID string
-	UserID    string
-}
+// sessionCookieName is the cookie holding the session ID
+const sessionCookieName = "session"
+
+// sessionRenewInterval limits how often a session's expiry is pushed back, so every request doesn't write to the store
+const sessionRenewInterval = time.Minute
 
-// SessionStorer is a session storer for Authboss
+// SessionStorer is a session storer for Authboss that keeps session values in a SessionStore.
+// Sessions expire after IdleTimeout without use, and each use pushes the expiry back.
 type SessionStorer struct {
-	sessions map[string]SessionData
+	Store       SessionStore
+	IdleTimeout time.Duration
+	now         func() time.Time
 }
 
 // NewSessionStorer creates a new SessionStorer
-func NewSessionStorer() *SessionStorer {
+func NewSessionStorer(store SessionStore, idleTimeout time.Duration) *SessionStorer {
 	return &SessionStorer{
-		sessions: make(map[string]SessionData),
+		Store:       store,
+		IdleTimeout: idleTimeout,
+		now:         time.Now,
 	}
 }
 
-// ReadState reads the session state
+// ReadState loads the session named by the session cookie, renewing its expiry
 func (s *SessionStorer) ReadState(r *http.Request) (authboss.ClientState, error) {
-	// In a real application, you would read the session from a store
-	// For now, we'll use a simple in-memory store
 	session := &Session{
 		data: make(map[string]string),
 	}
 
-	// Get the session ID from the cookie
-	cookie, err := r.Cookie("session")
+	id, stored, err := s.load(r)
+	if errors.Is(err, ErrSessionNotFound) {
+		return session, nil
+	}
 	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(stored.Data), &session.data); err != nil {
+		log.Printf("Discarding unreadable session: %v", err)
 		return session, nil
 	}
+	session.id = id
+	session.stored = stored
+
+	return session, nil
+}
 
-	// Get the session data
-	if sessionData, ok := s.sessions[cookie.Value]; ok {
-		session.data["session_id"] = sessionData.SessionID
-		session.data["user_id"] = sessionData.UserID
+// load finds the unexpired session named by the session cookie, renewing its expiry,
+// and returns it with the session ID, or ErrSessionNotFound
+func (s *SessionStorer) load(r *http.Request) (string, *models.LoginSession, error) {
+	// Get the session ID from the cookie
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", nil, ErrSessionNotFound
 	}
 
+	token := hashSessionID(cookie.Value)
+	stored, err := s.Store.Get(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := s.now()
+	if stored.IsExpired(now) {
+		if err := s.Store.Delete(token); err != nil {
+			log.Printf("Failed to delete expired session: %v", err)
+		}
+		return "", nil, ErrSessionNotFound
+	}
+
+	// Sliding expiry: sessions in use stay alive
+	if now.Sub(stored.LastSeenAt) >= sessionRenewInterval {
+		stored.LastSeenAt = now
+		stored.ExpiresAt = now.Add(s.IdleTimeout)
+		if err := s.Store.Save(stored); err != nil {
+			log.Printf("Failed to renew session: %v", err)
+		}
+	}
+
+	return cookie.Value, stored, nil
+}
+
+// Start signs the browser in to a new session for the user, replacing any session it already had
+func (s *SessionStorer) Start(w http.ResponseWriter, r *http.Request, userID uint, ipAddress string) (*models.LoginSession, error) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := s.Store.Delete(hashSessionID(cookie.Value)); err != nil {
+			return nil, err
+		}
+	}
+
+	id, session, err := s.create(userID, r.UserAgent(), ipAddress)
+	if err != nil {
+		return nil, err
+	}
+	setSessionCookie(w, id)
 	return session, nil
 }
 
-// WriteState writes the session state
+// create saves a new session for the user and returns it with the session ID for the cookie
+func (s *SessionStorer) create(userID uint, userAgent, ipAddress string) (string, *models.LoginSession, error) {
+	id := randomString(32)
+	now := s.now()
+	session := &models.LoginSession{
+		Token:      hashSessionID(id),
+		UserID:     userID,
+		Data:       "{}",
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.IdleTimeout),
+	}
+	if err := s.Store.Save(session); err != nil {
+		return "", nil, err
+	}
+	return id, session, nil
+}
+
+// Current returns the request's unexpired session, or ErrSessionNotFound
+func (s *SessionStorer) Current(r *http.Request) (*models.LoginSession, error) {
+	_, stored, err := s.load(r)
+	return stored, err
+}
+
+// End removes the request's session and its cookie
+func (s *SessionStorer) End(w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	clearSessionCookie(w)
+	return s.Store.Delete(hashSessionID(cookie.Value))
+}
+
+// WriteState applies the request's session changes and saves the session
 func (s *SessionStorer) WriteState(w http.ResponseWriter, state authboss.ClientState, events []authboss.ClientStateEvent) error {
-	// In a real application, you would write the session to a store
-	// For now, we'll use a simple in-memory store
 	session := state.(*Session)
 
-	// Get the session ID
-	sessionID, _ := session.Get("session_id")
-	if sessionID == "" {
-		// Create a new session ID
-		sessionID = randomString(32)
-		session.Set("session_id", sessionID)
+	signedIn := false
+	for _, event := range events {
+		switch event.Kind {
+		case authboss.ClientStateEventPut:
+			session.Set(event.Key, event.Value)
+			if event.Key == authboss.SessionKey {
+				signedIn = true
+			}
+		case authboss.ClientStateEventDel:
+			session.Delete(event.Key)
+		case authboss.ClientStateEventDelAll:
+			keep := make(map[string]bool)
+			for _, key := range strings.Split(event.Key, ",") {
+				keep[key] = true
+			}
+			for key := range session.data {
+				if !keep[key] {
+					session.Delete(key)
+				}
+			}
+		}
+	}
+
+	// A session emptied by signing out is removed rather than saved
+	if len(session.data) == 0 {
+		if session.id != "" {
+			if err := s.Store.Delete(hashSessionID(session.id)); err != nil {
+				return err
+			}
+			clearSessionCookie(w)
+		}
+		return nil
+	}
 
-		// Set the cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "session",
-			Value:    sessionID,
-			Path:     "/",
-			HttpOnly: true,
-		})
+	// Signing in gets a new session ID, so an ID planted before sign in is useless afterwards
+	if session.id != "" && signedIn {
+		if err := s.Store.Delete(hashSessionID(session.id)); err != nil {
+			return err
+		}
+		session.id = ""
+		session.stored = nil
 	}
 
-	// Store the session data
-	userID, _ := session.Get("user_id")
-	s.sessions[sessionID] = SessionData{
-		SessionID: sessionID,
-		UserID:    userID,
+	if session.id == "" {
+		session.id = randomString(32)
+		setSessionCookie(w, session.id)
 	}
 
-	return nil
+	values, err := json.Marshal(session.data)
+	if err != nil {
+		return err
+	}
+	stored := session.stored
+	if stored == nil {
+		stored = &models.LoginSession{Token: hashSessionID(session.id)}
+	}
+	now := s.now()
+	stored.Data = string(values)
+	stored.LastSeenAt = now
+	stored.ExpiresAt = now.Add(s.IdleTimeout)
+	return s.Store.Save(stored)
+}
+
+// setSessionCookie gives the browser its session ID
+func setSessionCookie(w http.ResponseWriter, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie removes the session ID from the browser
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
 }
 
 // Session is a session for Authboss
 type Session struct {
-	data map[string]string
+	id     string               // Session ID from the cookie, empty for a new session
+	stored *models.LoginSession // The stored session, nil for a new session
+	data   map[string]string
 }
 
 // Get gets a value from the session
@@ -103,11 +256,17 @@ func (s *Session) Delete(key string) {
 	delete(s.data, key)
 }
 
-// randomString generates a random string of the specified length
+// hashSessionID returns the token a session is stored under
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomString generates a random cookie-safe string from length random bytes
 func randomString(length int) string {
 	b := make([]byte, length)
 	rand.Read(b)
-	return base64.StdEncoding.EncodeToString(b)
+	return base64.RawURLEncoding.EncodeToString(b)
 }
 
 // ClientStateMiddleware is a middleware that loads and saves the client state
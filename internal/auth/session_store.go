@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrSessionNotFound is returned when a session store has no session with a token
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrSessionsUnavailable is returned when an Auth has no session store to sign users in with
+var ErrSessionsUnavailable = errors.New("login sessions are not set up")
+
+// SessionStore keeps login sessions on the server, keyed by the hash of the session ID
+type SessionStore interface {
+	// Get returns the session with the token, or ErrSessionNotFound
+	Get(token string) (*models.LoginSession, error)
+
+	// Save creates a session without an ID, or updates the values and expiry of one that still exists
+	Save(session *models.LoginSession) error
+
+	// Delete removes the session with the token, if there is one
+	Delete(token string) error
+
+	// ListByUser returns a user's unexpired sessions, most recently used first
+	ListByUser(userID uint, now time.Time) ([]models.LoginSession, error)
+
+	// DeleteByUser removes one of a user's sessions by ID, or returns ErrSessionNotFound
+	DeleteByUser(userID, id uint) error
+
+	// DeleteAllByUser removes every session of a user except the one with exceptToken, which may be empty
+	DeleteAllByUser(userID uint, exceptToken string) (int64, error)
+
+	// DeleteExpired removes sessions that expired before now and returns how many were removed
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// NewSessionStore creates the session store selected in the configuration
+func NewSessionStore(cfg *config.Config) (SessionStore, error) {
+	switch cfg.SessionBackend {
+	case "", "database":
+		return NewGORMSessionStore(nil), nil
+	case "memory":
+		return NewMemorySessionStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown session backend: %s", cfg.SessionBackend)
+	}
+}
+
+// GORMSessionStore keeps sessions in the login_sessions table, so they survive restarts
+// and are shared by every instance
+type GORMSessionStore struct {
+	DB *gorm.DB // Optional, the application database is used when nil
+}
+
+// Ensure GORMSessionStore implements SessionStore
+var _ SessionStore = (*GORMSessionStore)(nil)
+
+// NewGORMSessionStore creates a new GORMSessionStore
+func NewGORMSessionStore(db *gorm.DB) *GORMSessionStore {
+	return &GORMSessionStore{
+		DB: db,
+	}
+}
+
+// db returns the database to use, connecting lazily so the store can be created before the database
+func (s *GORMSessionStore) db() *gorm.DB {
+	if s.DB != nil {
+		return s.DB
+	}
+	return database.GetDB()
+}
+
+// Get loads a session from the database
+func (s *GORMSessionStore) Get(token string) (*models.LoginSession, error) {
+	session, err := models.FindLoginSessionByToken(s.db(), token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// Save writes a session to the database
+func (s *GORMSessionStore) Save(session *models.LoginSession) error {
+	return models.SaveLoginSession(s.db(), session)
+}
+
+// Delete removes a session from the database
+func (s *GORMSessionStore) Delete(token string) error {
+	return models.DeleteLoginSession(s.db(), token)
+}
+
+// ListByUser loads a user's sessions from the database
+func (s *GORMSessionStore) ListByUser(userID uint, now time.Time) ([]models.LoginSession, error) {
+	return models.FindLoginSessionsByUser(s.db(), userID, now)
+}
+
+// DeleteByUser removes one of a user's sessions from the database
+func (s *GORMSessionStore) DeleteByUser(userID, id uint) error {
+	deleted, err := models.DeleteUserLoginSession(s.db(), userID, id)
+	if err == nil && !deleted {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+// DeleteAllByUser removes a user's sessions from the database
+func (s *GORMSessionStore) DeleteAllByUser(userID uint, exceptToken string) (int64, error) {
+	return models.DeleteUserLoginSessions(s.db(), userID, exceptToken)
+}
+
+// DeleteExpired removes expired sessions from the database
+func (s *GORMSessionStore) DeleteExpired(now time.Time) (int64, error) {
+	return models.DeleteExpiredLoginSessions(s.db(), now)
+}
+
+// MemorySessionStore keeps sessions in memory, for tests and single-instance development
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	nextID   uint
+	sessions map[string]models.LoginSession
+}
+
+// Ensure MemorySessionStore implements SessionStore
+var _ SessionStore = (*MemorySessionStore)(nil)
+
+// NewMemorySessionStore creates a new MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]models.LoginSession),
+	}
+}
+
+// Get returns a copy of the session
+func (s *MemorySessionStore) Get(token string) (*models.LoginSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+// Save stores a copy of the session
+func (s *MemorySessionStore) Save(session *models.LoginSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if session.ID == 0 {
+		s.nextID++
+		session.ID = s.nextID
+		session.CreatedAt = now
+	} else if _, ok := s.sessions[session.Token]; !ok {
+		// Revoked while a request was using it
+		return nil
+	}
+	session.UpdatedAt = now
+	s.sessions[session.Token] = *session
+	return nil
+}
+
+// Delete forgets the session
+func (s *MemorySessionStore) Delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, token)
+	return nil
+}
+
+// ListByUser returns copies of a user's sessions
+func (s *MemorySessionStore) ListByUser(userID uint, now time.Time) ([]models.LoginSession, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []models.LoginSession
+	for _, session := range s.sessions {
+		if session.UserID == userID && !session.IsExpired(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastSeenAt.Equal(sessions[j].LastSeenAt) {
+			return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+// DeleteByUser forgets one of a user's sessions
+func (s *MemorySessionStore) DeleteByUser(userID, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, session := range s.sessions {
+		if session.ID == id && session.UserID == userID {
+			delete(s.sessions, token)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// DeleteAllByUser forgets a user's sessions
+func (s *MemorySessionStore) DeleteAllByUser(userID uint, exceptToken string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for token, session := range s.sessions {
+		if session.UserID == userID && token != exceptToken {
+			delete(s.sessions, token)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// DeleteExpired forgets sessions that have expired
+func (s *MemorySessionStore) DeleteExpired(now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for token, session := range s.sessions {
+		if session.IsExpired(now) {
+			delete(s.sessions, token)
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volatiletech/authboss/v3"
+)
+
+func TestSessionStorer(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	stores := map[string]SessionStore{
+		"memory":   NewMemorySessionStore(),
+		"database": NewGORMSessionStore(db),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			storer := NewSessionStorer(store, time.Hour)
+			storer.now = func() time.Time { return now }
+
+			// read loads the session for a cookie, which may be empty
+			read := func(cookie string) *Session {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				if cookie != "" {
+					r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+				}
+				state, err := storer.ReadState(r)
+				require.NoError(t, err)
+				return state.(*Session)
+			}
+			// write saves the session's events and returns the session cookie, if one was set
+			write := func(session *Session, events ...authboss.ClientStateEvent) *http.Cookie {
+				w := httptest.NewRecorder()
+				require.NoError(t, storer.WriteState(w, session, events))
+				for _, cookie := range w.Result().Cookies() {
+					if cookie.Name == sessionCookieName {
+						return cookie
+					}
+				}
+				return nil
+			}
+
+			// Signing in creates a session that's stored by its hash
+			cookie := write(read(""), authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "user@example.com"})
+			require.NotNil(t, cookie)
+			_, err := store.Get(cookie.Value)
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+			stored, err := store.Get(hashSessionID(cookie.Value))
+			require.NoError(t, err)
+			assert.Equal(t, now.Add(time.Hour), stored.ExpiresAt.UTC())
+
+			session := read(cookie.Value)
+			uid, ok := session.Get(authboss.SessionKey)
+			require.True(t, ok)
+			assert.Equal(t, "user@example.com", uid)
+
+			// Using the session pushes its expiry back
+			now = now.Add(50 * time.Minute)
+			read(cookie.Value)
+			stored, err = store.Get(hashSessionID(cookie.Value))
+			require.NoError(t, err)
+			assert.Equal(t, now.Add(time.Hour), stored.ExpiresAt.UTC())
+
+			// Other values are kept without changing the session ID
+			assert.Nil(t, write(read(cookie.Value), authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: "flash", Value: "hello"}))
+			flash, _ := read(cookie.Value).Get("flash")
+			assert.Equal(t, "hello", flash)
+
+			// Signing in again gets a new session ID and the old one stops working
+			rotated := write(read(cookie.Value), authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "user@example.com"})
+			require.NotNil(t, rotated)
+			assert.NotEqual(t, cookie.Value, rotated.Value)
+			_, ok = read(cookie.Value).Get(authboss.SessionKey)
+			assert.False(t, ok)
+			flash, _ = read(rotated.Value).Get("flash")
+			assert.Equal(t, "hello", flash)
+
+			// Signing out removes the session
+			cleared := write(read(rotated.Value), authboss.ClientStateEvent{Kind: authboss.ClientStateEventDelAll})
+			require.NotNil(t, cleared)
+			assert.Negative(t, cleared.MaxAge)
+			_, err = store.Get(hashSessionID(rotated.Value))
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+
+			// An unused session expires
+			idle := write(read(""), authboss.ClientStateEvent{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: "idle@example.com"})
+			now = now.Add(time.Hour)
+			_, ok = read(idle.Value).Get(authboss.SessionKey)
+			assert.False(t, ok)
+			_, err = store.Get(hashSessionID(idle.Value))
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+		})
+	}
+}
+
+func TestSessionStoreDeleteExpired(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for name, store := range map[string]SessionStore{"memory": NewMemorySessionStore(), "database": NewGORMSessionStore(db)} {
+		t.Run(name, func(t *testing.T) {
+			// Half of the sessions have already expired
+			for i := 0; i < 10; i++ {
+				expiresAt := now.Add(time.Minute)
+				if i%2 == 0 {
+					expiresAt = now.Add(-time.Minute)
+				}
+				require.NoError(t, store.Save(&models.LoginSession{Token: fmt.Sprintf("token-%d", i), Data: "{}", LastSeenAt: now, ExpiresAt: expiresAt}))
+			}
+
+			removed, err := store.DeleteExpired(now)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), removed)
+			_, err = store.Get("token-1")
+			assert.NoError(t, err)
+			_, err = store.Get("token-0")
+			assert.ErrorIs(t, err, ErrSessionNotFound)
+		})
+	}
+}
+
+func TestMemorySessionStoreConcurrency(t *testing.T) {
+	store := NewMemorySessionStore()
+	storer := NewSessionStorer(store, time.Hour)
+
+	// Requests signing in, reading and signing out at the same time don't race
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			session := &Session{data: make(map[string]string)}
+			assert.NoError(t, storer.WriteState(w, session, []authboss.ClientStateEvent{
+				{Kind: authboss.ClientStateEventPut, Key: authboss.SessionKey, Value: fmt.Sprintf("user%d@example.com", i)},
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, cookie := range w.Result().Cookies() {
+				r.AddCookie(cookie)
+			}
+			state, err := storer.ReadState(r)
+			assert.NoError(t, err)
+			assert.NoError(t, storer.WriteState(httptest.NewRecorder(), state, []authboss.ClientStateEvent{{Kind: authboss.ClientStateEventDelAll}}))
+			_, err = store.DeleteExpired(time.Now())
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	removed, err := store.DeleteExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
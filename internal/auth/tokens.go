@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// TokenLifetime is how long email confirmation and password reset links work for
+const TokenLifetime = 24 * time.Hour
+
+// Lengths of the two halves of a token, in random bytes. The token in the link is both halves hex-encoded.
+const (
+	selectorLength = 16
+	verifierLength = 32
+)
+
+// IssueConfirmToken gives the user a new email confirmation token, replacing any earlier one, and returns it
+// for the link in the email. The user still needs saving.
+func IssueConfirmToken(user *models.User, now time.Time) (string, error) {
+	token, selector, verifier, err := newSplitToken()
+	if err != nil {
+		return "", err
+	}
+	user.ConfirmSelector = selector
+	user.ConfirmVerifier = verifier
+	user.ConfirmTokenExpiry = now.Add(TokenLifetime)
+	return token, nil
+}
+
+// IssueRecoverToken gives the user a new password reset token, replacing any earlier one, and returns it
+// for the link in the email. The user still needs saving.
+func IssueRecoverToken(user *models.User, now time.Time) (string, error) {
+	token, selector, verifier, err := newSplitToken()
+	if err != nil {
+		return "", err
+	}
+	user.RecoverSelector = selector
+	user.RecoverVerifier = verifier
+	user.RecoverTokenExpiry = now.Add(TokenLifetime)
+	return token, nil
+}
+
+// FindUserByConfirmToken finds the user an email confirmation token belongs to. It returns ErrTokenInvalid
+// for a token that doesn't match, and the user with ErrTokenExpired for one that has expired.
+func FindUserByConfirmToken(db *gorm.DB, token string, now time.Time) (*models.User, error) {
+	return findUserByToken(db, "confirm_selector", token, now, func(user *models.User) (string, time.Time) {
+		return user.ConfirmVerifier, user.ConfirmTokenExpiry
+	})
+}
+
+// FindUserByRecoverToken finds the user a password reset token belongs to. It returns ErrTokenInvalid
+// for a token that doesn't match, and the user with ErrTokenExpired for one that has expired.
+func FindUserByRecoverToken(db *gorm.DB, token string, now time.Time) (*models.User, error) {
+	return findUserByToken(db, "recover_selector", token, now, func(user *models.User) (string, time.Time) {
+		return user.RecoverVerifier, user.RecoverTokenExpiry
+	})
+}
+
+// ConfirmEmail marks the user's email as confirmed and uses up their confirmation token.
+// It returns ErrTokenInvalid if the token has already been used, even by a request running at the same time.
+func ConfirmEmail(db *gorm.DB, user *models.User) error {
+	result := db.Model(&models.User{}).
+		Where("id = ? AND confirm_selector = ? AND confirm_selector <> ''", user.ID, user.ConfirmSelector).
+		Updates(map[string]interface{}{
+			"confirmed":            true,
+			"confirm_selector":     "",
+			"confirm_verifier":     "",
+			"confirm_token_expiry": time.Time{},
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenInvalid
+	}
+	user.Confirmed = true
+	user.ConfirmSelector = ""
+	user.ConfirmVerifier = ""
+	user.ConfirmTokenExpiry = time.Time{}
+	return nil
+}
+
+// ResetPassword sets the user's new password hash and uses up their password reset token.
+// Whoever knew the old password may be signed in, so remembered logins stop working too.
+// It returns ErrTokenInvalid if the token has already been used, even by a request running at the same time.
+func ResetPassword(db *gorm.DB, user *models.User, hashedPassword string) error {
+	result := db.Model(&models.User{}).
+		Where("id = ? AND recover_selector = ? AND recover_selector <> ''", user.ID, user.RecoverSelector).
+		Updates(map[string]interface{}{
+			"password":             hashedPassword,
+			"recover_selector":     "",
+			"recover_verifier":     "",
+			"recover_token_expiry": time.Time{},
+			"remember_token":       "",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenInvalid
+	}
+	user.Password = hashedPassword
+	user.RecoverSelector = ""
+	user.RecoverVerifier = ""
+	user.RecoverTokenExpiry = time.Time{}
+	user.RememberToken = ""
+	return nil
+}
+
+// findUserByToken looks the user up by the token's selector, then checks its verifier in constant time
+// and its expiry
+func findUserByToken(db *gorm.DB, selectorColumn, token string, now time.Time, stored func(*models.User) (string, time.Time)) (*models.User, error) {
+	if len(token) != 2*(selectorLength+verifierLength) {
+		return nil, ErrTokenInvalid
+	}
+	selector, verifier := token[:2*selectorLength], token[2*selectorLength:]
+
+	var user models.User
+	err := db.Where(selectorColumn+" = ?", selector).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	verifierHash, expiry := stored(&user)
+	if subtle.ConstantTimeCompare([]byte(hashVerifier(verifier)), []byte(verifierHash)) != 1 {
+		return nil, ErrTokenInvalid
+	}
+	if !now.Before(expiry) {
+		return &user, ErrTokenExpired
+	}
+	return &user, nil
+}
+
+// newSplitToken generates a token, returning it with the selector to store and the hash of its verifier to store
+func newSplitToken() (token, selector, verifierHash string, err error) {
+	b := make([]byte, selectorLength+verifierLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	selector = hex.EncodeToString(b[:selectorLength])
+	verifier := hex.EncodeToString(b[selectorLength:])
+	return selector + verifier, selector, hashVerifier(verifier), nil
+}
+
+// hashVerifier returns the SHA-256 hash a verifier is stored as
+func hashVerifier(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return hex.EncodeToString(sum[:])
+}
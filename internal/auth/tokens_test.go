@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmToken(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := models.User{Email: "confirm@example.com", Password: "x"}
+	token, err := IssueConfirmToken(&user, now)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&user).Error)
+
+	// Only the selector and a hash of the verifier are stored
+	assert.True(t, strings.HasPrefix(token, user.ConfirmSelector))
+	assert.NotContains(t, token, user.ConfirmVerifier)
+	assert.Equal(t, now.Add(TokenLifetime), user.ConfirmTokenExpiry)
+
+	// A token with the right selector but the wrong verifier doesn't match
+	tampered := user.ConfirmSelector + strings.Repeat("0", len(token)-len(user.ConfirmSelector))
+	_, err = FindUserByConfirmToken(db, tampered, now)
+	assert.ErrorIs(t, err, ErrTokenInvalid)
+	_, err = FindUserByConfirmToken(db, "short", now)
+	assert.ErrorIs(t, err, ErrTokenInvalid)
+
+	// The token only works until it expires
+	_, err = FindUserByConfirmToken(db, token, now.Add(TokenLifetime))
+	assert.ErrorIs(t, err, ErrTokenExpired)
+
+	found, err := FindUserByConfirmToken(db, token, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	// And only once, even if the user was looked up before it was used
+	stale := *found
+	require.NoError(t, ConfirmEmail(db, found))
+	assert.ErrorIs(t, ConfirmEmail(db, &stale), ErrTokenInvalid)
+	_, err = FindUserByConfirmToken(db, token, now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrTokenInvalid)
+
+	require.NoError(t, db.First(&user, user.ID).Error)
+	assert.True(t, user.Confirmed)
+	assert.Empty(t, user.ConfirmSelector)
+	assert.Empty(t, user.ConfirmVerifier)
+}
+
+func TestRecoverToken(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := models.User{Email: "recover@example.com", Password: "old", RememberToken: "remembered"}
+	first, err := IssueRecoverToken(&user, now)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&user).Error)
+
+	// Asking again replaces the earlier token
+	token, err := IssueRecoverToken(&user, now)
+	require.NoError(t, err)
+	require.NoError(t, db.Save(&user).Error)
+	_, err = FindUserByRecoverToken(db, first, now)
+	assert.ErrorIs(t, err, ErrTokenInvalid)
+
+	found, err := FindUserByRecoverToken(db, token, now)
+	require.NoError(t, err)
+	stale := *found
+	require.NoError(t, ResetPassword(db, found, "new"))
+	assert.ErrorIs(t, ResetPassword(db, &stale, "newer"), ErrTokenInvalid)
+
+	require.NoError(t, db.First(&user, user.ID).Error)
+	assert.Equal(t, "new", user.Password)
+	assert.Empty(t, user.RecoverSelector)
+	assert.Empty(t, user.RecoverVerifier)
+	assert.Empty(t, user.RememberToken)
+}
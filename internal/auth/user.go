@@ -42,14 +42,14 @@ func (u *UserWrapper) GetConfirmed() bool {
 	return u.Confirmed
 }
 
-// GetConfirmSelector gets the user's confirm token
+// GetConfirmSelector gets the selector the user's confirm token is looked up by
 func (u *UserWrapper) GetConfirmSelector() string {
-	return u.ConfirmToken
+	return u.ConfirmSelector
 }
 
-// GetConfirmVerifier gets the user's confirm token
+// GetConfirmVerifier gets the hash of the user's confirm token verifier
 func (u *UserWrapper) GetConfirmVerifier() string {
-	return u.ConfirmToken
+	return u.ConfirmVerifier
 }
 
 // GetLocked gets the user's locked status
@@ -67,14 +67,14 @@ func (u *UserWrapper) GetLastAttempt() time.Time {
 	return u.LastAttempt
 }
 
-// GetRecoverSelector gets the user's recover token
+// GetRecoverSelector gets the selector the user's recover token is looked up by
 func (u *UserWrapper) GetRecoverSelector() string {
-	return u.RecoverToken
+	return u.RecoverSelector
 }
 
-// GetRecoverVerifier gets the user's recover token
+// GetRecoverVerifier gets the hash of the user's recover token verifier
 func (u *UserWrapper) GetRecoverVerifier() string {
-	return u.RecoverToken
+	return u.RecoverVerifier
 }
 
 // GetRecoverExpiry gets the user's recover token expiry
@@ -117,14 +117,14 @@ func (u *UserWrapper) PutConfirmed(confirmed bool) {
 	u.Confirmed = confirmed
 }
 
-// PutConfirmSelector sets the user's confirm token
+// PutConfirmSelector sets the selector the user's confirm token is looked up by
 func (u *UserWrapper) PutConfirmSelector(selector string) {
-	u.ConfirmToken = selector
+	u.ConfirmSelector = selector
 }
 
-// PutConfirmVerifier sets the user's confirm token
+// PutConfirmVerifier sets the hash of the user's confirm token verifier
 func (u *UserWrapper) PutConfirmVerifier(verifier string) {
-	u.ConfirmToken = verifier
+	u.ConfirmVerifier = verifier
 }
 
 // PutLocked sets the user's locked status
@@ -142,14 +142,14 @@ func (u *UserWrapper) PutLastAttempt(last time.Time) {
 	u.LastAttempt = last
 }
 
-// PutRecoverSelector sets the user's recover token
+// PutRecoverSelector sets the selector the user's recover token is looked up by
 func (u *UserWrapper) PutRecoverSelector(selector string) {
-	u.RecoverToken = selector
+	u.RecoverSelector = selector
 }
 
-// PutRecoverVerifier sets the user's recover token
+// PutRecoverVerifier sets the hash of the user's recover token verifier
 func (u *UserWrapper) PutRecoverVerifier(verifier string) {
-	u.RecoverToken = verifier
+	u.RecoverVerifier = verifier
 }
 
 // PutRecoverExpiry sets the user's recover token expiry
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/ammo"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// AmmoController handles requests related to ammunition
+type AmmoController struct {
+	DB *gorm.DB
+}
+
+// NewAmmoController creates a new AmmoController
+func NewAmmoController(db *gorm.DB) *AmmoController {
+	return &AmmoController{
+		DB: db,
+	}
+}
+
+// Index displays a list of all ammo belonging to the current user
+func (c *AmmoController) Index(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get all ammo for the current user
+	ammoItems, err := models.FindAmmoByOwner(c.DB, user.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get ammo"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the index template
+	component := ammo.Index(ammoItems, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Show displays details for specific ammo
+func (c *AmmoController) Show(ctx *gin.Context) {
+	// Get the ammo ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid ammo ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the ammo
+	ammoItem, err := models.FindAmmoByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Ammo not found"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the show template
+	component := ammo.Show(*ammoItem, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// New displays the form to create new ammo
+func (c *AmmoController) New(ctx *gin.Context) {
+	// Get calibers sorted by popularity (descending) and then alphabetically
+	var calibers []models.Caliber
+	if err := c.DB.Order("popularity DESC, caliber").Find(&calibers).Error; err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to retrieve calibers"})
+		return
+	}
+
+	// Render the new template
+	component := ammo.New(calibers)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create creates new ammo
+func (c *AmmoController) Create(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Check the user's plan has room for another ammo entry
+	if err := entitlements.CheckAmmo(c.DB, user, 1); err != nil {
+		if !errors.Is(err, entitlements.ErrLimitReached) {
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to check ammo limit"})
+			return
+		}
+		flash.SetMessage(ctx, err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, "/pricing")
+		return
+	}
+
+	// Create the ammo object from the form
+	ammoItem := models.Ammo{OwnerID: user.ID}
+	if errMsg := bindAmmoForm(ctx, &ammoItem); errMsg != "" {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": errMsg})
+		return
+	}
+
+	// Save the ammo to the database
+	if err := models.CreateAmmo(c.DB, &ammoItem); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create ammo"})
+		return
+	}
+
+	// Redirect to the ammo index page
+	flash.SetMessage(ctx, "Ammo added successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, "/owner/ammo")
+}
+
+// Edit displays the form to edit ammo
+func (c *AmmoController) Edit(ctx *gin.Context) {
+	// Get the ammo ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid ammo ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the ammo
+	ammoItem, err := models.FindAmmoByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Ammo not found"})
+		return
+	}
+
+	// Get calibers sorted by popularity (descending) and then alphabetically
+	var calibers []models.Caliber
+	if err := c.DB.Order("popularity DESC, caliber").Find(&calibers).Error; err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to retrieve calibers"})
+		return
+	}
+
+	// Render the edit template
+	component := ammo.Edit(*ammoItem, calibers)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Update updates ammo
+func (c *AmmoController) Update(ctx *gin.Context) {
+	// Get the ammo ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid ammo ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the ammo
+	ammoItem, err := models.FindAmmoByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Ammo not found"})
+		return
+	}
+
+	// Update the ammo from the form
+	if errMsg := bindAmmoForm(ctx, ammoItem); errMsg != "" {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": errMsg})
+		return
+	}
+
+	// Clear the preloaded caliber so the new caliber ID is saved
+	ammoItem.Caliber = models.Caliber{}
+
+	// Save the ammo to the database
+	if err := models.UpdateAmmo(c.DB, ammoItem); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to update ammo"})
+		return
+	}
+
+	// Redirect to the ammo details page
+	flash.SetMessage(ctx, "Ammo updated successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/ammo/%d", id))
+}
+
+// Delete deletes ammo
+func (c *AmmoController) Delete(ctx *gin.Context) {
+	// Get the ammo ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid ammo ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Delete the ammo
+	if err := models.DeleteAmmo(c.DB, uint(id), user.ID); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to delete ammo"})
+		return
+	}
+
+	// Redirect to the ammo index page
+	flash.SetMessage(ctx, "Ammo deleted successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, "/owner/ammo")
+}
+
+// bindAmmoForm copies the submitted form values onto the ammo, returning an error message if any are invalid
+func bindAmmoForm(ctx *gin.Context, ammoItem *models.Ammo) string {
+	brand := strings.TrimSpace(ctx.PostForm("brand"))
+	if brand == "" {
+		return "Brand is required"
+	}
+
+	caliberID, err := strconv.ParseUint(ctx.PostForm("caliber_id"), 10, 64)
+	if err != nil {
+		return "Invalid caliber ID"
+	}
+
+	grain := 0
+	if grainStr := ctx.PostForm("grain"); grainStr != "" {
+		grain, err = strconv.Atoi(grainStr)
+		if err != nil || grain < 0 {
+			return "Invalid grain"
+		}
+	}
+
+	quantity, err := strconv.Atoi(ctx.PostForm("quantity"))
+	if err != nil || quantity < 0 {
+		return "Invalid quantity"
+	}
+
+	costPerRound, err := parseCents(ctx.PostForm("cost_per_round"))
+	if err != nil {
+		return "Invalid cost per round"
+	}
+
+	ammoItem.Brand = brand
+	ammoItem.CaliberID = uint(caliberID)
+	ammoItem.Grain = grain
+	ammoItem.BulletType = strings.TrimSpace(ctx.PostForm("bullet_type"))
+	ammoItem.Quantity = quantity
+	ammoItem.CostPerRound = costPerRound
+	ammoItem.StorageLocation = strings.TrimSpace(ctx.PostForm("storage_location"))
+
+	return ""
+}
+
+// maxCents is the largest amount parseCents accepts, $10 million
+const maxCents = 1_000_000_000
+
+// parseCents converts a dollar amount such as "0.35" or "$12" into cents
+func parseCents(value string) (int64, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "$")
+	if value == "" {
+		return 0, nil
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) || amount < 0 {
+		return 0, fmt.Errorf("invalid amount: %s", value)
+	}
+
+	cents := math.Round(amount * 100)
+	if cents > maxCents {
+		return 0, fmt.Errorf("amount too large: %s", value)
+	}
+	return int64(cents), nil
+}
@@ -0,0 +1,277 @@
+package ammo_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Counter for generating unique emails
+var emailCounter = 0
+
+// setupAmmoTest sets up the test environment for ammo tests
+func setupAmmoTest(t *testing.T) (*gin.Engine, *controllers.AmmoController, *models.User) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	assert.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	emailCounter++
+	email := fmt.Sprintf("ammo%d@example.com", emailCounter)
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	assert.NoError(t, err)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Create an ammo controller
+	ammoController := controllers.NewAmmoController(db)
+
+	// Create a test router
+	router := gin.Default()
+
+	return router, ammoController, user
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+// createTestCaliber creates a test caliber in the database
+func createTestCaliber(t *testing.T, name string) *models.Caliber {
+	caliber := models.Caliber{
+		Caliber: name,
+	}
+	assert.NoError(t, database.DB.Create(&caliber).Error)
+	return &caliber
+}
+
+func TestAmmoCreate(t *testing.T) {
+	// Setup
+	router, ammoController, user := setupAmmoTest(t)
+	defer cleanup()
+
+	caliber := createTestCaliber(t, "9mm Test")
+
+	// Setup the route
+	router.POST("/owner/ammo", ammoController.Create)
+
+	// Create form data
+	form := url.Values{}
+	form.Add("brand", "Federal")
+	form.Add("caliber_id", strconv.FormatUint(uint64(caliber.ID), 10))
+	form.Add("grain", "115")
+	form.Add("bullet_type", "FMJ")
+	form.Add("quantity", "500")
+	form.Add("cost_per_round", "0.35")
+	form.Add("storage_location", "Basement safe")
+
+	// Create a request
+	req, err := http.NewRequest("POST", "/owner/ammo", strings.NewReader(form.Encode()))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Perform the request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Check the redirect
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/ammo", w.Header().Get("Location"))
+
+	// Verify the ammo was created in the database
+	ammoItems, err := models.FindAmmoByOwner(database.DB, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ammoItems))
+	assert.Equal(t, "Federal", ammoItems[0].Brand)
+	assert.Equal(t, caliber.ID, ammoItems[0].CaliberID)
+	assert.Equal(t, 115, ammoItems[0].Grain)
+	assert.Equal(t, 500, ammoItems[0].Quantity)
+	assert.Equal(t, int64(35), ammoItems[0].CostPerRound)
+	assert.Equal(t, "$175.00", ammoItems[0].FormatTotalCost())
+}
+
+func TestAmmoUpdate(t *testing.T) {
+	// Setup
+	router, ammoController, user := setupAmmoTest(t)
+	defer cleanup()
+
+	caliber := createTestCaliber(t, "5.56 Test")
+	newCaliber := createTestCaliber(t, ".223 Test")
+
+	ammoItem := models.Ammo{
+		Brand:     "Winchester",
+		CaliberID: caliber.ID,
+		Quantity:  100,
+		OwnerID:   user.ID,
+	}
+	assert.NoError(t, models.CreateAmmo(database.DB, &ammoItem))
+
+	// Setup the route
+	router.POST("/owner/ammo/:id", ammoController.Update)
+
+	// Create form data
+	form := url.Values{}
+	form.Add("brand", "Winchester White Box")
+	form.Add("caliber_id", strconv.FormatUint(uint64(newCaliber.ID), 10))
+	form.Add("quantity", "80")
+
+	// Create a request
+	req, err := http.NewRequest("POST", "/owner/ammo/"+strconv.FormatUint(uint64(ammoItem.ID), 10), strings.NewReader(form.Encode()))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Perform the request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Check the redirect
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/ammo/"+strconv.FormatUint(uint64(ammoItem.ID), 10), w.Header().Get("Location"))
+
+	// Verify the ammo was updated in the database
+	updated, err := models.FindAmmoByID(database.DB, ammoItem.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Winchester White Box", updated.Brand)
+	assert.Equal(t, newCaliber.ID, updated.CaliberID)
+	assert.Equal(t, 80, updated.Quantity)
+}
+
+func TestAmmoCreateInvalidQuantity(t *testing.T) {
+	// Setup
+	router, ammoController, user := setupAmmoTest(t)
+	defer cleanup()
+
+	caliber := createTestCaliber(t, "45 Test")
+
+	// Setup the route
+	router.POST("/owner/ammo", ammoController.Create)
+
+	// Create form data with a negative quantity
+	form := url.Values{}
+	form.Add("brand", "Federal")
+	form.Add("caliber_id", strconv.FormatUint(uint64(caliber.ID), 10))
+	form.Add("quantity", "-5")
+
+	// Create a request
+	req, err := http.NewRequest("POST", "/owner/ammo", strings.NewReader(form.Encode()))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Perform the request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Verify no ammo was created
+	assert.NotEqual(t, http.StatusSeeOther, w.Code)
+	var count int64
+	database.DB.Model(&models.Ammo{}).Where("owner_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestAmmoCreateInvalidCost(t *testing.T) {
+	// Setup
+	router, ammoController, user := setupAmmoTest(t)
+	defer cleanup()
+
+	caliber := createTestCaliber(t, "380 Test")
+
+	// Setup the route
+	router.POST("/owner/ammo", ammoController.Create)
+
+	// Costs that aren't a real amount, or are implausibly large, are rejected
+	for _, cost := range []string{"NaN", "Inf", "-Inf", "1e300", "10000000.01"} {
+		form := url.Values{}
+		form.Add("brand", "Federal")
+		form.Add("caliber_id", strconv.FormatUint(uint64(caliber.ID), 10))
+		form.Add("quantity", "50")
+		form.Add("cost_per_round", cost)
+
+		req, err := http.NewRequest("POST", "/owner/ammo", strings.NewReader(form.Encode()))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.NotEqual(t, http.StatusSeeOther, w.Code, cost)
+	}
+
+	// Verify no ammo was created
+	var count int64
+	database.DB.Model(&models.Ammo{}).Where("owner_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestAmmoDeleteIsOwnerScoped(t *testing.T) {
+	// Setup
+	router, ammoController, user := setupAmmoTest(t)
+	defer cleanup()
+
+	caliber := createTestCaliber(t, "308 Test")
+
+	// Create ammo belonging to another user
+	other, err := testutils.CreateTestUser(database.DB, fmt.Sprintf("ammo-other%d@example.com", emailCounter), "password123", false)
+	assert.NoError(t, err)
+	otherAmmo := models.Ammo{Brand: "Hornady", CaliberID: caliber.ID, Quantity: 20, OwnerID: other.ID}
+	assert.NoError(t, models.CreateAmmo(database.DB, &otherAmmo))
+
+	// Create ammo belonging to the current user
+	ownAmmo := models.Ammo{Brand: "PMC", CaliberID: caliber.ID, Quantity: 20, OwnerID: user.ID}
+	assert.NoError(t, models.CreateAmmo(database.DB, &ownAmmo))
+
+	// Setup the route
+	router.POST("/owner/ammo/:id/delete", ammoController.Delete)
+
+	// Attempt to delete both
+	for _, id := range []uint{otherAmmo.ID, ownAmmo.ID} {
+		req, err := http.NewRequest("POST", "/owner/ammo/"+strconv.FormatUint(uint64(id), 10)+"/delete", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusSeeOther, w.Code)
+	}
+
+	// The other user's ammo must survive
+	_, err = models.FindAmmoByID(database.DB, otherAmmo.ID, other.ID)
+	assert.NoError(t, err)
+
+	// The current user's ammo must be gone
+	_, err = models.FindAmmoByID(database.DB, ownAmmo.ID, user.ID)
+	assert.Error(t, err)
+}
+
+func TestFindAmmoByCaliber(t *testing.T) {
+	// Setup
+	_, _, user := setupAmmoTest(t)
+	defer cleanup()
+
+	nine := createTestCaliber(t, "9mm Match Test")
+	forty := createTestCaliber(t, "40 S&W Test")
+
+	assert.NoError(t, models.CreateAmmo(database.DB, &models.Ammo{Brand: "Federal", CaliberID: nine.ID, Quantity: 50, OwnerID: user.ID}))
+	assert.NoError(t, models.CreateAmmo(database.DB, &models.Ammo{Brand: "Blazer", CaliberID: nine.ID, Quantity: 0, OwnerID: user.ID}))
+	assert.NoError(t, models.CreateAmmo(database.DB, &models.Ammo{Brand: "Speer", CaliberID: forty.ID, Quantity: 50, OwnerID: user.ID}))
+
+	// Only 9mm ammo with rounds remaining should be returned
+	ammoItems, err := models.FindAmmoByCaliber(database.DB, user.ID, nine.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(ammoItems))
+	assert.Equal(t, "Federal", ammoItems[0].Brand)
+}
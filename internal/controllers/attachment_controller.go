@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/attachment"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// AttachmentController handles requests related to gun attachments
+type AttachmentController struct {
+	DB      *gorm.DB
+	Service *attachment.Service
+}
+
+// NewAttachmentController creates a new AttachmentController
+func NewAttachmentController(db *gorm.DB, store storage.Storage) *AttachmentController {
+	return &AttachmentController{
+		DB:      db,
+		Service: attachment.NewService(db, store),
+	}
+}
+
+// Create uploads a new attachment for a gun
+func (c *AttachmentController) Create(ctx *gin.Context) {
+	// Get the gun ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid gun ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the gun
+	gunItem, err := models.FindGunByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Gun not found"})
+		return
+	}
+
+	gunURL := fmt.Sprintf("/owner/guns/%d", gunItem.ID)
+
+	// Validate the attachment kind
+	kind := ctx.PostForm("kind")
+	if !models.IsValidAttachmentKind(kind) {
+		flash.SetMessage(ctx, "Please choose whether the file is a photo, receipt or manual", "error")
+		ctx.Redirect(http.StatusSeeOther, gunURL)
+		return
+	}
+
+	// Get the uploaded file
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		flash.SetMessage(ctx, "Please choose a file to upload", "error")
+		ctx.Redirect(http.StatusSeeOther, gunURL)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	// Store the attachment
+	if _, err := c.Service.Upload(ctx.Request.Context(), user, gunItem, kind, fileHeader.Filename, file); err != nil {
+		switch {
+		case errors.Is(err, attachment.ErrFileTooLarge):
+			flash.SetMessage(ctx, "That file is too large. Files can be up to "+models.FormatBytes(attachment.MaxFileSize)+".", "error")
+		case errors.Is(err, attachment.ErrImageTooLarge):
+			flash.SetMessage(ctx, "That image is too large. Images can be up to "+strconv.Itoa(attachment.MaxImagePixels/1_000_000)+" megapixels.", "error")
+		case errors.Is(err, attachment.ErrUnsupportedType):
+			flash.SetMessage(ctx, "Only JPEG and PNG images and PDF documents can be uploaded", "error")
+		case errors.Is(err, attachment.ErrQuotaExceeded):
+			flash.SetMessage(ctx, "You've used all of your storage. Upgrade your plan or delete some attachments to upload more.", "error")
+		default:
+			log.Printf("Failed to upload attachment for gun %d: %v", gunItem.ID, err)
+			flash.SetMessage(ctx, "Failed to upload attachment", "error")
+		}
+		ctx.Redirect(http.StatusSeeOther, gunURL)
+		return
+	}
+
+	// Redirect to the gun page
+	flash.SetMessage(ctx, "Attachment uploaded successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, gunURL)
+}
+
+// Show streams an attachment to its owner
+func (c *AttachmentController) Show(ctx *gin.Context) {
+	c.serve(ctx, false)
+}
+
+// Thumbnail streams an attachment's thumbnail to its owner
+func (c *AttachmentController) Thumbnail(ctx *gin.Context) {
+	c.serve(ctx, true)
+}
+
+// Delete deletes an attachment and its stored file
+func (c *AttachmentController) Delete(ctx *gin.Context) {
+	// Get the current user and attachment
+	_, item, ok := c.getAttachment(ctx)
+	if !ok {
+		return
+	}
+
+	// Delete the attachment
+	if err := c.Service.Delete(ctx.Request.Context(), item); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to delete attachment"})
+		return
+	}
+
+	// Redirect to the gun page
+	flash.SetMessage(ctx, "Attachment deleted", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d", item.GunID))
+}
+
+// serve writes an attachment or its thumbnail to the response
+func (c *AttachmentController) serve(ctx *gin.Context, thumbnail bool) {
+	// Get the current user and attachment
+	_, item, ok := c.getAttachment(ctx)
+	if !ok {
+		return
+	}
+
+	// Open the stored file
+	reader, err := c.Service.Open(ctx.Request.Context(), item, thumbnail)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			ctx.String(http.StatusNotFound, "File not found")
+			return
+		}
+		log.Printf("Failed to open attachment %d: %v", item.ID, err)
+		ctx.String(http.StatusInternalServerError, "Failed to open file")
+		return
+	}
+	defer reader.Close()
+
+	contentType := item.ContentType
+	if thumbnail {
+		contentType = "image/jpeg"
+	}
+
+	// Files are private to their owner, so keep them out of shared caches
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": item.FileName}))
+	ctx.Header("Cache-Control", "private, max-age=3600")
+	ctx.Header("X-Content-Type-Options", "nosniff")
+	ctx.Status(http.StatusOK)
+
+	if _, err := io.Copy(ctx.Writer, reader); err != nil {
+		log.Printf("Failed to stream attachment %d: %v", item.ID, err)
+	}
+}
+
+// getAttachment loads the current user and the attachment from the URL, writing an error response if either fails
+func (c *AttachmentController) getAttachment(ctx *gin.Context) (*models.User, *models.Attachment, bool) {
+	// Get the attachment ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid attachment ID")
+		return nil, nil, false
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, "Failed to get current user")
+		return nil, nil, false
+	}
+
+	// Get the attachment, scoped to its owner
+	item, err := models.FindAttachmentByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.String(http.StatusNotFound, "Attachment not found")
+		return nil, nil, false
+	}
+
+	return user, item, true
+}
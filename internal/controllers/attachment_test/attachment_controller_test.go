@@ -0,0 +1,163 @@
+package attachment_test
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Counter for generating unique emails
+var emailCounter = 0
+
+// setupAttachmentTest sets up the test environment for attachment tests
+func setupAttachmentTest(t *testing.T) (*gin.Engine, *models.User, *models.Gun) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	user := createUser(t)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Create a gun for the user
+	gun := models.Gun{Name: "Attachment Test Gun", OwnerID: user.ID}
+	require.NoError(t, models.CreateGun(db, &gun))
+
+	// Create an attachment controller backed by a temporary directory
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	attachmentController := controllers.NewAttachmentController(db, store)
+
+	// Create a test router with the attachment routes
+	router := gin.Default()
+	router.POST("/owner/guns/:id/attachments", attachmentController.Create)
+	router.GET("/owner/attachments/:id", attachmentController.Show)
+	router.GET("/owner/attachments/:id/thumbnail", attachmentController.Thumbnail)
+	router.POST("/owner/attachments/:id/delete", attachmentController.Delete)
+
+	return router, user, &gun
+}
+
+// createUser creates a test user with a unique email
+func createUser(t *testing.T) *models.User {
+	emailCounter++
+	email := fmt.Sprintf("attachment%d@example.com", emailCounter)
+	user, err := testutils.CreateTestUser(database.DB, email, "password123", false)
+	require.NoError(t, err)
+	return user
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+// uploadRequest builds a multipart upload request
+func uploadRequest(t *testing.T, gunID uint, kind, fileName string, content []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("kind", kind))
+	part, err := writer.CreateFormFile("file", fileName)
+	require.NoError(t, err)
+	_, err = part.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/owner/guns/%d/attachments", gunID), &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestAttachmentUploadAndDownload(t *testing.T) {
+	// Setup
+	router, user, gun := setupAttachmentTest(t)
+	defer cleanup()
+
+	content := []byte("%PDF-1.4\n%owner manual")
+
+	// Upload a manual
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, uploadRequest(t, gun.ID, models.AttachmentKindManual, "manual.pdf", content))
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, fmt.Sprintf("/owner/guns/%d", gun.ID), resp.Header().Get("Location"))
+
+	attachments, err := models.FindAttachmentsByGun(database.DB, gun.ID, user.ID)
+	require.NoError(t, err)
+	require.Len(t, attachments, 1)
+	item := attachments[0]
+	assert.Equal(t, "manual.pdf", item.FileName)
+
+	// The owner can download it
+	resp = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/owner/attachments/%d", item.ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/pdf", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "manual.pdf")
+	assert.Equal(t, content, resp.Body.Bytes())
+
+	// PDFs have no thumbnail
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/owner/attachments/%d/thumbnail", item.ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Another user cannot download or delete it
+	auth.MockUser = createUser(t)
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/owner/attachments/%d", item.ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/owner/attachments/%d/delete", item.ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// The owner can delete it
+	auth.MockUser = user
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/owner/attachments/%d/delete", item.ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+
+	attachments, err = models.FindAttachmentsByGun(database.DB, gun.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, attachments, 0)
+}
+
+func TestAttachmentUploadRejectsUnsupportedType(t *testing.T) {
+	// Setup
+	router, user, gun := setupAttachmentTest(t)
+	defer cleanup()
+
+	// Upload an executable disguised as an image
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, uploadRequest(t, gun.ID, models.AttachmentKindPhoto, "photo.jpg", []byte("MZ\x90\x00 not an image")))
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+
+	attachments, err := models.FindAttachmentsByGun(database.DB, gun.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, attachments, 0)
+}
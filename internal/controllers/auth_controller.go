@@ -3,6 +3,8 @@ package controllers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -18,9 +20,18 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/flash"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/passkey"
+	"github.com/hail2skins/the-virtual-armory/internal/services/twofactor"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+// twoFactorLoginCookie holds the token for a login waiting on a two-factor code
+const twoFactorLoginCookie = "two_factor_login"
+
+// passkeyLoginCookie holds the token for a login waiting on the user's passkey
+const passkeyLoginCookie = "passkey_login"
+
 // AuthController handles authentication-related routes
 type AuthController struct {
 	Auth         *auth.Auth
@@ -108,6 +119,13 @@ func (c *AuthController) Profile(ctx *gin.Context) {
 		return
 	}
 
+	// Get the guns that are overdue for maintenance
+	overdue, err := models.FindOverdueGuns(db, user.ID, time.Now())
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to retrieve maintenance status"})
+		return
+	}
+
 	// Get flash message from cookie
 	flashMessage, err := ctx.Cookie("flash_message")
 	flashType, _ := ctx.Cookie("flash_type")
@@ -122,7 +140,7 @@ func (c *AuthController) Profile(ctx *gin.Context) {
 	}
 
 	// Render the profile template with flash message
-	component := authviews.Profile(user, guns, flashMessage, flashType)
+	component := authviews.Profile(user, guns, overdue, flashMessage, flashType)
 	component.Render(ctx.Request.Context(), ctx.Writer)
 }
 
@@ -170,14 +188,14 @@ func (c *AuthController) ProcessLogin(ctx *gin.Context) {
 	user.LastAttempt = time.Now()
 	db.Save(&user)
 
-	// This would normally be handled by Authboss
-	// For now, we'll simulate a successful login by setting session cookies
-	ctx.SetCookie("is_logged_in", "true", 3600, "/", "", false, true)
-	ctx.SetCookie("user_email", email, 3600, "/", "", false, true)
+	if needsTwoFactor(db, &user) {
+		c.startTwoFactorLogin(ctx, &user)
+		return
+	}
 
-	// Check if the user is an admin and set the appropriate cookie
-	if user.IsAdmin {
-		ctx.SetCookie("is_admin", "true", 3600, "/", "", false, true)
+	if err := c.signIn(ctx, &user); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to log in"})
+		return
 	}
 
 	// Set a welcome back message
@@ -187,6 +205,220 @@ func (c *AuthController) ProcessLogin(ctx *gin.Context) {
 	ctx.Redirect(http.StatusSeeOther, "/owner")
 }
 
+// signIn starts the session that keeps the user signed in. The session cookie is the only thing
+// that identifies the user, so the login fails if the session can't be started.
+func (c *AuthController) signIn(ctx *gin.Context, user *models.User) error {
+	if err := c.Auth.StartSession(ctx, user); err != nil {
+		log.Printf("Failed to start session for user %d: %v", user.ID, err)
+		return err
+	}
+	return nil
+}
+
+// needsTwoFactor returns whether a user who has entered their password still needs a code before
+// they're signed in. That's users with two-factor authentication, and admins who are required to set it up.
+func needsTwoFactor(db *gorm.DB, user *models.User) bool {
+	return user.TOTPEnabled || (user.IsAdmin && models.AdminTwoFactorRequired(db))
+}
+
+// startTwoFactorLogin remembers that the user has entered their password and asks for their code
+func (c *AuthController) startTwoFactorLogin(ctx *gin.Context, user *models.User) {
+	token, err := twofactor.NewService(database.GetDB()).StartLogin(user, time.Now())
+	if err != nil {
+		log.Printf("Failed to start two-factor login for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to log in"})
+		return
+	}
+	ctx.SetCookie(twoFactorLoginCookie, token, int(twofactor.LoginTimeout.Seconds()), "/", "", false, true)
+	ctx.Redirect(http.StatusSeeOther, "/login/two-factor")
+}
+
+// pendingTwoFactorLogin finds the user whose login is waiting on a code, sending them back to the login page if there isn't one
+func (c *AuthController) pendingTwoFactorLogin(ctx *gin.Context, service *twofactor.Service) (*models.User, bool) {
+	token, _ := ctx.Cookie(twoFactorLoginCookie)
+	user, err := service.PendingLogin(token, time.Now())
+	if err != nil {
+		if !errors.Is(err, twofactor.ErrLoginExpired) {
+			log.Printf("Failed to find two-factor login: %v", err)
+		}
+		ctx.SetCookie(twoFactorLoginCookie, "", -1, "/", "", false, true)
+		flash.SetMessage(ctx, "Your login has expired. Please log in again.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return nil, false
+	}
+	return user, true
+}
+
+// TwoFactorLogin asks for the code from the user's authenticator app, or has an admin who must use
+// two-factor authentication set it up
+func (c *AuthController) TwoFactorLogin(ctx *gin.Context) {
+	service := twofactor.NewService(database.GetDB())
+	user, ok := c.pendingTwoFactorLogin(ctx, service)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		component := authviews.TwoFactorLogin("")
+		component.Render(ctx, ctx.Writer)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		if err := service.BeginEnrollment(user); err != nil {
+			log.Printf("Failed to begin two-factor enrollment for user %d: %v", user.ID, err)
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to set up two-factor authentication"})
+			return
+		}
+	}
+	c.renderTwoFactorLoginSetup(ctx, service, user, "")
+}
+
+// ProcessTwoFactorLogin checks the code and finishes signing the user in
+func (c *AuthController) ProcessTwoFactorLogin(ctx *gin.Context) {
+	service := twofactor.NewService(database.GetDB())
+	user, ok := c.pendingTwoFactorLogin(ctx, service)
+	if !ok {
+		return
+	}
+	code := strings.TrimSpace(ctx.PostForm("code"))
+
+	// An admin setting up two-factor authentication gets signed in once the app's code checks out
+	if !user.TOTPEnabled {
+		codes, err := service.Enable(user, code, time.Now())
+		if err != nil {
+			if !errors.Is(err, twofactor.ErrInvalidCode) && !errors.Is(err, twofactor.ErrNotEnrolled) {
+				log.Printf("Failed to enable two-factor authentication for user %d: %v", user.ID, err)
+			}
+			c.renderTwoFactorLoginSetup(ctx, service, user, "Invalid code. Please try again.")
+			return
+		}
+		if err := service.CancelLogin(user); err != nil {
+			log.Printf("Failed to finish two-factor login for user %d: %v", user.ID, err)
+		}
+		ctx.SetCookie(twoFactorLoginCookie, "", -1, "/", "", false, true)
+		if err := c.signIn(ctx, user); err != nil {
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to log in"})
+			return
+		}
+
+		component := userviews.RecoveryCodes(codes, "/owner")
+		component.Render(ctx, ctx.Writer)
+		return
+	}
+
+	usedRecoveryCode, err := service.FinishLogin(user, code, time.Now())
+	if errors.Is(err, twofactor.ErrInvalidCode) {
+		component := authviews.TwoFactorLogin("Invalid code. Please try again.")
+		component.Render(ctx, ctx.Writer)
+		return
+	}
+	if err != nil {
+		if !errors.Is(err, twofactor.ErrTooManyAttempts) {
+			log.Printf("Failed to finish two-factor login for user %d: %v", user.ID, err)
+		}
+		ctx.SetCookie(twoFactorLoginCookie, "", -1, "/", "", false, true)
+		flash.SetMessage(ctx, "Too many invalid codes. Please log in again.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	ctx.SetCookie(twoFactorLoginCookie, "", -1, "/", "", false, true)
+	if err := c.signIn(ctx, user); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	if usedRecoveryCode {
+		remaining, _ := service.RemainingRecoveryCodes(user)
+		flash.SetMessage(ctx, fmt.Sprintf("Welcome back! You used a recovery code and have %d left.", remaining), "warning")
+	} else {
+		flash.SetMessage(ctx, "Welcome back!", "success")
+	}
+	ctx.Redirect(http.StatusSeeOther, "/owner")
+}
+
+// renderTwoFactorLoginSetup shows the QR code for an admin setting up two-factor authentication while logging in
+func (c *AuthController) renderTwoFactorLoginSetup(ctx *gin.Context, service *twofactor.Service, user *models.User, errorMsg string) {
+	qrCode, err := service.QRCode(user)
+	if err != nil {
+		log.Printf("Failed to create QR code for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to set up two-factor authentication"})
+		return
+	}
+	component := userviews.TwoFactorSetup(qrCode, user.TOTPSecret, "/login/two-factor", true, errorMsg)
+	component.Render(ctx, ctx.Writer)
+}
+
+// BeginPasskeyLogin returns the options for the browser to ask the user for a passkey
+func (c *AuthController) BeginPasskeyLogin(ctx *gin.Context) {
+	service, err := c.passkeys()
+	if err != nil {
+		log.Printf("Failed to set up passkeys: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not available"})
+		return
+	}
+
+	assertion, token, err := service.BeginLogin(time.Now())
+	if err != nil {
+		log.Printf("Failed to begin passkey login: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+	ctx.SetCookie(passkeyLoginCookie, token, int(passkey.ChallengeTimeout.Seconds()), "/", "", false, true)
+	ctx.JSON(http.StatusOK, assertion)
+}
+
+// FinishPasskeyLogin checks the passkey the browser sent and signs its owner in.
+// The authenticator has already verified the user, so there's no two-factor code to ask for.
+func (c *AuthController) FinishPasskeyLogin(ctx *gin.Context) {
+	service, err := c.passkeys()
+	if err != nil {
+		log.Printf("Failed to set up passkeys: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not available"})
+		return
+	}
+
+	token, _ := ctx.Cookie(passkeyLoginCookie)
+	ctx.SetCookie(passkeyLoginCookie, "", -1, "/", "", false, true)
+
+	user, err := service.FinishLogin(token, ctx.Request.Body, time.Now())
+	if errors.Is(err, passkey.ErrChallengeExpired) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Your login has expired. Please try again."})
+		return
+	}
+	if errors.Is(err, passkey.ErrInvalidPasskey) {
+		log.Printf("Rejected passkey login: %v", err)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "That passkey couldn't be verified"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to finish passkey login: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+
+	// Update the LastAttempt field to track the last successful login
+	if err := database.GetDB().Model(user).Update("last_attempt", time.Now()).Error; err != nil {
+		log.Printf("Failed to record login for user %d: %v", user.ID, err)
+	}
+
+	if err := c.signIn(ctx, user); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	flash.SetMessage(ctx, "Welcome back!", "success")
+	ctx.JSON(http.StatusOK, gin.H{"redirect": "/owner"})
+}
+
+// passkeys creates the passkey service for the site's base URL
+func (c *AuthController) passkeys() (*passkey.Service, error) {
+	if c.config == nil {
+		return nil, errors.New("no configuration for passkeys")
+	}
+	return passkey.NewService(database.GetDB(), c.config.AppBaseURL)
+}
+
 // ProcessRegister handles the registration form submission
 func (c *AuthController) ProcessRegister(ctx *gin.Context) {
 	// Get form data
@@ -243,34 +475,29 @@ func (c *AuthController) ProcessRegister(ctx *gin.Context) {
 		return
 	}
 
-	// Generate confirmation token
-	token, err := generateToken(32)
+	// Hash the password using bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		component := authviews.RegisterForm("Error creating user: "+err.Error(), email)
 		component.Render(ctx, ctx.Writer)
 		return
 	}
 
-	// Set token expiry (24 hours from now)
-	tokenExpiry := time.Now().Add(24 * time.Hour)
+	// Create new user
+	user := models.User{
+		Email:     email,
+		Password:  string(hashedPassword),
+		Confirmed: false,
+	}
 
-	// Hash the password using bcrypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	// Generate confirmation token
+	token, err := auth.IssueConfirmToken(&user, time.Now())
 	if err != nil {
 		component := authviews.RegisterForm("Error creating user: "+err.Error(), email)
 		component.Render(ctx, ctx.Writer)
 		return
 	}
 
-	// Create new user
-	user := models.User{
-		Email:              email,
-		Password:           string(hashedPassword),
-		ConfirmToken:       token,
-		ConfirmTokenExpiry: tokenExpiry,
-		Confirmed:          false,
-	}
-
 	result = db.Create(&user)
 	if result.Error != nil {
 		component := authviews.RegisterForm("Error creating user: "+result.Error.Error(), email)
@@ -302,30 +529,28 @@ func (c *AuthController) VerifyEmail(ctx *gin.Context) {
 	}
 
 	// Find the user with this verification token
-	var user models.User
 	db := database.GetDB()
-	result := db.Where("confirm_token = ?", token).First(&user)
-	if result.Error != nil {
-		log.Printf("Error finding user by verification token: %v", result.Error)
+	user, err := auth.FindUserByConfirmToken(db, token, time.Now())
+	if errors.Is(err, auth.ErrTokenExpired) {
+		log.Printf("Verification token expired for user %s", user.Email)
+		component := partials.Error("Your verification token has expired. Please request a new verification email.")
+		component.Render(ctx, ctx.Writer)
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding user by verification token: %v", err)
 		component := partials.Error("Invalid or expired verification token. Please request a new verification email.")
 		component.Render(ctx, ctx.Writer)
 		return
 	}
 
-	// Check if token is expired
-	if user.ConfirmTokenExpiry.Before(time.Now()) {
-		log.Printf("Verification token expired for user %s", user.Email)
-		component := partials.Error("Your verification token has expired. Please request a new verification email.")
+	// Mark user as verified, using up the token
+	err = auth.ConfirmEmail(db, user)
+	if errors.Is(err, auth.ErrTokenInvalid) {
+		component := partials.Error("Invalid or expired verification token. Please request a new verification email.")
 		component.Render(ctx, ctx.Writer)
 		return
 	}
-
-	// Mark user as verified
-	user.Confirmed = true
-	user.ConfirmToken = ""
-	user.ConfirmTokenExpiry = time.Time{}
-
-	err := db.Save(&user).Error
 	if err != nil {
 		log.Printf("Error updating user verification status: %v", err)
 		component := partials.Error("An error occurred while verifying your email. Please try again later.")
@@ -388,8 +613,8 @@ func (c *AuthController) ResendVerification(ctx *gin.Context) {
 		return
 	}
 
-	// Generate a new token
-	token, err := generateToken(32)
+	// Generate a new token, which replaces the old one
+	token, err := auth.IssueConfirmToken(&user, time.Now())
 	if err != nil {
 		log.Printf("Error generating token: %v", err)
 		component := partials.Error("An error occurred. Please try again later.")
@@ -398,8 +623,6 @@ func (c *AuthController) ResendVerification(ctx *gin.Context) {
 	}
 
 	// Update the user with the new token
-	user.ConfirmToken = token
-	user.ConfirmTokenExpiry = time.Now().Add(24 * time.Hour)
 	err = db.Save(&user).Error
 	if err != nil {
 		log.Printf("Error updating user with new token: %v", err)
@@ -452,18 +675,13 @@ func (c *AuthController) ProcessRecover(ctx *gin.Context) {
 	}
 
 	// Generate a recovery token
-	token, err := generateToken(32)
+	token, err := auth.IssueRecoverToken(&user, time.Now())
 	if err != nil {
 		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Error generating recovery token"})
 		return
 	}
 
-	// Set token expiry (24 hours from now)
-	tokenExpiry := time.Now().Add(24 * time.Hour)
-
 	// Save the token to the user record
-	user.RecoverToken = token
-	user.RecoverTokenExpiry = tokenExpiry
 	db.Save(&user)
 
 	// Send recovery email
@@ -484,10 +702,11 @@ func (c *AuthController) ProcessRecover(ctx *gin.Context) {
 
 // Logout handles user logout
 func (c *AuthController) Logout(ctx *gin.Context) {
-	// This would normally be handled by Authboss
-	// For now, we'll just clear the cookies and redirect to the home page with a flash message
-	ctx.SetCookie("is_logged_in", "", -1, "/", "", false, true)
-	ctx.SetCookie("user_email", "", -1, "/", "", false, true)
+	// End the browser's session and clear its cookies, then redirect to the home page with a flash message
+	if err := c.Auth.EndSession(ctx); err != nil {
+		log.Printf("Failed to end session: %v", err)
+	}
+	auth.ClearLoginCookies(ctx)
 
 	// Set a flash message with a MaxAge of 5 seconds for test compatibility
 	// but still ensure it's visible on the home page
@@ -553,9 +772,11 @@ func (c *AuthController) ProcessDeleteAccount(ctx *gin.Context) {
 		return
 	}
 
-	// Log the user out
-	ctx.SetCookie("is_logged_in", "", -1, "/", "", false, true)
-	ctx.SetCookie("user_email", "", -1, "/", "", false, true)
+	// Log the user out everywhere
+	if _, err := c.Auth.RevokeSessions(ctx, user.ID, false); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+	}
+	auth.ClearLoginCookies(ctx)
 
 	// Set a flash message for account deletion
 	flash.SetMessage(ctx, "Sorry to see you go. Your account has been deleted.", "success")
@@ -644,9 +865,19 @@ func (c *AuthController) ProcessReactivation(ctx *gin.Context) {
 		return
 	}
 
+	if needsTwoFactor(db, &user) {
+		c.startTwoFactorLogin(ctx, &user)
+		return
+	}
+
 	// Log the user in (using the same approach as in ProcessLogin)
-	ctx.SetCookie("is_logged_in", "true", 3600, "/", "", false, true)
-	ctx.SetCookie("user_email", email, 3600, "/", "", false, true)
+	if err := c.signIn(ctx, &user); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "auth/reactivate.html", gin.H{
+			"Email": email,
+			"Error": "Your account was reactivated, but we couldn't log you in. Please log in again.",
+		})
+		return
+	}
 
 	// Set flash message
 	flash.SetMessage(ctx, "Your account has been successfully reactivated!", "success")
@@ -674,19 +905,7 @@ func (c *AuthController) ResetPassword(ctx *gin.Context) {
 	}
 
 	// Find the user with this token
-	var user models.User
-	result := db.Where("recover_token = ?", token).First(&user)
-	if result.Error != nil {
-		// Token not found or invalid
-		flash.SetMessage(ctx, "Invalid or expired password reset link", "error")
-		ctx.Redirect(http.StatusSeeOther, "/recover")
-		return
-	}
-
-	// Check if the token has expired
-	if user.RecoverTokenExpiry.Before(time.Now()) {
-		flash.SetMessage(ctx, "Password reset link has expired", "error")
-		ctx.Redirect(http.StatusSeeOther, "/recover")
+	if _, ok := c.findRecoverUser(ctx, db, token); !ok {
 		return
 	}
 
@@ -732,19 +951,8 @@ func (c *AuthController) ProcessResetPassword(ctx *gin.Context) {
 	}
 
 	// Find the user with this token
-	var user models.User
-	result := db.Where("recover_token = ?", token).First(&user)
-	if result.Error != nil {
-		// Token not found or invalid
-		flash.SetMessage(ctx, "Invalid or expired password reset link", "error")
-		ctx.Redirect(http.StatusSeeOther, "/recover")
-		return
-	}
-
-	// Check if the token has expired
-	if user.RecoverTokenExpiry.Before(time.Now()) {
-		flash.SetMessage(ctx, "Password reset link has expired", "error")
-		ctx.Redirect(http.StatusSeeOther, "/recover")
+	user, ok := c.findRecoverUser(ctx, db, token)
+	if !ok {
 		return
 	}
 
@@ -755,17 +963,48 @@ func (c *AuthController) ProcessResetPassword(ctx *gin.Context) {
 		return
 	}
 
-	// Update the user's password and clear the recovery token
-	user.Password = string(hashedPassword)
-	user.RecoverToken = ""
-	user.RecoverTokenExpiry = time.Time{}
-	db.Save(&user)
+	// Update the user's password, using up the recovery token
+	err = auth.ResetPassword(db, user, string(hashedPassword))
+	if errors.Is(err, auth.ErrTokenInvalid) {
+		flash.SetMessage(ctx, "Invalid or expired password reset link", "error")
+		ctx.Redirect(http.StatusSeeOther, "/recover")
+		return
+	}
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Error resetting password"})
+		return
+	}
+
+	// Sign out every other session
+	if _, err := c.Auth.RevokeSessions(ctx, user.ID, true); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+	}
 
 	// Set flash message and redirect to login
 	flash.SetMessage(ctx, "Your password has been reset successfully. You can now log in with your new password.", "success")
 	ctx.Redirect(http.StatusSeeOther, "/login")
 }
 
+// findRecoverUser finds the user a password reset token belongs to, or sends the browser
+// back to /recover with a message and returns false
+func (c *AuthController) findRecoverUser(ctx *gin.Context, db *gorm.DB, token string) (*models.User, bool) {
+	user, err := auth.FindUserByRecoverToken(db, token, time.Now())
+	if errors.Is(err, auth.ErrTokenExpired) {
+		flash.SetMessage(ctx, "Password reset link has expired", "error")
+		ctx.Redirect(http.StatusSeeOther, "/recover")
+		return nil, false
+	}
+	if err != nil {
+		if !errors.Is(err, auth.ErrTokenInvalid) {
+			log.Printf("Error finding user by recovery token: %v", err)
+		}
+		flash.SetMessage(ctx, "Invalid or expired password reset link", "error")
+		ctx.Redirect(http.StatusSeeOther, "/recover")
+		return nil, false
+	}
+	return user, true
+}
+
 // Helper function to generate a random token
 func generateToken(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -49,6 +49,36 @@ func (m *MockEmailService) SendContactFormEmail(name, email, subject, message st
 	return args.Error(0)
 }
 
+// SendMaintenanceReminderEmail sends a maintenance reminder email
+func (m *MockEmailService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	args := m.Called(email, overdue)
+	return args.Error(0)
+}
+
+// SendDataExportEmail mocks the SendDataExportEmail method
+func (m *MockEmailService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	args := m.Called(email, token, expiresAt)
+	return args.Error(0)
+}
+
+// SendPaymentFailedEmail mocks the SendPaymentFailedEmail method
+func (m *MockEmailService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	args := m.Called(email, reminder, graceEndsAt)
+	return args.Error(0)
+}
+
+// SendDataExportFailedEmail mocks the SendDataExportFailedEmail method
+func (m *MockEmailService) SendDataExportFailedEmail(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+// SendReceiptEmail mocks the SendReceiptEmail method
+func (m *MockEmailService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	args := m.Called(email, payment, pdf)
+	return args.Error(0)
+}
+
 // setupTestDB sets up a test database
 func setupTestDB(t *testing.T) *gorm.DB {
 	// Use an in-memory SQLite database for testing
@@ -58,7 +88,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// Migrate the schema
-	err = db.AutoMigrate(&models.User{})
+	err = db.AutoMigrate(&models.User{}, &models.LoginSession{})
 	if err != nil {
 		t.Fatalf("Failed to migrate schema: %v", err)
 	}
@@ -80,11 +110,8 @@ func setupTestRouter(t *testing.T, db *gorm.DB) (*gin.Engine, *AuthController) {
 	mockEmailService.On("SendPasswordResetEmail", mock.Anything, mock.Anything).Return(nil)
 	mockEmailService.On("SendContactFormEmail", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
-	// Create an auth instance
-	authInstance, err := auth.New()
-	if err != nil {
-		t.Fatalf("Failed to create auth instance: %v", err)
-	}
+	// Create an auth instance keeping its sessions in the test database
+	authInstance := auth.NewWithSessionStore(auth.NewGORMSessionStore(db), time.Hour)
 
 	// Create a test config
 	testConfig := &config.Config{
@@ -139,7 +166,8 @@ func TestRegisterSuccess(t *testing.T) {
 
 	// Verify other user properties
 	assert.False(t, user.Confirmed)
-	assert.NotEmpty(t, user.ConfirmToken)
+	assert.NotEmpty(t, user.ConfirmSelector)
+	assert.NotEmpty(t, user.ConfirmVerifier)
 	assert.False(t, user.ConfirmTokenExpiry.IsZero())
 }
 
@@ -194,14 +222,13 @@ func TestVerifyEmailSuccess(t *testing.T) {
 	router.GET("/verify/:token", authController.VerifyEmail)
 
 	// Create a test user with a confirmation token
-	token := "test-token"
 	user := models.User{
-		Email:              "test@example.com",
-		Password:           "password123",
-		ConfirmToken:       token,
-		ConfirmTokenExpiry: time.Now().Add(24 * time.Hour),
-		Confirmed:          false,
+		Email:     "test@example.com",
+		Password:  "password123",
+		Confirmed: false,
 	}
+	token, err := auth.IssueConfirmToken(&user, time.Now())
+	assert.NoError(t, err)
 	db.Create(&user)
 
 	// Create a test request
@@ -218,8 +245,16 @@ func TestVerifyEmailSuccess(t *testing.T) {
 	// Check that the user is now confirmed
 	db.First(&user, user.ID)
 	assert.True(t, user.Confirmed)
-	assert.Empty(t, user.ConfirmToken)
+	assert.Empty(t, user.ConfirmSelector)
+	assert.Empty(t, user.ConfirmVerifier)
 	assert.True(t, user.ConfirmTokenExpiry.IsZero())
+
+	// The link only works once
+	req, _ = http.NewRequest("GET", "/verify/"+token, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Location"))
 }
 
 // TestVerifyEmailInvalidToken tests email verification with an invalid token
@@ -263,14 +298,13 @@ func TestVerifyEmailExpiredToken(t *testing.T) {
 	router.GET("/verify/:token", authController.VerifyEmail)
 
 	// Create a test user with an expired confirmation token
-	token := "test-token"
 	user := models.User{
-		Email:              "test@example.com",
-		Password:           "password123",
-		ConfirmToken:       token,
-		ConfirmTokenExpiry: time.Now().Add(-24 * time.Hour), // Expired
-		Confirmed:          false,
+		Email:     "test@example.com",
+		Password:  "password123",
+		Confirmed: false,
 	}
+	token, err := auth.IssueConfirmToken(&user, time.Now().Add(-48*time.Hour)) // Expired
+	assert.NoError(t, err)
 	db.Create(&user)
 
 	// Create a test request
@@ -345,12 +379,13 @@ func TestResendVerification(t *testing.T) {
 
 	// Create a test user that is not confirmed
 	user := models.User{
-		Email:              "test@example.com",
-		Password:           "password123",
-		ConfirmToken:       "old-token",
-		ConfirmTokenExpiry: time.Now().Add(-24 * time.Hour), // Expired
-		Confirmed:          false,
+		Email:     "test@example.com",
+		Password:  "password123",
+		Confirmed: false,
 	}
+	_, err := auth.IssueConfirmToken(&user, time.Now().Add(-48*time.Hour)) // Expired
+	assert.NoError(t, err)
+	oldSelector := user.ConfirmSelector
 	db.Create(&user)
 
 	// Create a test request
@@ -370,7 +405,7 @@ func TestResendVerification(t *testing.T) {
 	// Check that the user's token was updated
 	var updatedUser models.User
 	db.First(&updatedUser, user.ID)
-	assert.NotEqual(t, "old-token", updatedUser.ConfirmToken)
+	assert.NotEqual(t, oldSelector, updatedUser.ConfirmSelector)
 	assert.True(t, updatedUser.ConfirmTokenExpiry.After(time.Now()))
 }
 
@@ -412,20 +447,19 @@ func TestLoginSuccess(t *testing.T) {
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	assert.Equal(t, "/owner", w.Header().Get("Location"))
 
-	// Check that cookies were set
-	cookies := w.Result().Cookies()
-	var isLoggedInCookie, userEmailCookie *http.Cookie
-	for _, cookie := range cookies {
-		if cookie.Name == "is_logged_in" {
-			isLoggedInCookie = cookie
-		} else if cookie.Name == "user_email" {
-			userEmailCookie = cookie
+	// Check that only the session cookie identifies the user
+	var sessionCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		assert.NotContains(t, []string{"is_logged_in", "user_email", "is_admin"}, cookie.Name)
+		if cookie.Name == "session" {
+			sessionCookie = cookie
 		}
 	}
-	assert.NotNil(t, isLoggedInCookie, "is_logged_in cookie should be set")
-	assert.Equal(t, "true", isLoggedInCookie.Value)
-	assert.NotNil(t, userEmailCookie, "user_email cookie should be set")
-	assert.Equal(t, "test%40example.com", userEmailCookie.Value)
+	if assert.NotNil(t, sessionCookie, "session cookie should be set") {
+		sessions, err := authController.Auth.ListSessions(user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1, "a login session should be started for the user")
+	}
 
 	// Check that the LastAttempt field was updated
 	var updatedUser models.User
@@ -565,15 +599,12 @@ func TestLogout(t *testing.T) {
 	// Create a test request
 	req, _ := http.NewRequest("GET", "/logout", nil)
 
-	// Add a cookie to simulate a logged-in user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: "test@example.com",
-	})
+	// Sign a user in
+	user := models.User{Email: "test@example.com", Password: "password", Confirmed: true}
+	db.Create(&user)
+	sessionCookie, err := authController.Auth.SessionCookie(&user)
+	assert.NoError(t, err)
+	req.AddCookie(sessionCookie)
 
 	// Perform the request
 	w := httptest.NewRecorder()
@@ -585,13 +616,11 @@ func TestLogout(t *testing.T) {
 
 	// Check that cookies were cleared
 	cookies := w.Result().Cookies()
-	var isLoggedInCookie, userEmailCookie, flashMessageCookie, flashTypeCookie *http.Cookie
+	var clearedSessionCookie, flashMessageCookie, flashTypeCookie *http.Cookie
 	for _, cookie := range cookies {
 		switch cookie.Name {
-		case "is_logged_in":
-			isLoggedInCookie = cookie
-		case "user_email":
-			userEmailCookie = cookie
+		case "session":
+			clearedSessionCookie = cookie
 		case "flash_message":
 			flashMessageCookie = cookie
 		case "flash_type":
@@ -599,14 +628,14 @@ func TestLogout(t *testing.T) {
 		}
 	}
 
-	// Check that login cookies were cleared
-	assert.NotNil(t, isLoggedInCookie, "is_logged_in cookie should be present")
-	assert.Equal(t, "", isLoggedInCookie.Value, "is_logged_in cookie should be empty")
-	assert.True(t, isLoggedInCookie.MaxAge < 0, "is_logged_in cookie should have negative MaxAge")
+	// Check that the session was ended and its cookie cleared
+	assert.NotNil(t, clearedSessionCookie, "session cookie should be present")
+	assert.Equal(t, "", clearedSessionCookie.Value, "session cookie should be empty")
+	assert.True(t, clearedSessionCookie.MaxAge < 0, "session cookie should have negative MaxAge")
 
-	assert.NotNil(t, userEmailCookie, "user_email cookie should be present")
-	assert.Equal(t, "", userEmailCookie.Value, "user_email cookie should be empty")
-	assert.True(t, userEmailCookie.MaxAge < 0, "user_email cookie should have negative MaxAge")
+	sessions, err := authController.Auth.ListSessions(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions, "the login session should be ended")
 
 	// Check that flash message cookies were set
 	assert.NotNil(t, flashMessageCookie, "flash_message cookie should be present")
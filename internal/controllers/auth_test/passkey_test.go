@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/passkey"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const passkeyOrigin = "http://localhost:8080"
+
+// setupPasskeyRouter sets up the passkey login routes with session tracking
+func setupPasskeyRouter(t *testing.T) (*gin.Engine, *gorm.DB, *auth.Auth) {
+	router, db, _, _ := SetupTestRouter(t)
+	database.TestDB = db
+
+	authInstance := auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+	authController := controllers.NewAuthController(authInstance, nil, &config.Config{AppBaseURL: passkeyOrigin})
+	router.POST("/login/passkey/begin", authController.BeginPasskeyLogin)
+	router.POST("/login/passkey/finish", authController.FinishPasskeyLogin)
+	router.GET("/owner", authInstance.RequireAuth(), func(c *gin.Context) {
+		user, err := auth.GetCurrentUser(c)
+		require.NoError(t, err)
+		c.String(http.StatusOK, user.Email)
+	})
+	return router, db, authInstance
+}
+
+// registerPasskey gives the user a passkey held by a software authenticator
+func registerPasskey(t *testing.T, db *gorm.DB, user *models.User) *testutils.SoftwareAuthenticator {
+	service, err := passkey.NewService(db, passkeyOrigin)
+	require.NoError(t, err)
+	authenticator, err := testutils.NewSoftwareAuthenticator(passkeyOrigin)
+	require.NoError(t, err)
+
+	creation, token, err := service.BeginRegistration(user, time.Now())
+	require.NoError(t, err)
+	body, err := authenticator.Register(creation.Response.Challenge.String(), passkey.UserHandle(user.ID))
+	require.NoError(t, err)
+	_, err = service.FinishRegistration(user, token, "Test Key", bytes.NewReader(body), time.Now())
+	require.NoError(t, err)
+	return authenticator
+}
+
+// beginPasskeyLogin asks for login options, returning the challenge and the browser's cookies
+func beginPasskeyLogin(t *testing.T, router *gin.Engine) (string, []*http.Cookie) {
+	req, w := CreateTestRequest("POST", "/login/passkey/begin", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	require.NotEmpty(t, options.PublicKey.Challenge)
+	return options.PublicKey.Challenge, w.Result().Cookies()
+}
+
+// finishPasskeyLogin sends the authenticator's answer with the browser's cookies
+func finishPasskeyLogin(router *gin.Engine, body []byte, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req, w := CreateTestRequest("POST", "/login/passkey/finish", string(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	router, db, authInstance := setupPasskeyRouter(t)
+	defer CleanupTest(db)
+
+	// Passkeys already count as two factors, so a user with two-factor authentication isn't asked for a code
+	user := createTwoFactorUser(t, db, "passkey@example.com", false)
+	require.NoError(t, db.Model(user).Update("totp_enabled", true).Error)
+	authenticator := registerPasskey(t, db, user)
+
+	t.Run("signs the passkey's owner in", func(t *testing.T) {
+		challenge, cookies := beginPasskeyLogin(t, router)
+		body, err := authenticator.Login(challenge)
+		require.NoError(t, err)
+
+		w := finishPasskeyLogin(router, body, cookies)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"redirect": "/owner"}`, w.Body.String())
+		sessionID, ok := cookieValue(w, "session")
+		require.True(t, ok)
+
+		sessions, err := authInstance.ListSessions(user.ID)
+		require.NoError(t, err)
+		assert.Len(t, sessions, 1)
+
+		// The session cookie is all the browser needs to be recognised as the user
+		req, owner := CreateTestRequest("GET", "/owner", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: sessionID})
+		router.ServeHTTP(owner, req)
+		assert.Equal(t, http.StatusOK, owner.Code)
+		assert.Equal(t, user.Email, owner.Body.String())
+
+		// The same answer can't be used again
+		w = finishPasskeyLogin(router, body, cookies)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("rejects an answer without a login in progress", func(t *testing.T) {
+		challenge, _ := beginPasskeyLogin(t, router)
+		body, err := authenticator.Login(challenge)
+		require.NoError(t, err)
+
+		w := finishPasskeyLogin(router, body, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		_, ok := cookieValue(w, "session")
+		assert.False(t, ok)
+	})
+
+	t.Run("rejects a removed passkey", func(t *testing.T) {
+		require.NoError(t, db.Unscoped().Where("user_id = ?", user.ID).Delete(&models.PasskeyCredential{}).Error)
+		challenge, cookies := beginPasskeyLogin(t, router)
+		body, err := authenticator.Login(challenge)
+		require.NoError(t, err)
+
+		w := finishPasskeyLogin(router, body, cookies)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		_, ok := cookieValue(w, "session")
+		assert.False(t, ok)
+	})
+}
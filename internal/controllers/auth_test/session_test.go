@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginCookies signs the user in and returns the cookies the browser would keep
+func loginCookies(t *testing.T, router *gin.Engine, email, userAgent string) []*http.Cookie {
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "password123")
+	req, w := CreateFormRequest("POST", "/login", form)
+	req.Header.Set("User-Agent", userAgent)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	var cookies []*http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge >= 0 && cookie.Value != "" {
+			cookies = append(cookies, cookie)
+		}
+	}
+	return cookies
+}
+
+func TestLoginSessions(t *testing.T) {
+	// Setup
+	router, db, authController, _ := SetupTestRouter(t)
+	defer CleanupTest(db)
+	database.TestDB = db
+
+	authController.Auth = auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+	router.POST("/login", authController.ProcessLogin)
+	router.POST("/reset-password/:token", authController.ProcessResetPassword)
+	router.GET("/owner", authController.Auth.RequireAuth(), func(c *gin.Context) {
+		c.String(http.StatusOK, "armory")
+	})
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := models.User{Email: "sessions@example.com", Password: string(hashedPassword), Confirmed: true}
+	require.NoError(t, db.Create(&user).Error)
+
+	// Each login gets its own session
+	laptop := loginCookies(t, router, user.Email, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0 Safari/537.36")
+	phone := loginCookies(t, router, user.Email, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Safari/604.1")
+	sessions, err := authController.Auth.ListSessions(user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	devices := []string{sessions[0].Device(), sessions[1].Device()}
+	assert.ElementsMatch(t, []string{"Chrome on Windows", "Safari on iPhone"}, devices)
+
+	visit := func(cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/owner", nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, visit(laptop).Code)
+	assert.Equal(t, http.StatusOK, visit(phone).Code)
+
+	// Resetting the password from the laptop signs the phone out
+	token, err := auth.IssueRecoverToken(&user, time.Now())
+	require.NoError(t, err)
+	user.RememberToken = "remembered"
+	require.NoError(t, db.Save(&user).Error)
+	form := url.Values{}
+	form.Add("password", "newpassword123")
+	form.Add("confirm_password", "newpassword123")
+	req, w := CreateFormRequest("POST", "/reset-password/"+token, form)
+	for _, cookie := range laptop {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	assert.Equal(t, http.StatusOK, visit(laptop).Code)
+	w = visit(phone)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Empty(t, updated.RememberToken)
+	sessions, err = authController.Auth.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	// Cookies naming a user without a session don't sign anyone in
+	w = visit([]*http.Cookie{
+		{Name: "is_logged_in", Value: "true"},
+		{Name: "user_email", Value: user.Email},
+	})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	// Nor does dropping the session cookie of a revoked session
+	w = visit(withoutSession(phone))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+// withoutSession returns the cookies without the session cookie
+func withoutSession(cookies []*http.Cookie) []*http.Cookie {
+	var kept []*http.Cookie
+	for _, cookie := range cookies {
+		if cookie.Name != "session" {
+			kept = append(kept, cookie)
+		}
+	}
+	return kept
+}
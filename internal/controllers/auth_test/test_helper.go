@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
@@ -60,7 +61,7 @@ func SetupTestRouter(t *testing.T) (*gin.Engine, *gorm.DB, *controllers.AuthCont
 
 	// Create the auth controller with the mock auth service
 	authController := &controllers.AuthController{
-		Auth: &auth.Auth{}, // We'll use a minimal Auth struct
+		Auth: auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour), // Sessions are kept in memory
 	}
 
 	return router, db, authController, mockAuth
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/twofactor"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// setupTwoFactorRouter sets up the login routes with session tracking
+func setupTwoFactorRouter(t *testing.T) (*gin.Engine, *gorm.DB, *auth.Auth) {
+	router, db, authController, _ := SetupTestRouter(t)
+	database.TestDB = db
+
+	authController.Auth = auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+	router.POST("/login", authController.ProcessLogin)
+	router.GET("/login/two-factor", authController.TwoFactorLogin)
+	router.POST("/login/two-factor", authController.ProcessTwoFactorLogin)
+	router.POST("/reactivate", authController.ProcessReactivation)
+	return router, db, authController.Auth
+}
+
+// createTwoFactorUser creates a confirmed user with the password "password123"
+func createTwoFactorUser(t *testing.T, db *gorm.DB, email string, isAdmin bool) *models.User {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	user := &models.User{Email: email, Password: string(hashedPassword), Confirmed: true, IsAdmin: isAdmin}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
+
+// postTwoFactor submits a form with the browser's cookies
+func postTwoFactor(router *gin.Engine, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req, w := CreateFormRequest("POST", path, form)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// cookieValue returns the value the response set for a cookie
+func cookieValue(w *httptest.ResponseRecorder, name string) (string, bool) {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	router, db, authInstance := setupTwoFactorRouter(t)
+	defer CleanupTest(db)
+
+	user := createTwoFactorUser(t, db, "totp@example.com", false)
+	service := twofactor.NewService(db)
+	require.NoError(t, service.BeginEnrollment(user))
+	code, err := totp.GenerateCode(user.TOTPSecret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	recoveryCodes, err := service.Enable(user, code, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+
+	login := func() []*http.Cookie {
+		w := postTwoFactor(router, "/login", url.Values{"email": {user.Email}, "password": {"password123"}}, nil)
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		assert.Equal(t, "/login/two-factor", w.Header().Get("Location"))
+		_, loggedIn := cookieValue(w, "session")
+		assert.False(t, loggedIn, "the password alone shouldn't sign the user in")
+		return w.Result().Cookies()
+	}
+
+	// A wrong code doesn't sign the user in
+	pending := login()
+	w := postTwoFactor(router, "/login/two-factor", url.Values{"code": {"000000"}}, pending)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, loggedIn := cookieValue(w, "session")
+	assert.False(t, loggedIn)
+
+	// The code from the app does
+	code, err = totp.GenerateCode(user.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {code}}, pending)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+	_, loggedIn = cookieValue(w, "session")
+	assert.True(t, loggedIn)
+	sessions, err := authInstance.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 1)
+
+	// The finished login can't be reused
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {code}}, pending)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	// A recovery code works too
+	pending = login()
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {recoveryCodes[0]}}, pending)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// Too many wrong codes cancel the login
+	pending = login()
+	for i := 1; i < twofactor.MaxLoginAttempts; i++ {
+		w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {"000000"}}, pending)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {"000000"}}, pending)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {recoveryCodes[1]}}, pending)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+}
+
+func TestAdminTwoFactorRequiredAtLogin(t *testing.T) {
+	router, db, _ := setupTwoFactorRouter(t)
+	defer CleanupTest(db)
+
+	admin := createTwoFactorUser(t, db, "admin-2fa@example.com", true)
+	member := createTwoFactorUser(t, db, "member-2fa@example.com", false)
+	require.NoError(t, models.SetSetting(db, models.SettingRequireAdminTwoFactor, "true"))
+
+	// Users who aren't admins aren't affected
+	w := postTwoFactor(router, "/login", url.Values{"email": {member.Email}, "password": {"password123"}}, nil)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// An admin without two-factor authentication has to set it up to finish logging in
+	w = postTwoFactor(router, "/login", url.Values{"email": {admin.Email}, "password": {"password123"}}, nil)
+	assert.Equal(t, "/login/two-factor", w.Header().Get("Location"))
+	_, loggedIn := cookieValue(w, "session")
+	assert.False(t, loggedIn)
+	pending := w.Result().Cookies()
+
+	req, _ := http.NewRequest("GET", "/login/two-factor", nil)
+	for _, cookie := range pending {
+		req.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var enrolling models.User
+	require.NoError(t, db.First(&enrolling, admin.ID).Error)
+	require.NotEmpty(t, enrolling.TOTPSecret)
+	assert.False(t, enrolling.TOTPEnabled)
+
+	code, err := totp.GenerateCode(enrolling.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	w = postTwoFactor(router, "/login/two-factor", url.Values{"code": {code}}, pending)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, loggedIn = cookieValue(w, "session")
+	assert.True(t, loggedIn)
+
+	var enrolled models.User
+	require.NoError(t, db.First(&enrolled, admin.ID).Error)
+	assert.True(t, enrolled.TOTPEnabled)
+	remaining, err := models.CountUnusedRecoveryCodes(db, admin.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(twofactor.RecoveryCodeCount), remaining)
+
+	// Reactivating a deleted admin account goes through the same check
+	returning := createTwoFactorUser(t, db, "returning-admin@example.com", true)
+	require.NoError(t, db.Delete(returning).Error)
+	w = postTwoFactor(router, "/reactivate", url.Values{"email": {returning.Email}, "password": {"password123"}}, nil)
+	assert.Equal(t, "/login/two-factor", w.Header().Get("Location"))
+	_, loggedIn = cookieValue(w, "session")
+	assert.False(t, loggedIn)
+}
@@ -0,0 +1,482 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// MaxAdjustmentDays limits how far an admin can comp or extend a subscription in one go
+const MaxAdjustmentDays = 3650
+
+// BillingAdminController handles the admin pages for fixing a user's billing by hand
+type BillingAdminController struct {
+	DB       *gorm.DB
+	Provider billing.PaymentProvider
+}
+
+// NewBillingAdminController creates a new BillingAdminController
+func NewBillingAdminController(db *gorm.DB, provider billing.PaymentProvider) *BillingAdminController {
+	return &BillingAdminController{
+		DB:       db,
+		Provider: provider,
+	}
+}
+
+// Index searches users by email and lists the latest billing adjustments
+func (c *BillingAdminController) Index(ctx *gin.Context) {
+	query := strings.TrimSpace(ctx.Query("q"))
+
+	var users []models.User
+	if query != "" {
+		if err := c.DB.Where("email LIKE ?", "%"+query+"%").Order("email").Limit(50).Find(&users).Error; err != nil {
+			log.Printf("Error searching users: %v", err)
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to search users"})
+			return
+		}
+	}
+
+	adjustments, err := models.FindRecentBillingAdjustments(c.DB, 20)
+	if err != nil {
+		log.Printf("Error fetching billing adjustments: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch billing adjustments"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.BillingIndex(query, users, adjustments, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Show displays a user's subscription, payments and billing adjustments with the forms to change them
+func (c *BillingAdminController) Show(ctx *gin.Context) {
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+
+	payments, err := models.GetPaymentsByUserID(c.DB, user.ID)
+	if err != nil {
+		log.Printf("Error fetching payments for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	adjustments, err := models.FindBillingAdjustmentsByUser(c.DB, user.ID)
+	if err != nil {
+		log.Printf("Error fetching billing adjustments for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch billing adjustments"})
+		return
+	}
+
+	history, err := models.FindSubscriptionChangesByUser(c.DB, user.ID)
+	if err != nil {
+		log.Printf("Error fetching subscription history for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch subscription history"})
+		return
+	}
+
+	// Only recurring plans can be comped, lifetime plans never expire
+	plans, err := models.FindActivePlans(c.DB)
+	if err != nil {
+		log.Printf("Error fetching plans: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch plans"})
+		return
+	}
+	var compPlans []models.Plan
+	for _, plan := range plans {
+		if !plan.IsFree() && plan.IsRecurring() {
+			compPlans = append(compPlans, plan)
+		}
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.BillingShow(user, payments, adjustments, history, compPlans, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Refund refunds all or part of a payment with the payment provider
+func (c *BillingAdminController) Refund(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Payment not found"})
+		return
+	}
+	payment, err := models.FindPaymentByID(c.DB, uint(id))
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Payment not found"})
+		return
+	}
+	userPath := fmt.Sprintf("/admin/billing/users/%d", payment.UserID)
+
+	adminUser, reason, ok := c.adjustmentAuthor(ctx, userPath)
+	if !ok {
+		return
+	}
+
+	// A blank amount refunds whatever is left of the payment
+	refundable := payment.RefundableAmount()
+	amount := refundable
+	if value := strings.TrimSpace(ctx.PostForm("amount")); value != "" {
+		amount, err = parseCents(value)
+		if err != nil {
+			flash.SetMessage(ctx, "Refund amount must be a dollar amount", "error")
+			ctx.Redirect(http.StatusSeeOther, userPath)
+			return
+		}
+	}
+	if refundable <= 0 {
+		flash.SetMessage(ctx, "This payment has nothing left to refund", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+	if amount <= 0 || amount > refundable {
+		remaining := models.Payment{Amount: refundable, Currency: payment.Currency}
+		flash.SetMessage(ctx, "Refund amount must be more than zero and no more than "+remaining.FormatAmount(), "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// Reserve the amount before asking the provider, so two admins can't both refund what's left
+	idempotencyKey := fmt.Sprintf("refund-%d-%d-%d", payment.ID, payment.Refunded, amount)
+	reserved, err := models.ReserveRefund(c.DB, payment, amount)
+	if err != nil {
+		log.Printf("Error reserving refund of payment %d: %v", payment.ID, err)
+		flash.SetMessage(ctx, "Failed to refund payment", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+	if !reserved {
+		flash.SetMessage(ctx, "This payment changed while you were refunding it, please check it and try again", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	refundID, err := c.Provider.RefundPayment(payment.StripeID, amount, idempotencyKey)
+	if err != nil {
+		log.Printf("Error refunding payment %d: %v", payment.ID, err)
+		if releaseErr := models.ReleaseRefund(c.DB, payment, amount); releaseErr != nil {
+			log.Printf("ERROR: couldn't release refund of %d reserved on payment %d: %v", amount, payment.ID, releaseErr)
+		}
+		flash.SetMessage(ctx, "The payment provider couldn't refund this payment: "+err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// The money has moved and is already counted against the payment, so record the rest even if it's the last step to fail
+	adjustment := models.BillingAdjustment{
+		UserID:    payment.UserID,
+		AdminID:   adminUser.ID,
+		Action:    models.AdjustmentRefund,
+		Reason:    reason,
+		PaymentID: &payment.ID,
+		Amount:    amount,
+		Currency:  payment.Currency,
+		StripeID:  refundID,
+	}
+	if err := c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := models.MarkRefundedIfFull(tx, payment); err != nil {
+			return err
+		}
+		return models.CreateBillingAdjustment(tx, &adjustment)
+	}); err != nil {
+		log.Printf("ERROR: refund %s of payment %d succeeded but wasn't recorded: %v", refundID, payment.ID, err)
+		flash.SetMessage(ctx, "The refund was issued but couldn't be recorded. Refund ID: "+refundID, "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	log.Printf("Admin %d refunded %d of payment %d: %s", adminUser.ID, amount, payment.ID, reason)
+	flash.SetMessage(ctx, "Refunded "+adjustment.FormatAmount(), "success")
+	ctx.Redirect(http.StatusSeeOther, userPath)
+}
+
+// Comp gives a user a recurring plan for a number of days without charging them
+func (c *BillingAdminController) Comp(ctx *gin.Context) {
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	adminUser, reason, ok := c.adjustmentAuthor(ctx, userPath)
+	if !ok {
+		return
+	}
+	days, ok := adjustmentDays(ctx, userPath)
+	if !ok {
+		return
+	}
+
+	plan, err := models.FindActivePlanByTier(c.DB, ctx.PostForm("tier"))
+	if err != nil || plan.IsFree() || !plan.IsRecurring() {
+		flash.SetMessage(ctx, "Choose a recurring plan to comp", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// A paying subscriber would keep being billed, and lifetime plans already include everything
+	if user.StripeSubscriptionID != "" {
+		flash.SetMessage(ctx, "This user has a paid subscription. Extend or revoke it instead.", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+	if user.IsLifetimeSubscriber() {
+		flash.SetMessage(ctx, "This user already has a lifetime plan", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// Comping the plan a user already has adds to the time they have left
+	start := time.Now()
+	if user.SubscriptionTier == plan.Tier && user.SubscriptionExpiresAt.After(start) {
+		start = user.SubscriptionExpiresAt
+	}
+	expiresAt := start.AddDate(0, 0, days)
+
+	adjustment := newAdjustment(user, adminUser, models.AdjustmentComp, reason)
+	adjustment.Days = days
+	adjustment.NewTier = plan.Tier
+	adjustment.NewExpiresAt = &expiresAt
+
+	if err := c.applyAdjustment(user, &adjustment, map[string]interface{}{
+		"subscription_tier":       plan.Tier,
+		"subscription_expires_at": expiresAt,
+		"subscription_canceled":   true, // Comped plans don't renew
+		"payment_failed_at":       nil,
+		"grace_period_ends_at":    nil,
+		"dunning_reminders_sent":  0,
+	}); err != nil {
+		log.Printf("Error comping user %d: %v", user.ID, err)
+		flash.SetMessage(ctx, "Failed to comp the plan", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	log.Printf("Admin %d comped user %d %s for %d days: %s", adminUser.ID, user.ID, plan.Tier, days, reason)
+	flash.SetMessage(ctx, fmt.Sprintf("Comped %s until %s", plan.Name, expiresAt.Format("January 2, 2006")), "success")
+	ctx.Redirect(http.StatusSeeOther, userPath)
+}
+
+// Extend adds days to a user's subscription, moving their next renewal with the payment provider
+func (c *BillingAdminController) Extend(ctx *gin.Context) {
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	adminUser, reason, ok := c.adjustmentAuthor(ctx, userPath)
+	if !ok {
+		return
+	}
+	days, ok := adjustmentDays(ctx, userPath)
+	if !ok {
+		return
+	}
+
+	if user.SubscriptionTier == models.TierFree || user.IsLifetimeSubscriber() {
+		flash.SetMessage(ctx, "Only recurring subscriptions can be extended", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// Lapsed subscriptions are extended from today
+	start := time.Now()
+	if user.SubscriptionExpiresAt.After(start) {
+		start = user.SubscriptionExpiresAt
+	}
+	expiresAt := start.AddDate(0, 0, days)
+
+	// Stripe would otherwise bill on the old renewal date
+	if user.StripeSubscriptionID != "" {
+		if err := c.Provider.ExtendSubscription(user.StripeSubscriptionID, expiresAt); err != nil {
+			log.Printf("Error extending subscription %s for user %d: %v", user.StripeSubscriptionID, user.ID, err)
+			flash.SetMessage(ctx, "The payment provider couldn't extend this subscription: "+err.Error(), "error")
+			ctx.Redirect(http.StatusSeeOther, userPath)
+			return
+		}
+	}
+
+	adjustment := newAdjustment(user, adminUser, models.AdjustmentExtend, reason)
+	adjustment.Days = days
+	adjustment.NewTier = user.SubscriptionTier
+	adjustment.NewExpiresAt = &expiresAt
+
+	if err := c.applyAdjustment(user, &adjustment, map[string]interface{}{
+		"subscription_expires_at": expiresAt,
+	}); err != nil {
+		log.Printf("Error extending subscription for user %d: %v", user.ID, err)
+		flash.SetMessage(ctx, "Failed to extend the subscription", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	log.Printf("Admin %d extended user %d by %d days: %s", adminUser.ID, user.ID, days, reason)
+	flash.SetMessage(ctx, "Subscription extended until "+expiresAt.Format("January 2, 2006"), "success")
+	ctx.Redirect(http.StatusSeeOther, userPath)
+}
+
+// Revoke ends a user's subscription now and moves them to the free tier
+func (c *BillingAdminController) Revoke(ctx *gin.Context) {
+	user, ok := c.findUser(ctx)
+	if !ok {
+		return
+	}
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	adminUser, reason, ok := c.adjustmentAuthor(ctx, userPath)
+	if !ok {
+		return
+	}
+
+	if user.SubscriptionTier == models.TierFree {
+		flash.SetMessage(ctx, "This user is already on the free tier", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	// Stop billing first so the user is never charged for a plan they no longer have
+	if user.StripeSubscriptionID != "" {
+		err := c.Provider.CancelSubscription(user.StripeSubscriptionID)
+		if err != nil && !errors.Is(err, billing.ErrSubscriptionNotFound) {
+			log.Printf("Error canceling subscription %s for user %d: %v", user.StripeSubscriptionID, user.ID, err)
+			flash.SetMessage(ctx, "The payment provider couldn't cancel this subscription: "+err.Error(), "error")
+			ctx.Redirect(http.StatusSeeOther, userPath)
+			return
+		}
+	}
+
+	now := time.Now()
+	adjustment := newAdjustment(user, adminUser, models.AdjustmentRevoke, reason)
+	adjustment.NewTier = models.TierFree
+	adjustment.NewExpiresAt = &now
+
+	if err := c.applyAdjustment(user, &adjustment, map[string]interface{}{
+		"subscription_tier":       models.TierFree,
+		"subscription_expires_at": now,
+		"stripe_subscription_id":  "",
+		"subscription_canceled":   false,
+		"payment_failed_at":       nil,
+		"grace_period_ends_at":    nil,
+		"dunning_reminders_sent":  0,
+	}); err != nil {
+		log.Printf("Error revoking subscription for user %d: %v", user.ID, err)
+		flash.SetMessage(ctx, "Failed to revoke the subscription", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return
+	}
+
+	log.Printf("Admin %d revoked the subscription of user %d: %s", adminUser.ID, user.ID, reason)
+	flash.SetMessage(ctx, "Subscription revoked", "success")
+	ctx.Redirect(http.StatusSeeOther, userPath)
+}
+
+// findUser loads the user in the URL, writing a not found page if there isn't one
+func (c *BillingAdminController) findUser(ctx *gin.Context) (*models.User, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "User not found"})
+		return nil, false
+	}
+	var user models.User
+	if err := c.DB.First(&user, id).Error; err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "User not found"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// adjustmentAuthor returns the acting admin and the reason they gave.
+// Every adjustment needs a reason, so it redirects back to the user with an error if there isn't one.
+func (c *BillingAdminController) adjustmentAuthor(ctx *gin.Context, userPath string) (*models.User, string, bool) {
+	adminUser, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return nil, "", false
+	}
+
+	reason := strings.TrimSpace(ctx.PostForm("reason"))
+	if reason == "" {
+		flash.SetMessage(ctx, "A reason is required for every billing adjustment", "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return nil, "", false
+	}
+	return adminUser, reason, true
+}
+
+// adjustmentDays parses the number of days to comp or extend, redirecting back to the user if it isn't valid
+func adjustmentDays(ctx *gin.Context, userPath string) (int, bool) {
+	days, err := strconv.Atoi(strings.TrimSpace(ctx.PostForm("days")))
+	if err != nil || days < 1 || days > MaxAdjustmentDays {
+		flash.SetMessage(ctx, fmt.Sprintf("Days must be between 1 and %d", MaxAdjustmentDays), "error")
+		ctx.Redirect(http.StatusSeeOther, userPath)
+		return 0, false
+	}
+	return days, true
+}
+
+// newAdjustment starts recording a change to a user's subscription, noting what they had before
+func newAdjustment(user, adminUser *models.User, action, reason string) models.BillingAdjustment {
+	previousExpiresAt := user.SubscriptionExpiresAt
+	return models.BillingAdjustment{
+		UserID:            user.ID,
+		AdminID:           adminUser.ID,
+		Action:            action,
+		Reason:            reason,
+		PreviousTier:      user.SubscriptionTier,
+		PreviousExpiresAt: &previousExpiresAt,
+	}
+}
+
+// applyAdjustment updates the user and records the adjustment together,
+// adding to the user's subscription history when their tier changes
+func (c *BillingAdminController) applyAdjustment(user *models.User, adjustment *models.BillingAdjustment, updates map[string]interface{}) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		if err := models.CreateBillingAdjustment(tx, adjustment); err != nil {
+			return err
+		}
+
+		tier, ok := updates["subscription_tier"].(string)
+		if !ok {
+			return nil
+		}
+		change := models.SubscriptionChange{
+			UserID:   user.ID,
+			FromTier: adjustment.PreviousTier,
+			ToTier:   tier,
+			Cause:    models.SubscriptionCauseAdmin,
+			Note:     adjustment.Reason,
+		}
+		if tier != models.TierFree {
+			change.ExpiresAt = adjustment.NewExpiresAt
+		}
+		return models.CreateSubscriptionChange(tx, &change)
+	})
+}
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// CouponController handles the admin pages for managing promo codes
+type CouponController struct {
+	DB       *gorm.DB
+	Provider billing.PaymentProvider
+}
+
+// NewCouponController creates a new CouponController
+func NewCouponController(db *gorm.DB, provider billing.PaymentProvider) *CouponController {
+	return &CouponController{
+		DB:       db,
+		Provider: provider,
+	}
+}
+
+// Index displays every coupon with how often it has been redeemed
+func (c *CouponController) Index(ctx *gin.Context) {
+	coupons, err := models.FindCoupons(c.DB)
+	if err != nil {
+		log.Printf("Error fetching coupons: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch coupons"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.CouponIndex(coupons, time.Now(), flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// New displays the form to create a coupon
+func (c *CouponController) New(ctx *gin.Context) {
+	coupon := models.Coupon{Currency: "usd", Duration: models.CouponDurationOnce}
+	component := admin.CouponNew(coupon, "")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create registers a coupon with the payment provider and saves it
+func (c *CouponController) Create(ctx *gin.Context) {
+	var coupon models.Coupon
+
+	// Validate the form and apply it to the coupon
+	if errorMessage := applyCouponForm(ctx, &coupon); errorMessage != "" {
+		ctx.Status(http.StatusBadRequest)
+		component := admin.CouponNew(coupon, errorMessage)
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
+	// Codes can't be reused, even once a coupon is retired
+	if _, err := models.FindCouponByCode(c.DB, coupon.Code); err == nil {
+		ctx.Status(http.StatusBadRequest)
+		component := admin.CouponNew(coupon, "A coupon with that code already exists")
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Error checking coupon code: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	// The provider applies the discount at checkout, so it needs its own copy of the coupon
+	stripeCouponID, err := c.Provider.CreateCoupon(&coupon)
+	if err != nil {
+		log.Printf("Error creating coupon with payment provider: %v", err)
+		ctx.Status(http.StatusBadGateway)
+		component := admin.CouponNew(coupon, "The payment provider rejected this coupon: "+err.Error())
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+	coupon.StripeCouponID = stripeCouponID
+	coupon.Active = true
+
+	if err := models.CreateCoupon(c.DB, &coupon); err != nil {
+		log.Printf("Error creating coupon: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create coupon"})
+		return
+	}
+
+	flash.SetMessage(ctx, "Coupon "+coupon.Code+" created", "success")
+	ctx.Redirect(http.StatusSeeOther, "/admin/coupons")
+}
+
+// Toggle retires an active coupon or brings a retired one back
+func (c *CouponController) Toggle(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Coupon not found"})
+		return
+	}
+	coupon, err := models.FindCouponByID(c.DB, uint(id))
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Coupon not found"})
+		return
+	}
+
+	coupon.Active = !coupon.Active
+	if err := models.UpdateCoupon(c.DB, coupon); err != nil {
+		log.Printf("Error updating coupon: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to update coupon"})
+		return
+	}
+
+	if coupon.Active {
+		flash.SetMessage(ctx, "Coupon "+coupon.Code+" reactivated", "success")
+	} else {
+		flash.SetMessage(ctx, "Coupon "+coupon.Code+" deactivated", "success")
+	}
+	ctx.Redirect(http.StatusSeeOther, "/admin/coupons")
+}
+
+// applyCouponForm copies the submitted form onto a coupon, returning a message if it isn't valid
+func applyCouponForm(ctx *gin.Context, coupon *models.Coupon) string {
+	coupon.Code = models.NormalizeCouponCode(ctx.PostForm("code"))
+	coupon.Description = strings.TrimSpace(ctx.PostForm("description"))
+	coupon.Currency = strings.ToLower(strings.TrimSpace(ctx.PostForm("currency")))
+	coupon.Duration = ctx.PostForm("duration")
+
+	if coupon.Code == "" {
+		return "Code is required"
+	}
+	if strings.ContainsAny(coupon.Code, " \t") {
+		return "Code can't contain spaces"
+	}
+	if coupon.Currency == "" {
+		coupon.Currency = "usd"
+	}
+
+	// A coupon takes either a percentage or a fixed amount off
+	if ctx.PostForm("discount_type") == "amount" {
+		amountOff, err := parseCents(ctx.PostForm("amount_off"))
+		if err != nil || amountOff <= 0 {
+			return "Amount off must be a dollar amount"
+		}
+		coupon.AmountOff = amountOff
+	} else {
+		percentOff, err := strconv.Atoi(strings.TrimSpace(ctx.PostForm("percent_off")))
+		if err != nil || percentOff < 1 || percentOff > 100 {
+			return "Percent off must be between 1 and 100"
+		}
+		coupon.PercentOff = percentOff
+	}
+
+	switch coupon.Duration {
+	case models.CouponDurationOnce, models.CouponDurationForever:
+	case models.CouponDurationRepeating:
+		months, err := strconv.Atoi(strings.TrimSpace(ctx.PostForm("duration_in_months")))
+		if err != nil || months < 1 {
+			return "Repeating discounts need a number of months"
+		}
+		coupon.DurationInMonths = months
+	default:
+		return "Duration must be once, repeating or forever"
+	}
+
+	if value := strings.TrimSpace(ctx.PostForm("max_redemptions")); value != "" {
+		maxRedemptions, err := strconv.Atoi(value)
+		if err != nil || maxRedemptions < 0 {
+			return "Redemption limit must be a whole number, or blank for unlimited"
+		}
+		coupon.MaxRedemptions = maxRedemptions
+	}
+
+	// The coupon stays valid through the end of the chosen day
+	if value := strings.TrimSpace(ctx.PostForm("expires_at")); value != "" {
+		day, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return "Expiry must be a date"
+		}
+		expiresAt := day.AddDate(0, 0, 1)
+		if !expiresAt.After(time.Now()) {
+			return "Expiry must be in the future"
+		}
+		coupon.ExpiresAt = &expiresAt
+	}
+
+	return ""
+}
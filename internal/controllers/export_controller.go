@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	userviews "github.com/hail2skins/the-virtual-armory/cmd/web/views/user"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/export"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ExportController handles personal data exports
+type ExportController struct {
+	DB       *gorm.DB
+	Storage  storage.Storage // Optional, every export is generated immediately without it
+	Exporter *export.Exporter
+}
+
+// NewExportController creates a new ExportController
+func NewExportController(db *gorm.DB, store storage.Storage) *ExportController {
+	return &ExportController{
+		DB:       db,
+		Storage:  store,
+		Exporter: export.NewExporter(db, store),
+	}
+}
+
+// Create downloads the user's data, or queues it to be emailed if the export is large
+func (c *ExportController) Create(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Large exports are generated in the background when there's somewhere to keep them
+	large := false
+	if c.Storage != nil {
+		large, err = c.Exporter.IsLarge(user.ID)
+		if err != nil {
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to prepare export"})
+			return
+		}
+	}
+
+	if large {
+		// Only queue one export at a time
+		if _, err := models.FindPendingDataExport(c.DB, user.ID); err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to prepare export"})
+				return
+			}
+
+			token, err := generateToken(32)
+			if err != nil {
+				ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to prepare export"})
+				return
+			}
+
+			dataExport := models.DataExport{
+				UserID: user.ID,
+				Status: models.DataExportStatusPending,
+				Token:  token,
+			}
+			if err := models.CreateDataExport(c.DB, &dataExport); err != nil {
+				ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to prepare export"})
+				return
+			}
+		}
+
+		// Render the queued template
+		component := userviews.ExportQueued(*user, export.LinkTTL)
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
+	// Stream the archive straight to the browser
+	now := time.Now()
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", exportDisposition(now))
+	if err := c.Exporter.Write(ctx.Request.Context(), ctx.Writer, user, now); err != nil {
+		log.Printf("Failed to export data for user %d: %v", user.ID, err)
+		if !ctx.Writer.Written() {
+			ctx.Writer.Header().Del("Content-Disposition")
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to generate export"})
+		}
+	}
+}
+
+// Download streams an export generated in the background using the emailed link
+func (c *ExportController) Download(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Find the export, which must belong to the user and still be available
+	dataExport, err := models.FindDataExportByToken(c.DB, ctx.Param("token"), user.ID)
+	if err != nil || c.Storage == nil || dataExport.Status != models.DataExportStatusReady || dataExport.IsExpired(time.Now()) {
+		ctx.String(http.StatusNotFound, "This download link is invalid or has expired. Please request a new export from your profile.")
+		return
+	}
+
+	// Open the stored archive
+	reader, err := c.Storage.Get(ctx.Request.Context(), dataExport.StorageKey)
+	if err != nil {
+		log.Printf("Failed to open data export %d: %v", dataExport.ID, err)
+		ctx.String(http.StatusNotFound, "This download link is invalid or has expired. Please request a new export from your profile.")
+		return
+	}
+	defer reader.Close()
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", exportDisposition(dataExport.CreatedAt))
+	ctx.Header("Content-Length", fmt.Sprint(dataExport.Size))
+	if _, err := io.Copy(ctx.Writer, reader); err != nil {
+		log.Printf("Failed to send data export %d: %v", dataExport.ID, err)
+	}
+}
+
+// exportDisposition returns the Content-Disposition header for an export archive
+func exportDisposition(generated time.Time) string {
+	return fmt.Sprintf(`attachment; filename="virtual-armory-export-%s.zip"`, generated.Format("2006-01-02"))
+}
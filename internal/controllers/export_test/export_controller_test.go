@@ -0,0 +1,153 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/export"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Counter for generating unique emails
+var emailCounter = 0
+
+// setupExportTest sets up the test environment for export tests
+func setupExportTest(t *testing.T) (*gin.Engine, *models.User, storage.Storage) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	emailCounter++
+	email := fmt.Sprintf("export%d@example.com", emailCounter)
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	require.NoError(t, err)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Create a test router with the export routes
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	exportController := controllers.NewExportController(db, store)
+	router := gin.Default()
+	router.POST("/profile/export", exportController.Create)
+	router.GET("/profile/export/:token", exportController.Download)
+
+	return router, user, store
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+func TestCreateExportDownloadsSmallArchive(t *testing.T) {
+	router, user, _ := setupExportTest(t)
+	defer cleanup()
+
+	require.NoError(t, models.CreateGun(database.DB, &models.Gun{Name: "Small Export Gun", OwnerID: user.ID}))
+
+	req, _ := http.NewRequest("POST", "/profile/export", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/zip", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "attachment")
+
+	reader, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	require.NoError(t, err)
+	assert.NotEmpty(t, reader.File)
+
+	// Nothing is queued for a small export
+	var count int64
+	database.DB.Model(&models.DataExport{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestCreateExportQueuesLargeArchive(t *testing.T) {
+	router, user, _ := setupExportTest(t)
+	defer cleanup()
+
+	gun := models.Gun{Name: "Large Export Gun", OwnerID: user.ID}
+	require.NoError(t, models.CreateGun(database.DB, &gun))
+	require.NoError(t, models.CreateAttachment(database.DB, &models.Attachment{
+		GunID: gun.ID, OwnerID: user.ID, Kind: models.AttachmentKindPhoto, FileName: "big.jpg", StorageKey: "big.jpg", Size: export.LargeExportSize + 1,
+	}))
+
+	// Requesting twice only queues one export
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/profile/export", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotEqual(t, "application/zip", resp.Header().Get("Content-Type"))
+	}
+
+	var exports []models.DataExport
+	require.NoError(t, database.DB.Where("user_id = ?", user.ID).Find(&exports).Error)
+	require.Len(t, exports, 1)
+	assert.Equal(t, models.DataExportStatusPending, exports[0].Status)
+	assert.Len(t, exports[0].Token, 64)
+}
+
+func TestDownloadExport(t *testing.T) {
+	router, user, store := setupExportTest(t)
+	defer cleanup()
+
+	key := fmt.Sprintf("exports/%d/archive.zip", user.ID)
+	require.NoError(t, store.Put(context.Background(), key, strings.NewReader("zip data"), 8, "application/zip"))
+
+	expiresAt := time.Now().Add(time.Hour)
+	ready := models.DataExport{UserID: user.ID, Status: models.DataExportStatusReady, Token: fmt.Sprintf("ready-%d", user.ID), StorageKey: key, Size: 8, ExpiresAt: &expiresAt}
+	require.NoError(t, models.CreateDataExport(database.DB, &ready))
+
+	expiredAt := time.Now().Add(-time.Hour)
+	expired := models.DataExport{UserID: user.ID, Status: models.DataExportStatusReady, Token: fmt.Sprintf("expired-%d", user.ID), StorageKey: key, Size: 8, ExpiresAt: &expiredAt}
+	require.NoError(t, models.CreateDataExport(database.DB, &expired))
+
+	// The owner can download a ready export
+	req, _ := http.NewRequest("GET", "/profile/export/"+ready.Token, nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "zip data", resp.Body.String())
+
+	// Expired and unknown links don't work
+	for _, token := range []string{expired.Token, "unknown"} {
+		req, _ = http.NewRequest("GET", "/profile/export/"+token, nil)
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	}
+
+	// Someone else can't use the owner's link
+	other, err := testutils.CreateTestUser(database.DB, fmt.Sprintf("export-other%d@example.com", emailCounter), "password123", false)
+	require.NoError(t, err)
+	auth.MockUser = other
+
+	req, _ = http.NewRequest("GET", "/profile/export/"+ready.Token, nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
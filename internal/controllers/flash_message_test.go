@@ -56,15 +56,10 @@ func TestLogoutFlashMessage(t *testing.T) {
 	// Create a request to logout
 	req, _ := http.NewRequest("GET", "/logout", nil)
 
-	// Set cookies to simulate a logged-in user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: "test@example.com",
-	})
+	// Sign the user in
+	sessionCookie, err := authInstance.SessionCookie(&user)
+	assert.NoError(t, err)
+	req.AddCookie(sessionCookie)
 
 	// Perform the logout request
 	w := httptest.NewRecorder()
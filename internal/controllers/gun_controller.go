@@ -1,14 +1,17 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/cmd/web/views/gun"
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
 	"github.com/hail2skins/the-virtual-armory/internal/flash"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"gorm.io/gorm"
@@ -36,22 +39,14 @@ func (c *GunController) Index(ctx *gin.Context) {
 	}
 
 	// Get all guns for the current user
-	var guns []models.Gun
-	if err := c.DB.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").Where("owner_id = ?", user.ID).Find(&guns).Error; err != nil {
+	guns, err := models.FindGunsByOwner(c.DB, user.ID)
+	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get guns"})
 		return
 	}
 
-	// Check if the user has more guns than they can view
-	if !user.HasActiveSubscription() && len(guns) > 2 {
-		// Set the flag to show the limited view message
-		if len(guns) > 0 {
-			guns[0].HasMoreGuns = true
-			guns[0].TotalGuns = len(guns)
-		}
-		// Limit to only 2 guns
-		guns = guns[:2]
-	}
+	// Only show as many guns as the user's plan includes
+	guns = entitlements.VisibleGuns(user, guns)
 
 	// Get flash messages from cookies
 	flashMessage, _ := ctx.Cookie("flash_message")
@@ -88,6 +83,34 @@ func (c *GunController) Show(ctx *gin.Context) {
 		return
 	}
 
+	// Get the ammo on hand that matches the gun's caliber
+	ammoItems, err := models.FindAmmoByCaliber(c.DB, user.ID, gunItem.CaliberID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get ammo"})
+		return
+	}
+
+	// Get the lifetime round count and last fired date
+	rangeStats, err := models.FindGunRangeStats(c.DB, gunItem.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get range history"})
+		return
+	}
+
+	// Get the photos and documents attached to the gun
+	attachments, err := models.FindAttachmentsByGun(c.DB, gunItem.ID, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get attachments"})
+		return
+	}
+
+	// Get the owner's storage usage
+	storageUsed, err := models.GetStorageUsed(c.DB, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get storage usage"})
+		return
+	}
+
 	// Get flash messages from cookies
 	flashMessage, _ := ctx.Cookie("flash_message")
 	flashType, _ := ctx.Cookie("flash_type")
@@ -96,7 +119,7 @@ func (c *GunController) Show(ctx *gin.Context) {
 	flash.ClearMessage(ctx)
 
 	// Render the show template with empty flash messages if none exist
-	component := gun.Show(*gunItem, flashMessage, flashType)
+	component := gun.Show(*gunItem, ammoItems, rangeStats, attachments, storageUsed, entitlements.For(user).MaxAttachmentBytes, flashMessage, flashType)
 	component.Render(ctx.Request.Context(), ctx.Writer)
 }
 
@@ -165,17 +188,29 @@ func (c *GunController) Create(ctx *gin.Context) {
 		return
 	}
 
-	// Check if the user is on the free tier and already has 2 guns
-	if user.SubscriptionTier == "free" {
-		var count int64
-		c.DB.Model(&models.Gun{}).Where("owner_id = ?", user.ID).Count(&count)
-
-		// If the user already has 2 guns, redirect to the pricing page
-		if count >= 2 {
-			flash.SetMessage(ctx, "You've reached the limit of 2 guns for the free tier. Please upgrade your subscription to add more guns.", "error")
-			ctx.Redirect(http.StatusSeeOther, "/pricing")
+	// Check the user's plan has room for another gun
+	if err := entitlements.CheckGuns(c.DB, user, 1); err != nil {
+		if !errors.Is(err, entitlements.ErrLimitReached) {
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to check gun limit"})
 			return
 		}
+		flash.SetMessage(ctx, err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, "/pricing")
+		return
+	}
+
+	// Parse the purchase price
+	purchasePrice, err := parseCents(ctx.PostForm("purchase_price"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid purchase price"})
+		return
+	}
+
+	// Validate the condition grade
+	condition := ctx.PostForm("condition")
+	if !models.IsValidGunCondition(condition) {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid condition"})
+		return
 	}
 
 	// Create the gun object
@@ -185,6 +220,9 @@ func (c *GunController) Create(ctx *gin.Context) {
 		CaliberID:      uint(caliberID),
 		ManufacturerID: uint(manufacturerID),
 		OwnerID:        user.ID,
+		PurchasePrice:  purchasePrice,
+		PurchaseSource: strings.TrimSpace(ctx.PostForm("purchase_source")),
+		Condition:      condition,
 	}
 
 	// Parse and set the acquired date if provided
@@ -307,11 +345,28 @@ func (c *GunController) Update(ctx *gin.Context) {
 		return
 	}
 
+	// Parse the purchase price
+	purchasePrice, err := parseCents(ctx.PostForm("purchase_price"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid purchase price"})
+		return
+	}
+
+	// Validate the condition grade
+	condition := ctx.PostForm("condition")
+	if !models.IsValidGunCondition(condition) {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid condition"})
+		return
+	}
+
 	// Update the gun
 	gunItem.Name = name
 	gunItem.WeaponTypeID = uint(weaponTypeID)
 	gunItem.CaliberID = uint(caliberID)
 	gunItem.ManufacturerID = uint(manufacturerID)
+	gunItem.PurchasePrice = purchasePrice
+	gunItem.PurchaseSource = strings.TrimSpace(ctx.PostForm("purchase_source"))
+	gunItem.Condition = condition
 
 	// Parse and set the acquired date if provided
 	if acquiredStr != "" {
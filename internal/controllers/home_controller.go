@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/cmd/web/views/home"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/flash"
 	"github.com/hail2skins/the-virtual-armory/internal/services/email"
 )
@@ -26,10 +27,8 @@ func NewHomeController(emailService email.EmailService) *HomeController {
 // Index handles the home page request
 func (h *HomeController) Index(c *gin.Context) {
 	// Check if user is logged in
-	isLoggedIn := false
-	if cookie, err := c.Cookie("is_logged_in"); err == nil && cookie == "true" {
-		isLoggedIn = true
-	}
+	_, err := auth.GetCurrentUser(c)
+	isLoggedIn := err == nil
 
 	// Get flash message from cookie
 	flashMessage, _ := c.Cookie("flash_message")
@@ -50,7 +49,7 @@ func (h *HomeController) Index(c *gin.Context) {
 		component = home.IndexWithFlash(isLoggedIn, flashMessage, flashType)
 	}
 
-	err := component.Render(c.Request.Context(), c.Writer)
+	err = component.Render(c.Request.Context(), c.Writer)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		log.Printf("Error rendering index page: %v", err)
@@ -66,11 +65,8 @@ func (h *HomeController) Index(c *gin.Context) {
 // About handles the about page request
 func (h *HomeController) About(c *gin.Context) {
 	// Check if user is logged in
-	isLoggedIn := false
-	cookie, err := c.Cookie("is_logged_in")
-	if err == nil && cookie == "true" {
-		isLoggedIn = true
-	}
+	_, err := auth.GetCurrentUser(c)
+	isLoggedIn := err == nil
 
 	component := home.About(isLoggedIn)
 	err = component.Render(c.Request.Context(), c.Writer)
@@ -84,11 +80,8 @@ func (h *HomeController) About(c *gin.Context) {
 // Contact handles the contact page request
 func (h *HomeController) Contact(c *gin.Context) {
 	// Check if user is logged in
-	isLoggedIn := false
-	cookie, err := c.Cookie("is_logged_in")
-	if err == nil && cookie == "true" {
-		isLoggedIn = true
-	}
+	_, err := auth.GetCurrentUser(c)
+	isLoggedIn := err == nil
 
 	component := home.Contact(isLoggedIn)
 	err = component.Render(c.Request.Context(), c.Writer)
@@ -121,11 +114,8 @@ func (h *HomeController) HandleHelloForm(c *gin.Context) {
 // HandleContactForm handles the contact form submission
 func (h *HomeController) HandleContactForm(c *gin.Context) {
 	// Check if user is logged in
-	isLoggedIn := false
-	cookie, err := c.Cookie("is_logged_in")
-	if err == nil && cookie == "true" {
-		isLoggedIn = true
-	}
+	_, err := auth.GetCurrentUser(c)
+	isLoggedIn := err == nil
 
 	// Get form data
 	name := c.PostForm("name")
@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -38,6 +40,36 @@ func (m *MockHomeEmailService) SendContactFormEmail(name, email, subject, messag
 	return args.Error(0)
 }
 
+// SendMaintenanceReminderEmail sends a maintenance reminder email
+func (m *MockHomeEmailService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	args := m.Called(email, overdue)
+	return args.Error(0)
+}
+
+// SendDataExportEmail mocks the SendDataExportEmail method
+func (m *MockHomeEmailService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	args := m.Called(email, token, expiresAt)
+	return args.Error(0)
+}
+
+// SendPaymentFailedEmail mocks the SendPaymentFailedEmail method
+func (m *MockHomeEmailService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	args := m.Called(email, reminder, graceEndsAt)
+	return args.Error(0)
+}
+
+// SendDataExportFailedEmail mocks the SendDataExportFailedEmail method
+func (m *MockHomeEmailService) SendDataExportFailedEmail(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+// SendReceiptEmail mocks the SendReceiptEmail method
+func (m *MockHomeEmailService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	args := m.Called(email, payment, pdf)
+	return args.Error(0)
+}
+
 func TestHomeController_Index(t *testing.T) {
 	// Set Gin to test mode
 	gin.SetMode(gin.TestMode)
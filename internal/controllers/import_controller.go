@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/gun"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/importer"
+	"gorm.io/gorm"
+)
+
+// ImportController handles importing a gun inventory from a CSV or JSON file
+type ImportController struct {
+	DB *gorm.DB
+}
+
+// NewImportController creates a new ImportController
+func NewImportController(db *gorm.DB) *ImportController {
+	return &ImportController{
+		DB: db,
+	}
+}
+
+// New displays the import upload form
+func (c *ImportController) New(ctx *gin.Context) {
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the upload template
+	component := gun.ImportUpload(flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Upload parses an uploaded file and displays the column mapping form
+func (c *ImportController) Upload(ctx *gin.Context) {
+	// Get the uploaded file
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		flash.SetMessage(ctx, "Please choose a CSV or JSON file to import", "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/guns/import")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, importer.MaxFileSize+1))
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	// Parse the file
+	table, err := importer.Parse(fileHeader.Filename, data)
+	if err != nil {
+		flash.SetMessage(ctx, "We couldn't read that file: "+err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/guns/import")
+		return
+	}
+
+	encoded, err := encodeImportTable(table)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to prepare import"})
+		return
+	}
+
+	// Render the mapping template with a best guess at the mapping
+	component := gun.ImportMapping(table, importer.GuessMapping(table.Headers), encoded, "")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Preview shows a dry run of the import with any per-row errors
+func (c *ImportController) Preview(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Build the preview from the submitted file and mapping
+	mapping, encoded, preview, ok := c.buildPreview(ctx, user)
+	if !ok {
+		return
+	}
+
+	// Render the preview template
+	component := gun.ImportPreview(mapping, encoded, preview, c.limitMessage(user, preview))
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Commit imports every row in a single transaction
+func (c *ImportController) Commit(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Rebuild the preview so the rows are validated against the current reference data
+	mapping, encoded, preview, ok := c.buildPreview(ctx, user)
+	if !ok {
+		return
+	}
+
+	// Save the guns
+	count, err := importer.Commit(c.DB, user, preview)
+	if err != nil {
+		var message string
+		switch {
+		case errors.Is(err, importer.ErrInvalidRows):
+			message = "Fix the rows with errors before importing."
+		case errors.Is(err, entitlements.ErrLimitReached):
+			message = err.Error()
+		default:
+			log.Printf("Failed to import guns for user %d: %v", user.ID, err)
+			message = "The import failed and no guns were added. Please try again."
+		}
+		component := gun.ImportPreview(mapping, encoded, preview, message)
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
+	// Redirect to the guns index page
+	flash.SetMessage(ctx, fmt.Sprintf("Imported %d guns successfully", count), "success")
+	ctx.Redirect(http.StatusSeeOther, "/owner/guns")
+}
+
+// Mapping redisplays the column mapping form for a file that has already been uploaded
+func (c *ImportController) Mapping(ctx *gin.Context) {
+	table, mapping, encoded, ok := c.readForm(ctx)
+	if !ok {
+		return
+	}
+
+	// Render the mapping template with the current mapping
+	component := gun.ImportMapping(table, mapping, encoded, "")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// readForm decodes the table and mapping carried between import steps, redirecting if they're missing
+func (c *ImportController) readForm(ctx *gin.Context) (*importer.Table, importer.Mapping, string, bool) {
+	// Decode the table carried over from the upload step
+	encoded := ctx.PostForm("data")
+	table, err := decodeImportTable(encoded)
+	if err != nil {
+		flash.SetMessage(ctx, "Your import expired, please upload the file again", "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/guns/import")
+		return nil, nil, "", false
+	}
+
+	// Read the column chosen for each field
+	mapping := importer.Mapping{}
+	for _, field := range importer.Fields {
+		col, err := strconv.Atoi(ctx.PostForm("map_" + field.Key))
+		if err == nil && col >= 0 {
+			mapping[field.Key] = col
+		}
+	}
+
+	return table, mapping, encoded, true
+}
+
+// buildPreview runs a dry run of the submitted import, writing a response if it fails
+func (c *ImportController) buildPreview(ctx *gin.Context, user *models.User) (importer.Mapping, string, *importer.Preview, bool) {
+	table, mapping, encoded, ok := c.readForm(ctx)
+	if !ok {
+		return nil, "", nil, false
+	}
+
+	// Run the dry run, returning to the mapping form if the mapping is incomplete
+	preview, err := importer.BuildPreview(c.DB, table, mapping, user.ID)
+	if err != nil {
+		component := gun.ImportMapping(table, mapping, encoded, err.Error())
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return nil, "", nil, false
+	}
+
+	return mapping, encoded, preview, true
+}
+
+// limitMessage explains when an import would take the user over their plan's gun limit
+func (c *ImportController) limitMessage(user *models.User, preview *importer.Preview) string {
+	if err := entitlements.CheckGuns(c.DB, user, len(preview.Rows)); errors.Is(err, entitlements.ErrLimitReached) {
+		return err.Error()
+	}
+	return ""
+}
+
+// encodeImportTable serializes a parsed table so it can be carried between import steps
+func encodeImportTable(table *importer.Table) (string, error) {
+	data, err := json.Marshal(table)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeImportTable reverses encodeImportTable
+func decodeImportTable(encoded string) (*importer.Table, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var table importer.Table
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	if len(table.Rows) == 0 || len(table.Rows) > importer.MaxRows {
+		return nil, importer.ErrEmptyFile
+	}
+	return &table, nil
+}
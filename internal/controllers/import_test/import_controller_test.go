@@ -0,0 +1,189 @@
+package import_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/importer"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Counter for generating unique emails
+var emailCounter = 0
+
+// setupImportTest sets up the test environment for import tests
+func setupImportTest(t *testing.T, tier string) (*gin.Engine, *models.User) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	emailCounter++
+	email := fmt.Sprintf("import%d@example.com", emailCounter)
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	require.NoError(t, err)
+	user.SubscriptionTier = tier
+	require.NoError(t, db.Save(user).Error)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Make sure the reference data the rows refer to exists
+	require.NoError(t, db.Where(models.Manufacturer{Name: "Importwerks"}).Attrs(models.Manufacturer{Country: "Germany"}).FirstOrCreate(&models.Manufacturer{}).Error)
+	require.NoError(t, db.Where(models.Caliber{Caliber: "7.62x39mm Import"}).FirstOrCreate(&models.Caliber{}).Error)
+	require.NoError(t, db.Where(models.WeaponType{Type: "Import Carbine"}).FirstOrCreate(&models.WeaponType{}).Error)
+
+	// Create a test router with the import routes
+	importController := controllers.NewImportController(db)
+	router := gin.Default()
+	router.GET("/owner/guns/import", importController.New)
+	router.POST("/owner/guns/import", importController.Upload)
+	router.POST("/owner/guns/import/mapping", importController.Mapping)
+	router.POST("/owner/guns/import/preview", importController.Preview)
+	router.POST("/owner/guns/import/commit", importController.Commit)
+
+	return router, user
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+// importForm builds the form posted by the mapping and preview pages
+func importForm(t *testing.T, table importer.Table) url.Values {
+	data, err := json.Marshal(table)
+	require.NoError(t, err)
+
+	form := url.Values{}
+	form.Set("data", base64.StdEncoding.EncodeToString(data))
+	for field, col := range importer.GuessMapping(table.Headers) {
+		form.Set("map_"+field, fmt.Sprint(col))
+	}
+	return form
+}
+
+// postForm posts a form to the router and returns the response
+func postForm(router *gin.Engine, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+// countGuns returns the number of guns the user owns
+func countGuns(user *models.User) int64 {
+	var count int64
+	database.DB.Model(&models.Gun{}).Where("owner_id = ?", user.ID).Count(&count)
+	return count
+}
+
+func TestImportUpload(t *testing.T) {
+	router, _ := setupImportTest(t, "monthly")
+	defer cleanup()
+
+	// A valid file shows the mapping page
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "guns.csv")
+	require.NoError(t, err)
+	part.Write([]byte("Name,Make,Caliber,Type\nAK,Importwerks,7.62x39mm Import,Import Carbine\n"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/owner/guns/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// A file without rows goes back to the upload form
+	body.Reset()
+	writer = multipart.NewWriter(&body)
+	part, err = writer.CreateFormFile("file", "guns.csv")
+	require.NoError(t, err)
+	part.Write([]byte("Name,Make\n"))
+	writer.Close()
+
+	req, _ = http.NewRequest("POST", "/owner/guns/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/owner/guns/import", resp.Header().Get("Location"))
+}
+
+func TestImportCommit(t *testing.T) {
+	router, user := setupImportTest(t, "monthly")
+	defer cleanup()
+
+	table := importer.Table{
+		Headers: []string{"Name", "Make", "Caliber", "Type", "Price"},
+		Rows: [][]string{
+			{"Imported One", "importwerks", "7.62x39 Import", "Import Carbine", "$800"},
+			{"Imported Two", "Importwerk", "7.62x39mm Import", "import carbine", ""},
+		},
+	}
+
+	// The preview doesn't save anything
+	resp := postForm(router, "/owner/guns/import/preview", importForm(t, table))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, int64(0), countGuns(user))
+
+	// Committing saves every row
+	resp = postForm(router, "/owner/guns/import/commit", importForm(t, table))
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/owner/guns", resp.Header().Get("Location"))
+	assert.Equal(t, int64(2), countGuns(user))
+
+	// A row that can't be resolved blocks the whole import
+	table.Rows = append(table.Rows, []string{"Imported Three", "Nobody Arms", "7.62x39mm Import", "Import Carbine", ""})
+	resp = postForm(router, "/owner/guns/import/commit", importForm(t, table))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, int64(2), countGuns(user))
+
+	// A tampered payload goes back to the upload form
+	form := importForm(t, table)
+	form.Set("data", "not base64")
+	resp = postForm(router, "/owner/guns/import/commit", form)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/owner/guns/import", resp.Header().Get("Location"))
+}
+
+func TestImportCommitFreeTierLimit(t *testing.T) {
+	router, user := setupImportTest(t, "free")
+	defer cleanup()
+
+	table := importer.Table{
+		Headers: []string{"Name", "Make", "Caliber", "Type"},
+		Rows: [][]string{
+			{"Free One", "Importwerks", "7.62x39mm Import", "Import Carbine"},
+			{"Free Two", "Importwerks", "7.62x39mm Import", "Import Carbine"},
+			{"Free Three", "Importwerks", "7.62x39mm Import", "Import Carbine"},
+		},
+	}
+
+	// Going over the free tier limit saves nothing
+	resp := postForm(router, "/owner/guns/import/commit", importForm(t, table))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, int64(0), countGuns(user))
+}
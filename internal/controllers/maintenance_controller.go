@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/maintenance"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// MaintenanceController handles requests related to gun maintenance
+type MaintenanceController struct {
+	DB *gorm.DB
+}
+
+// NewMaintenanceController creates a new MaintenanceController
+func NewMaintenanceController(db *gorm.DB) *MaintenanceController {
+	return &MaintenanceController{
+		DB: db,
+	}
+}
+
+// Index displays the maintenance log and service intervals for a gun
+func (c *MaintenanceController) Index(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Get the maintenance records for the gun
+	records, err := models.FindMaintenanceRecordsByGun(c.DB, gunItem.ID, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get maintenance records"})
+		return
+	}
+
+	// Get the current service status
+	status, err := models.GetMaintenanceStatus(c.DB, *gunItem, time.Now())
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get maintenance status"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the index template
+	component := maintenance.Index(*gunItem, records, status, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create logs a new maintenance record for a gun
+func (c *MaintenanceController) Create(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Validate the maintenance type
+	maintenanceType := ctx.PostForm("type")
+	if !models.IsValidMaintenanceType(maintenanceType) {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid maintenance type"})
+		return
+	}
+
+	// Parse the date
+	date, err := time.Parse("2006-01-02", ctx.PostForm("date"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid date format"})
+		return
+	}
+
+	// Parse the cost
+	cost, err := parseCents(ctx.PostForm("cost"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid cost"})
+		return
+	}
+
+	record := models.MaintenanceRecord{
+		GunID:       gunItem.ID,
+		OwnerID:     user.ID,
+		Type:        maintenanceType,
+		Date:        date,
+		Description: strings.TrimSpace(ctx.PostForm("description")),
+		Cost:        cost,
+		Notes:       strings.TrimSpace(ctx.PostForm("notes")),
+	}
+
+	// Save the record to the database
+	if err := models.CreateMaintenanceRecord(c.DB, &record); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create maintenance record"})
+		return
+	}
+
+	// Redirect to the maintenance log
+	flash.SetMessage(ctx, "Maintenance logged successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d/maintenance", gunItem.ID))
+}
+
+// UpdateIntervals sets the day and round based service intervals for a gun
+func (c *MaintenanceController) UpdateIntervals(ctx *gin.Context) {
+	// Get the current user and gun
+	_, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Parse the intervals, where blank means no interval
+	days, err := parseInterval(ctx.PostForm("interval_days"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid day interval"})
+		return
+	}
+
+	rounds, err := parseInterval(ctx.PostForm("interval_rounds"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid round interval"})
+		return
+	}
+
+	// Save the intervals
+	if err := models.UpdateMaintenanceIntervals(c.DB, gunItem, days, rounds); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to update maintenance intervals"})
+		return
+	}
+
+	// Redirect to the maintenance log
+	flash.SetMessage(ctx, "Maintenance intervals updated", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d/maintenance", gunItem.ID))
+}
+
+// Delete deletes a maintenance record
+func (c *MaintenanceController) Delete(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Get the record ID from the URL
+	recordID, err := strconv.ParseUint(ctx.Param("recordID"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid maintenance record ID"})
+		return
+	}
+
+	// Delete the record
+	if err := models.DeleteMaintenanceRecord(c.DB, uint(recordID), user.ID); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to delete maintenance record"})
+		return
+	}
+
+	// Redirect to the maintenance log
+	flash.SetMessage(ctx, "Maintenance record deleted", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d/maintenance", gunItem.ID))
+}
+
+// getGun loads the current user and the gun from the URL, writing an error response if either fails
+func (c *MaintenanceController) getGun(ctx *gin.Context) (*models.User, *models.Gun, bool) {
+	// Get the gun ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid gun ID"})
+		return nil, nil, false
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return nil, nil, false
+	}
+
+	// Get the gun
+	gunItem, err := models.FindGunByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Gun not found"})
+		return nil, nil, false
+	}
+
+	return user, gunItem, true
+}
+
+// parseInterval parses an optional, non-negative maintenance interval
+func parseInterval(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := strconv.Atoi(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid interval: %s", value)
+	}
+
+	return interval, nil
+}
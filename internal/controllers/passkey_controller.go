@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	userviews "github.com/hail2skins/the-virtual-armory/cmd/web/views/user"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/services/passkey"
+	"gorm.io/gorm"
+)
+
+// passkeyRegistrationCookie holds the token for a passkey registration waiting on the user's authenticator
+const passkeyRegistrationCookie = "passkey_registration"
+
+// PasskeyController handles adding, naming and removing passkeys from the profile page
+type PasskeyController struct {
+	DB      *gorm.DB
+	Service *passkey.Service
+}
+
+// NewPasskeyController creates a new PasskeyController
+func NewPasskeyController(db *gorm.DB, service *passkey.Service) *PasskeyController {
+	return &PasskeyController{
+		DB:      db,
+		Service: service,
+	}
+}
+
+// Index lists the user's passkeys
+func (c *PasskeyController) Index(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	credentials, err := c.Service.Credentials(user)
+	if err != nil {
+		log.Printf("Error loading passkeys for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load passkeys"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := userviews.Passkeys(credentials, passkey.MaxNameLength, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// BeginRegistration returns the options for the browser to create a new passkey
+func (c *PasskeyController) BeginRegistration(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in again"})
+		return
+	}
+
+	creation, token, err := c.Service.BeginRegistration(user, time.Now())
+	if err != nil {
+		log.Printf("Error beginning passkey registration for user %d: %v", user.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start adding a passkey"})
+		return
+	}
+	ctx.SetCookie(passkeyRegistrationCookie, token, int(passkey.ChallengeTimeout.Seconds()), "/", "", false, true)
+	ctx.JSON(http.StatusOK, creation)
+}
+
+// FinishRegistration checks the new passkey the browser sent and saves it under the name in the query string
+func (c *PasskeyController) FinishRegistration(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in again"})
+		return
+	}
+
+	token, _ := ctx.Cookie(passkeyRegistrationCookie)
+	ctx.SetCookie(passkeyRegistrationCookie, "", -1, "/", "", false, true)
+
+	credential, err := c.Service.FinishRegistration(user, token, ctx.Query("name"), ctx.Request.Body, time.Now())
+	if errors.Is(err, passkey.ErrChallengeExpired) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Adding the passkey took too long. Please try again."})
+		return
+	}
+	if errors.Is(err, passkey.ErrInvalidPasskey) {
+		log.Printf("Rejected passkey registration for user %d: %v", user.ID, err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "That passkey couldn't be verified"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving passkey for user %d: %v", user.ID, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add passkey"})
+		return
+	}
+
+	flash.SetMessage(ctx, "Passkey \""+credential.Name+"\" added. You can now use it to log in.", "success")
+	ctx.JSON(http.StatusOK, gin.H{"redirect": "/profile/passkeys"})
+}
+
+// Rename renames one of the user's passkeys
+func (c *PasskeyController) Rename(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Passkey not found"})
+		return
+	}
+
+	err = c.Service.Rename(user, uint(id), ctx.PostForm("name"))
+	if errors.Is(err, passkey.ErrNotFound) {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Passkey not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error renaming passkey %d for user %d: %v", id, user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to rename passkey"})
+		return
+	}
+
+	flash.SetMessage(ctx, "Passkey renamed", "success")
+	ctx.Redirect(http.StatusSeeOther, "/profile/passkeys")
+}
+
+// Remove removes one of the user's passkeys, so it can no longer be used to log in
+func (c *PasskeyController) Remove(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Passkey not found"})
+		return
+	}
+
+	err = c.Service.Remove(user, uint(id))
+	if errors.Is(err, passkey.ErrNotFound) {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Passkey not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing passkey %d for user %d: %v", id, user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to remove passkey"})
+		return
+	}
+
+	flash.SetMessage(ctx, "Passkey removed", "success")
+	ctx.Redirect(http.StatusSeeOther, "/profile/passkeys")
+}
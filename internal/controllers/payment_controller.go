@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -16,22 +18,36 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/flash"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/receipt"
 	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/checkout/session"
-	"github.com/stripe/stripe-go/v72/sub"
-	"github.com/stripe/stripe-go/v72/webhook"
 	"gorm.io/gorm"
 )
 
 // PaymentController handles payment-related routes
 type PaymentController struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	Provider     billing.PaymentProvider
+	EmailService email.EmailService // Optional, receipts aren't emailed when nil
+	Business     receipt.Business
 }
 
 // NewPaymentController creates a new PaymentController
-func NewPaymentController(db *gorm.DB) *PaymentController {
+func NewPaymentController(db *gorm.DB, provider billing.PaymentProvider) *PaymentController {
 	return &PaymentController{
-		DB: db,
+		DB:       db,
+		Provider: provider,
+	}
+}
+
+// NewPaymentControllerWithEmailService creates a new PaymentController that emails receipts
+func NewPaymentControllerWithEmailService(db *gorm.DB, provider billing.PaymentProvider, emailService email.EmailService, business receipt.Business) *PaymentController {
+	return &PaymentController{
+		DB:           db,
+		Provider:     provider,
+		EmailService: emailService,
+		Business:     business,
 	}
 }
 
@@ -44,8 +60,35 @@ func (c *PaymentController) ShowPricingPage(ctx *gin.Context) {
 		user = nil
 	}
 
+	// Get flash messages from cookies, such as the upgrade prompt when a plan limit is reached
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Get the plans currently offered
+	plans, err := models.FindActivePlans(c.DB)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load plans"})
+		return
+	}
+
+	// Show the discount for a promo code entered on the page
+	var coupon *models.Coupon
+	if code := strings.TrimSpace(ctx.Query("coupon")); code != "" {
+		var userID uint
+		if user != nil {
+			userID = user.ID
+		}
+		coupon, err = c.findRedeemableCoupon(code, userID)
+		if err != nil {
+			flashMessage, flashType = couponMessage(err), "error"
+		}
+	}
+
 	// Render the pricing page
-	component := paymentViews.Pricing(user)
+	component := paymentViews.Pricing(user, plans, coupon, flashMessage, flashType)
 	component.Render(ctx.Request.Context(), ctx.Writer)
 }
 
@@ -61,153 +104,105 @@ func (c *PaymentController) CreateCheckoutSession(ctx *gin.Context) {
 		return
 	}
 
-	// Check if we're in test mode
-	if os.Getenv("APP_ENV") == "test" {
-		// In test mode, redirect to a test URL
-		baseURL := os.Getenv("APP_BASE_URL")
-		if baseURL == "" {
-			baseURL = "http://localhost:3000" // Default to port 3000 if not set
-		}
-		testURL := baseURL + "/payment/success?session_id=cs_test_" + strconv.FormatUint(uint64(user.ID), 10)
-		ctx.Redirect(http.StatusSeeOther, testURL)
-		return
-	}
-
-	// Set Stripe API key
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	// Check if automatic tax is enabled in the Stripe account
-	// If not, we'll still create the checkout session but without automatic tax
-	automaticTaxEnabled := true
-	if os.Getenv("STRIPE_TAX_ENABLED") == "false" {
-		automaticTaxEnabled = false
-		log.Printf("Automatic tax is disabled in the Stripe account")
-	}
-
-	// Get the product ID based on the tier
-	var productID string
-	switch tier {
-	case "monthly":
-		productID = os.Getenv("STRIPE_PRICE_MONTHLY")
-	case "yearly":
-		productID = os.Getenv("STRIPE_PRICE_YEARLY")
-	case "lifetime":
-		productID = os.Getenv("STRIPE_PRICE_LIFETIME")
-	case "premium_lifetime":
-		productID = os.Getenv("STRIPE_PRICE_PREMIUM")
-	default:
+	// Look up the plan being purchased
+	plan, err := models.FindActivePlanByTier(c.DB, tier)
+	if err != nil || plan.IsFree() {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription tier"})
 		return
 	}
 
-	// Define prices based on the tier
-	var unitAmount int64
-	var currency = "usd"
-	var productName string
-	var interval string
-	var intervalCount int64 = 1
-
-	switch tier {
-	case "monthly":
-		unitAmount = 500 // $5.00
-		productName = "Liking It Plan"
-		interval = "month"
-	case "yearly":
-		unitAmount = 3000 // $30.00
-		productName = "Loving It Plan"
-		interval = "year"
-	case "lifetime":
-		unitAmount = 10000 // $100.00
-		productName = "Supporter Plan"
-		interval = ""
-	case "premium_lifetime":
-		unitAmount = 100000 // $1000.00
-		productName = "Big Baller Plan"
-		interval = ""
-	}
-
-	// Create checkout session parameters
-	params := &stripe.CheckoutSessionParams{
-		PaymentMethodTypes: stripe.StringSlice([]string{
-			"card",
-		}),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String(currency),
-					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
-						Name:    stripe.String(productName),
-						TaxCode: stripe.String("txcd_10000000"), // Standard tax code
-					},
-					UnitAmount:  stripe.Int64(unitAmount),
-					TaxBehavior: stripe.String("exclusive"), // Add tax on top of the price
-					Recurring: func() *stripe.CheckoutSessionLineItemPriceDataRecurringParams {
-						if interval == "" {
-							return nil
-						}
-						return &stripe.CheckoutSessionLineItemPriceDataRecurringParams{
-							Interval:      stripe.String(interval),
-							IntervalCount: stripe.Int64(intervalCount),
-						}
-					}(),
-				},
-				Quantity: stripe.Int64(1),
-			},
-		},
-		Mode: func() *string {
-			if interval == "" {
-				return stripe.String(string(stripe.CheckoutSessionModePayment))
-			}
-			return stripe.String(string(stripe.CheckoutSessionModeSubscription))
-		}(),
-		SuccessURL:               stripe.String(os.Getenv("APP_BASE_URL") + "/payment/success?session_id={CHECKOUT_SESSION_ID}"),
-		CancelURL:                stripe.String(os.Getenv("APP_BASE_URL") + "/payment/cancel"),
-		CustomerEmail:            stripe.String(user.Email),
-		AutomaticTax:             &stripe.CheckoutSessionAutomaticTaxParams{Enabled: stripe.Bool(automaticTaxEnabled)},
-		BillingAddressCollection: stripe.String(string(stripe.CheckoutSessionBillingAddressCollectionRequired)),
-	}
-
-	// Add metadata
-	params.AddMetadata("user_id", strconv.FormatUint(uint64(user.ID), 10))
-	params.AddMetadata("subscription_tier", tier)
-	params.AddMetadata("product_id", productID)
-
-	// Log the success URL for debugging
-	successURL := os.Getenv("APP_BASE_URL") + "/payment/success?session_id={CHECKOUT_SESSION_ID}"
-	log.Printf("Success URL for checkout: %s", successURL)
-	log.Printf("APP_BASE_URL: %s", os.Getenv("APP_BASE_URL"))
-	log.Printf("Tax settings: AutomaticTax=%v, BillingAddressCollection=Required",
-		*params.AutomaticTax.Enabled)
-
-	// Create the checkout session
-	s, err := session.New(params)
+	// Apply the promo code, if one was entered, holding a use of it until the checkout completes or expires
+	var coupon *models.Coupon
+	var redemption *models.CouponRedemption
+	if code := strings.TrimSpace(ctx.PostForm("coupon")); code != "" {
+		coupon, err = c.findRedeemableCoupon(code, user.ID)
+		if err == nil {
+			redemption, err = models.ReserveCoupon(c.DB, coupon.ID, user.ID)
+		}
+		if err != nil {
+			flash.SetMessage(ctx, couponMessage(err), "error")
+			ctx.Redirect(http.StatusSeeOther, "/pricing")
+			return
+		}
+	}
+
+	// Create the checkout session with the payment provider
+	baseURL := os.Getenv("APP_BASE_URL")
+	s, err := c.Provider.CreateCheckoutSession(billing.CheckoutParams{
+		UserID:     user.ID,
+		Email:      user.Email,
+		Plan:       plan,
+		Coupon:     coupon,
+		TrialDays:  trialDays(user, plan),
+		SuccessURL: baseURL + "/payment/success?session_id={CHECKOUT_SESSION_ID}",
+		CancelURL:  baseURL + "/payment/cancel",
+	})
 	if err != nil {
 		log.Printf("Error creating checkout session: %v", err)
-
-		// If the error is related to tax, try again without automatic tax
-		if strings.Contains(err.Error(), "tax") {
-			log.Printf("Retrying checkout session creation without automatic tax")
-			params.AutomaticTax.Enabled = stripe.Bool(false)
-			s, err = session.New(params)
-			if err != nil {
-				log.Printf("Error creating checkout session without tax: %v", err)
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
-				return
+		if redemption != nil {
+			if err := models.ReleaseCoupon(c.DB, redemption); err != nil {
+				log.Printf("Error releasing promo code %s for user %d: %v", coupon.Code, user.ID, err)
 			}
-		} else {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
-			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
+		return
+	}
+	if redemption != nil {
+		if err := models.AttachCouponSession(c.DB, redemption, s.ID); err != nil {
+			log.Printf("ERROR: failed to attach promo code %s to session %s: %v", coupon.Code, s.ID, err)
 		}
 	}
 
 	log.Printf("Created checkout session for user %d, tier %s, session ID: %s", user.ID, tier, s.ID)
 	log.Printf("Checkout URL: %s", s.URL)
-	log.Printf("Success URL in session: %s", *params.SuccessURL)
 
 	// Redirect to the checkout page
 	ctx.Redirect(http.StatusSeeOther, s.URL)
 }
 
+// findRedeemableCoupon looks up a promo code and checks that the user can use it.
+// Problems with the code itself are returned as one of the models.ErrCoupon errors.
+func (c *PaymentController) findRedeemableCoupon(code string, userID uint) (*models.Coupon, error) {
+	coupon, err := models.FindCouponByCode(c.DB, code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, models.ErrCouponInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A coupon the provider doesn't know about can't be applied at checkout
+	if coupon.StripeCouponID == "" {
+		return nil, models.ErrCouponInvalid
+	}
+
+	if err := coupon.CheckRedeemable(c.DB, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return coupon, nil
+}
+
+// couponMessage turns an error from findRedeemableCoupon into a message for the user
+func couponMessage(err error) string {
+	switch {
+	case errors.Is(err, models.ErrCouponInvalid), errors.Is(err, models.ErrCouponExpired),
+		errors.Is(err, models.ErrCouponExhausted), errors.Is(err, models.ErrCouponAlreadyRedeemed):
+		message := err.Error()
+		return strings.ToUpper(message[:1]) + message[1:] + "."
+	default:
+		log.Printf("Error checking promo code: %v", err)
+		return "We couldn't check that promo code. Please try again."
+	}
+}
+
+// trialDays returns the free trial a user gets on a plan; only users who have never paid get one
+func trialDays(user *models.User, plan *models.Plan) int {
+	if !plan.IsRecurring() || user.StripeCustomerID != "" {
+		return 0
+	}
+	return plan.TrialDays
+}
+
 // Note: All subscription tiers (monthly, yearly, lifetime, premium_lifetime) now use Checkout Sessions
 // instead of direct Stripe payment links. This ensures consistent handling of payments and proper
 // redirection back to the site after checkout completion.
@@ -220,9 +215,6 @@ func logWebhookEvent(eventType string, eventID string, userID string, status str
 
 // HandleStripeWebhook handles Stripe webhook events
 func (c *PaymentController) HandleStripeWebhook(ctx *gin.Context) {
-	// Get the webhook secret from environment variables
-	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
-
 	// Read the request body
 	body, err := ioutil.ReadAll(ctx.Request.Body)
 	if err != nil {
@@ -247,7 +239,7 @@ func (c *PaymentController) HandleStripeWebhook(ctx *gin.Context) {
 	} else {
 		// In production mode, verify the webhook signature
 		var err error
-		event, err = webhook.ConstructEvent(body, signature, webhookSecret)
+		event, err = c.Provider.ConstructEvent(body, signature)
 		if err != nil {
 			log.Printf("Error verifying webhook signature: %v", err)
 			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
@@ -258,37 +250,120 @@ func (c *PaymentController) HandleStripeWebhook(ctx *gin.Context) {
 	// Log the event for monitoring
 	log.Printf("Received Stripe webhook event: %s (ID: %s)", event.Type, event.ID)
 
+	// Record the event, skipping any that were already handled or are being handled now
+	record, err := models.FindWebhookEventByEventID(c.DB, event.ID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Error looking up webhook event %s: %v", event.ID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record webhook event"})
+			return
+		}
+
+		record = &models.WebhookEvent{
+			EventID: event.ID,
+			Type:    string(event.Type),
+			Payload: string(body),
+			Status:  models.WebhookEventStatusReceived,
+		}
+		if err := models.CreateWebhookEvent(c.DB, record); err != nil {
+			// The event ID is unique, so if the event is there now another delivery got there first
+			if _, findErr := models.FindWebhookEventByEventID(c.DB, event.ID); findErr == nil {
+				log.Printf("Skipping webhook event %s, it is already being processed", event.ID)
+				ctx.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+				return
+			}
+			// Anything else is our failure, and Stripe will retry the delivery
+			log.Printf("Error recording webhook event %s: %v", event.ID, err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record webhook event"})
+			return
+		}
+	}
+
+	// Failures are kept on the event log for admins to replay
+	if err := c.ProcessWebhookEvent(record); err != nil {
+		if errors.Is(err, ErrWebhookEventClaimed) {
+			log.Printf("Skipping duplicate webhook event %s (status: %s)", event.ID, record.Status)
+			ctx.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+			return
+		}
+		log.Printf("Error processing webhook event %s: %v", event.ID, err)
+	}
+
+	// Always return a 200 OK to Stripe
+	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ErrWebhookEventClaimed is returned when a webhook event is already processed or another attempt is processing it
+var ErrWebhookEventClaimed = errors.New("webhook event is already processed or being processed")
+
+// ProcessWebhookEvent handles a recorded webhook event and saves the outcome on the event log
+func (c *PaymentController) ProcessWebhookEvent(record *models.WebhookEvent) error {
+	// Claim the event first so concurrent deliveries and replays can't both handle it
+	claimed, err := models.ClaimWebhookEvent(c.DB, record)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrWebhookEventClaimed
+	}
+
+	var event stripe.Event
+	handled := true
+	err = json.Unmarshal([]byte(record.Payload), &event)
+	if err == nil {
+		handled, err = c.dispatchWebhookEvent(event)
+	}
+
+	switch {
+	case err != nil:
+		record.Status = models.WebhookEventStatusFailed
+		record.Error = err.Error()
+	case !handled:
+		record.Status = models.WebhookEventStatusIgnored
+	default:
+		now := time.Now()
+		record.Status = models.WebhookEventStatusProcessed
+		record.ProcessedAt = &now
+	}
+
+	if saveErr := models.UpdateWebhookEvent(c.DB, record); saveErr != nil {
+		log.Printf("Error saving webhook event %s: %v", record.EventID, saveErr)
+	}
+	return err
+}
+
+// dispatchWebhookEvent passes an event to its handler, reporting false for event types we don't act on
+func (c *PaymentController) dispatchWebhookEvent(event stripe.Event) (bool, error) {
 	// Handle different event types
 	switch event.Type {
 	case "checkout.session.completed":
-		handleCheckoutSessionCompleted(c, ctx, event)
+		return true, handleCheckoutSessionCompleted(c, event)
+	case "checkout.session.expired":
+		return true, handleCheckoutSessionExpired(c, event)
 	case "customer.subscription.created":
-		handleSubscriptionCreated(c, ctx, event)
+		return true, handleSubscriptionCreated(c, event)
 	case "customer.subscription.updated":
-		handleSubscriptionUpdated(c, ctx, event)
+		return true, handleSubscriptionUpdated(c, event)
 	case "customer.subscription.deleted":
-		handleSubscriptionDeleted(c, ctx, event)
+		return true, handleSubscriptionDeleted(c, event)
 	case "invoice.paid":
-		handleInvoicePaid(c, ctx, event)
+		return true, handleInvoicePaid(c, event)
 	case "invoice.payment_failed":
-		handleInvoicePaymentFailed(c, ctx, event)
+		return true, handleInvoicePaymentFailed(c, event)
 	default:
 		// Log unhandled event types
 		log.Printf("Unhandled webhook event type: %s", event.Type)
+		return false, nil
 	}
-
-	// Always return a 200 OK to Stripe
-	ctx.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
 // handleCheckoutSessionCompleted processes a completed checkout session
-func handleCheckoutSessionCompleted(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleCheckoutSessionCompleted(c *PaymentController, event stripe.Event) error {
 	// Parse the event data
 	var session stripe.CheckoutSession
 	err := json.Unmarshal(event.Data.Raw, &session)
 	if err != nil {
-		log.Printf("Error parsing checkout.session.completed webhook: %v", err)
-		return
+		return fmt.Errorf("error parsing checkout.session.completed webhook: %w", err)
 	}
 
 	// Log the event for monitoring
@@ -307,93 +382,53 @@ func handleCheckoutSessionCompleted(c *PaymentController, ctx *gin.Context, even
 	}
 
 	if userID == "" {
-		log.Printf("Missing user_id in metadata and client_reference_id for session %s", session.ID)
-		return
+		return fmt.Errorf("missing user_id in metadata and client_reference_id for session %s", session.ID)
 	}
 
 	// Convert userID string to uint
 	userIDUint, err := strconv.ParseUint(userID, 10, 64)
 	if err != nil {
-		log.Printf("Invalid user_id format: %v", err)
-		return
+		return fmt.Errorf("invalid user_id format: %w", err)
 	}
 
 	// Find the user in the database
 	var user models.User
 	if err := c.DB.First(&user, userIDUint).Error; err != nil {
-		log.Printf("Failed to find user %s for session %s: %v", userID, session.ID, err)
-		return
+		return fmt.Errorf("failed to find user %s for session %s: %w", userID, session.ID, err)
 	}
 
-	// Get subscription tier from metadata or from the line items
-	var subscriptionTier string
-	if session.Metadata != nil && session.Metadata["subscription_tier"] != "" {
-		subscriptionTier = session.Metadata["subscription_tier"]
-	} else {
-		// Try to determine the tier from the amount
-		if session.AmountTotal >= 100000 {
-			// $1000 is premium lifetime (Big Baller)
-			subscriptionTier = "premium_lifetime"
-			log.Printf("Determined premium_lifetime subscription from amount: %d", session.AmountTotal)
-		} else if session.AmountTotal >= 10000 {
-			// $100 is lifetime (Supporter)
-			subscriptionTier = "lifetime"
-			log.Printf("Determined lifetime subscription from amount: %d", session.AmountTotal)
-		} else if session.AmountTotal >= 3000 {
-			// $30 is yearly (Loving It)
-			subscriptionTier = "yearly"
-			log.Printf("Determined yearly subscription from amount: %d", session.AmountTotal)
-		} else {
-			// $5 is monthly (Liking It)
-			subscriptionTier = "monthly"
-			log.Printf("Determined monthly subscription from amount: %d", session.AmountTotal)
-		}
-		log.Printf("Determined subscription tier from session: %s", subscriptionTier)
-	}
-
-	// Set expiration date based on subscription tier
-	var expirationDate time.Time
+	// Look up the plan that was paid for; the tier is never guessed from the amount
+	plan, err := findPaidPlan(c.DB, session.Metadata, nil)
+	if err != nil {
+		return fmt.Errorf("failed to find the plan paid for in session %s: %w", session.ID, err)
+	}
+	subscriptionTier := plan.Tier
 
-	// Check if user has an existing subscription that hasn't expired yet
+	// Work out the new expiration date from how often the plan is billed
+	var expirationDate time.Time
 	hasExistingTime := user.HasActiveSubscription() && !user.IsLifetimeSubscriber()
-
-	// If the user has an existing subscription, add time to it instead of replacing
-	if hasExistingTime && user.SubscriptionTier == subscriptionTier {
-		// If it's the same tier, just keep the existing expiration date
+	switch {
+	case !plan.IsRecurring() && user.IsLifetimeSubscriber():
+		// Moving between one-time plans keeps the lifetime expiration already set
 		expirationDate = user.SubscriptionExpiresAt
-		log.Printf("Keeping existing expiration date for user %d: %s", user.ID, expirationDate)
-	} else if hasExistingTime && subscriptionTier == "yearly" && user.SubscriptionTier == "monthly" {
-		// If upgrading from monthly to yearly, add 1 year to the existing expiration date
-		expirationDate = user.SubscriptionExpiresAt.AddDate(1, 0, 0)
-		log.Printf("Upgrading from monthly to yearly for user %d, new expiration: %s", user.ID, expirationDate)
-	} else if subscriptionTier == "premium_lifetime" && user.SubscriptionTier == "lifetime" {
-		// If upgrading from lifetime to premium lifetime, keep the same expiration date (already set to 100 years)
+	case !plan.IsRecurring():
+		expirationDate = plan.ExpiresAfter(time.Now())
+	case hasExistingTime && user.SubscriptionTier == subscriptionTier:
+		// Buying the same plan again keeps the existing expiration date
 		expirationDate = user.SubscriptionExpiresAt
-		log.Printf("Upgrading from lifetime to premium lifetime for user %d, keeping expiration: %s", user.ID, expirationDate)
-	} else if subscriptionTier == "lifetime" || subscriptionTier == "premium_lifetime" {
-		// For lifetime subscriptions, always set to 100 years from now regardless of previous tier
-		expirationDate = time.Now().AddDate(100, 0, 0)
-		log.Printf("Setting lifetime subscription expiration for user %d to %s", user.ID, expirationDate)
-	} else {
-		// Start from now for new subscriptions or downgrades
-		expirationDate = time.Now()
-
-		// Add time based on the new subscription tier
-		switch subscriptionTier {
-		case "monthly":
-			expirationDate = expirationDate.AddDate(0, 1, 0) // Add 1 month
-			log.Printf("Setting monthly subscription expiration for user %d to %s", user.ID, expirationDate)
-		case "yearly":
-			expirationDate = expirationDate.AddDate(1, 0, 0) // Add 1 year
-			log.Printf("Setting yearly subscription expiration for user %d to %s", user.ID, expirationDate)
-		case "lifetime", "premium_lifetime":
-			expirationDate = time.Now().AddDate(100, 0, 0) // 100 years from now (effectively lifetime)
-			log.Printf("Setting lifetime subscription expiration for user %d to %s", user.ID, expirationDate)
-		default:
-			log.Printf("Unknown subscription tier: %s, defaulting to monthly", subscriptionTier)
-			expirationDate = expirationDate.AddDate(0, 1, 0) // Default to 1 month
+	case hasExistingTime:
+		// Time already paid for on another plan carries over
+		expirationDate = plan.ExpiresAfter(user.SubscriptionExpiresAt)
+	default:
+		// Start from now for new subscriptions, or from the end of a free trial
+		start := time.Now()
+		if days, err := strconv.Atoi(session.Metadata["trial_days"]); err == nil && days > 0 {
+			start = start.AddDate(0, 0, days)
+			log.Printf("Subscription for user %d starts with a %d day trial", user.ID, days)
 		}
+		expirationDate = plan.ExpiresAfter(start)
 	}
+	log.Printf("Setting %s subscription expiration for user %d to %s", subscriptionTier, user.ID, expirationDate)
 
 	// Get or create Stripe customer ID
 	var stripeCustomerID string
@@ -411,91 +446,183 @@ func handleCheckoutSessionCompleted(c *PaymentController, ctx *gin.Context, even
 		}
 	}
 
+	// A one-time lifetime purchase replaces any recurring subscription, which must stop billing
+	previousTier := user.SubscriptionTier
+	stripeSubscriptionID := user.StripeSubscriptionID
+	if session.Subscription != nil && session.Subscription.ID != "" {
+		stripeSubscriptionID = session.Subscription.ID
+	} else if subscriptionTier == models.TierLifetime || subscriptionTier == models.TierPremiumLifetime {
+		c.cancelReplacedSubscription(&user, stripeCustomerID)
+		stripeSubscriptionID = ""
+	}
+
 	// Update the user's subscription
 	if err := c.DB.Model(&user).Updates(map[string]interface{}{
 		"subscription_tier":       subscriptionTier,
 		"subscription_expires_at": expirationDate,
 		"stripe_customer_id":      stripeCustomerID,
+		"stripe_subscription_id":  stripeSubscriptionID,
 		"subscription_canceled":   false, // Reset the canceled flag when resubscribing
 	}).Error; err != nil {
-		log.Printf("Failed to update user %s for session %s: %v", userID, session.ID, err)
-		return
-	}
-
-	// Calculate the amount based on the subscription tier
-	var amount int64
-	if session.AmountTotal > 0 {
-		amount = session.AmountTotal
-	} else if os.Getenv("APP_ENV") == "test" {
-		// In test mode, use dummy amounts
-		switch subscriptionTier {
-		case "monthly":
-			amount = 500 // $5.00
-		case "yearly":
-			amount = 3000 // $30.00
-		case "lifetime":
-			amount = 15000 // $150.00
-		case "premium_lifetime":
-			amount = 30000 // $300.00
-		}
+		return fmt.Errorf("failed to update user %s for session %s: %w", userID, session.ID, err)
 	}
 
-	// Create a payment record
+	// Create a payment record, unless the subscription's first invoice arrived first and already recorded it
 	payment := models.Payment{
 		UserID:      uint(userIDUint),
-		Amount:      amount,
+		Amount:      session.AmountTotal,
 		Currency:    string(session.Currency),
 		PaymentType: "subscription",
 		Status:      "succeeded",
 		Description: strings.Title(subscriptionTier) + " Subscription",
+		Tier:        subscriptionTier,
 		StripeID:    session.ID,
 	}
+	if session.Subscription != nil && session.Subscription.ID != "" {
+		payment.StripeSubscriptionID = session.Subscription.ID
+		if existing, err := models.FindSubscriptionStartPayment(c.DB, session.Subscription.ID); err == nil {
+			payment.ID = existing.ID
+			payment.CreatedAt = existing.CreatedAt
+			payment.StripeID = existing.StripeID
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to find payment for subscription %s: %w", session.Subscription.ID, err)
+		}
+	}
+	if session.TotalDetails != nil {
+		payment.TaxAmount = session.TotalDetails.AmountTax
+	}
 
-	if err := models.CreatePayment(c.DB, &payment); err != nil {
-		log.Printf("Failed to create payment record for session %s: %v", session.ID, err)
-		return
+	// Recurring plans are paid up to the new expiration date; lifetime plans have no billing period
+	if subscriptionTier != models.TierLifetime && subscriptionTier != models.TierPremiumLifetime {
+		periodStart := time.Now()
+		payment.PeriodStart = &periodStart
+		payment.PeriodEnd = &expirationDate
+	}
+
+	if payment.ID != 0 {
+		if err := models.UpdatePayment(c.DB, &payment); err != nil {
+			return fmt.Errorf("failed to update payment record for session %s: %w", session.ID, err)
+		}
+	} else if err := models.CreatePayment(c.DB, &payment); err != nil {
+		return fmt.Errorf("failed to create payment record for session %s: %w", session.ID, err)
 	}
 
 	log.Printf("Created payment record for subscription: %s", subscriptionTier)
+
+	recordSubscriptionChange(c.DB, &models.SubscriptionChange{
+		UserID:    user.ID,
+		FromTier:  previousTier,
+		ToTier:    subscriptionTier,
+		Cause:     models.SubscriptionCauseWebhook,
+		ExpiresAt: &expirationDate,
+		PaymentID: &payment.ID,
+		Note:      "Checkout completed",
+	})
+
+	// Record the promo code so the user can't use it again.
+	// Stripe has already applied the discount, so a failure here doesn't fail the purchase.
+	if code := session.Metadata["coupon_code"]; code != "" {
+		if coupon, err := models.FindCouponByCode(c.DB, code); err != nil {
+			log.Printf("ERROR: promo code %s used on session %s not found: %v", code, session.ID, err)
+		} else if err := models.RedeemCoupon(c.DB, coupon.ID, user.ID, session.ID); err != nil {
+			log.Printf("ERROR: failed to record promo code %s for user %d: %v", code, user.ID, err)
+		}
+	}
 	logWebhookEvent("checkout.session.completed", session.ID, userID, "success",
-		fmt.Sprintf("User %s subscribed to %s tier", userID, subscriptionTier))
+		fmt.Sprintf("User %s subscribed to %s tier (previously %s)", userID, subscriptionTier, previousTier))
+	return nil
+}
+
+// handleCheckoutSessionExpired gives back a promo code held for a checkout the user never finished
+func handleCheckoutSessionExpired(c *PaymentController, event stripe.Event) error {
+	var session stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
+		return fmt.Errorf("error parsing checkout.session.expired webhook: %w", err)
+	}
+
+	redemption, err := models.FindPendingCouponRedemption(c.DB, session.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find promo code held for session %s: %w", session.ID, err)
+	}
+	if err := models.ReleaseCoupon(c.DB, redemption); err != nil {
+		return fmt.Errorf("failed to release promo code held for session %s: %w", session.ID, err)
+	}
+
+	logWebhookEvent("checkout.session.expired", event.ID, fmt.Sprintf("%d", redemption.UserID), "success",
+		fmt.Sprintf("Released promo code held for session %s", session.ID))
+	return nil
+}
+
+// cancelReplacedSubscription stops the recurring subscription a user had before buying a lifetime plan.
+// Failures are logged rather than returned so the lifetime purchase itself is never lost.
+func (c *PaymentController) cancelReplacedSubscription(user *models.User, customerID string) {
+	if user.SubscriptionTier != models.TierMonthly && user.SubscriptionTier != models.TierYearly {
+		return
+	}
+
+	subscriptionID := user.StripeSubscriptionID
+	if subscriptionID == "" && customerID != "" {
+		found, err := c.Provider.FindSubscriptionID(customerID)
+		if err != nil {
+			log.Printf("No subscription to cancel for user %d after lifetime purchase: %v", user.ID, err)
+			return
+		}
+		subscriptionID = found
+	}
+	if subscriptionID == "" {
+		return
+	}
+
+	if err := c.Provider.CancelSubscription(subscriptionID); err != nil {
+		log.Printf("ERROR: failed to cancel subscription %s for user %d after lifetime purchase: %v", subscriptionID, user.ID, err)
+		return
+	}
+	log.Printf("Cancelled subscription %s for user %d, replaced by a lifetime plan", subscriptionID, user.ID)
+}
+
+// recordSubscriptionChange adds a tier change to the user's subscription history.
+// The change has already been made by then, so a failure is logged rather than returned.
+func recordSubscriptionChange(db *gorm.DB, change *models.SubscriptionChange) {
+	if err := models.CreateSubscriptionChange(db, change); err != nil {
+		log.Printf("ERROR: failed to record subscription change for user %d from %s to %s: %v", change.UserID, change.FromTier, change.ToTier, err)
+	}
 }
 
 // handleSubscriptionCreated processes a new subscription
-func handleSubscriptionCreated(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleSubscriptionCreated(c *PaymentController, event stripe.Event) error {
 	var subscription stripe.Subscription
 	err := json.Unmarshal(event.Data.Raw, &subscription)
 	if err != nil {
-		log.Printf("Error parsing subscription.created event: %v", err)
-		return
+		return fmt.Errorf("error parsing subscription.created event: %w", err)
 	}
 
 	// Try to find the user by Stripe customer ID
 	var user models.User
 	if err := c.DB.Where("stripe_customer_id = ?", subscription.Customer.ID).First(&user).Error; err != nil {
-		log.Printf("Failed to find user for customer %s: %v", subscription.Customer.ID, err)
-		return
+		return fmt.Errorf("failed to find user for customer %s: %w", subscription.Customer.ID, err)
 	}
 
 	// Log the event
 	logWebhookEvent("customer.subscription.created", subscription.ID, fmt.Sprintf("%d", user.ID), "success",
 		fmt.Sprintf("Subscription created for user %d", user.ID))
+	return nil
 }
 
 // handleSubscriptionUpdated processes an updated subscription
-func handleSubscriptionUpdated(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleSubscriptionUpdated(c *PaymentController, event stripe.Event) error {
 	var subscription stripe.Subscription
 	err := json.Unmarshal(event.Data.Raw, &subscription)
 	if err != nil {
-		log.Printf("Error parsing subscription.updated event: %v", err)
-		return
+		return fmt.Errorf("error parsing subscription.updated event: %w", err)
 	}
 
 	// Try to find the user by Stripe customer ID
 	var user models.User
 	if err := c.DB.Where("stripe_customer_id = ?", subscription.Customer.ID).First(&user).Error; err != nil {
-		log.Printf("Failed to find user for customer %s: %v", subscription.Customer.ID, err)
-		return
+		return fmt.Errorf("failed to find user for customer %s: %w", subscription.Customer.ID, err)
 	}
 
 	// We'll skip updating the subscription expiration date here
@@ -505,120 +632,377 @@ func handleSubscriptionUpdated(c *PaymentController, ctx *gin.Context, event str
 	// Log the event
 	logWebhookEvent("customer.subscription.updated", subscription.ID, fmt.Sprintf("%d", user.ID), "success",
 		fmt.Sprintf("Subscription updated for user %d (expiration already set by checkout.session.completed)", user.ID))
+	return nil
 }
 
 // handleSubscriptionDeleted processes a cancelled subscription
-func handleSubscriptionDeleted(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleSubscriptionDeleted(c *PaymentController, event stripe.Event) error {
 	var subscription stripe.Subscription
 	err := json.Unmarshal(event.Data.Raw, &subscription)
 	if err != nil {
-		log.Printf("Error parsing subscription.deleted event: %v", err)
-		return
+		return fmt.Errorf("error parsing subscription.deleted event: %w", err)
 	}
 
 	// Try to find the user by Stripe customer ID
 	var user models.User
 	if err := c.DB.Where("stripe_customer_id = ?", subscription.Customer.ID).First(&user).Error; err != nil {
-		log.Printf("Failed to find user for customer %s: %v", subscription.Customer.ID, err)
-		return
+		return fmt.Errorf("failed to find user for customer %s: %w", subscription.Customer.ID, err)
 	}
 
-	// Update the user's subscription to free tier
+	// A subscription the user has since replaced, such as by moving to a lifetime plan, doesn't affect their tier
+	if user.IsLifetimeSubscriber() || (user.StripeSubscriptionID != "" && user.StripeSubscriptionID != subscription.ID) {
+		logWebhookEvent("customer.subscription.deleted", subscription.ID, fmt.Sprintf("%d", user.ID), "success",
+			fmt.Sprintf("Ignored cancellation of replaced subscription for user %d", user.ID))
+		return nil
+	}
+
+	// Update the user's subscription to free tier, forgetting the subscription that has ended
+	previousTier := user.SubscriptionTier
 	if err := c.DB.Model(&user).Updates(map[string]interface{}{
-		"subscription_tier":       "free",
+		"subscription_tier":       models.TierFree,
 		"subscription_expires_at": time.Now(),
+		"stripe_subscription_id":  "",
+		"payment_failed_at":       nil,
+		"grace_period_ends_at":    nil,
+		"dunning_reminders_sent":  0,
 	}).Error; err != nil {
-		log.Printf("Failed to downgrade subscription for user %d: %v", user.ID, err)
-		return
+		return fmt.Errorf("failed to downgrade subscription for user %d: %w", user.ID, err)
+	}
+	if previousTier != models.TierFree {
+		recordSubscriptionChange(c.DB, &models.SubscriptionChange{
+			UserID:   user.ID,
+			FromTier: previousTier,
+			ToTier:   models.TierFree,
+			Cause:    models.SubscriptionCauseWebhook,
+			Note:     "Subscription ended with the payment provider",
+		})
 	}
 
 	// Log the event
 	logWebhookEvent("customer.subscription.deleted", subscription.ID, fmt.Sprintf("%d", user.ID), "success",
 		fmt.Sprintf("Subscription cancelled for user %d", user.ID))
+	return nil
 }
 
 // handleInvoicePaid processes a paid invoice
-func handleInvoicePaid(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleInvoicePaid(c *PaymentController, event stripe.Event) error {
 	var invoice stripe.Invoice
 	err := json.Unmarshal(event.Data.Raw, &invoice)
 	if err != nil {
-		log.Printf("Error parsing invoice.paid event: %v", err)
-		return
+		return fmt.Errorf("error parsing invoice.paid event: %w", err)
 	}
 
 	// Try to find the user by Stripe customer ID
 	var user models.User
 	if err := c.DB.Where("stripe_customer_id = ?", invoice.Customer.ID).First(&user).Error; err != nil {
-		log.Printf("Failed to find user for customer %s: %v", invoice.Customer.ID, err)
-		return
-	}
-
-	// Determine the subscription tier from the invoice amount
-	var subscriptionTier string
-
-	// Check the invoice amount to determine the tier
-	if invoice.AmountPaid >= 100000 {
-		// $1000 is premium lifetime (Big Baller)
-		subscriptionTier = "premium_lifetime"
-		log.Printf("Determined premium_lifetime subscription from invoice amount: %d", invoice.AmountPaid)
-	} else if invoice.AmountPaid >= 10000 {
-		// $100 is lifetime (Supporter)
-		subscriptionTier = "lifetime"
-		log.Printf("Determined lifetime subscription from invoice amount: %d", invoice.AmountPaid)
-	} else if invoice.AmountPaid >= 3000 {
-		// $30 is yearly (Loving It)
-		subscriptionTier = "yearly"
-		log.Printf("Determined yearly subscription from invoice amount: %d", invoice.AmountPaid)
+		return fmt.Errorf("failed to find user for customer %s: %w", invoice.Customer.ID, err)
+	}
+
+	// A paid subscription invoice covers the user to the end of its billing period, and a successful
+	// payment ends any grace period started by an earlier failure. Both are saved together so a late
+	// payment never leaves the user out of their grace period but already expired.
+	updates := map[string]interface{}{}
+	if _, end := invoicePeriod(&invoice); invoice.Subscription != nil && end > 0 && !user.IsLifetimeSubscriber() {
+		if periodEnd := time.Unix(end, 0); periodEnd.After(user.SubscriptionExpiresAt) {
+			updates["subscription_expires_at"] = periodEnd
+		}
+	}
+	if user.InGracePeriod() {
+		updates["payment_failed_at"] = nil
+		updates["grace_period_ends_at"] = nil
+		updates["dunning_reminders_sent"] = 0
+		log.Printf("Clearing payment grace period for user %d", user.ID)
+	}
+
+	// Renewals are recorded from the invoice in the same transaction as the user's new expiry.
+	// Other invoices are matched to the payment recorded at checkout or on a plan change.
+	var payment *models.Payment
+	subscriptionTier := user.SubscriptionTier
+	if invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCycle {
+		line := invoiceSubscriptionLine(&invoice)
+		if line == nil {
+			return fmt.Errorf("renewal invoice %s has no subscription line", invoice.ID)
+		}
+		plan, err := findPaidPlan(c.DB, line.Metadata, line.Price)
+		if err != nil {
+			return fmt.Errorf("failed to find the plan paid for in invoice %s: %w", invoice.ID, err)
+		}
+		subscriptionTier = plan.Tier
+		updates["subscription_tier"] = plan.Tier
+
+		payment, err = c.recordRenewal(&user, &invoice, plan, updates)
+		if err != nil {
+			return err
+		}
 	} else {
-		// $5 is monthly (Liking It)
-		subscriptionTier = "monthly"
-		log.Printf("Determined monthly subscription from invoice amount: %d", invoice.AmountPaid)
+		// A plan change's prorated invoice was recorded as pending when the plan changed
+		err := c.DB.Transaction(func(tx *gorm.DB) error {
+			if _, err := models.SettlePendingPayment(tx, invoice.ID, "succeeded"); err != nil {
+				return fmt.Errorf("failed to confirm payment for invoice %s: %w", invoice.ID, err)
+			}
+			if len(updates) > 0 {
+				if err := tx.Model(&user).Updates(updates).Error; err != nil {
+					return fmt.Errorf("failed to update subscription for user %d: %w", user.ID, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		payment, err = c.findInvoicePayment(&user, &invoice)
+		if err != nil {
+			return err
+		}
 	}
 
-	// For invoice.paid events, we'll skip creating a payment record
-	// since we already created one in handleCheckoutSessionCompleted
-	log.Printf("Skipping payment record creation for invoice.paid event (user %d, tier %s)", user.ID, subscriptionTier)
+	// Email a receipt; failing to send it doesn't fail the webhook
+	if payment != nil && payment.Amount > 0 {
+		c.sendReceipt(&user, payment)
+	}
 
 	// Log the event
 	logWebhookEvent("invoice.paid", invoice.ID, fmt.Sprintf("%d", user.ID), "success",
-		fmt.Sprintf("Invoice paid for user %d, amount: %d %s, tier: %s (payment record already created by checkout.session.completed)",
-			user.ID, invoice.AmountPaid, string(invoice.Currency), subscriptionTier))
+		fmt.Sprintf("Invoice paid for user %d, amount: %d %s, tier: %s, billing reason: %s",
+			user.ID, invoice.AmountPaid, string(invoice.Currency), subscriptionTier, invoice.BillingReason))
+	return nil
+}
+
+// recordRenewal creates the payment record for a subscription renewal invoice, once per invoice,
+// saving the updates to the user's subscription in the same transaction
+func (c *PaymentController) recordRenewal(user *models.User, invoice *stripe.Invoice, plan *models.Plan, updates map[string]interface{}) (*models.Payment, error) {
+	if existing, err := models.FindPaidPaymentByStripeID(c.DB, invoice.ID); err == nil {
+		return existing, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to check payment record for invoice %s: %w", invoice.ID, err)
+	}
+
+	payment := models.Payment{
+		UserID:      user.ID,
+		Amount:      invoice.AmountPaid,
+		Currency:    string(invoice.Currency),
+		PaymentType: "subscription_renewal",
+		Status:      "succeeded",
+		Description: strings.Title(plan.Tier) + " Subscription Renewal",
+		Tier:        plan.Tier,
+		StripeID:    invoice.ID,
+		TaxAmount:   invoice.Tax,
+	}
+
+	if start, end := invoicePeriod(invoice); end > 0 {
+		periodStart, periodEnd := time.Unix(start, 0), time.Unix(end, 0)
+		payment.PeriodStart = &periodStart
+		payment.PeriodEnd = &periodEnd
+	}
+
+	err := c.DB.Transaction(func(tx *gorm.DB) error {
+		if err := models.CreatePayment(tx, &payment); err != nil {
+			return fmt.Errorf("failed to create payment record for invoice %s: %w", invoice.ID, err)
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(user).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to update subscription for user %d: %w", user.ID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created renewal payment record for user %d from invoice %s", user.ID, invoice.ID)
+	return &payment, nil
+}
+
+// errUnknownPlan is returned when a payment can't be matched to one of our paid plans
+var errUnknownPlan = errors.New("not a known paid plan")
+
+// findPaidPlan finds the plan a payment was for, from the tier in the checkout or subscription metadata,
+// or else the Stripe price it was charged at. Plans that are no longer offered still match, so retiring
+// a plan doesn't stop renewals for the people already subscribed to it.
+func findPaidPlan(db *gorm.DB, metadata map[string]string, price *stripe.Price) (*models.Plan, error) {
+	var plan *models.Plan
+	var err error
+	switch {
+	case metadata["subscription_tier"] != "":
+		plan, err = models.FindPlanByTier(db, metadata["subscription_tier"])
+	case metadata["product_id"] != "":
+		plan, err = models.FindPlanByStripeID(db, metadata["product_id"])
+	case price != nil:
+		plan, err = models.FindPlanByStripeID(db, price.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) && price.Product != nil {
+			plan, err = models.FindPlanByStripeID(db, price.Product.ID)
+		}
+	default:
+		return nil, errUnknownPlan
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errUnknownPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plan.IsFree() {
+		return nil, errUnknownPlan
+	}
+	return plan, nil
+}
+
+// invoiceSubscriptionLine returns the line charging for the subscription itself, skipping
+// credits for unused time, or nil if the invoice has none
+func invoiceSubscriptionLine(invoice *stripe.Invoice) *stripe.InvoiceLine {
+	if invoice.Lines == nil {
+		return nil
+	}
+	for _, line := range invoice.Lines.Data {
+		if line.Amount >= 0 && (line.Price != nil || line.Metadata["subscription_tier"] != "") {
+			return line
+		}
+	}
+	return nil
+}
+
+// invoicePeriod returns the billing period an invoice pays for as Unix times. The subscription line
+// carries the period; the invoice's own period is only used when there isn't one.
+func invoicePeriod(invoice *stripe.Invoice) (start, end int64) {
+	if invoice.Lines != nil {
+		for _, line := range invoice.Lines.Data {
+			if line.Period != nil && line.Period.End > 0 {
+				return line.Period.Start, line.Period.End
+			}
+		}
+	}
+	return invoice.PeriodStart, invoice.PeriodEnd
+}
+
+// findInvoicePayment finds the payment recorded for an invoice paid at checkout or on a plan change,
+// matching only on Stripe IDs. An invoice that matches nothing is recorded as a new payment.
+func (c *PaymentController) findInvoicePayment(user *models.User, invoice *stripe.Invoice) (*models.Payment, error) {
+	ids := []string{invoice.ID}
+	if invoice.PaymentIntent != nil {
+		ids = append(ids, invoice.PaymentIntent.ID)
+	}
+	if invoice.Charge != nil {
+		ids = append(ids, invoice.Charge.ID)
+	}
+	payment, err := models.FindPaidPaymentByStripeIDs(c.DB, ids...)
+	if err == nil {
+		return payment, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find payment for invoice %s: %w", invoice.ID, err)
+	}
+
+	// A subscription's first invoice is paid at checkout
+	firstInvoice := invoice.BillingReason == stripe.InvoiceBillingReasonSubscriptionCreate && invoice.Subscription != nil
+	if firstInvoice {
+		payment, err = models.FindSubscriptionStartPayment(c.DB, invoice.Subscription.ID)
+		if err == nil {
+			return payment, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to find checkout payment for invoice %s: %w", invoice.ID, err)
+		}
+	}
+
+	// Nothing charged, such as the start of a trial, isn't a payment
+	if invoice.AmountPaid == 0 {
+		return nil, nil
+	}
+
+	payment = &models.Payment{
+		UserID:      user.ID,
+		Amount:      invoice.AmountPaid,
+		Currency:    string(invoice.Currency),
+		PaymentType: "invoice",
+		Status:      "succeeded",
+		Description: "Invoice Payment",
+		StripeID:    invoice.ID,
+		TaxAmount:   invoice.Tax,
+	}
+	if firstInvoice {
+		// The checkout's own payment record then matches this one rather than adding another
+		payment.PaymentType = "subscription"
+		payment.StripeSubscriptionID = invoice.Subscription.ID
+	}
+	if start, end := invoicePeriod(invoice); end > 0 {
+		periodStart, periodEnd := time.Unix(start, 0), time.Unix(end, 0)
+		payment.PeriodStart = &periodStart
+		payment.PeriodEnd = &periodEnd
+	}
+	if err := models.CreatePayment(c.DB, payment); err != nil {
+		return nil, fmt.Errorf("failed to create payment record for invoice %s: %w", invoice.ID, err)
+	}
+	return payment, nil
+}
+
+// sendReceipt emails the PDF receipt for a payment, logging rather than returning failures
+func (c *PaymentController) sendReceipt(user *models.User, payment *models.Payment) {
+	if c.EmailService == nil {
+		return
+	}
+
+	pdf, err := receipt.RenderPDF(c.Business, user, payment)
+	if err != nil {
+		log.Printf("Failed to render receipt for payment %d: %v", payment.ID, err)
+		return
+	}
+	if err := c.EmailService.SendReceiptEmail(user.Email, *payment, pdf); err != nil {
+		log.Printf("Failed to email receipt for payment %d to user %d: %v", payment.ID, user.ID, err)
+	}
 }
 
 // handleInvoicePaymentFailed processes a failed invoice payment
-func handleInvoicePaymentFailed(c *PaymentController, ctx *gin.Context, event stripe.Event) {
+func handleInvoicePaymentFailed(c *PaymentController, event stripe.Event) error {
 	var invoice stripe.Invoice
 	err := json.Unmarshal(event.Data.Raw, &invoice)
 	if err != nil {
-		log.Printf("Error parsing invoice.payment_failed event: %v", err)
-		return
+		return fmt.Errorf("error parsing invoice.payment_failed event: %w", err)
 	}
 
 	// Try to find the user by Stripe customer ID
 	var user models.User
 	if err := c.DB.Where("stripe_customer_id = ?", invoice.Customer.ID).First(&user).Error; err != nil {
-		log.Printf("Failed to find user for customer %s: %v", invoice.Customer.ID, err)
-		return
+		return fmt.Errorf("failed to find user for customer %s: %w", invoice.Customer.ID, err)
 	}
 
-	// Create a payment record for the failed payment
-	payment := models.Payment{
-		UserID:      user.ID,
-		Amount:      invoice.AmountDue,
-		Currency:    string(invoice.Currency),
-		PaymentType: "invoice",
-		Status:      "failed",
-		Description: "Failed Invoice Payment",
-		StripeID:    invoice.ID,
+	// Mark a plan change's pending payment as failed, or else record the failed payment
+	settled, err := models.SettlePendingPayment(c.DB, invoice.ID, "failed")
+	if err != nil {
+		return fmt.Errorf("failed to record failed payment for invoice %s: %w", invoice.ID, err)
 	}
+	if !settled {
+		payment := models.Payment{
+			UserID:      user.ID,
+			Amount:      invoice.AmountDue,
+			Currency:    string(invoice.Currency),
+			PaymentType: "invoice",
+			Status:      "failed",
+			Description: "Failed Invoice Payment",
+			StripeID:    invoice.ID,
+		}
 
-	if err := models.CreatePayment(c.DB, &payment); err != nil {
-		log.Printf("Failed to create payment record for failed invoice %s: %v", invoice.ID, err)
+		if err := models.CreatePayment(c.DB, &payment); err != nil {
+			return fmt.Errorf("failed to create payment record for failed invoice %s: %w", invoice.ID, err)
+		}
+	}
+
+	// Start the grace period; the dunning job sends reminders and downgrades the user if it runs out.
+	// Later failures while it's running don't extend it.
+	if !user.IsLifetimeSubscriber() && !user.InGracePeriod() {
+		now := time.Now()
+		if err := c.DB.Model(&user).Updates(map[string]interface{}{
+			"payment_failed_at":      now,
+			"grace_period_ends_at":   now.Add(models.PaymentGracePeriod),
+			"dunning_reminders_sent": 0,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to start grace period for user %d: %w", user.ID, err)
+		}
 	}
 
 	// Log the event
 	logWebhookEvent("invoice.payment_failed", invoice.ID, fmt.Sprintf("%d", user.ID), "failed",
 		fmt.Sprintf("Invoice payment failed for user %d, amount: %d %s", user.ID, invoice.AmountDue, invoice.Currency))
+	return nil
 }
 
 // HandlePaymentSuccess handles successful payments
@@ -637,105 +1021,8 @@ func (c *PaymentController) HandlePaymentSuccess(ctx *gin.Context) {
 		return
 	}
 
-	// Check if we're in test mode and using a test session ID
-	if os.Getenv("APP_ENV") == "test" && strings.HasPrefix(sessionID, "cs_test_") {
-		// In test mode, we don't need to verify with Stripe
-		// Just log the success and redirect
-		log.Printf("Test payment success for user %d, session %s", user.ID, sessionID)
-
-		// Extract the tier from the session ID (for test purposes)
-		var tier string
-		if strings.Contains(sessionID, "monthly") {
-			tier = "monthly"
-		} else if strings.Contains(sessionID, "yearly") {
-			tier = "yearly"
-		} else if strings.Contains(sessionID, "premium") {
-			tier = "premium_lifetime"
-		} else if strings.Contains(sessionID, "lifetime") {
-			tier = "lifetime"
-		} else {
-			// Default to monthly if not specified
-			tier = "monthly"
-		}
-
-		// Calculate expiration date based on tier
-		var expirationDate time.Time
-		hasExistingTime := !user.SubscriptionExpiresAt.IsZero() && user.SubscriptionExpiresAt.After(time.Now())
-
-		if hasExistingTime && tier == user.SubscriptionTier {
-			// If the tier hasn't changed, keep the existing expiration date
-			expirationDate = user.SubscriptionExpiresAt
-		} else if hasExistingTime && tier == "yearly" && user.SubscriptionTier == "monthly" {
-			// If upgrading from monthly to yearly, add 1 year to the existing expiration date
-			expirationDate = user.SubscriptionExpiresAt.AddDate(1, 0, 0)
-		} else if tier == "premium_lifetime" && user.SubscriptionTier == "lifetime" {
-			// If upgrading from lifetime to premium lifetime, keep the same expiration date
-			expirationDate = user.SubscriptionExpiresAt
-		} else if tier == "lifetime" || tier == "premium_lifetime" {
-			// For lifetime subscriptions, set to 100 years from now
-			expirationDate = time.Now().AddDate(100, 0, 0)
-		} else {
-			// Start from now for new subscriptions or downgrades
-			expirationDate = time.Now()
-
-			// Add time based on the new subscription tier
-			switch tier {
-			case "monthly":
-				expirationDate = expirationDate.AddDate(0, 1, 0) // Add 1 month
-			case "yearly":
-				expirationDate = expirationDate.AddDate(1, 0, 0) // Add 1 year
-			}
-		}
-
-		// Update the user's subscription
-		if err := c.DB.Model(user).Updates(map[string]interface{}{
-			"subscription_tier":       tier,
-			"subscription_expires_at": expirationDate,
-			"subscription_canceled":   false,
-		}).Error; err != nil {
-			log.Printf("Failed to update user %d subscription in test mode: %v", user.ID, err)
-		}
-
-		// Create a payment record
-		var amount int64
-		switch tier {
-		case "monthly":
-			amount = 500 // $5.00
-		case "yearly":
-			amount = 3000 // $30.00
-		case "lifetime":
-			amount = 15000 // $150.00
-		case "premium_lifetime":
-			amount = 30000 // $300.00
-		}
-
-		payment := models.Payment{
-			UserID:      user.ID,
-			Amount:      amount,
-			Currency:    "usd",
-			PaymentType: "subscription",
-			Status:      "succeeded",
-			Description: strings.Title(tier) + " Subscription",
-			StripeID:    sessionID,
-		}
-
-		if err := models.CreatePayment(c.DB, &payment); err != nil {
-			log.Printf("Failed to create payment record in test mode: %v", err)
-		}
-
-		// Set a success message
-		flash.SetMessage(ctx, "Your payment was successful! Thank you for your subscription.", "success")
-
-		// Redirect to the owner page
-		ctx.Redirect(http.StatusSeeOther, "/owner")
-		return
-	}
-
-	// Set Stripe API key
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	// Retrieve the session from Stripe to verify it
-	s, err := session.Get(sessionID, nil)
+	// Retrieve the session from the payment provider to verify it
+	s, err := c.Provider.GetCheckoutSession(sessionID)
 	if err != nil {
 		log.Printf("Error retrieving session %s: %v", sessionID, err)
 		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to verify payment"})
@@ -816,6 +1103,68 @@ func (c *PaymentController) ShowPaymentHistory(ctx *gin.Context) {
 	}
 }
 
+// ShowReceipt displays the receipt for one of the current user's payments
+func (c *PaymentController) ShowReceipt(ctx *gin.Context) {
+	user, payment, ok := c.findReceiptPayment(ctx)
+	if !ok {
+		return
+	}
+
+	component := paymentViews.Receipt(user, payment, c.Business)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// DownloadReceipt sends the PDF receipt for one of the current user's payments
+func (c *PaymentController) DownloadReceipt(ctx *gin.Context) {
+	user, payment, ok := c.findReceiptPayment(ctx)
+	if !ok {
+		return
+	}
+
+	// Render the receipt into a buffer so errors can still be shown to the user
+	pdf, err := receipt.RenderPDF(c.Business, user, payment)
+	if err != nil {
+		log.Printf("Failed to render receipt for payment %d: %v", payment.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to generate receipt"})
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", receipt.Filename(payment)))
+	ctx.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// findReceiptPayment loads the payment in the URL, scoped to the current user.
+// It writes the response and returns false if there's no receipt to show.
+func (c *PaymentController) findReceiptPayment(ctx *gin.Context) (*models.User, *models.Payment, bool) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return nil, nil, false
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Receipt not found"})
+		return nil, nil, false
+	}
+
+	// Other users' payments look the same as missing ones
+	payment, err := models.FindPaymentByIDAndUser(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Receipt not found"})
+		return nil, nil, false
+	}
+
+	// Failed and pending payments weren't paid, so they don't get a receipt
+	if !payment.HasReceipt() {
+		flash.SetMessage(ctx, "Receipts are only available for successful payments.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
+		return nil, nil, false
+	}
+
+	return user, payment, true
+}
+
 // ShowCancelConfirmation displays the subscription cancellation confirmation page
 func (c *PaymentController) ShowCancelConfirmation(ctx *gin.Context) {
 	// Get the current user
@@ -826,7 +1175,7 @@ func (c *PaymentController) ShowCancelConfirmation(ctx *gin.Context) {
 	}
 
 	// Check if the user has an active subscription
-	if user.SubscriptionTier == "free" || user.IsLifetimeSubscriber() {
+	if user.SubscriptionTier == models.TierFree || user.IsLifetimeSubscriber() {
 		// Redirect to payment history if the user doesn't have a recurring subscription
 		flash.SetMessage(ctx, "You don't have an active recurring subscription to cancel.", "error")
 		ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
@@ -855,7 +1204,7 @@ func (c *PaymentController) CancelSubscription(ctx *gin.Context) {
 	}
 
 	// Check if the user has an active subscription
-	if user.SubscriptionTier == "free" || user.IsLifetimeSubscriber() {
+	if user.SubscriptionTier == models.TierFree || user.IsLifetimeSubscriber() {
 		// Redirect to payment history if the user doesn't have a recurring subscription
 		flash.SetMessage(ctx, "You don't have an active recurring subscription to cancel.", "error")
 		ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
@@ -885,43 +1234,16 @@ func (c *PaymentController) CancelSubscription(ctx *gin.Context) {
 		return
 	}
 
-	// Set Stripe API key
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-
-	// Check if the user has a Stripe subscription ID
-	if user.StripeSubscriptionID == "" {
-		// Try to find the subscription by customer ID
-		params := &stripe.SubscriptionListParams{}
-		params.Customer = user.StripeCustomerID
-		iter := sub.List(params)
-		var subscription *stripe.Subscription
-		for iter.Next() {
-			subscription = iter.Subscription()
-			break
-		}
-
-		if subscription == nil {
-			log.Printf("Failed to find subscription for user %d with customer ID %s", user.ID, user.StripeCustomerID)
-			flash.SetMessage(ctx, "Failed to find your subscription. Please contact support.", "error")
-			ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
-			return
-		}
-
-		// Save the subscription ID for future use
-		if err := c.DB.Model(user).Update("stripe_subscription_id", subscription.ID).Error; err != nil {
-			log.Printf("Failed to save subscription ID for user %d: %v", user.ID, err)
-		}
-
-		// Use the found subscription ID
-		user.StripeSubscriptionID = subscription.ID
+	// Make sure we know the user's Stripe subscription ID
+	if err := c.ensureSubscriptionID(user); err != nil {
+		log.Printf("Failed to find subscription for user %d with customer ID %s: %v", user.ID, user.StripeCustomerID, err)
+		flash.SetMessage(ctx, "Failed to find your subscription. Please contact support.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
+		return
 	}
 
 	// Cancel the subscription at period end
-	_, err = sub.Update(user.StripeSubscriptionID, &stripe.SubscriptionParams{
-		CancelAtPeriodEnd: stripe.Bool(true),
-	})
-
-	if err != nil {
+	if err := c.Provider.CancelSubscriptionAtPeriodEnd(user.StripeSubscriptionID); err != nil {
 		log.Printf("Failed to cancel subscription for user %d: %v", user.ID, err)
 		flash.SetMessage(ctx, "Failed to cancel subscription with Stripe. Please try again.", "error")
 		ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
@@ -938,15 +1260,139 @@ func (c *PaymentController) CancelSubscription(ctx *gin.Context) {
 	ctx.Redirect(http.StatusSeeOther, "/owner/payment-history")
 }
 
-// HandleCheckoutRedirect handles GET requests to /checkout and redirects to the appropriate Stripe payment link
-func (c *PaymentController) HandleCheckoutRedirect(ctx *gin.Context) {
-	// Get the subscription tier from the query parameters
-	tier := ctx.Query("tier")
-	if tier == "" {
-		ctx.Redirect(http.StatusSeeOther, "/pricing")
+// ensureSubscriptionID looks up the user's subscription by customer ID if we haven't stored it yet
+func (c *PaymentController) ensureSubscriptionID(user *models.User) error {
+	if user.StripeSubscriptionID != "" {
+		return nil
+	}
+
+	subscriptionID, err := c.Provider.FindSubscriptionID(user.StripeCustomerID)
+	if err != nil {
+		return err
+	}
+
+	// Save the subscription ID for future use
+	if err := c.DB.Model(user).Update("stripe_subscription_id", subscriptionID).Error; err != nil {
+		log.Printf("Failed to save subscription ID for user %d: %v", user.ID, err)
+	}
+	user.StripeSubscriptionID = subscriptionID
+	return nil
+}
+
+// ChangeSubscription moves the user to another plan. Recurring plans are swapped on the existing
+// subscription with proration, while one-time lifetime plans go through checkout and replace the
+// subscription once paid.
+func (c *PaymentController) ChangeSubscription(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Look up the plan being moved to
+	plan, err := models.FindActivePlanByTier(c.DB, ctx.PostForm("tier"))
+	if err != nil || plan.IsFree() {
+		flash.SetMessage(ctx, "Please choose a paid plan. To move to the free plan, cancel your subscription instead.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+		return
+	}
+
+	if plan.Tier == user.SubscriptionTier && user.HasActiveSubscription() {
+		flash.SetMessage(ctx, "You're already on the "+plan.Name+" plan.", "info")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+		return
+	}
+
+	// Lifetime plans can only move up to premium lifetime, which is a regular purchase
+	if user.IsLifetimeSubscriber() && plan.Tier != models.TierPremiumLifetime {
+		flash.SetMessage(ctx, "Lifetime plans can't be changed to a recurring plan.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+		return
+	}
+
+	// Without a recurring subscription to change, or when moving to a one-time plan, the user pays at checkout
+	hasRecurring := user.HasActiveSubscription() && !user.IsLifetimeSubscriber()
+	if !hasRecurring || !plan.IsRecurring() {
+		ctx.Redirect(http.StatusSeeOther, "/checkout?tier="+plan.Tier)
+		return
+	}
+
+	if err := c.ensureSubscriptionID(user); err != nil {
+		log.Printf("Failed to find subscription for user %d with customer ID %s: %v", user.ID, user.StripeCustomerID, err)
+		flash.SetMessage(ctx, "Failed to find your subscription. Please contact support.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
 		return
 	}
 
+	change, err := c.Provider.ChangeSubscriptionPlan(user.StripeSubscriptionID, plan)
+	if err != nil {
+		log.Printf("Failed to change subscription for user %d to %s: %v", user.ID, plan.Tier, err)
+		flash.SetMessage(ctx, "Failed to change your plan. Please try again.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+		return
+	}
+
+	previousTier := user.SubscriptionTier
+	if err := c.DB.Model(user).Updates(map[string]interface{}{
+		"subscription_tier":       plan.Tier,
+		"subscription_expires_at": change.CurrentPeriodEnd,
+		"stripe_subscription_id":  change.SubscriptionID,
+		"subscription_canceled":   false, // Changing plans resumes renewal
+	}).Error; err != nil {
+		log.Printf("Failed to update user %d after changing to %s: %v", user.ID, plan.Tier, err)
+		flash.SetMessage(ctx, "Your plan was changed but we couldn't update your account. Please contact support.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+		return
+	}
+
+	// Record the change alongside the user's other payments; it covers the rest of the current period.
+	// A prorated charge stays pending until invoice.paid confirms it went through.
+	now := time.Now()
+	status := "succeeded"
+	if change.AmountDue > 0 && change.InvoiceID != "" {
+		status = "pending"
+	}
+	payment := models.Payment{
+		UserID:      user.ID,
+		Amount:      change.AmountDue,
+		Currency:    change.Currency,
+		PaymentType: "subscription_change",
+		Status:      status,
+		Description: fmt.Sprintf("Plan change from %s to %s", strings.Title(previousTier), strings.Title(plan.Tier)),
+		Tier:        plan.Tier,
+		StripeID:    change.InvoiceID,
+		PeriodStart: &now,
+		PeriodEnd:   &change.CurrentPeriodEnd,
+	}
+	history := models.SubscriptionChange{
+		UserID:    user.ID,
+		FromTier:  previousTier,
+		ToTier:    plan.Tier,
+		Cause:     models.SubscriptionCauseUser,
+		ExpiresAt: &change.CurrentPeriodEnd,
+		Note:      "Changed plan",
+	}
+	if err := models.CreatePayment(c.DB, &payment); err != nil {
+		log.Printf("Failed to record plan change for user %d: %v", user.ID, err)
+	} else {
+		history.PaymentID = &payment.ID
+	}
+	recordSubscriptionChange(c.DB, &history)
+
+	log.Printf("Changed subscription for user %d from %s to %s, prorated amount %d", user.ID, previousTier, plan.Tier, change.AmountDue)
+	message := "Your plan has been changed to " + plan.Name + "."
+	if change.AmountDue > 0 {
+		message += " We're charging " + payment.FormatAmount() + " for the rest of this billing period and will email your receipt once it goes through."
+	} else {
+		message += " Unused time on your previous plan has been credited to your account."
+	}
+	flash.SetMessage(ctx, message, "success")
+	ctx.Redirect(http.StatusSeeOther, "/profile/subscription")
+}
+
+// HandleCheckoutRedirect handles GET requests to /checkout by sending the plan on to the POST checkout endpoint
+func (c *PaymentController) HandleCheckoutRedirect(ctx *gin.Context) {
 	// Check if user is logged in
 	_, err := auth.GetCurrentUser(ctx)
 	if err != nil {
@@ -954,50 +1400,35 @@ func (c *PaymentController) HandleCheckoutRedirect(ctx *gin.Context) {
 		return
 	}
 
-	// Get the appropriate Stripe payment link
-	var paymentLink string
-	switch tier {
-	case "monthly":
-		paymentLink = os.Getenv("STRIPE_LINK_MONTHLY")
-	case "yearly":
-		paymentLink = os.Getenv("STRIPE_LINK_YEARLY")
-	case "lifetime":
-		paymentLink = os.Getenv("STRIPE_LINK_LIFETIME")
-	case "premium_lifetime":
-		paymentLink = os.Getenv("STRIPE_LINK_PREMIUM")
-	default:
+	// Only plans that are currently offered can be bought
+	plan, err := models.FindActivePlanByTier(c.DB, ctx.Query("tier"))
+	if err != nil || plan.IsFree() {
 		ctx.Redirect(http.StatusSeeOther, "/pricing")
 		return
 	}
-
-	// If the payment link is not set, fall back to the POST checkout endpoint
-	if paymentLink == "" {
-		// Render a form that submits to the POST /checkout endpoint
-		html := `
-		<!DOCTYPE html>
-		<html>
-		<head>
-			<title>Redirecting to Checkout</title>
-			<script>
-				document.addEventListener('DOMContentLoaded', function() {
-					document.getElementById('checkout-form').submit();
-				});
-			</script>
-		</head>
-		<body>
-			<form id="checkout-form" method="POST" action="/checkout">
-				<input type="hidden" name="tier" value="` + tier + `">
-				<p>Redirecting to checkout...</p>
-				<button type="submit">Click here if you are not redirected automatically</button>
-			</form>
-		</body>
-		</html>
-		`
-		ctx.Header("Content-Type", "text/html")
-		ctx.String(http.StatusOK, html)
-		return
-	}
-
-	// Redirect to the Stripe payment link
-	ctx.Redirect(http.StatusSeeOther, paymentLink)
+	tier := html.EscapeString(plan.Tier)
+
+	// Render a form that submits to the POST /checkout endpoint
+	page := `
+	<!DOCTYPE html>
+	<html>
+	<head>
+		<title>Redirecting to Checkout</title>
+		<script>
+			document.addEventListener('DOMContentLoaded', function() {
+				document.getElementById('checkout-form').submit();
+			});
+		</script>
+	</head>
+	<body>
+		<form id="checkout-form" method="POST" action="/checkout">
+			<input type="hidden" name="tier" value="` + tier + `">
+			<p>Redirecting to checkout...</p>
+			<button type="submit">Click here if you are not redirected automatically</button>
+		</form>
+	</body>
+	</html>
+	`
+	ctx.Header("Content-Type", "text/html")
+	ctx.String(http.StatusOK, page)
 }
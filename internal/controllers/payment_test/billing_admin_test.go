@@ -0,0 +1,257 @@
+package payment_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// billingAdminRouter wires the billing admin routes for a logged in admin
+func billingAdminRouter(db *gorm.DB, provider billing.PaymentProvider, adminUser *models.User) *gin.Engine {
+	billingAdminController := controllers.NewBillingAdminController(db, provider)
+	router := gin.New()
+	router.HTMLRender = &payment_test_utils.TestRenderer{}
+	router.Use(payment_test_utils.SignedIn(adminUser), payment_test_utils.Sessions.LoadUser())
+	router.GET("/admin/billing", billingAdminController.Index)
+	router.GET("/admin/billing/users/:id", billingAdminController.Show)
+	router.POST("/admin/billing/users/:id/comp", billingAdminController.Comp)
+	router.POST("/admin/billing/users/:id/extend", billingAdminController.Extend)
+	router.POST("/admin/billing/users/:id/revoke", billingAdminController.Revoke)
+	router.POST("/admin/billing/payments/:id/refund", billingAdminController.Refund)
+	return router
+}
+
+func TestAdminRefundsPayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	adminUser, err := testutils.CreateTestUser(db, "billing-admin@example.com", "password123", true)
+	require.NoError(t, err)
+	provider := billing.NewFakeProvider()
+
+	purchase(t, planChangeRouter(db, provider, user), provider, models.TierYearly)
+	router := billingAdminRouter(db, provider, adminUser)
+
+	var payment models.Payment
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&payment).Error)
+	refundPath := fmt.Sprintf("/admin/billing/payments/%d/refund", payment.ID)
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	assert.Equal(t, http.StatusOK, get(router, "/admin/billing?q=example.com").Code)
+	assert.Equal(t, http.StatusOK, get(router, userPath).Code)
+
+	// Every refund needs a reason
+	w := postForm(router, refundPath, url.Values{"amount": {"10.00"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, userPath, w.Header().Get("Location"))
+	assert.Empty(t, provider.Refunds(payment.StripeID))
+
+	// A partial refund
+	w = postForm(router, refundPath, url.Values{"amount": {"10.00"}, "reason": {"Billed twice for part of the year"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var refunded models.Payment
+	require.NoError(t, db.First(&refunded, payment.ID).Error)
+	assert.Equal(t, int64(1000), refunded.Refunded)
+	assert.Equal(t, "succeeded", refunded.Status)
+	refunds := provider.Refunds(payment.StripeID)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, int64(1000), refunds[0].Amount)
+
+	// More than what's left can't be refunded
+	postForm(router, refundPath, url.Values{"amount": {"25.00"}, "reason": {"Too much"}})
+	assert.Len(t, provider.Refunds(payment.StripeID), 1)
+
+	// A blank amount refunds the rest
+	postForm(router, refundPath, url.Values{"reason": {"Customer asked to cancel"}})
+	var fullyRefunded models.Payment
+	require.NoError(t, db.First(&fullyRefunded, payment.ID).Error)
+	assert.Equal(t, payment.Amount, fullyRefunded.Refunded)
+	assert.Equal(t, "refunded", fullyRefunded.Status)
+	assert.Equal(t, int64(0), fullyRefunded.RefundableAmount())
+	assert.True(t, fullyRefunded.HasReceipt())
+
+	postForm(router, refundPath, url.Values{"reason": {"Again"}})
+	assert.Len(t, provider.Refunds(payment.StripeID), 2)
+
+	// Each refund is recorded with the admin and reason
+	adjustments, err := models.FindBillingAdjustmentsByUser(db, user.ID)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 2)
+	assert.Equal(t, models.AdjustmentRefund, adjustments[1].Action)
+	assert.Equal(t, adminUser.ID, adjustments[1].AdminID)
+	assert.Equal(t, adminUser.Email, adjustments[1].Admin.Email)
+	assert.Equal(t, "Billed twice for part of the year", adjustments[1].Reason)
+	assert.Equal(t, int64(1000), adjustments[1].Amount)
+	assert.Equal(t, refunds[0].ID, adjustments[1].StripeID)
+	require.NotNil(t, adjustments[1].PaymentID)
+	assert.Equal(t, payment.ID, *adjustments[1].PaymentID)
+	assert.Equal(t, payment.Amount-1000, adjustments[0].Amount)
+}
+
+func TestAdminRefundsAreReserved(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	adminUser, err := testutils.CreateTestUser(db, "billing-admin@example.com", "password123", true)
+	require.NoError(t, err)
+	provider := billing.NewFakeProvider()
+	router := billingAdminRouter(db, provider, adminUser)
+
+	// The provider can't refund a payment it has no record of
+	payment := models.Payment{UserID: user.ID, Amount: 3000, Currency: "usd", Status: "succeeded", PaymentType: "one-time"}
+	require.NoError(t, db.Create(&payment).Error)
+	refundPath := fmt.Sprintf("/admin/billing/payments/%d/refund", payment.ID)
+
+	postForm(router, refundPath, url.Values{"amount": {"10.00"}, "reason": {"Provider is down"}})
+
+	// So the amount held back for the refund is given back
+	var released models.Payment
+	require.NoError(t, db.First(&released, payment.ID).Error)
+	assert.Equal(t, int64(0), released.Refunded)
+	assert.Equal(t, "succeeded", released.Status)
+	adjustments, err := models.FindBillingAdjustmentsByUser(db, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, adjustments)
+
+	// Two admins looking at the same payment can't both refund what's left
+	first, second := released, released
+	reserved, err := models.ReserveRefund(db, &first, 3000)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+	reserved, err = models.ReserveRefund(db, &second, 3000)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+
+	var updated models.Payment
+	require.NoError(t, db.First(&updated, payment.ID).Error)
+	assert.Equal(t, int64(3000), updated.Refunded)
+
+	// Asking the provider again with the same key doesn't refund twice
+	refundID, err := provider.RefundPayment("pi_reserved", 3000, "refund-key")
+	require.NoError(t, err)
+	again, err := provider.RefundPayment("pi_reserved", 3000, "refund-key")
+	require.NoError(t, err)
+	assert.Equal(t, refundID, again)
+	assert.Len(t, provider.Refunds("pi_reserved"), 1)
+}
+
+func TestAdminAdjustsSubscription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	adminUser, err := testutils.CreateTestUser(db, "billing-admin@example.com", "password123", true)
+	require.NoError(t, err)
+	provider := billing.NewFakeProvider()
+
+	purchase(t, planChangeRouter(db, provider, user), provider, models.TierMonthly)
+	router := billingAdminRouter(db, provider, adminUser)
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	var subscribed models.User
+	require.NoError(t, db.First(&subscribed, user.ID).Error)
+	subscriptionID := subscribed.StripeSubscriptionID
+
+	// Paying subscribers can't be comped, since they'd still be billed
+	postForm(router, userPath+"/comp", url.Values{"tier": {models.TierYearly}, "days": {"30"}, "reason": {"Goodwill"}})
+	var unchanged models.User
+	require.NoError(t, db.First(&unchanged, user.ID).Error)
+	assert.Equal(t, models.TierMonthly, unchanged.SubscriptionTier)
+
+	// Extending moves the renewal with the provider too
+	w := postForm(router, userPath+"/extend", url.Values{"days": {"10"}, "reason": {"Outage last week"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var extended models.User
+	require.NoError(t, db.First(&extended, user.ID).Error)
+	assert.WithinDuration(t, subscribed.SubscriptionExpiresAt.AddDate(0, 0, 10), extended.SubscriptionExpiresAt, time.Second)
+	subscription, ok := provider.Subscription(subscriptionID)
+	require.True(t, ok)
+	assert.WithinDuration(t, extended.SubscriptionExpiresAt, subscription.CurrentPeriodEnd, time.Second)
+
+	// The number of days is checked
+	postForm(router, userPath+"/extend", url.Values{"days": {"0"}, "reason": {"Nothing"}})
+
+	// Revoking cancels billing and moves the user to the free tier
+	w = postForm(router, userPath+"/revoke", url.Values{"reason": {"Chargeback"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var revoked models.User
+	require.NoError(t, db.First(&revoked, user.ID).Error)
+	assert.Equal(t, models.TierFree, revoked.SubscriptionTier)
+	assert.Empty(t, revoked.StripeSubscriptionID)
+	assert.False(t, revoked.HasActiveSubscription())
+	subscription, _ = provider.Subscription(subscriptionID)
+	assert.True(t, subscription.Canceled)
+
+	// Free users can be comped a recurring plan, which doesn't renew
+	w = postForm(router, userPath+"/comp", url.Values{"tier": {models.TierYearly}, "days": {"30"}, "reason": {"Contest winner"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var comped models.User
+	require.NoError(t, db.First(&comped, user.ID).Error)
+	assert.Equal(t, models.TierYearly, comped.SubscriptionTier)
+	assert.True(t, comped.SubscriptionCanceled)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 30), comped.SubscriptionExpiresAt, time.Minute)
+	assert.True(t, comped.HasActiveSubscription())
+
+	// Comping the same plan again adds to the time left
+	postForm(router, userPath+"/comp", url.Values{"tier": {models.TierYearly}, "days": {"30"}, "reason": {"Second prize"}})
+	var compedAgain models.User
+	require.NoError(t, db.First(&compedAgain, user.ID).Error)
+	assert.WithinDuration(t, comped.SubscriptionExpiresAt.AddDate(0, 0, 30), compedAgain.SubscriptionExpiresAt, time.Second)
+
+	// Lifetime plans can't be comped for a number of days
+	postForm(router, userPath+"/comp", url.Values{"tier": {models.TierLifetime}, "days": {"30"}, "reason": {"Nope"}})
+	var stillYearly models.User
+	require.NoError(t, db.First(&stillYearly, user.ID).Error)
+	assert.Equal(t, models.TierYearly, stillYearly.SubscriptionTier)
+
+	// Only the successful changes are recorded, newest first
+	adjustments, err := models.FindBillingAdjustmentsByUser(db, user.ID)
+	require.NoError(t, err)
+	require.Len(t, adjustments, 4)
+	assert.Equal(t, []string{models.AdjustmentComp, models.AdjustmentComp, models.AdjustmentRevoke, models.AdjustmentExtend},
+		[]string{adjustments[0].Action, adjustments[1].Action, adjustments[2].Action, adjustments[3].Action})
+
+	revoke := adjustments[2]
+	assert.Equal(t, adminUser.ID, revoke.AdminID)
+	assert.Equal(t, "Chargeback", revoke.Reason)
+	assert.Equal(t, models.TierMonthly, revoke.PreviousTier)
+	assert.Equal(t, models.TierFree, revoke.NewTier)
+
+	extend := adjustments[3]
+	assert.Equal(t, 10, extend.Days)
+	require.NotNil(t, extend.PreviousExpiresAt)
+	assert.WithinDuration(t, subscribed.SubscriptionExpiresAt, *extend.PreviousExpiresAt, time.Second)
+
+	assert.Equal(t, http.StatusOK, get(router, userPath).Code)
+	assert.Equal(t, http.StatusOK, get(router, "/admin/billing").Code)
+	assert.Equal(t, http.StatusNotFound, get(router, "/admin/billing/users/999999").Code)
+}
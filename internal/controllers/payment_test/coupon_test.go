@@ -0,0 +1,221 @@
+package payment_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCouponCheckout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	couponController := controllers.NewCouponController(db, provider)
+	router.POST("/admin/coupons", couponController.Create)
+
+	// Create a coupon limited to two uses
+	w := postForm(router, "/admin/coupons", url.Values{
+		"code":            {" spring20 "},
+		"discount_type":   {"percent"},
+		"percent_off":     {"20"},
+		"duration":        {models.CouponDurationOnce},
+		"max_redemptions": {"2"},
+		"expires_at":      {time.Now().AddDate(0, 1, 0).Format("2006-01-02")},
+	})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	coupon, err := models.FindCouponByCode(db, "SPRING20")
+	require.NoError(t, err)
+	assert.True(t, coupon.Active)
+	assert.NotEmpty(t, coupon.StripeCouponID)
+	assert.Equal(t, "20% off your first payment", coupon.Summary())
+
+	// The same code can't be created twice
+	w = postForm(router, "/admin/coupons", url.Values{
+		"code": {"SPRING20"}, "discount_type": {"percent"}, "percent_off": {"10"}, "duration": {models.CouponDurationOnce},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// An unknown code sends the user back to the pricing page
+	w = postForm(router, "/checkout", url.Values{"tier": {models.TierMonthly}, "coupon": {"NOPE"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/pricing", w.Header().Get("Location"))
+
+	// Check out with the coupon
+	w = postForm(router, "/checkout", url.Values{"tier": {models.TierYearly}, "coupon": {"spring20"}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	sessionID := location.Query().Get("session_id")
+
+	hook, err := provider.CompleteCheckout(sessionID)
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var payment models.Payment
+	require.NoError(t, db.Where("stripe_id = ?", sessionID).First(&payment).Error)
+	assert.Equal(t, int64(2400), payment.Amount)
+
+	// The redemption is recorded against the user and the coupon's limit
+	var redemption models.CouponRedemption
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&redemption).Error)
+	assert.Equal(t, coupon.ID, redemption.CouponID)
+	assert.Equal(t, sessionID, redemption.StripeID)
+	assert.Equal(t, models.CouponRedemptionRedeemed, redemption.Status)
+
+	coupon, err = models.FindCouponByCode(db, "SPRING20")
+	require.NoError(t, err)
+	assert.Equal(t, 1, coupon.TimesRedeemed)
+
+	// The user can't use the code again
+	assert.ErrorIs(t, coupon.CheckRedeemable(db, user.ID, time.Now()), models.ErrCouponAlreadyRedeemed)
+	w = postForm(router, "/checkout", url.Values{"tier": {models.TierLifetime}, "coupon": {"SPRING20"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/pricing", w.Header().Get("Location"))
+
+	// Once the limit is reached nobody else can either
+	other, err := testutils.CreateTestUser(db, "coupon-other@example.com", "password123", false)
+	require.NoError(t, err)
+	require.NoError(t, models.RedeemCoupon(db, coupon.ID, other.ID, "cs_other"))
+	coupon, err = models.FindCouponByCode(db, "SPRING20")
+	require.NoError(t, err)
+	assert.ErrorIs(t, coupon.CheckRedeemable(db, 0, time.Now()), models.ErrCouponExhausted)
+}
+
+func TestCouponIsHeldDuringCheckout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	// A coupon with a single use left
+	coupon := models.Coupon{Code: "LASTONE", PercentOff: 50, Duration: models.CouponDurationOnce, MaxRedemptions: 1, Active: true, StripeCouponID: "co_lastone"}
+	require.NoError(t, models.CreateCoupon(db, &coupon))
+
+	checkout := func() string {
+		w := postForm(router, "/checkout", url.Values{"tier": {models.TierMonthly}, "coupon": {"lastone"}})
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		location, err := url.Parse(w.Header().Get("Location"))
+		require.NoError(t, err)
+		return location.Query().Get("session_id")
+	}
+
+	// Starting checkout holds the use for the user
+	sessionID := checkout()
+	require.NotEmpty(t, sessionID)
+	var redemption models.CouponRedemption
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&redemption).Error)
+	assert.Equal(t, models.CouponRedemptionPending, redemption.Status)
+	assert.Equal(t, sessionID, redemption.StripeID)
+
+	// So the user can't open a second checkout with it, and nobody else can take it meanwhile
+	w := postForm(router, "/checkout", url.Values{"tier": {models.TierYearly}, "coupon": {"LASTONE"}})
+	assert.Equal(t, "/pricing", w.Header().Get("Location"))
+	other, err := testutils.CreateTestUser(db, "coupon-other@example.com", "password123", false)
+	require.NoError(t, err)
+	_, err = models.ReserveCoupon(db, coupon.ID, other.ID)
+	assert.ErrorIs(t, err, models.ErrCouponExhausted)
+
+	// When the checkout expires the use is given back
+	hook, err := provider.Sign("checkout.session.expired", map[string]interface{}{"id": sessionID, "object": "checkout.session"})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var count int64
+	require.NoError(t, db.Unscoped().Model(&models.CouponRedemption{}).Count(&count).Error)
+	assert.Zero(t, count)
+	released, err := models.FindCouponByID(db, coupon.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, released.TimesRedeemed)
+
+	// And the user can use it on a checkout they finish
+	sessionID = checkout()
+	hook, err = provider.CompleteCheckout(sessionID)
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var confirmed models.CouponRedemption
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&confirmed).Error)
+	assert.Equal(t, models.CouponRedemptionRedeemed, confirmed.Status)
+	assert.Equal(t, sessionID, confirmed.StripeID)
+	redeemed, err := models.FindCouponByID(db, coupon.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, redeemed.TimesRedeemed)
+
+	// A late expiry for the finished checkout doesn't give it back
+	hook, err = provider.Sign("checkout.session.expired", map[string]interface{}{"id": sessionID, "object": "checkout.session"})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+	redeemed, err = models.FindCouponByID(db, coupon.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, redeemed.TimesRedeemed)
+}
+
+func TestTrialCheckout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+	require.NoError(t, db.Model(&models.Plan{}).Where("tier = ?", models.TierMonthly).Update("trial_days", 14).Error)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierMonthly, updated.SubscriptionTier)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 14).AddDate(0, 1, 0), updated.SubscriptionExpiresAt, time.Minute)
+
+	// Nothing is charged until the trial ends
+	subscription, ok := provider.Subscription(updated.StripeSubscriptionID)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 14), subscription.TrialEnd, time.Minute)
+
+	var payment models.Payment
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&payment).Error)
+	assert.Equal(t, int64(0), payment.Amount)
+
+	// A returning customer doesn't get another trial
+	require.NoError(t, provider.CancelSubscription(updated.StripeSubscriptionID))
+	require.NoError(t, db.Model(&updated).Updates(map[string]interface{}{
+		"subscription_tier":      models.TierFree,
+		"stripe_subscription_id": "",
+	}).Error)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var returning models.User
+	require.NoError(t, db.First(&returning, user.ID).Error)
+	subscription, ok = provider.Subscription(returning.StripeSubscriptionID)
+	require.True(t, ok)
+	assert.True(t, subscription.TrialEnd.IsZero())
+}
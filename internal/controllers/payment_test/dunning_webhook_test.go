@@ -0,0 +1,133 @@
+package payment_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvoiceWebhooksManageGracePeriod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+
+	invoice := func(id string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"object":     "invoice",
+			"customer":   updated.StripeCustomerID,
+			"amount_due": 500,
+			"currency":   "usd",
+		}
+	}
+
+	// A failed renewal starts the grace period
+	hook, err := provider.Sign("invoice.payment_failed", invoice("in_failed_1"))
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	require.True(t, updated.InGracePeriod())
+	assert.WithinDuration(t, time.Now().Add(models.PaymentGracePeriod), *updated.GracePeriodEndsAt, time.Minute)
+	assert.Equal(t, models.TierMonthly, updated.SubscriptionTier)
+	graceEndsAt := *updated.GracePeriodEndsAt
+
+	// Another failure doesn't extend it
+	hook, err = provider.Sign("invoice.payment_failed", invoice("in_failed_2"))
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.True(t, graceEndsAt.Equal(*updated.GracePeriodEndsAt))
+
+	// Paying the invoice ends it
+	hook, err = provider.Sign("invoice.paid", invoice("in_paid"))
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var paid models.User
+	require.NoError(t, db.First(&paid, user.ID).Error)
+	assert.False(t, paid.InGracePeriod())
+	assert.Nil(t, paid.PaymentFailedAt)
+	assert.Equal(t, models.TierMonthly, paid.SubscriptionTier)
+}
+
+func TestLateRenewalPaymentKeepsSubscription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	// The renewal fails as the paid month runs out
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	require.NoError(t, db.Model(&updated).Update("subscription_expires_at", time.Now().Add(-time.Hour)).Error)
+
+	hook, err := provider.Sign("invoice.payment_failed", map[string]interface{}{
+		"id":         "in_renewal",
+		"object":     "invoice",
+		"customer":   updated.StripeCustomerID,
+		"amount_due": 500,
+		"currency":   "usd",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	// Paying it late covers the new month, so the user keeps their plan once the grace period ends
+	periodStart := time.Now().Add(-time.Hour).Truncate(time.Second)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	hook, err = provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             "in_renewal",
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"subscription":   updated.StripeSubscriptionID,
+		"billing_reason": "subscription_cycle",
+		"amount_paid":    500,
+		"currency":       "usd",
+		"lines": map[string]interface{}{
+			"object": "list",
+			"data": []map[string]interface{}{{
+				"id":       "il_renewal",
+				"object":   "line_item",
+				"period":   map[string]interface{}{"start": periodStart.Unix(), "end": periodEnd.Unix()},
+				"metadata": map[string]string{"subscription_tier": models.TierMonthly},
+			}},
+		},
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var paid models.User
+	require.NoError(t, db.First(&paid, user.ID).Error)
+	assert.False(t, paid.InGracePeriod())
+	assert.True(t, periodEnd.Equal(paid.SubscriptionExpiresAt), "expiry should move to the end of the paid period")
+	assert.True(t, paid.HasActiveSubscription())
+	assert.Equal(t, models.TierMonthly, paid.SubscriptionTier)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/middleware"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -41,12 +42,13 @@ func TestRealWorldPaymentScenario(t *testing.T) {
 	// Create a test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
 
 	// Create a test user
 	user := payment_test_utils.CreateTestUser(t, db)
 
 	// Create a payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Create a router with the webhook handler
 	router := gin.Default()
@@ -73,6 +75,10 @@ func TestRealWorldPaymentScenario(t *testing.T) {
 					"id": "cus_test_customer",
 				},
 				"client_reference_id": userIDString,
+				"subscription":        "sub_test_subscription",
+				"metadata": map[string]interface{}{
+					"subscription_tier": "monthly",
+				},
 			},
 		},
 	}
@@ -106,7 +112,8 @@ func TestRealWorldPaymentScenario(t *testing.T) {
 				"customer": map[string]interface{}{
 					"id": "cus_test_customer",
 				},
-				"subscription": "sub_test_subscription",
+				"subscription":   "sub_test_subscription",
+				"billing_reason": "subscription_create",
 			},
 		},
 	}
@@ -156,12 +163,13 @@ func TestSinglePaymentForSubscription(t *testing.T) {
 	// Create a test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
 
 	// Create a test user
 	user := payment_test_utils.CreateTestUser(t, db)
 
 	// Create a payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Create a router with the webhook handler
 	router := gin.Default()
@@ -188,6 +196,10 @@ func TestSinglePaymentForSubscription(t *testing.T) {
 					"id": "cus_test_customer",
 				},
 				"client_reference_id": userIDString,
+				"subscription":        "sub_test_subscription",
+				"metadata": map[string]interface{}{
+					"subscription_tier": "monthly",
+				},
 			},
 		},
 	}
@@ -214,7 +226,8 @@ func TestSinglePaymentForSubscription(t *testing.T) {
 				"customer": map[string]interface{}{
 					"id": "cus_test_customer",
 				},
-				"subscription": "sub_test_subscription",
+				"subscription":   "sub_test_subscription",
+				"billing_reason": "subscription_create",
 			},
 		},
 	}
@@ -264,6 +277,7 @@ func TestSinglePaymentForUpgrade(t *testing.T) {
 	// Create a test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
 
 	// Create a test user with an existing monthly subscription
 	user := payment_test_utils.CreateTestUser(t, db)
@@ -276,7 +290,7 @@ func TestSinglePaymentForUpgrade(t *testing.T) {
 	})
 
 	// Create a payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Create a router with the webhook handler
 	router := gin.Default()
@@ -300,6 +314,10 @@ func TestSinglePaymentForUpgrade(t *testing.T) {
 					"id": "cus_test_customer",
 				},
 				"client_reference_id": userIDString,
+				"subscription":        "sub_test_subscription_yearly",
+				"metadata": map[string]interface{}{
+					"subscription_tier": "yearly",
+				},
 			},
 		},
 	}
@@ -326,7 +344,8 @@ func TestSinglePaymentForUpgrade(t *testing.T) {
 				"customer": map[string]interface{}{
 					"id": "cus_test_customer",
 				},
-				"subscription": "sub_test_subscription_yearly",
+				"subscription":   "sub_test_subscription_yearly",
+				"billing_reason": "subscription_create",
 			},
 		},
 	}
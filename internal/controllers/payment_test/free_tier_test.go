@@ -247,13 +247,10 @@ func TestFreeTierGunLimit(t *testing.T) {
 
 	// Set up test router and controller
 	router, gunController := payment_test_utils.SetupGunTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for creating guns
 	router.POST("/owner/guns", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		gunController.Create(c)
 	})
 
@@ -266,10 +263,8 @@ func TestFreeTierGunLimit(t *testing.T) {
 		"manufacturer_id": {fmt.Sprintf("%d", manufacturer.ID)},
 	}
 	req1, _ := http.NewRequest("POST", "/owner/guns", strings.NewReader(formData1.Encode()))
+	payment_test_utils.SignIn(t, req1, user)
 	req1.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	// Add authentication cookies to the request
-	req1.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req1.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w1 := httptest.NewRecorder()
 	router.ServeHTTP(w1, req1)
@@ -286,10 +281,8 @@ func TestFreeTierGunLimit(t *testing.T) {
 		"manufacturer_id": {fmt.Sprintf("%d", manufacturer.ID)},
 	}
 	req2, _ := http.NewRequest("POST", "/owner/guns", strings.NewReader(formData2.Encode()))
+	payment_test_utils.SignIn(t, req2, user)
 	req2.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	// Add authentication cookies to the request
-	req2.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req2.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w2 := httptest.NewRecorder()
 	router.ServeHTTP(w2, req2)
@@ -306,10 +299,8 @@ func TestFreeTierGunLimit(t *testing.T) {
 		"manufacturer_id": {fmt.Sprintf("%d", manufacturer.ID)},
 	}
 	req3, _ := http.NewRequest("POST", "/owner/guns", strings.NewReader(formData3.Encode()))
+	payment_test_utils.SignIn(t, req3, user)
 	req3.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	// Add authentication cookies to the request
-	req3.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req3.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w3 := httptest.NewRecorder()
 	router.ServeHTTP(w3, req3)
@@ -346,6 +337,7 @@ func TestExpiredSubscriptionRevertToFreeTier(t *testing.T) {
 
 	// Set up test router and controller
 	router, gunController := setupTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Create 3 guns for the user directly in the database
 	// (bypassing the controller to simulate a user who had a subscription)
@@ -362,18 +354,12 @@ func TestExpiredSubscriptionRevertToFreeTier(t *testing.T) {
 
 	// Set up the route for listing guns
 	router.GET("/owner/guns", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		gunController.Index(c)
 	})
 
 	// Test accessing the guns list
 	req, _ := http.NewRequest("GET", "/owner/guns", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -427,21 +413,16 @@ func TestExpiredSubscriptionShowsLimitedGuns(t *testing.T) {
 
 	// Set up test router and controller
 	router, gunController := payment_test_utils.SetupGunTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for listing guns
 	router.GET("/owner/guns", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		gunController.Index(c)
 	})
 
 	// Test accessing the guns list
 	req, _ := http.NewRequest("GET", "/owner/guns", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
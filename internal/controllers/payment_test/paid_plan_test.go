@@ -0,0 +1,139 @@
+package payment_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebhooksOnlyGrantKnownPlans verifies that payments are matched to a plan rather than guessed from the amount
+func TestWebhooksOnlyGrantKnownPlans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "test")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
+	router := gin.Default()
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	userID := fmt.Sprintf("%d", user.ID)
+
+	checkoutFor := func(eventID, tier string) map[string]interface{} {
+		event := checkoutCompletedEvent(eventID, userID)
+		session := event["data"].(map[string]interface{})["object"].(map[string]interface{})
+		session["amount_total"] = 100000
+		session["metadata"] = map[string]interface{}{"user_id": userID, "subscription_tier": tier}
+		return event
+	}
+	assertFailed := func(eventID string) {
+		record, err := models.FindWebhookEventByEventID(db, eventID)
+		require.NoError(t, err)
+		assert.Equal(t, models.WebhookEventStatusFailed, record.Status)
+		assert.Contains(t, record.Error, "not a known paid plan")
+	}
+
+	// A checkout without a plan isn't guessed from what was paid
+	sendWebhook(t, router, checkoutFor("evt_plan_missing", ""))
+	assertFailed("evt_plan_missing")
+
+	// Nor is one for a plan we don't have
+	sendWebhook(t, router, checkoutFor("evt_plan_unknown", "platinum"))
+	assertFailed("evt_plan_unknown")
+
+	var unchanged models.User
+	require.NoError(t, db.First(&unchanged, user.ID).Error)
+	assert.Equal(t, models.TierFree, unchanged.SubscriptionTier)
+	var count int64
+	require.NoError(t, db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Zero(t, count)
+
+	// A renewal is matched to its plan by the Stripe price it was charged at, even once the plan is retired
+	require.NoError(t, db.Model(&models.Plan{}).Where("tier = ?", models.TierYearly).Updates(map[string]interface{}{
+		"stripe_product_id": "price_yearly",
+		"active":            false,
+	}).Error)
+	require.NoError(t, db.Model(&unchanged).Updates(map[string]interface{}{
+		"subscription_tier":  models.TierMonthly,
+		"stripe_customer_id": "cus_paid_plan",
+	}).Error)
+	sendWebhook(t, router, map[string]interface{}{
+		"id":     "evt_plan_renewal",
+		"object": "event",
+		"type":   "invoice.paid",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":             "in_plan_renewal",
+				"object":         "invoice",
+				"customer":       "cus_paid_plan",
+				"subscription":   "sub_paid_plan",
+				"amount_paid":    3000,
+				"currency":       "usd",
+				"billing_reason": "subscription_cycle",
+				"lines": map[string]interface{}{
+					"object": "list",
+					"data": []map[string]interface{}{
+						{"id": "il_plan", "object": "line_item", "amount": 3000, "price": map[string]interface{}{"id": "price_yearly", "object": "price"}},
+					},
+				},
+			},
+		},
+	})
+
+	record, err := models.FindWebhookEventByEventID(db, "evt_plan_renewal")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusProcessed, record.Status, record.Error)
+	renewal, err := models.FindPaymentByStripeID(db, "in_plan_renewal")
+	require.NoError(t, err)
+	assert.Equal(t, models.TierYearly, renewal.Tier)
+
+	var renewed models.User
+	require.NoError(t, db.First(&renewed, user.ID).Error)
+	assert.Equal(t, models.TierYearly, renewed.SubscriptionTier)
+}
+
+// TestCheckoutRedirectOnlyOffersActivePlans verifies that GET /checkout only passes plans on sale on to checkout
+func TestCheckoutRedirectOnlyOffersActivePlans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+	require.NoError(t, db.Model(&models.Plan{}).Where("tier = ?", models.TierLifetime).Update("active", false).Error)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
+	router := gin.New()
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	router.GET("/checkout", paymentController.HandleCheckoutRedirect)
+
+	visit := func(tier string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/checkout?tier="+tier, nil)
+		payment_test_utils.SignIn(t, req, user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := visit(models.TierMonthly)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `name="tier" value="monthly"`)
+
+	for _, tier := range []string{models.TierLifetime, models.TierFree, "unknown", ""} {
+		w = visit(tier)
+		assert.Equal(t, http.StatusSeeOther, w.Code, tier)
+		assert.Equal(t, "/pricing", w.Header().Get("Location"), tier)
+	}
+}
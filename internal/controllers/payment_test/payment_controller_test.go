@@ -5,7 +5,6 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"testing"
 
@@ -15,6 +14,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,7 +31,7 @@ func setupPaymentTest(t *testing.T) (*gin.Engine, *controllers.PaymentController
 	auth.MockUser = user
 
 	// Create a payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Create a test router
 	router := gin.Default()
@@ -86,8 +86,9 @@ func TestShowPricingPage(t *testing.T) {
 
 func TestCreateCheckoutSession(t *testing.T) {
 	// Setup
-	router, paymentController, user := setupPaymentTest(t)
+	router, paymentController, _ := setupPaymentTest(t)
 	defer cleanup()
+	seedFlowPlans(t, paymentController.DB)
 
 	// Save original environment variables
 	originalAppEnv := os.Getenv("APP_ENV")
@@ -127,9 +128,8 @@ func TestCreateCheckoutSession(t *testing.T) {
 		// Check the status code (should be a redirect)
 		assert.Equal(t, http.StatusSeeOther, w.Code)
 
-		// In test mode, it should redirect to a test URL with the user ID
-		expectedURL := "http://localhost:3000/payment/success?session_id=cs_test_" + strconv.FormatUint(uint64(user.ID), 10)
-		assert.Equal(t, expectedURL, w.Header().Get("Location"))
+		// The fake provider sends the user straight on to the success page
+		assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "http://localhost:3000/payment/success?session_id=cs_"), tier)
 	}
 }
 
@@ -141,8 +141,8 @@ func TestHandlePaymentSuccess(t *testing.T) {
 	// Setup the route
 	router.GET("/payment/success", paymentController.HandlePaymentSuccess)
 
-	// Create a request with a test session ID
-	sessionID := "cs_test_" + strconv.FormatUint(uint64(user.ID), 10)
+	// Create a request for a checkout the user started
+	sessionID := startCheckout(t, paymentController.Provider, user)
 	req, err := http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
 	assert.NoError(t, err)
 
@@ -9,6 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -28,24 +29,20 @@ func TestPaymentFeedback(t *testing.T) {
 
 	// Set up the router
 	router := gin.Default()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the controller
 	authController := controllers.NewAuthController(nil, nil, nil)
 
 	// Set up the route
 	router.GET("/owner", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		// Call the Profile function
 		authController.Profile(c)
 	})
 
 	// Test accessing the owner page with flash message
 	req, _ := http.NewRequest("GET", "/owner", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	req.AddCookie(&http.Cookie{Name: "flash_message", Value: "Your payment was successful"})
 	req.AddCookie(&http.Cookie{Name: "flash_type", Value: "success"})
 
@@ -74,23 +71,20 @@ func TestRedirectToOwnerAfterPayment(t *testing.T) {
 
 	// Set up the router
 	router := gin.Default()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the controller
-	paymentController := controllers.NewPaymentController(db)
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
 
 	// Set up the route
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.HandlePaymentSuccess(c)
 	})
 
 	// Test accessing the payment success page
-	req, _ := http.NewRequest("GET", "/payment/success?session_id=cs_test_123", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	req, _ := http.NewRequest("GET", "/payment/success?session_id="+startCheckout(t, provider, user), nil)
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
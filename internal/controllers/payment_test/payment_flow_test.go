@@ -12,6 +12,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -22,6 +23,7 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -36,37 +38,29 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.GET("/pricing", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		// Use the mock pricing page instead of the real one to avoid template issues
 		payment_test_utils.MockPricingPage(c, user)
 	})
 
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
 	// STEP 1: Visit the pricing page
 	t.Log("STEP 1: Visiting pricing page")
 	req, _ := http.NewRequest("GET", "/pricing", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -86,9 +80,8 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ = http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -96,17 +89,17 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(redirectURL, "session_id=")[1]
 	t.Logf("Got session ID: %s", sessionID)
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 3: Follow the redirect to the success page
 	t.Log("STEP 3: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -142,7 +135,7 @@ func TestEndToEndPaymentFlow(t *testing.T) {
 	assert.Equal(t, "subscription", payment.PaymentType)
 	assert.Equal(t, "succeeded", payment.Status)
 	assert.Contains(t, payment.Description, "Monthly")
-	assert.Contains(t, payment.StripeID, "cs_test_")
+	assert.Equal(t, sessionID, payment.StripeID)
 }
 
 // TestUpgradeSubscriptionFlow tests upgrading from one subscription to another
@@ -150,6 +143,7 @@ func TestUpgradeSubscriptionFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -172,18 +166,17 @@ func TestUpgradeSubscriptionFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -192,27 +185,23 @@ func TestUpgradeSubscriptionFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "yearly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(redirectURL, "session_id=")[1]
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test the payment success handler
 	t.Log("STEP 2: Handling payment success for upgrade")
-
-	// In test mode, we need to manually add the tier to the URL
-	// This simulates the metadata that would be in the Stripe session
-	successURL := "/payment/success?session_id=" + sessionID + "_yearly"
+	successURL := "/payment/success?session_id=" + sessionID
 	req, _ = http.NewRequest("GET", successURL, nil)
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -12,6 +12,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,6 +22,7 @@ func TestPaymentIntegration(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -34,21 +36,16 @@ func TestPaymentIntegration(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := paymentController.Provider.(*billing.FakeProvider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -57,9 +54,8 @@ func TestPaymentIntegration(t *testing.T) {
 		"tier": {"monthly"},
 	}
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(formData.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -67,12 +63,12 @@ func TestPaymentIntegration(t *testing.T) {
 	// Check that we're redirected to the success page
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Step 2: Follow the redirect to the success page
+	// Step 2: Pay for the checkout and follow the redirect to the success page
+	completeCheckout(t, router, provider, strings.Split(redirectURL, "session_id=")[1])
 	req, _ = http.NewRequest("GET", redirectURL, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -106,7 +102,7 @@ func TestPaymentIntegration(t *testing.T) {
 	assert.Equal(t, "subscription", payment.PaymentType)
 	assert.Equal(t, "succeeded", payment.Status)
 	assert.Contains(t, payment.Description, "Monthly")
-	assert.Contains(t, payment.StripeID, "cs_test_")
+	assert.Equal(t, strings.Split(redirectURL, "session_id=")[1], payment.StripeID)
 }
 
 // TestPaymentHistoryPage tests the payment history page
@@ -133,21 +129,17 @@ func TestPaymentHistoryPage(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.Default()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Set up the route for payment history
 	router.GET("/owner/payment-history", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.ShowPaymentHistory(c)
 	})
 
 	// Test accessing the payment history page
 	req, _ := http.NewRequest("GET", "/owner/payment-history", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -164,6 +156,7 @@ func TestPaymentFlowWithExistingSubscription(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -186,18 +179,17 @@ func TestPaymentFlowWithExistingSubscription(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -206,27 +198,23 @@ func TestPaymentFlowWithExistingSubscription(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "yearly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(redirectURL, "session_id=")[1]
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test the payment success handler
 	t.Log("STEP 2: Handling payment success for upgrade")
-
-	// In test mode, we need to manually add the tier to the URL
-	// This simulates the metadata that would be in the Stripe session
-	successURL := "/payment/success?session_id=" + sessionID + "_yearly"
+	successURL := "/payment/success?session_id=" + sessionID
 	req, _ = http.NewRequest("GET", successURL, nil)
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -256,6 +244,7 @@ func TestCompletePaymentFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -270,20 +259,17 @@ func TestCompletePaymentFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -292,9 +278,8 @@ func TestCompletePaymentFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -302,17 +287,17 @@ func TestCompletePaymentFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(redirectURL, "session_id=")[1]
 	t.Logf("Got session ID: %s", sessionID)
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test the payment success handler
 	t.Log("STEP 2: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -348,5 +333,5 @@ func TestCompletePaymentFlow(t *testing.T) {
 	assert.Equal(t, "subscription", payment.PaymentType)
 	assert.Equal(t, "succeeded", payment.Status)
 	assert.Contains(t, payment.Description, "Monthly")
-	assert.Contains(t, payment.StripeID, "cs_test_")
+	assert.Equal(t, sessionID, payment.StripeID)
 }
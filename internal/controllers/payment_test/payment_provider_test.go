@@ -0,0 +1,114 @@
+package payment_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCheckoutToWebhookWithFakeProvider runs a purchase through the payment provider without test-mode shortcuts
+func TestCheckoutToWebhookWithFakeProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("APP_BASE_URL", "http://localhost:8080")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+
+	require.NoError(t, db.Create(&models.Plan{
+		Tier: models.TierMonthly, Name: "Liking It", Price: 500, Currency: "usd",
+		Interval: models.PlanIntervalMonth, Active: true, MaxGuns: -1, MaxAmmo: -1,
+	}).Error)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+
+	authInstance := auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+	router := gin.New()
+	router.Use(authInstance.LoadUser())
+	router.POST("/checkout", paymentController.CreateCheckoutSession)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	router.GET("/payment/success", paymentController.HandlePaymentSuccess)
+	router.POST("/subscription/cancel", paymentController.CancelSubscription)
+
+	loggedIn := func(req *http.Request) *http.Request {
+		cookie, err := authInstance.SessionCookie(user)
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		return req
+	}
+
+	// Start the checkout
+	form := url.Values{"tier": {models.TierMonthly}}
+	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, loggedIn(req))
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	sessionID := location.Query().Get("session_id")
+	assert.True(t, strings.HasPrefix(sessionID, "cs_fake_"))
+
+	// An unsigned delivery is rejected
+	hook, err := provider.CompleteCheckout(sessionID)
+	require.NoError(t, err)
+
+	req, _ = http.NewRequest("POST", "/webhook", bytes.NewReader(hook.Payload))
+	req.Header.Set("Stripe-Signature", "t=1,v1=bad")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The signed delivery upgrades the user
+	req, _ = http.NewRequest("POST", "/webhook", bytes.NewReader(hook.Payload))
+	req.Header.Set("Stripe-Signature", hook.Signature)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierMonthly, updated.SubscriptionTier)
+	assert.Equal(t, provider.CustomerID(user.ID), updated.StripeCustomerID)
+
+	var payment models.Payment
+	require.NoError(t, db.Where("stripe_id = ?", sessionID).First(&payment).Error)
+	assert.Equal(t, int64(500), payment.Amount)
+
+	// The user lands back on the site
+	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, loggedIn(req))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// Cancelling stops the provider's subscription from renewing
+	req, _ = http.NewRequest("POST", "/subscription/cancel", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, loggedIn(req))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.True(t, updated.SubscriptionCanceled)
+
+	subscription, ok := provider.Subscription(updated.StripeSubscriptionID)
+	require.True(t, ok)
+	assert.True(t, subscription.CancelAtPeriodEnd)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,17 +30,18 @@ func TestPaymentSuccessRedirectToOwner(t *testing.T) {
 	// Set up the router
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the payment controller
-	paymentController := controllers.NewPaymentController(db)
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
 	router.GET("/payment/success", paymentController.HandlePaymentSuccess)
 
-	// Create a test request with a test session ID
-	req, _ := http.NewRequest("GET", "/payment/success?session_id=cs_test_123", nil)
+	// Create a test request for a checkout the user started
+	req, _ := http.NewRequest("GET", "/payment/success?session_id="+startCheckout(t, provider, user), nil)
 
-	// Set the required cookies for authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	payment_test_utils.SignIn(t, req, user)
 
 	resp := httptest.NewRecorder()
 
@@ -3,16 +3,20 @@ package payment_test_utils
 import (
 	"fmt"
 	"html/template"
+	"net/http"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/render"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -139,6 +143,26 @@ func AuthMiddlewareMock(user *models.User) gin.HandlerFunc {
 	}
 }
 
+// Sessions keeps the login sessions the tests sign in with
+var Sessions = auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+
+// SignIn signs the request in to a new session for the user
+func SignIn(t *testing.T, req *http.Request, user *models.User) {
+	cookie, err := Sessions.SessionCookie(user)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+}
+
+// SignedIn is a middleware that signs every request in to a new session for the user
+func SignedIn(user *models.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cookie, err := Sessions.SessionCookie(user); err == nil {
+			c.Request.AddCookie(cookie)
+		}
+		c.Next()
+	}
+}
+
 // CreateTestPayment creates a test payment
 func CreateTestPayment(t *testing.T, db *gorm.DB, user *models.User) *models.Payment {
 	payment := &models.Payment{
@@ -198,7 +222,7 @@ func SetupPricingTestRouter(t *testing.T, db *gorm.DB) (*gin.Engine, *controller
 	router.HTMLRender = &TestRenderer{}
 
 	// Create the payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	return router, paymentController
 }
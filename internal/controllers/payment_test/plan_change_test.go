@@ -0,0 +1,237 @@
+package payment_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// seedPaidPlans creates the paid plans used by the plan change tests
+func seedPaidPlans(t *testing.T, db *gorm.DB) {
+	plans := []models.Plan{
+		{Tier: models.TierMonthly, Name: "Liking It", Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth, Active: true},
+		{Tier: models.TierYearly, Name: "Loving It", Price: 3000, Currency: "usd", Interval: models.PlanIntervalYear, Active: true},
+		{Tier: models.TierLifetime, Name: "Supporter", Price: 10000, Currency: "usd", Active: true},
+	}
+	for i := range plans {
+		require.NoError(t, db.Create(&plans[i]).Error)
+	}
+}
+
+// planChangeRouter wires the payment routes for a logged in user
+func planChangeRouter(db *gorm.DB, provider billing.PaymentProvider, user *models.User) *gin.Engine {
+	paymentController := controllers.NewPaymentController(db, provider)
+	router := gin.New()
+	router.Use(payment_test_utils.SignedIn(user), payment_test_utils.Sessions.LoadUser())
+	router.POST("/checkout", paymentController.CreateCheckoutSession)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	router.POST("/subscription/change", paymentController.ChangeSubscription)
+	return router
+}
+
+// postForm sends a form post through the router
+func postForm(router *gin.Engine, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// deliver posts a signed webhook through the router
+func deliver(t *testing.T, router *gin.Engine, hook *billing.Webhook) {
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewReader(hook.Payload))
+	req.Header.Set("Stripe-Signature", hook.Signature)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+// purchase checks out a plan with the fake provider and delivers the completion webhook
+func purchase(t *testing.T, router *gin.Engine, provider *billing.FakeProvider, tier string) {
+	w := postForm(router, "/checkout", url.Values{"tier": {tier}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	hook, err := provider.CompleteCheckout(location.Query().Get("session_id"))
+	require.NoError(t, err)
+	deliver(t, router, hook)
+}
+
+func TestChangeSubscriptionBetweenRecurringPlans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	require.Equal(t, models.TierMonthly, updated.SubscriptionTier)
+	subscriptionID := updated.StripeSubscriptionID
+	require.NotEmpty(t, subscriptionID)
+
+	// Upgrade to yearly on the same subscription
+	w := postForm(router, "/subscription/change", url.Values{"tier": {models.TierYearly}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/profile/subscription", w.Header().Get("Location"))
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierYearly, updated.SubscriptionTier)
+	assert.Equal(t, subscriptionID, updated.StripeSubscriptionID)
+	assert.True(t, updated.SubscriptionExpiresAt.After(time.Now().AddDate(0, 11, 0)))
+
+	subscription, ok := provider.Subscription(subscriptionID)
+	require.True(t, ok)
+	assert.Equal(t, models.TierYearly, subscription.Tier)
+
+	var change models.Payment
+	require.NoError(t, db.Where("user_id = ? AND payment_type = ?", user.ID, "subscription_change").First(&change).Error)
+	assert.InDelta(t, 2500, change.Amount, 1)
+	assert.Equal(t, "Plan change from Monthly to Yearly", change.Description)
+	assert.NotEmpty(t, change.StripeID)
+
+	// The prorated charge is pending until Stripe reports the invoice paid
+	assert.Equal(t, "pending", change.Status)
+	hook, err := provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             change.StripeID,
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"subscription":   subscriptionID,
+		"billing_reason": "subscription_update",
+		"amount_paid":    change.Amount,
+		"currency":       "usd",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var paid models.Payment
+	require.NoError(t, db.First(&paid, change.ID).Error)
+	assert.Equal(t, "succeeded", paid.Status)
+
+	// Changing to the current plan does nothing
+	w = postForm(router, "/subscription/change", url.Values{"tier": {models.TierYearly}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var count int64
+	db.Model(&models.Payment{}).Where("user_id = ? AND payment_type = ?", user.ID, "subscription_change").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestFailedPlanChangeChargeIsNotRecordedAsPaid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+	w := postForm(router, "/subscription/change", url.Values{"tier": {models.TierYearly}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+
+	var change models.Payment
+	require.NoError(t, db.Where("user_id = ? AND payment_type = ?", user.ID, "subscription_change").First(&change).Error)
+	require.Equal(t, "pending", change.Status)
+
+	// The card is declined for the prorated invoice
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	hook, err := provider.Sign("invoice.payment_failed", map[string]interface{}{
+		"id":         change.StripeID,
+		"object":     "invoice",
+		"customer":   updated.StripeCustomerID,
+		"amount_due": change.Amount,
+		"currency":   "usd",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	var failed models.Payment
+	require.NoError(t, db.First(&failed, change.ID).Error)
+	assert.Equal(t, "failed", failed.Status)
+
+	var count int64
+	db.Model(&models.Payment{}).Where("stripe_id = ?", change.StripeID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestChangeSubscriptionToLifetimeCancelsRecurring(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	subscriptionID := updated.StripeSubscriptionID
+
+	// Moving to a one-time plan goes through checkout
+	w := postForm(router, "/subscription/change", url.Values{"tier": {models.TierLifetime}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/checkout?tier="+models.TierLifetime, w.Header().Get("Location"))
+
+	purchase(t, router, provider, models.TierLifetime)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierLifetime, updated.SubscriptionTier)
+	assert.Empty(t, updated.StripeSubscriptionID)
+
+	subscription, ok := provider.Subscription(subscriptionID)
+	require.True(t, ok)
+	assert.True(t, subscription.Canceled)
+
+	// Stripe's notice that the old subscription ended doesn't downgrade the lifetime plan
+	hook, err := provider.Sign("customer.subscription.deleted", map[string]interface{}{
+		"id":       subscriptionID,
+		"object":   "subscription",
+		"customer": updated.StripeCustomerID,
+		"status":   "canceled",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierLifetime, updated.SubscriptionTier)
+
+	// Lifetime plans can't go back to recurring
+	w = postForm(router, "/subscription/change", url.Values{"tier": {models.TierMonthly}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Equal(t, models.TierLifetime, updated.SubscriptionTier)
+}
@@ -51,25 +51,20 @@ func TestPricingPageWithLoggedInUser(t *testing.T) {
 
 	// Set up test router and controller
 	router, _ := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Create a test user
 	user := payment_test_utils.CreateTestUser(t, db)
 
 	// Set up the route for the pricing page
 	router.GET("/pricing", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		// Use the mock pricing page instead of the real one to avoid template issues
 		payment_test_utils.MockPricingPage(c, user)
 	})
 
 	// Test accessing the pricing page as a logged-in user
 	req, _ := http.NewRequest("GET", "/pricing", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -88,6 +83,7 @@ func TestPricingPageWithSubscribedUser(t *testing.T) {
 
 	// Set up test router and controller
 	router, _ := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Create a test user with a subscription
 	user := payment_test_utils.CreateTestUser(t, db)
@@ -98,19 +94,13 @@ func TestPricingPageWithSubscribedUser(t *testing.T) {
 
 	// Set up the route for the pricing page
 	router.GET("/pricing", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		// Use the mock pricing page instead of the real one to avoid template issues
 		payment_test_utils.MockPricingPage(c, user)
 	})
 
 	// Test accessing the pricing page as a subscribed user
 	req, _ := http.NewRequest("GET", "/pricing", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -126,6 +116,7 @@ func TestStripeCheckoutRedirect(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -133,16 +124,13 @@ func TestStripeCheckoutRedirect(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Create a test user
 	user := payment_test_utils.CreateTestUser(t, db)
 
 	// Set up the route for creating a checkout session
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.CreateCheckoutSession(c)
 	})
 
@@ -151,15 +139,13 @@ func TestStripeCheckoutRedirect(t *testing.T) {
 		"tier": {"monthly"},
 	}
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(formData.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
-	assert.Contains(t, w.Header().Get("Location"), "/payment/success?session_id=cs_test_")
+	assert.Contains(t, w.Header().Get("Location"), "/payment/success?session_id=cs_")
 }
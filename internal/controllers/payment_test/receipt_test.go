@@ -0,0 +1,273 @@
+package payment_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/receipt"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// receiptRouter sets up checkout, webhook and receipt routes for a logged in user
+func receiptRouter(db *gorm.DB, provider billing.PaymentProvider, emailService email.EmailService, user *models.User) *gin.Engine {
+	paymentController := controllers.NewPaymentControllerWithEmailService(db, provider, emailService, receipt.Business{
+		Name:    "The Virtual Armory",
+		Address: "123 Main St, Springfield",
+		TaxID:   "12-3456789",
+	})
+	router := gin.New()
+	router.HTMLRender = &payment_test_utils.TestRenderer{}
+	router.Use(payment_test_utils.SignedIn(user), payment_test_utils.Sessions.LoadUser())
+	router.POST("/checkout", paymentController.CreateCheckoutSession)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	router.GET("/owner/payment-history/:id/receipt", paymentController.ShowReceipt)
+	router.GET("/owner/payment-history/:id/receipt.pdf", paymentController.DownloadReceipt)
+	return router
+}
+
+func get(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDownloadReceipt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	router := receiptRouter(db, provider, &email.MockEmailService{}, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	// The checkout payment covers the first month
+	var payment models.Payment
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&payment).Error)
+	require.NotNil(t, payment.PeriodStart)
+	require.NotNil(t, payment.PeriodEnd)
+	assert.WithinDuration(t, time.Now(), *payment.PeriodStart, time.Minute)
+	assert.WithinDuration(t, time.Now().AddDate(0, 1, 0), *payment.PeriodEnd, time.Minute)
+
+	w := get(router, fmt.Sprintf("/owner/payment-history/%d/receipt", payment.ID))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = get(router, fmt.Sprintf("/owner/payment-history/%d/receipt.pdf", payment.ID))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), receipt.Filename(&payment))
+	assert.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")))
+
+	// Failed payments don't have receipts
+	failed := models.Payment{UserID: user.ID, Amount: 500, Currency: "usd", Status: "failed", Description: "Failed Invoice Payment"}
+	require.NoError(t, models.CreatePayment(db, &failed))
+	w = get(router, fmt.Sprintf("/owner/payment-history/%d/receipt.pdf", failed.ID))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/payment-history", w.Header().Get("Location"))
+
+	// Nobody can download another user's receipt
+	other, err := testutils.CreateTestUser(db, "receipt-other@example.com", "password123", false)
+	require.NoError(t, err)
+	otherPayment := models.Payment{UserID: other.ID, Amount: 500, Currency: "usd", Status: "succeeded", Description: "Monthly Subscription"}
+	require.NoError(t, models.CreatePayment(db, &otherPayment))
+	w = get(router, fmt.Sprintf("/owner/payment-history/%d/receipt.pdf", otherPayment.ID))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = get(router, "/owner/payment-history/nope/receipt")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestInvoicePaidEmailsReceipt(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	mockEmail := &email.MockEmailService{}
+	router := receiptRouter(db, provider, mockEmail, user)
+
+	purchase(t, router, provider, models.TierMonthly)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	var checkoutPayment models.Payment
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&checkoutPayment).Error)
+
+	// The first invoice emails a receipt for the payment recorded at checkout
+	hook, err := provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             "in_first",
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"subscription":   updated.StripeSubscriptionID,
+		"amount_paid":    checkoutPayment.Amount,
+		"currency":       "usd",
+		"billing_reason": "subscription_create",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	assert.Equal(t, 1, mockEmail.SendReceiptEmailCalls)
+	assert.Equal(t, user.Email, mockEmail.SendReceiptEmailEmail)
+	assert.Equal(t, checkoutPayment.ID, mockEmail.SendReceiptEmailPayment.ID)
+	assert.True(t, bytes.HasPrefix(mockEmail.SendReceiptEmailPDF, []byte("%PDF-")))
+
+	var count int64
+	require.NoError(t, db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// A renewal is recorded with its tax and billing period, then emailed
+	periodStart := time.Now().AddDate(0, 1, 0).Truncate(time.Second)
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	renewal := map[string]interface{}{
+		"id":             "in_renewal",
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"subscription":   updated.StripeSubscriptionID,
+		"amount_paid":    540,
+		"tax":            40,
+		"currency":       "usd",
+		"billing_reason": "subscription_cycle",
+		"lines": map[string]interface{}{
+			"object": "list",
+			"data": []map[string]interface{}{
+				{
+					"id":       "il_1",
+					"object":   "line_item",
+					"period":   map[string]int64{"start": periodStart.Unix(), "end": periodEnd.Unix()},
+					"metadata": map[string]string{"subscription_tier": models.TierMonthly},
+				},
+			},
+		},
+	}
+	hook, err = provider.Sign("invoice.paid", renewal)
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	renewed, err := models.FindPaymentByStripeID(db, "in_renewal")
+	require.NoError(t, err)
+	assert.Equal(t, int64(540), renewed.Amount)
+	assert.Equal(t, int64(40), renewed.TaxAmount)
+	assert.Equal(t, "$5.00", renewed.FormatSubtotal())
+	require.NotNil(t, renewed.PeriodEnd)
+	assert.True(t, periodStart.Equal(*renewed.PeriodStart))
+	assert.True(t, periodEnd.Equal(*renewed.PeriodEnd))
+	assert.Equal(t, 2, mockEmail.SendReceiptEmailCalls)
+	assert.Equal(t, renewed.ID, mockEmail.SendReceiptEmailPayment.ID)
+
+	// The user is paid up to the end of the renewed period
+	var renewedUser models.User
+	require.NoError(t, db.First(&renewedUser, user.ID).Error)
+	assert.True(t, periodEnd.Equal(renewedUser.SubscriptionExpiresAt))
+	assert.Equal(t, models.TierMonthly, renewedUser.SubscriptionTier)
+
+	// Stripe retrying the invoice doesn't record it twice
+	hook, err = provider.Sign("invoice.paid", renewal)
+	require.NoError(t, err)
+	deliver(t, router, hook)
+	require.NoError(t, db.Model(&models.Payment{}).Where("stripe_id = ?", "in_renewal").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Invoices with nothing charged, such as the start of a trial, don't send a receipt
+	mockEmail.SendReceiptEmailCalls = 0
+	hook, err = provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             "in_trial",
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"amount_paid":    0,
+		"currency":       "usd",
+		"billing_reason": "subscription_create",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+	assert.Equal(t, 0, mockEmail.SendReceiptEmailCalls)
+
+	// An invoice that matches no recorded payment is recorded as a new one, even for the checkout's amount
+	hook, err = provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             "in_manual",
+		"object":         "invoice",
+		"customer":       updated.StripeCustomerID,
+		"amount_paid":    checkoutPayment.Amount,
+		"currency":       "usd",
+		"billing_reason": "manual",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+
+	manual, err := models.FindPaymentByStripeID(db, "in_manual")
+	require.NoError(t, err)
+	assert.NotEqual(t, checkoutPayment.ID, manual.ID)
+	assert.Equal(t, checkoutPayment.Amount, manual.Amount)
+	assert.Equal(t, 1, mockEmail.SendReceiptEmailCalls)
+	assert.Equal(t, manual.ID, mockEmail.SendReceiptEmailPayment.ID)
+}
+
+func TestFirstInvoiceBeforeCheckoutIsRecordedOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	mockEmail := &email.MockEmailService{}
+	router := receiptRouter(db, provider, mockEmail, user)
+
+	// A returning customer's first invoice can arrive before the checkout completes
+	require.NoError(t, db.Model(user).Update("stripe_customer_id", provider.CustomerID(user.ID)).Error)
+	sessionID := startCheckout(t, provider, user)
+	checkout, err := provider.CompleteCheckout(sessionID)
+	require.NoError(t, err)
+	var event struct {
+		Data struct {
+			Object struct {
+				Customer     string `json:"customer"`
+				Subscription string `json:"subscription"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(checkout.Payload, &event))
+	require.NotEmpty(t, event.Data.Object.Subscription)
+
+	hook, err := provider.Sign("invoice.paid", map[string]interface{}{
+		"id":             "in_early",
+		"object":         "invoice",
+		"customer":       event.Data.Object.Customer,
+		"subscription":   event.Data.Object.Subscription,
+		"amount_paid":    500,
+		"currency":       "usd",
+		"billing_reason": "subscription_create",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+	deliver(t, router, checkout)
+
+	var payments []models.Payment
+	require.NoError(t, db.Where("user_id = ?", user.ID).Find(&payments).Error)
+	require.Len(t, payments, 1)
+	assert.Equal(t, models.TierMonthly, payments[0].Tier)
+	assert.Equal(t, event.Data.Object.Subscription, payments[0].StripeSubscriptionID)
+}
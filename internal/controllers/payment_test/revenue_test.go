@@ -0,0 +1,162 @@
+package payment_test
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// seedRevenue creates customers with payments in early 2025:
+// a monthly subscriber who keeps paying, one who lapses in February,
+// a yearly and a partly refunded lifetime conversion, a free trial and a free user
+func seedRevenue(t *testing.T, db *gorm.DB) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2025, month, d, 12, 0, 0, 0, time.Local)
+	}
+	customer := func(email string) uint {
+		user := models.User{Email: email, Password: "password", SubscriptionTier: models.TierFree}
+		user.CreatedAt = day(time.January, 1).AddDate(0, -1, 0)
+		require.NoError(t, db.Create(&user).Error)
+		return user.ID
+	}
+	pay := func(userID uint, tier string, amount int64, paidAt time.Time, periodEnd *time.Time, change func(*models.Payment)) {
+		payment := models.Payment{UserID: userID, Tier: tier, Amount: amount, Currency: "usd", PaymentType: "subscription", Status: "succeeded"}
+		payment.CreatedAt = paidAt
+		if periodEnd != nil {
+			payment.PeriodStart, payment.PeriodEnd = &paidAt, periodEnd
+		}
+		if change != nil {
+			change(&payment)
+		}
+		require.NoError(t, db.Create(&payment).Error)
+	}
+	until := func(at time.Time) *time.Time { return &at }
+
+	keeps := customer("keeps@example.com")
+	pay(keeps, models.TierMonthly, 500, day(time.January, 15), until(day(time.February, 15)), nil)
+	pay(keeps, models.TierMonthly, 500, day(time.February, 15), until(day(time.March, 15)), nil)
+	pay(keeps, models.TierMonthly, 500, day(time.March, 15), until(day(time.April, 15)), nil)
+
+	lapses := customer("lapses@example.com")
+	pay(lapses, models.TierMonthly, 500, day(time.January, 10), until(day(time.February, 10)), nil)
+
+	yearly := customer("yearly@example.com")
+	pay(yearly, models.TierYearly, 3300, day(time.March, 5), until(day(time.March, 5).AddDate(1, 0, 0)), func(p *models.Payment) {
+		p.TaxAmount = 300
+	})
+
+	lifetime := customer("lifetime@example.com")
+	pay(lifetime, models.TierLifetime, 10000, day(time.February, 20), nil, func(p *models.Payment) {
+		p.Refunded = 2000
+	})
+
+	trial := customer("trial@example.com")
+	pay(trial, models.TierMonthly, 0, day(time.March, 1), until(day(time.March, 15)), nil)
+
+	customer("free@example.com")
+}
+
+// revenueRouter wires the admin revenue routes
+func revenueRouter(db *gorm.DB) *gin.Engine {
+	revenueController := controllers.NewRevenueController(db)
+	router := gin.New()
+	router.HTMLRender = &payment_test_utils.TestRenderer{}
+	router.GET("/admin/revenue", revenueController.Index)
+	router.GET("/admin/revenue.csv", revenueController.CSV)
+	return router
+}
+
+func TestBuildRevenueReport(t *testing.T) {
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+	seedRevenue(t, db)
+
+	start := time.Date(2025, time.February, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2025, time.April, 1, 0, 0, 0, 0, time.Local)
+	report, err := models.BuildRevenueReport(db, start, end)
+	require.NoError(t, err)
+
+	// Both monthly subscribers were paying at the start, only one still is, and the yearly plan adds $30/12
+	assert.Equal(t, int64(1000), report.StartMRR)
+	assert.Equal(t, int64(750), report.MRR)
+	assert.Equal(t, int64(9000), report.ARR)
+	assert.InDelta(t, -25.0, report.MRRGrowth(), 0.01)
+	assert.Equal(t, 2, report.SubscribersAtStart)
+	assert.Equal(t, 1, report.Churned)
+	assert.InDelta(t, 50.0, report.ChurnRate, 0.01)
+
+	// The yearly and lifetime customers converted out of four users who hadn't paid yet
+	assert.Equal(t, 2, report.Conversions)
+	assert.InDelta(t, 50.0, report.ConversionRate, 0.01)
+
+	// Revenue is net of tax and refunds
+	assert.Equal(t, int64(12000), report.Revenue)
+	assert.Equal(t, int64(13000), report.LifetimeRevenue)
+	assert.Equal(t, 4, report.PayingCustomers)
+	assert.Equal(t, int64(3250), report.LTV)
+
+	require.Len(t, report.Months, 2)
+	assert.Equal(t, []string{models.TierMonthly, models.TierYearly, models.TierLifetime}, report.Tiers)
+
+	february := report.Months[0]
+	assert.Equal(t, time.February, february.Month.Month())
+	assert.Equal(t, int64(8500), february.Revenue)
+	assert.Equal(t, int64(500), february.MRR)
+	assert.Equal(t, 1, february.NewCustomers)
+	assert.Equal(t, 1, february.Churned)
+	assert.Equal(t, map[string]int{models.TierMonthly: 1, models.TierLifetime: 1}, february.Tiers)
+	assert.InDelta(t, 50.0, february.TierMix(models.TierLifetime), 0.01)
+
+	march := report.Months[1]
+	assert.Equal(t, int64(3500), march.Revenue)
+	assert.Equal(t, int64(750), march.MRR)
+	assert.Equal(t, 1, march.NewCustomers)
+	assert.Equal(t, 0, march.Churned)
+	assert.Equal(t, map[string]int{models.TierMonthly: 1, models.TierYearly: 1, models.TierLifetime: 1}, march.Tiers)
+}
+
+func TestRevenueRangesAndCSV(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+	seedRevenue(t, db)
+	router := revenueRouter(db)
+
+	for _, path := range []string{"/admin/revenue", "/admin/revenue?range=30d", "/admin/revenue?range=ytd", "/admin/revenue?start=2025-02-01&end=2025-03-31"} {
+		assert.Equal(t, http.StatusOK, get(router, path).Code, path)
+	}
+
+	// Bad ranges go back to the default range with a message
+	for _, path := range []string{"/admin/revenue?range=forever", "/admin/revenue?start=2025-03-31&end=2025-02-01", "/admin/revenue.csv?start=yesterday"} {
+		w := get(router, path)
+		assert.Equal(t, http.StatusSeeOther, w.Code, path)
+		assert.Equal(t, "/admin/revenue", w.Header().Get("Location"), path)
+	}
+
+	w := get(router, "/admin/revenue.csv?start=2025-02-01&end=2025-03-31")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "revenue-2025-02-01-to-2025-03-31.csv")
+
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, []string{"Month", "Revenue", "MRR", "ARR", "New Customers", "Churned", "monthly Customers", "yearly Customers", "lifetime Customers"}, records[0])
+	assert.Equal(t, []string{"2025-02", "85.00", "5.00", "60.00", "1", "1", "1", "0", "1"}, records[1])
+	assert.Equal(t, []string{"2025-03", "35.00", "7.50", "90.00", "1", "0", "1", "1", "1"}, records[2])
+	assert.Equal(t, []string{"Total", "120.00", "7.50", "90.00", "2", "1", "", "", ""}, records[3])
+}
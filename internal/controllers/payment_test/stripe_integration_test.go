@@ -19,6 +19,7 @@ func TestStripeCheckoutCreation(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -29,13 +30,10 @@ func TestStripeCheckoutCreation(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for checkout
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.CreateCheckoutSession(c)
 	})
 
@@ -43,17 +41,15 @@ func TestStripeCheckoutCreation(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
-	assert.Contains(t, w.Header().Get("Location"), "/payment/success?session_id=cs_test_")
+	assert.Contains(t, w.Header().Get("Location"), "/payment/success?session_id=cs_")
 }
 
 // TestStripeWebhookSignatureVerification tests that Stripe webhook signatures are verified
@@ -112,21 +108,16 @@ func TestEnhancedPricingPageFeatures(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for the pricing page
 	router.GET("/pricing", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.ShowPricingPage(c)
 	})
 
 	// Test accessing the pricing page as a logged-in user
 	req, _ := http.NewRequest("GET", "/pricing", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -173,21 +164,16 @@ func TestPaymentHistoryDisplay(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for the payment history page
 	router.GET("/payment/history", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.ShowPaymentHistory(c)
 	})
 
 	// Test accessing the payment history page
 	req, _ := http.NewRequest("GET", "/payment/history", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
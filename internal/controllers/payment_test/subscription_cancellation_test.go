@@ -10,6 +10,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -57,23 +58,19 @@ func TestCancelSubscriptionButton(t *testing.T) {
 
 			// Set up the router
 			router := gin.Default()
+			router.Use(payment_test_utils.Sessions.LoadUser())
 
 			// Set up the controller
-			paymentController := controllers.NewPaymentController(db)
+			paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 			// Set up the route
 			router.GET("/owner/payment-history", func(c *gin.Context) {
-				// Set authentication cookies for the test
-				c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-				c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 				paymentController.ShowPaymentHistory(c)
 			})
 
 			// Test accessing the payment history page
 			req, _ := http.NewRequest("GET", "/owner/payment-history", nil)
-			req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-			req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+			payment_test_utils.SignIn(t, req, user)
 
 			w := httptest.NewRecorder()
 			router.ServeHTTP(w, req)
@@ -111,23 +108,19 @@ func TestCancelSubscriptionEndpoint(t *testing.T) {
 
 	// Set up the router
 	router := gin.Default()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Set up the route
 	router.POST("/subscription/cancel", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.CancelSubscription(c)
 	})
 
 	// Test accessing the cancel subscription endpoint
 	req, _ := http.NewRequest("POST", "/subscription/cancel", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -182,23 +175,19 @@ func TestCancelSubscriptionWithStripe(t *testing.T) {
 
 	// Set up the router
 	router := gin.Default()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Set up the route
 	router.POST("/subscription/cancel", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.CancelSubscription(c)
 	})
 
 	// Test accessing the cancel subscription endpoint
 	req, _ := http.NewRequest("POST", "/subscription/cancel", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -231,23 +220,19 @@ func TestCancelSubscriptionConfirmationPage(t *testing.T) {
 
 	// Set up the router
 	router := gin.Default()
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Set up the route
 	router.GET("/subscription/cancel/confirm", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.ShowCancelConfirmation(c)
 	})
 
 	// Test accessing the cancel confirmation page
 	req, _ := http.NewRequest("GET", "/subscription/cancel/confirm", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -12,15 +12,51 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
+// seedFlowPlans seeds the plans bought in the subscription flow tests
+func seedFlowPlans(t *testing.T, db *gorm.DB) {
+	plans := []models.Plan{
+		{Tier: models.TierMonthly, Name: "Liking It", Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth, Active: true},
+		{Tier: models.TierYearly, Name: "Loving It", Price: 3000, Currency: "usd", Interval: models.PlanIntervalYear, Active: true},
+		{Tier: models.TierLifetime, Name: "Supporter", Price: 15000, Currency: "usd", Active: true},
+		{Tier: models.TierPremiumLifetime, Name: "Big Baller", Price: 30000, Currency: "usd", Active: true},
+	}
+	for i := range plans {
+		require.NoError(t, db.Create(&plans[i]).Error)
+	}
+}
+
+// startCheckout starts a checkout for a monthly plan with the fake provider and returns its session ID
+func startCheckout(t *testing.T, provider billing.PaymentProvider, user *models.User) string {
+	s, err := provider.CreateCheckoutSession(billing.CheckoutParams{
+		UserID:     user.ID,
+		Email:      user.Email,
+		Plan:       &models.Plan{Tier: models.TierMonthly, Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth},
+		SuccessURL: "/payment/success?session_id={CHECKOUT_SESSION_ID}",
+	})
+	require.NoError(t, err)
+	return s.ID
+}
+
+// completeCheckout pays for a checkout with the fake provider and delivers the completion webhook
+func completeCheckout(t *testing.T, router *gin.Engine, provider *billing.FakeProvider, sessionID string) {
+	hook, err := provider.CompleteCheckout(sessionID)
+	require.NoError(t, err)
+	deliver(t, router, hook)
+}
+
 // TestMonthlySubscriptionFlow tests the complete flow for subscribing to the monthly plan
 func TestMonthlySubscriptionFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -34,20 +70,17 @@ func TestMonthlySubscriptionFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -71,9 +104,8 @@ func TestMonthlySubscriptionFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -81,17 +113,16 @@ func TestMonthlySubscriptionFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_monthly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test handling the payment success
 	t.Log("STEP 2: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -134,6 +165,7 @@ func TestYearlySubscriptionFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -147,20 +179,17 @@ func TestYearlySubscriptionFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -184,9 +213,8 @@ func TestYearlySubscriptionFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "yearly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -194,17 +222,16 @@ func TestYearlySubscriptionFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_yearly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test handling the payment success
 	t.Log("STEP 2: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -247,6 +274,7 @@ func TestLifetimeSubscriptionFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -260,20 +288,17 @@ func TestLifetimeSubscriptionFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -297,9 +322,8 @@ func TestLifetimeSubscriptionFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "lifetime")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -307,17 +331,16 @@ func TestLifetimeSubscriptionFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_lifetime" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test handling the payment success
 	t.Log("STEP 2: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -362,6 +385,7 @@ func TestPremiumLifetimeSubscriptionFlow(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -375,20 +399,17 @@ func TestPremiumLifetimeSubscriptionFlow(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -412,9 +433,8 @@ func TestPremiumLifetimeSubscriptionFlow(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "premium_lifetime")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -422,17 +442,16 @@ func TestPremiumLifetimeSubscriptionFlow(t *testing.T) {
 	// Check that the user is redirected to the success page with a test session ID
 	assert.Equal(t, http.StatusSeeOther, w.Code)
 	redirectURL := w.Header().Get("Location")
-	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_test_")
+	assert.Contains(t, redirectURL, "/payment/success?session_id=cs_")
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_premium" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// STEP 2: Test handling the payment success
 	t.Log("STEP 2: Handling payment success")
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -477,6 +496,7 @@ func TestUpgradeMonthlyToYearly(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -487,20 +507,17 @@ func TestUpgradeMonthlyToYearly(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -524,22 +541,20 @@ func TestUpgradeMonthlyToYearly(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_monthly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the monthly subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -557,22 +572,20 @@ func TestUpgradeMonthlyToYearly(t *testing.T) {
 	form = url.Values{}
 	form.Add("tier", "yearly")
 	req, _ = http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL = w.Header().Get("Location")
 	sessionID = strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_yearly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the yearly subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -622,6 +635,7 @@ func TestUpgradeMonthlyToLifetime(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -632,20 +646,17 @@ func TestUpgradeMonthlyToLifetime(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -669,22 +680,20 @@ func TestUpgradeMonthlyToLifetime(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "monthly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_monthly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the monthly subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -699,22 +708,20 @@ func TestUpgradeMonthlyToLifetime(t *testing.T) {
 	form = url.Values{}
 	form.Add("tier", "lifetime")
 	req, _ = http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL = w.Header().Get("Location")
 	sessionID = strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_lifetime" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the lifetime subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -765,6 +772,7 @@ func TestUpgradeYearlyToLifetime(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -775,20 +783,17 @@ func TestUpgradeYearlyToLifetime(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -812,22 +817,20 @@ func TestUpgradeYearlyToLifetime(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "yearly")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_yearly" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the yearly subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -842,22 +845,20 @@ func TestUpgradeYearlyToLifetime(t *testing.T) {
 	form = url.Values{}
 	form.Add("tier", "lifetime")
 	req, _ = http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL = w.Header().Get("Location")
 	sessionID = strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_lifetime" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the lifetime subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -908,6 +909,7 @@ func TestUpgradeLifetimeToPremium(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedFlowPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -918,20 +920,17 @@ func TestUpgradeLifetimeToPremium(t *testing.T) {
 
 	// Set up test router and controller
 	router := gin.New()
-	paymentController := controllers.NewPaymentController(db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
 
 	// Set up the routes
 	router.POST("/checkout", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.CreateCheckoutSession(c)
 	})
 
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
 		paymentController.HandlePaymentSuccess(c)
 	})
 
@@ -955,22 +954,20 @@ func TestUpgradeLifetimeToPremium(t *testing.T) {
 	form := url.Values{}
 	form.Add("tier", "lifetime")
 	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL := w.Header().Get("Location")
 	sessionID := strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_lifetime" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the lifetime subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -988,22 +985,20 @@ func TestUpgradeLifetimeToPremium(t *testing.T) {
 	form = url.Values{}
 	form.Add("tier", "premium_lifetime")
 	req, _ = http.NewRequest("POST", "/checkout", strings.NewReader(form.Encode()))
+	payment_test_utils.SignIn(t, req, user)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
 
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
-	// Extract the session ID from the redirect URL and add tier information
+	// Extract the session ID from the redirect URL and pay for the checkout
 	redirectURL = w.Header().Get("Location")
 	sessionID = strings.Split(strings.Split(redirectURL, "session_id=")[1], "&")[0]
-	sessionID = sessionID + "_premium" // Add tier to session ID for test mode
+	completeCheckout(t, router, provider, sessionID)
 
 	// Complete the premium lifetime subscription
 	req, _ = http.NewRequest("GET", "/payment/success?session_id="+sessionID, nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
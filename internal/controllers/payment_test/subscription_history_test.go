@@ -0,0 +1,99 @@
+package payment_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "development")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	adminUser, err := testutils.CreateTestUser(db, "history-admin@example.com", "password123", true)
+	require.NoError(t, err)
+	provider := billing.NewFakeProvider()
+	router := planChangeRouter(db, provider, user)
+	adminRouter := billingAdminRouter(db, provider, adminUser)
+	userPath := fmt.Sprintf("/admin/billing/users/%d", user.ID)
+
+	history := func() []models.SubscriptionChange {
+		changes, err := models.FindSubscriptionChangesByUser(db, user.ID)
+		require.NoError(t, err)
+		return changes
+	}
+
+	// Checking out records the upgrade with the payment
+	purchase(t, router, provider, models.TierMonthly)
+	changes := history()
+	require.Len(t, changes, 1)
+	assert.Equal(t, models.TierFree, changes[0].FromTier)
+	assert.Equal(t, models.TierMonthly, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseWebhook, changes[0].Cause)
+	assert.True(t, changes[0].IsUpgrade())
+	require.NotNil(t, changes[0].ExpiresAt)
+	require.NotNil(t, changes[0].Payment)
+	assert.Equal(t, models.TierMonthly, changes[0].Payment.Tier)
+
+	// Switching plans is recorded as the user's own change
+	w := postForm(router, "/subscription/change", url.Values{"tier": {models.TierYearly}})
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	changes = history()
+	require.Len(t, changes, 2)
+	assert.Equal(t, models.TierMonthly, changes[0].FromTier)
+	assert.Equal(t, models.TierYearly, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseUser, changes[0].Cause)
+	require.NotNil(t, changes[0].PaymentID)
+
+	// The provider ending the subscription lapses the user, but only once
+	var subscribed models.User
+	require.NoError(t, db.First(&subscribed, user.ID).Error)
+	hook, err := provider.Sign("customer.subscription.deleted", map[string]interface{}{
+		"id":       subscribed.StripeSubscriptionID,
+		"object":   "subscription",
+		"customer": subscribed.StripeCustomerID,
+		"status":   "canceled",
+	})
+	require.NoError(t, err)
+	deliver(t, router, hook)
+	deliver(t, router, hook)
+
+	changes = history()
+	require.Len(t, changes, 3)
+	assert.Equal(t, models.TierYearly, changes[0].FromTier)
+	assert.Equal(t, models.TierFree, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseWebhook, changes[0].Cause)
+	assert.True(t, changes[0].IsLapse())
+	assert.Nil(t, changes[0].PaymentID)
+
+	// Admin comps and revokes are recorded with the admin's reason
+	postForm(adminRouter, userPath+"/comp", url.Values{"tier": {models.TierMonthly}, "days": {"14"}, "reason": {"Sorry about the outage"}})
+	postForm(adminRouter, userPath+"/extend", url.Values{"days": {"7"}, "reason": {"Still sorry"}})
+	postForm(adminRouter, userPath+"/revoke", url.Values{"reason": {"Outage credit used up"}})
+
+	changes = history()
+	require.Len(t, changes, 5)
+	assert.Equal(t, models.TierMonthly, changes[1].ToTier)
+	assert.Equal(t, models.SubscriptionCauseAdmin, changes[1].Cause)
+	assert.Equal(t, "Sorry about the outage", changes[1].Note)
+	require.NotNil(t, changes[1].ExpiresAt)
+	assert.Equal(t, models.TierFree, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseAdmin, changes[0].Cause)
+	assert.Nil(t, changes[0].ExpiresAt)
+
+	assert.Equal(t, http.StatusOK, get(adminRouter, userPath).Code)
+}
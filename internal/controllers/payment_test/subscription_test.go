@@ -51,21 +51,17 @@ func TestSubscriptionTiers(t *testing.T) {
 
 	// Set up test router
 	router, _ := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for the pricing page
 	router.GET("/pricing", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		// Use the mock pricing page instead of the real one to avoid template issues
 		payment_test_utils.MockPricingPage(c, user)
 	})
 
 	// Test accessing the pricing page as a logged-in user
 	req, _ := http.NewRequest("GET", "/pricing", nil)
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -94,6 +90,7 @@ func TestStripeWebhookHandling(t *testing.T) {
 	// Set up test database
 	db := payment_test_utils.SetupTestDB(t)
 	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
 
 	// Set test environment
 	t.Setenv("APP_ENV", "test")
@@ -157,21 +154,16 @@ func TestPaymentSuccess(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for payment success
 	router.GET("/payment/success", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.HandlePaymentSuccess(c)
 	})
 
 	// Test accessing the payment success page
-	req, _ := http.NewRequest("GET", "/payment/success?session_id=cs_test_123", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	req, _ := http.NewRequest("GET", "/payment/success?session_id="+startCheckout(t, paymentController.Provider, user), nil)
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -192,21 +184,16 @@ func TestPaymentCancellation(t *testing.T) {
 
 	// Set up test router and controller
 	router, paymentController := payment_test_utils.SetupPricingTestRouter(t, db)
+	router.Use(payment_test_utils.Sessions.LoadUser())
 
 	// Set up the route for payment cancellation
 	router.GET("/payment/cancel", func(c *gin.Context) {
-		// Set authentication cookies for the test
-		c.SetCookie("is_logged_in", "true", 3600, "/", "localhost", false, true)
-		c.SetCookie("user_email", user.Email, 3600, "/", "localhost", false, true)
-
 		paymentController.HandlePaymentCancellation(c)
 	})
 
 	// Test accessing the payment cancellation page
 	req, _ := http.NewRequest("GET", "/payment/cancel", nil)
-	// Add authentication cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	payment_test_utils.SignIn(t, req, user)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
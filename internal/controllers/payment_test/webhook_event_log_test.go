@@ -0,0 +1,266 @@
+package payment_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// sendWebhook posts a test-mode Stripe event to the webhook endpoint
+func sendWebhook(t *testing.T, router *gin.Engine, event map[string]interface{}) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBuffer(mustMarshal(t, event)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Stripe-Signature", "test_signature")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// checkoutCompletedEvent builds a checkout.session.completed event for a user
+func checkoutCompletedEvent(eventID string, userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     eventID,
+		"object": "event",
+		"type":   "checkout.session.completed",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":                  "cs_" + eventID,
+				"object":              "checkout.session",
+				"amount_total":        500,
+				"currency":            "usd",
+				"client_reference_id": userID,
+				"metadata": map[string]interface{}{
+					"user_id":           userID,
+					"subscription_tier": "monthly",
+				},
+			},
+		},
+	}
+}
+
+// TestWebhookRetriesAreProcessedOnce verifies that a redelivered event doesn't create a second payment
+func TestWebhookRetriesAreProcessedOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "test")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
+	router := gin.Default()
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+
+	event := checkoutCompletedEvent("evt_log_retry", fmt.Sprintf("%d", user.ID))
+	for i := 0; i < 3; i++ {
+		w := sendWebhook(t, router, event)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	var count int64
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	record, err := models.FindWebhookEventByEventID(db, "evt_log_retry")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusProcessed, record.Status)
+	assert.Equal(t, "checkout.session.completed", record.Type)
+	assert.Equal(t, 1, record.Attempts)
+	assert.NotNil(t, record.ProcessedAt)
+	assert.Contains(t, record.Payload, "evt_log_retry")
+
+	// Event types we don't act on are logged as ignored
+	w := sendWebhook(t, router, map[string]interface{}{"id": "evt_log_ignored", "object": "event", "type": "customer.created", "data": map[string]interface{}{"object": map[string]interface{}{}}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	ignored, err := models.FindWebhookEventByEventID(db, "evt_log_ignored")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusIgnored, ignored.Status)
+}
+
+// TestWebhookRecordingFailuresAreRetried verifies that Stripe is asked to retry when the event can't be recorded
+func TestWebhookRecordingFailuresAreRetried(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "test")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
+	router := gin.Default()
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+
+	failing := true
+	require.NoError(t, db.Callback().Create().Before("gorm:create").Register("fail_webhook_events", func(tx *gorm.DB) {
+		if failing && tx.Statement.Table == "webhook_events" {
+			tx.AddError(errors.New("database is locked"))
+		}
+	}))
+
+	event := checkoutCompletedEvent("evt_log_unrecorded", fmt.Sprintf("%d", user.ID))
+	w := sendWebhook(t, router, event)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	_, err := models.FindWebhookEventByEventID(db, "evt_log_unrecorded")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Stripe's retry goes through once the database recovers
+	failing = false
+	w = sendWebhook(t, router, event)
+	assert.Equal(t, http.StatusOK, w.Code)
+	record, err := models.FindWebhookEventByEventID(db, "evt_log_unrecorded")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusProcessed, record.Status, record.Error)
+}
+
+// TestFailedWebhookCanBeReplayed verifies that failures are recorded and can be replayed by an admin
+func TestFailedWebhookCanBeReplayed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "test")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	webhookEventController := controllers.NewWebhookEventController(db, provider)
+	router := gin.Default()
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	router.POST("/admin/webhook-events/:id/replay", webhookEventController.Replay)
+
+	// The event names a user that doesn't exist yet
+	user := payment_test_utils.CreateTestUser(t, db)
+	missingID := fmt.Sprintf("%d", user.ID+1000)
+	w := sendWebhook(t, router, checkoutCompletedEvent("evt_log_failed", missingID))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	record, err := models.FindWebhookEventByEventID(db, "evt_log_failed")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusFailed, record.Status)
+	assert.Contains(t, record.Error, "failed to find user")
+	assert.True(t, record.CanReplay())
+
+	// Point the stored payload at the real user and replay it
+	record.Payload = string(mustMarshal(t, checkoutCompletedEvent("evt_log_failed", fmt.Sprintf("%d", user.ID))))
+	require.NoError(t, models.UpdateWebhookEvent(db, record))
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/webhook-events/%d/replay", record.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	record, err = models.FindWebhookEventByEventID(db, "evt_log_failed")
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusProcessed, record.Status)
+	assert.Equal(t, 2, record.Attempts)
+	assert.Empty(t, record.Error)
+
+	var count int64
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// A processed event can't be replayed again
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/webhook-events/%d/replay", record.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestWebhookEventsAreClaimedOnce verifies that only one attempt can process an event at a time
+func TestWebhookEventsAreClaimedOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("APP_ENV", "test")
+
+	db := payment_test_utils.SetupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	seedPaidPlans(t, db)
+
+	user := payment_test_utils.CreateTestUser(t, db)
+	provider := billing.NewFakeProvider()
+	paymentController := controllers.NewPaymentController(db, provider)
+	webhookEventController := controllers.NewWebhookEventController(db, provider)
+	router := gin.Default()
+	router.POST("/webhook", paymentController.HandleStripeWebhook)
+	router.POST("/admin/webhook-events/:id/replay", webhookEventController.Replay)
+
+	// An event that another attempt is in the middle of processing
+	record := &models.WebhookEvent{
+		EventID: "evt_log_in_flight",
+		Type:    "checkout.session.completed",
+		Payload: string(mustMarshal(t, checkoutCompletedEvent("evt_log_in_flight", fmt.Sprintf("%d", user.ID)))),
+		Status:  models.WebhookEventStatusReceived,
+	}
+	require.NoError(t, models.CreateWebhookEvent(db, record))
+	claimed, err := models.ClaimWebhookEvent(db, record)
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	// Neither a second claim, a redelivery nor a replay can process it as well
+	stale := *record
+	stale.Status = models.WebhookEventStatusReceived
+	claimed, err = models.ClaimWebhookEvent(db, &stale)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	w := sendWebhook(t, router, checkoutCompletedEvent("evt_log_in_flight", fmt.Sprintf("%d", user.ID)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "duplicate")
+
+	assert.False(t, record.CanReplay())
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/webhook-events/%d/replay", record.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	var count int64
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Zero(t, count)
+
+	// Once the attempt has been stuck past the timeout an admin can replay it
+	require.NoError(t, db.Model(&models.WebhookEvent{}).Where("id = ?", record.ID).
+		UpdateColumn("updated_at", time.Now().Add(-models.WebhookEventStaleAfter-time.Minute)).Error)
+	record, err = models.FindWebhookEventByID(db, record.ID)
+	require.NoError(t, err)
+	assert.True(t, record.CanReplay())
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/webhook-events/%d/replay", record.ID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	record, err = models.FindWebhookEventByID(db, record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.WebhookEventStatusProcessed, record.Status)
+	assert.Equal(t, 2, record.Attempts)
+	assert.False(t, record.CanReplay())
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+// mustMarshal encodes a value as JSON, failing the test on error
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
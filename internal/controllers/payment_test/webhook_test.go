@@ -11,6 +11,7 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers/payment_test/payment_test_utils"
 	"github.com/hail2skins/the-virtual-armory/internal/middleware"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
 )
@@ -123,7 +124,7 @@ func TestWebhookHandlerInTestMode(t *testing.T) {
 	defer testutils.CleanupTestDB(db)
 
 	// Create a payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentController(db, billing.NewFakeProvider())
 
 	// Create a router with the webhook handler
 	router := gin.Default()
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// PlanController handles the admin pages for editing the pricing catalog
+type PlanController struct {
+	DB *gorm.DB
+}
+
+// NewPlanController creates a new PlanController
+func NewPlanController(db *gorm.DB) *PlanController {
+	return &PlanController{
+		DB: db,
+	}
+}
+
+// Index displays every plan, including inactive ones
+func (c *PlanController) Index(ctx *gin.Context) {
+	plans, err := models.FindPlans(c.DB)
+	if err != nil {
+		log.Printf("Error fetching plans: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch plans"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.PlanIndex(plans, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Edit displays the form to edit a plan
+func (c *PlanController) Edit(ctx *gin.Context) {
+	plan, err := c.findPlan(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Plan not found"})
+		return
+	}
+
+	component := admin.PlanEdit(*plan, "")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Update saves changes to a plan and applies its new limits
+func (c *PlanController) Update(ctx *gin.Context) {
+	plan, err := c.findPlan(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Plan not found"})
+		return
+	}
+
+	// Validate the form and apply it to the plan
+	if errorMessage := applyPlanForm(ctx, plan); errorMessage != "" {
+		ctx.Status(http.StatusBadRequest)
+		component := admin.PlanEdit(*plan, errorMessage)
+		component.Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
+	if err := models.UpdatePlan(c.DB, plan); err != nil {
+		log.Printf("Error updating plan: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to update plan"})
+		return
+	}
+
+	// Apply the new limits straight away
+	if err := entitlements.Load(c.DB); err != nil {
+		log.Printf("Error reloading plan limits: %v", err)
+	}
+
+	flash.SetMessage(ctx, plan.Name+" plan updated", "success")
+	ctx.Redirect(http.StatusSeeOther, "/admin/plans")
+}
+
+// findPlan loads the plan named in the URL
+func (c *PlanController) findPlan(ctx *gin.Context) (*models.Plan, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return models.FindPlanByID(c.DB, uint(id))
+}
+
+// applyPlanForm copies the submitted form onto a plan, returning a message if it isn't valid
+func applyPlanForm(ctx *gin.Context, plan *models.Plan) string {
+	plan.Name = strings.TrimSpace(ctx.PostForm("name"))
+	plan.Description = strings.TrimSpace(ctx.PostForm("description"))
+	plan.Features = strings.TrimSpace(ctx.PostForm("features"))
+	plan.Badge = strings.TrimSpace(ctx.PostForm("badge"))
+	plan.Currency = strings.ToLower(strings.TrimSpace(ctx.PostForm("currency")))
+	plan.Interval = ctx.PostForm("interval")
+	plan.StripeProductID = strings.TrimSpace(ctx.PostForm("stripe_product_id"))
+	plan.Active = ctx.PostForm("active") == "on"
+	plan.Exports = ctx.PostForm("exports") == "on"
+	plan.APIAccess = ctx.PostForm("api_access") == "on"
+
+	if plan.Name == "" {
+		return "Name is required"
+	}
+	if plan.Currency == "" {
+		plan.Currency = "usd"
+	}
+	if plan.Interval != "" && plan.Interval != models.PlanIntervalMonth && plan.Interval != models.PlanIntervalYear {
+		return "Billing interval must be monthly, yearly or one-time"
+	}
+
+	trialDays := 0
+	if value := strings.TrimSpace(ctx.PostForm("trial_days")); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return "Free trial must be a whole number of days"
+		}
+		trialDays = days
+	}
+	if trialDays > 0 && plan.Interval == "" {
+		return "Only monthly and yearly plans can have a free trial"
+	}
+	plan.TrialDays = trialDays
+
+	price, err := parseCents(ctx.PostForm("price"))
+	if err != nil {
+		return "Price must be a dollar amount"
+	}
+	plan.Price = price
+
+	// The free tier is what everyone falls back to, so it can't be charged for or withdrawn
+	if plan.Tier == models.TierFree {
+		if plan.Price != 0 || plan.Interval != "" {
+			return "The free plan can't have a price"
+		}
+		if !plan.Active {
+			return "The free plan can't be deactivated"
+		}
+	} else if plan.Price == 0 {
+		return "Paid plans must have a price"
+	}
+
+	sortOrder, err := strconv.Atoi(strings.TrimSpace(ctx.DefaultPostForm("sort_order", "0")))
+	if err != nil {
+		return "Sort order must be a whole number"
+	}
+	plan.SortOrder = sortOrder
+
+	if plan.MaxGuns, err = parseLimit(ctx.PostForm("max_guns")); err != nil {
+		return "Gun limit must be a whole number, or blank for unlimited"
+	}
+	if plan.MaxAmmo, err = parseLimit(ctx.PostForm("max_ammo")); err != nil {
+		return "Ammo limit must be a whole number, or blank for unlimited"
+	}
+
+	storageMB, err := strconv.ParseInt(strings.TrimSpace(ctx.PostForm("max_attachment_mb")), 10, 64)
+	if err != nil || storageMB < 0 {
+		return "Storage must be a whole number of megabytes"
+	}
+	plan.MaxAttachmentBytes = storageMB << 20
+
+	return ""
+}
+
+// parseLimit converts a count limit from a form, where blank means unlimited
+func parseLimit(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return entitlements.Unlimited, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return limit, nil
+}
@@ -0,0 +1,139 @@
+package plan_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupPlanTest sets up the test environment with the free and monthly plans
+func setupPlanTest(t *testing.T) (*gin.Engine, *models.Plan, *models.Plan) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	database.DB = db
+	db.Exec("DELETE FROM plans")
+
+	free := models.Plan{Tier: models.TierFree, Name: "Free", Currency: "usd", Active: true, MaxGuns: 2, MaxAmmo: 4}
+	require.NoError(t, db.Create(&free).Error)
+	monthly := models.Plan{Tier: models.TierMonthly, Name: "Liking It", Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth,
+		Active: true, MaxGuns: entitlements.Unlimited, MaxAmmo: entitlements.Unlimited, Exports: true}
+	require.NoError(t, db.Create(&monthly).Error)
+
+	// Create a test router with the plan routes
+	planController := controllers.NewPlanController(db)
+	router := gin.Default()
+	router.GET("/admin/plans", planController.Index)
+	router.POST("/admin/plans/:id", planController.Update)
+
+	return router, &free, &monthly
+}
+
+// cleanup removes the plans and restores the built-in limits
+func cleanup() {
+	database.DB.Exec("DELETE FROM plans")
+	entitlements.Load(database.DB)
+}
+
+// postPlan submits the plan edit form
+func postPlan(router *gin.Engine, plan *models.Plan, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/admin/plans/"+strconv.FormatUint(uint64(plan.ID), 10), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestUpdatePlan(t *testing.T) {
+	router, free, monthly := setupPlanTest(t)
+	defer cleanup()
+
+	form := url.Values{
+		"name":              {"Free Forever"},
+		"currency":          {"usd"},
+		"price":             {"0"},
+		"active":            {"on"},
+		"max_guns":          {"5"},
+		"max_ammo":          {""},
+		"max_attachment_mb": {"50"},
+	}
+	resp := postPlan(router, free, form)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/admin/plans", resp.Header().Get("Location"))
+
+	updated, err := models.FindPlanByID(database.DB, free.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Free Forever", updated.Name)
+	assert.Equal(t, 5, updated.MaxGuns)
+	assert.Equal(t, entitlements.Unlimited, updated.MaxAmmo)
+	assert.Equal(t, int64(50<<20), updated.MaxAttachmentBytes)
+
+	// The new limits apply straight away
+	limits := entitlements.ForTier(models.TierFree)
+	assert.Equal(t, "Free Forever", limits.Plan)
+	assert.Equal(t, 5, limits.MaxGuns)
+
+	// Prices are entered in dollars
+	form = url.Values{
+		"name":              {"Liking It"},
+		"price":             {"7.50"},
+		"interval":          {models.PlanIntervalMonth},
+		"trial_days":        {"14"},
+		"max_attachment_mb": {"1024"},
+	}
+	resp = postPlan(router, monthly, form)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+
+	updated, err = models.FindPlanByID(database.DB, monthly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(750), updated.Price)
+	assert.Equal(t, 14, updated.TrialDays)
+	assert.Equal(t, "usd", updated.Currency)
+	assert.False(t, updated.Active)
+}
+
+func TestUpdatePlanValidation(t *testing.T) {
+	router, free, monthly := setupPlanTest(t)
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		plan *models.Plan
+		form url.Values
+	}{
+		{"free plan with a price", free, url.Values{"name": {"Free"}, "price": {"5"}, "active": {"on"}, "max_attachment_mb": {"25"}}},
+		{"free plan deactivated", free, url.Values{"name": {"Free"}, "price": {"0"}, "max_attachment_mb": {"25"}}},
+		{"paid plan without a price", monthly, url.Values{"name": {"Liking It"}, "price": {""}, "interval": {"month"}, "max_attachment_mb": {"25"}}},
+		{"missing name", monthly, url.Values{"name": {""}, "price": {"5"}, "max_attachment_mb": {"25"}}},
+		{"unknown interval", monthly, url.Values{"name": {"Liking It"}, "price": {"5"}, "interval": {"week"}, "max_attachment_mb": {"25"}}},
+		{"negative limit", monthly, url.Values{"name": {"Liking It"}, "price": {"5"}, "max_guns": {"-3"}, "max_attachment_mb": {"25"}}},
+		{"trial on a one-time plan", monthly, url.Values{"name": {"Liking It"}, "price": {"5"}, "trial_days": {"14"}, "max_attachment_mb": {"25"}}},
+		{"negative trial", monthly, url.Values{"name": {"Liking It"}, "price": {"5"}, "interval": {"month"}, "trial_days": {"-1"}, "max_attachment_mb": {"25"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := postPlan(router, tt.plan, tt.form)
+			assert.Equal(t, http.StatusBadRequest, resp.Code)
+		})
+	}
+
+	// Nothing was saved
+	unchanged, err := models.FindPlanByID(database.DB, monthly.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), unchanged.Price)
+	assert.True(t, unchanged.Active)
+}
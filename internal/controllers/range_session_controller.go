@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/rangesession"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// RangeSessionController handles requests related to range sessions
+type RangeSessionController struct {
+	DB *gorm.DB
+}
+
+// NewRangeSessionController creates a new RangeSessionController
+func NewRangeSessionController(db *gorm.DB) *RangeSessionController {
+	return &RangeSessionController{
+		DB: db,
+	}
+}
+
+// Index displays a list of all range sessions belonging to the current user
+func (c *RangeSessionController) Index(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get all range sessions for the current user
+	sessions, err := models.FindRangeSessionsByOwner(c.DB, user.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get range sessions"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the index template
+	component := rangesession.Index(sessions, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Show displays details for a specific range session
+func (c *RangeSessionController) Show(ctx *gin.Context) {
+	// Get the range session ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid range session ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the range session
+	session, err := models.FindRangeSessionByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Range session not found"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the show template
+	component := rangesession.Show(*session, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// New displays the form to log a new range session
+func (c *RangeSessionController) New(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Get the user's guns for the line item dropdowns
+	var guns []models.Gun
+	if err := c.DB.Preload("Caliber").Where("owner_id = ?", user.ID).Order("name").Find(&guns).Error; err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to retrieve guns"})
+		return
+	}
+
+	// Get the user's ammo for the line item dropdowns
+	ammoItems, err := models.FindAmmoByOwner(c.DB, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to retrieve ammo"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the new template
+	component := rangesession.New(guns, ammoItems, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create logs a new range session
+func (c *RangeSessionController) Create(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Parse the session date
+	date, err := time.Parse("2006-01-02", ctx.PostForm("date"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid date format"})
+		return
+	}
+
+	session := models.RangeSession{
+		Date:     date,
+		Location: strings.TrimSpace(ctx.PostForm("location")),
+		Notes:    strings.TrimSpace(ctx.PostForm("notes")),
+		OwnerID:  user.ID,
+	}
+
+	// Parse the line items, which are submitted as parallel arrays
+	gunIDs := ctx.PostFormArray("gun_id")
+	ammoIDs := ctx.PostFormArray("ammo_id")
+	roundsFired := ctx.PostFormArray("rounds_fired")
+	if len(ammoIDs) != len(gunIDs) || len(roundsFired) != len(gunIDs) {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid range session items"})
+		return
+	}
+
+	for i := range gunIDs {
+		// Skip rows the user left blank
+		if gunIDs[i] == "" {
+			continue
+		}
+
+		gunID, err := strconv.ParseUint(gunIDs[i], 10, 64)
+		if err != nil {
+			ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid gun ID"})
+			return
+		}
+
+		// Make sure the gun belongs to the current user
+		if _, err := models.FindGunByID(c.DB, uint(gunID), user.ID); err != nil {
+			ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Gun not found"})
+			return
+		}
+
+		rounds, err := strconv.Atoi(roundsFired[i])
+		if err != nil || rounds < 0 {
+			ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid rounds fired"})
+			return
+		}
+
+		item := models.RangeSessionItem{
+			GunID:       uint(gunID),
+			RoundsFired: rounds,
+		}
+
+		if ammoIDs[i] != "" {
+			ammoID, err := strconv.ParseUint(ammoIDs[i], 10, 64)
+			if err != nil {
+				ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid ammo ID"})
+				return
+			}
+
+			// Make sure the ammo belongs to the current user
+			if _, err := models.FindAmmoByID(c.DB, uint(ammoID), user.ID); err != nil {
+				ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Ammo not found"})
+				return
+			}
+
+			id := uint(ammoID)
+			item.AmmoID = &id
+		}
+
+		session.Items = append(session.Items, item)
+	}
+
+	if len(session.Items) == 0 {
+		flash.SetMessage(ctx, "Please add at least one gun to the range session.", "error")
+		ctx.Redirect(http.StatusSeeOther, "/owner/range/new")
+		return
+	}
+
+	// Save the range session, deducting the rounds fired from stock if requested
+	decrementAmmo := ctx.PostForm("decrement_ammo") == "on"
+	if err := models.CreateRangeSession(c.DB, &session, decrementAmmo); err != nil {
+		if errors.Is(err, models.ErrInsufficientAmmo) {
+			flash.SetMessage(ctx, "You don't have enough ammo on hand to cover the rounds fired.", "error")
+			ctx.Redirect(http.StatusSeeOther, "/owner/range/new")
+			return
+		}
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create range session"})
+		return
+	}
+
+	// Redirect to the range session details page
+	flash.SetMessage(ctx, "Range session logged successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/range/%d", session.ID))
+}
+
+// Delete deletes a range session
+func (c *RangeSessionController) Delete(ctx *gin.Context) {
+	// Get the range session ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid range session ID"})
+		return
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Delete the range session
+	if err := models.DeleteRangeSession(c.DB, uint(id), user.ID); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to delete range session"})
+		return
+	}
+
+	// Redirect to the range session index page
+	flash.SetMessage(ctx, "Range session deleted successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, "/owner/range")
+}
@@ -0,0 +1,207 @@
+package range_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Counter for generating unique emails
+var emailCounter = 0
+
+// setupRangeTest sets up the test environment for range session tests
+func setupRangeTest(t *testing.T) (*gin.Engine, *controllers.RangeSessionController, *models.User) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	assert.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	emailCounter++
+	email := fmt.Sprintf("range%d@example.com", emailCounter)
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	assert.NoError(t, err)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Create a range session controller
+	rangeSessionController := controllers.NewRangeSessionController(db)
+
+	// Create a test router
+	router := gin.Default()
+	router.POST("/owner/range", rangeSessionController.Create)
+
+	return router, rangeSessionController, user
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+// createTestGunAndAmmo creates a gun and matching ammo owned by the user
+func createTestGunAndAmmo(t *testing.T, user *models.User, quantity int) (*models.Gun, *models.Ammo) {
+	caliber := models.Caliber{Caliber: fmt.Sprintf("Range Caliber %d", emailCounter)}
+	assert.NoError(t, database.DB.Create(&caliber).Error)
+
+	gun := models.Gun{Name: "Range Gun", CaliberID: caliber.ID, OwnerID: user.ID}
+	assert.NoError(t, models.CreateGun(database.DB, &gun))
+
+	ammo := models.Ammo{Brand: "Federal", CaliberID: caliber.ID, Quantity: quantity, OwnerID: user.ID}
+	assert.NoError(t, models.CreateAmmo(database.DB, &ammo))
+
+	return &gun, &ammo
+}
+
+// postRangeSession submits the range session form
+func postRangeSession(router *gin.Engine, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/owner/range", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateRangeSessionDecrementsAmmo(t *testing.T) {
+	// Setup
+	router, _, user := setupRangeTest(t)
+	defer cleanup()
+
+	gun, ammo := createTestGunAndAmmo(t, user, 200)
+
+	// Create form data
+	form := url.Values{}
+	form.Add("date", "2024-03-15")
+	form.Add("location", "County Range")
+	form.Add("gun_id", strconv.FormatUint(uint64(gun.ID), 10))
+	form.Add("ammo_id", strconv.FormatUint(uint64(ammo.ID), 10))
+	form.Add("rounds_fired", "150")
+	form.Add("decrement_ammo", "on")
+
+	// Perform the request
+	w := postRangeSession(router, form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	// Verify the session was recorded
+	sessions, err := models.FindRangeSessionsByOwner(database.DB, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, 150, sessions[0].TotalRounds())
+	assert.Equal(t, fmt.Sprintf("/owner/range/%d", sessions[0].ID), w.Header().Get("Location"))
+
+	// Verify the ammo stock was decremented
+	updated, err := models.FindAmmoByID(database.DB, ammo.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, updated.Quantity)
+
+	// Verify the gun stats
+	stats, err := models.FindGunRangeStats(database.DB, gun.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 150, stats.RoundsFired)
+	if assert.NotNil(t, stats.LastFired) {
+		assert.Equal(t, "2024-03-15", stats.LastFired.Format("2006-01-02"))
+	}
+}
+
+func TestCreateRangeSessionInsufficientAmmo(t *testing.T) {
+	// Setup
+	router, _, user := setupRangeTest(t)
+	defer cleanup()
+
+	gun, ammo := createTestGunAndAmmo(t, user, 20)
+
+	// Create form data using more rounds than are on hand
+	form := url.Values{}
+	form.Add("date", time.Now().Format("2006-01-02"))
+	form.Add("gun_id", strconv.FormatUint(uint64(gun.ID), 10))
+	form.Add("ammo_id", strconv.FormatUint(uint64(ammo.ID), 10))
+	form.Add("rounds_fired", "50")
+	form.Add("decrement_ammo", "on")
+
+	// Perform the request
+	w := postRangeSession(router, form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/range/new", w.Header().Get("Location"))
+
+	// Verify nothing was recorded and the stock is untouched
+	sessions, err := models.FindRangeSessionsByOwner(database.DB, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(sessions))
+
+	updated, err := models.FindAmmoByID(database.DB, ammo.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, updated.Quantity)
+}
+
+func TestCreateRangeSessionWithoutDecrement(t *testing.T) {
+	// Setup
+	router, _, user := setupRangeTest(t)
+	defer cleanup()
+
+	gun, ammo := createTestGunAndAmmo(t, user, 100)
+
+	// Create form data without asking to deduct from stock
+	form := url.Values{}
+	form.Add("date", "2024-04-01")
+	form.Add("gun_id", strconv.FormatUint(uint64(gun.ID), 10))
+	form.Add("ammo_id", strconv.FormatUint(uint64(ammo.ID), 10))
+	form.Add("rounds_fired", "60")
+
+	// Perform the request
+	w := postRangeSession(router, form)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	// Verify the stock is untouched
+	updated, err := models.FindAmmoByID(database.DB, ammo.ID, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, updated.Quantity)
+}
+
+func TestGunRangeStatsIgnoresDeletedSessions(t *testing.T) {
+	// Setup
+	_, _, user := setupRangeTest(t)
+	defer cleanup()
+
+	gun, _ := createTestGunAndAmmo(t, user, 0)
+
+	first := models.RangeSession{
+		Date:    time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+		OwnerID: user.ID,
+		Items:   []models.RangeSessionItem{{GunID: gun.ID, RoundsFired: 100}},
+	}
+	assert.NoError(t, models.CreateRangeSession(database.DB, &first, false))
+
+	second := models.RangeSession{
+		Date:    time.Date(2024, 2, 20, 0, 0, 0, 0, time.UTC),
+		OwnerID: user.ID,
+		Items:   []models.RangeSessionItem{{GunID: gun.ID, RoundsFired: 40}},
+	}
+	assert.NoError(t, models.CreateRangeSession(database.DB, &second, false))
+
+	// Delete the most recent session
+	assert.NoError(t, models.DeleteRangeSession(database.DB, second.ID, user.ID))
+
+	stats, err := models.FindGunRangeStats(database.DB, gun.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, stats.RoundsFired)
+	if assert.NotNil(t, stats.LastFired) {
+		assert.Equal(t, "2024-01-10", stats.LastFired.Format("2006-01-02"))
+	}
+}
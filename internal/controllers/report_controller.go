@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/reports"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/insurance"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// ReportController handles requests related to owner reports
+type ReportController struct {
+	DB        *gorm.DB
+	Insurance *insurance.Generator
+}
+
+// NewReportController creates a new ReportController, store may be nil if file storage is unavailable
+func NewReportController(db *gorm.DB, store storage.Storage) *ReportController {
+	return &ReportController{
+		DB:        db,
+		Insurance: insurance.NewGenerator(db, store),
+	}
+}
+
+// Value displays the collection value report
+func (c *ReportController) Value(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Build the report
+	report, err := models.BuildValueReport(c.DB, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to build value report"})
+		return
+	}
+
+	// Render the value report template
+	component := reports.Value(report)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// ValueCSV exports the collection value report as CSV
+func (c *ReportController) ValueCSV(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Check the user's plan includes report exports
+	if err := entitlements.CheckExports(user); err != nil {
+		flash.SetMessage(ctx, err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, "/pricing")
+		return
+	}
+
+	// Build the report
+	report, err := models.BuildValueReport(c.DB, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to build value report"})
+		return
+	}
+
+	// Write one row per gun followed by the totals
+	filename := fmt.Sprintf("collection-value-%s.csv", time.Now().Format("2006-01-02"))
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Status(http.StatusOK)
+
+	writer := csv.NewWriter(ctx.Writer)
+	writer.Write([]string{"Name", "Weapon Type", "Manufacturer", "Caliber", "Acquired", "Purchased From", "Condition", "Cost", "Current Value", "Gain", "Last Appraised"})
+	for _, item := range report.Guns {
+		writer.Write([]string{
+			formatCSVText(item.Gun.Name),
+			formatCSVText(item.Gun.WeaponType.Type),
+			formatCSVText(item.Gun.Manufacturer.Name),
+			formatCSVText(item.Gun.Caliber.Caliber),
+			formatCSVDate(item.Gun.Acquired),
+			formatCSVText(item.Gun.PurchaseSource),
+			models.FormatGunCondition(item.Gun.Condition),
+			formatCSVCents(item.Cost),
+			formatCSVCents(item.Value),
+			formatCSVCents(item.Value - item.Cost),
+			formatCSVDate(item.LastAppraised),
+		})
+	}
+	writer.Write([]string{"Total", "", "", "", "", "", "", formatCSVCents(report.Total.Cost), formatCSVCents(report.Total.Value), formatCSVCents(report.Total.Gain()), ""})
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to write value report CSV: %v", err)
+	}
+}
+
+// InsurancePDF generates a printable PDF schedule of the selected guns
+func (c *ReportController) InsurancePDF(ctx *gin.Context) {
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return
+	}
+
+	// Check the user's plan includes report exports
+	if err := entitlements.CheckExports(user); err != nil {
+		flash.SetMessage(ctx, err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, "/pricing")
+		return
+	}
+
+	// Parse the selected gun IDs
+	var gunIDs []uint
+	for _, value := range ctx.PostFormArray("gun_ids") {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid gun ID"})
+			return
+		}
+		gunIDs = append(gunIDs, uint(id))
+	}
+
+	// Render the report into a buffer so errors can still be shown to the user
+	var buf bytes.Buffer
+	now := time.Now()
+	if err := c.Insurance.Generate(ctx.Request.Context(), &buf, user, gunIDs, now); err != nil {
+		if errors.Is(err, insurance.ErrNoGuns) {
+			flash.SetMessage(ctx, "Please select at least one gun for the report", "error")
+			ctx.Redirect(http.StatusSeeOther, "/owner/guns")
+			return
+		}
+		log.Printf("Failed to generate insurance report for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to generate report"})
+		return
+	}
+
+	// Send the PDF as a download
+	filename := fmt.Sprintf("firearm-schedule-%s.pdf", now.Format("2006-01-02"))
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
+
+// formatCSVText keeps text a user typed from being run as a formula when the CSV is opened in a spreadsheet
+func formatCSVText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// formatCSVCents formats an amount in cents as a plain decimal for spreadsheets
+func formatCSVCents(cents int64) string {
+	return strconv.FormatFloat(float64(cents)/100.0, 'f', 2, 64)
+}
+
+// formatCSVDate formats an optional date for CSV output
+func formatCSVDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,283 @@
+package report_test
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Counter for generating unique names
+var testCounter = 0
+
+// setupReportTest sets up the test environment for report tests
+func setupReportTest(t *testing.T) (*gin.Engine, *models.User) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	// Set the global database variable to the test database
+	database.DB = db
+
+	// Create a test user with a unique email
+	testCounter++
+	email := fmt.Sprintf("report%d@example.com", testCounter)
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	require.NoError(t, err)
+
+	// Exports are a paid feature
+	user.SubscriptionTier = models.TierMonthly
+	user.SubscriptionExpiresAt = time.Now().AddDate(0, 1, 0)
+	require.NoError(t, db.Save(user).Error)
+
+	// Set the mock user for authentication
+	auth.MockUser = user
+
+	// Create a test router with the report and valuation routes
+	reportController := controllers.NewReportController(db, nil)
+	valuationController := controllers.NewValuationController(db)
+	router := gin.Default()
+	router.GET("/owner/reports/value.csv", reportController.ValueCSV)
+	router.POST("/owner/reports/insurance", reportController.InsurancePDF)
+	router.POST("/owner/guns/:id/valuations", valuationController.Create)
+	router.POST("/owner/guns/:id/valuations/:valuationID/delete", valuationController.Delete)
+
+	return router, user
+}
+
+// Cleanup function to reset MockUser after each test
+func cleanup() {
+	auth.MockUser = nil
+}
+
+// createGun creates a gun with a unique weapon type and manufacturer name
+func createGun(t *testing.T, user *models.User, name, weaponType, manufacturer string, price int64) *models.Gun {
+	wt := models.WeaponType{Type: fmt.Sprintf("%s %d", weaponType, testCounter)}
+	require.NoError(t, database.DB.Create(&wt).Error)
+	m := models.Manufacturer{Name: fmt.Sprintf("%s %d", manufacturer, testCounter)}
+	require.NoError(t, database.DB.Where(m).FirstOrCreate(&m).Error)
+
+	gun := models.Gun{
+		Name:           name,
+		WeaponTypeID:   wt.ID,
+		ManufacturerID: m.ID,
+		OwnerID:        user.ID,
+		PurchasePrice:  price,
+		Condition:      models.GunConditionExcellent,
+	}
+	require.NoError(t, models.CreateGun(database.DB, &gun))
+	return &gun
+}
+
+func TestValuationCreateAndDelete(t *testing.T) {
+	// Setup
+	router, user := setupReportTest(t)
+	defer cleanup()
+
+	gun := createGun(t, user, "Appraised Rifle", "Rifle", "Ruger", 50000)
+
+	// Record a valuation
+	form := url.Values{}
+	form.Add("date", "2024-03-01")
+	form.Add("value", "725.50")
+	form.Add("source", "Insurance appraiser")
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/owner/guns/%d/valuations", gun.ID), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+
+	valuations, err := models.FindValuationsByGun(database.DB, gun.ID, user.ID)
+	require.NoError(t, err)
+	require.Len(t, valuations, 1)
+	assert.Equal(t, int64(72550), valuations[0].Value)
+	assert.Equal(t, "Insurance appraiser", valuations[0].Source)
+
+	// A value is required
+	form.Set("value", "")
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/owner/guns/%d/valuations", gun.ID), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(resp, req)
+	assert.NotEqual(t, http.StatusSeeOther, resp.Code)
+
+	// Delete the valuation
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/owner/guns/%d/valuations/%d/delete", gun.ID, valuations[0].ID), nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+
+	valuations, err = models.FindValuationsByGun(database.DB, gun.ID, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, valuations, 0)
+}
+
+func TestBuildValueReport(t *testing.T) {
+	// Setup
+	_, user := setupReportTest(t)
+	defer cleanup()
+
+	rifle := createGun(t, user, "Report Rifle", "Rifle", "Ruger", 100000)
+	pistol := createGun(t, user, "Report Pistol", "Pistol", "Ruger", 50000)
+
+	// The latest valuation is used, not the most recently entered one
+	require.NoError(t, models.CreateValuation(database.DB, &models.Valuation{
+		GunID: rifle.ID, OwnerID: user.ID, Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Value: 150000,
+	}))
+	require.NoError(t, models.CreateValuation(database.DB, &models.Valuation{
+		GunID: rifle.ID, OwnerID: user.ID, Date: time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC), Value: 120000,
+	}))
+
+	report, err := models.BuildValueReport(database.DB, user.ID)
+	require.NoError(t, err)
+
+	// The pistol has never been appraised so its value is its cost
+	assert.Equal(t, int64(150000), report.Total.Cost)
+	assert.Equal(t, int64(200000), report.Total.Value)
+	assert.Equal(t, int64(50000), report.Total.Gain())
+	require.Len(t, report.Guns, 2)
+
+	for _, item := range report.Guns {
+		if item.Gun.ID == pistol.ID {
+			assert.Nil(t, item.LastAppraised)
+		} else {
+			require.NotNil(t, item.LastAppraised)
+			assert.Equal(t, 2024, item.LastAppraised.Year())
+		}
+	}
+
+	// Both guns share a manufacturer but not a weapon type
+	require.Len(t, report.ByManufacturer, 1)
+	assert.Equal(t, 2, report.ByManufacturer[0].Count)
+	require.Len(t, report.ByWeaponType, 2)
+	assert.Equal(t, int64(150000), report.ByWeaponType[0].Value)
+}
+
+func TestValueReportCSV(t *testing.T) {
+	// Setup
+	router, user := setupReportTest(t)
+	defer cleanup()
+
+	createGun(t, user, "CSV Shotgun", "Shotgun", "Benelli", 189999)
+
+	// Export the report
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/owner/reports/value.csv", nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "text/csv")
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "attachment")
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "Name", records[0][0])
+	assert.Equal(t, "CSV Shotgun", records[1][0])
+	assert.Equal(t, "Excellent", records[1][6])
+	assert.Equal(t, "1899.99", records[1][7])
+	assert.Equal(t, "1899.99", records[1][8])
+	assert.Equal(t, "Total", records[2][0])
+}
+
+func TestValueReportCSVEscapesFormulas(t *testing.T) {
+	// Setup
+	router, user := setupReportTest(t)
+	defer cleanup()
+
+	gun := createGun(t, user, `=HYPERLINK("http://example.com","Click")`, "Rifle", "Ruger", 50000)
+	require.NoError(t, database.DB.Model(gun).Update("purchase_source", "@SUM(1+1)").Error)
+	createGun(t, user, "-2+3", "Pistol", "Glock", 40000)
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/owner/reports/value.csv", nil)
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	// Text a spreadsheet would run as a formula is kept as text
+	names := []string{records[1][0], records[2][0]}
+	assert.ElementsMatch(t, []string{`'=HYPERLINK("http://example.com","Click")`, "'-2+3"}, names)
+	for _, record := range records[1:3] {
+		if strings.HasPrefix(record[0], "'=") {
+			assert.Equal(t, "'@SUM(1+1)", record[5])
+		}
+	}
+
+	// Amounts are still plain numbers
+	assert.Equal(t, "900.00", records[3][7])
+}
+
+func TestInsurancePDF(t *testing.T) {
+	// Setup
+	router, user := setupReportTest(t)
+	defer cleanup()
+
+	gun := createGun(t, user, "Scheduled Revolver", "Revolver", "Smith & Wesson", 80000)
+
+	// Request a report for the selected gun
+	form := url.Values{}
+	form.Add("gun_ids", fmt.Sprintf("%d", gun.ID))
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/owner/reports/insurance", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/pdf", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "firearm-schedule-")
+	assert.True(t, strings.HasPrefix(resp.Body.String(), "%PDF-"))
+
+	// Submitting without a selection redirects back to the gun list
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/owner/reports/insurance", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/owner/guns", resp.Header().Get("Location"))
+}
+
+func TestExportsRequirePaidPlan(t *testing.T) {
+	// Setup
+	router, user := setupReportTest(t)
+	defer cleanup()
+
+	gun := createGun(t, user, "Free Tier Pistol", "Pistol", "Glock", 55000)
+
+	// Drop the user back to the free tier
+	user.SubscriptionTier = models.TierFree
+	require.NoError(t, database.DB.Save(user).Error)
+
+	// Both exports redirect to the pricing page
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/owner/reports/value.csv", nil)
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/pricing", resp.Header().Get("Location"))
+
+	form := url.Values{}
+	form.Add("gun_ids", fmt.Sprintf("%d", gun.ID))
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/owner/reports/insurance", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusSeeOther, resp.Code)
+	assert.Equal(t, "/pricing", resp.Header().Get("Location"))
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// RevenueController handles the admin revenue and subscription analytics pages
+type RevenueController struct {
+	DB *gorm.DB
+}
+
+// NewRevenueController creates a new RevenueController
+func NewRevenueController(db *gorm.DB) *RevenueController {
+	return &RevenueController{
+		DB: db,
+	}
+}
+
+// Index displays MRR, churn, conversions and revenue for the selected date range
+func (c *RevenueController) Index(ctx *gin.Context) {
+	report, selected, ok := c.buildReport(ctx)
+	if !ok {
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.Revenue(report, selected, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// CSV exports the revenue report for the selected date range, one row per month
+func (c *RevenueController) CSV(ctx *gin.Context) {
+	report, _, ok := c.buildReport(ctx)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("revenue-%s-to-%s.csv", report.Start.Format("2006-01-02"), report.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Status(http.StatusOK)
+
+	writer := csv.NewWriter(ctx.Writer)
+	header := []string{"Month", "Revenue", "MRR", "ARR", "New Customers", "Churned"}
+	for _, tier := range report.Tiers {
+		header = append(header, tier+" Customers")
+	}
+	writer.Write(header)
+	for _, month := range report.Months {
+		row := []string{
+			month.Month.Format("2006-01"),
+			formatCSVCents(month.Revenue),
+			formatCSVCents(month.MRR),
+			formatCSVCents(month.MRR * 12),
+			strconv.Itoa(month.NewCustomers),
+			strconv.Itoa(month.Churned),
+		}
+		for _, tier := range report.Tiers {
+			row = append(row, strconv.Itoa(month.Tiers[tier]))
+		}
+		writer.Write(row)
+	}
+	total := []string{"Total", formatCSVCents(report.Revenue), formatCSVCents(report.MRR), formatCSVCents(report.ARR), strconv.Itoa(report.Conversions), strconv.Itoa(report.Churned)}
+	writer.Write(append(total, make([]string, len(report.Tiers))...))
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Printf("Failed to write revenue report CSV: %v", err)
+	}
+}
+
+// buildReport builds the revenue report for the range in the query string, handling any errors
+func (c *RevenueController) buildReport(ctx *gin.Context) (*models.RevenueReport, string, bool) {
+	selected, start, end, problem := revenueRange(ctx, time.Now())
+	if problem != "" {
+		flash.SetMessage(ctx, problem, "error")
+		ctx.Redirect(http.StatusSeeOther, "/admin/revenue")
+		return nil, "", false
+	}
+
+	report, err := models.BuildRevenueReport(c.DB, start, end)
+	if err != nil {
+		log.Printf("Error building revenue report: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to build revenue report"})
+		return nil, "", false
+	}
+	return report, selected, true
+}
+
+// revenueRange returns the selected range and its start and end from a preset or custom dates,
+// or a message saying what's wrong with the dates
+func revenueRange(ctx *gin.Context, now time.Time) (string, time.Time, time.Time, string) {
+	// Custom ranges include the whole of the end date
+	if ctx.Query("start") != "" || ctx.Query("end") != "" {
+		start, err := time.ParseInLocation("2006-01-02", ctx.Query("start"), now.Location())
+		if err != nil {
+			return "", time.Time{}, time.Time{}, "Please enter a valid start date"
+		}
+		end, err := time.ParseInLocation("2006-01-02", ctx.Query("end"), now.Location())
+		if err != nil {
+			return "", time.Time{}, time.Time{}, "Please enter a valid end date"
+		}
+		if end.Before(start) {
+			return "", time.Time{}, time.Time{}, "The end date must be after the start date"
+		}
+		return "custom", start, end.AddDate(0, 0, 1), ""
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch selected := ctx.DefaultQuery("range", "12m"); selected {
+	case "30d":
+		return selected, today.AddDate(0, 0, -29), now, ""
+	case "90d":
+		return selected, today.AddDate(0, 0, -89), now, ""
+	case "ytd":
+		return selected, time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location()), now, ""
+	case "12m":
+		return selected, time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -11, 0), now, ""
+	default:
+		return "", time.Time{}, time.Time{}, "Please choose a date range"
+	}
+}
@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	userviews "github.com/hail2skins/the-virtual-armory/cmd/web/views/user"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/twofactor"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// TwoFactorController handles setting up two-factor authentication from the profile page,
+// and the admin pages for requiring and resetting it
+type TwoFactorController struct {
+	DB      *gorm.DB
+	Auth    *auth.Auth // Optional, used to sign users out when an admin resets their two-factor authentication
+	Service *twofactor.Service
+}
+
+// NewTwoFactorController creates a new TwoFactorController
+func NewTwoFactorController(db *gorm.DB, authInstance *auth.Auth) *TwoFactorController {
+	return &TwoFactorController{
+		DB:      db,
+		Auth:    authInstance,
+		Service: twofactor.NewService(db),
+	}
+}
+
+// Show displays whether two-factor authentication is on, with the forms to change it
+func (c *TwoFactorController) Show(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	remaining, err := c.Service.RemainingRecoveryCodes(user)
+	if err != nil {
+		log.Printf("Error counting recovery codes for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load two-factor authentication"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := userviews.TwoFactor(*user, remaining, c.required(user), flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// BeginSetup gives the user a new secret and shows it as a QR code for their authenticator app
+func (c *TwoFactorController) BeginSetup(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if user.TOTPEnabled {
+		flash.SetMessage(ctx, "Two-factor authentication is already on", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+		return
+	}
+
+	if err := c.Service.BeginEnrollment(user); err != nil {
+		log.Printf("Error beginning two-factor enrollment for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to set up two-factor authentication"})
+		return
+	}
+	c.renderSetup(ctx, user, "")
+}
+
+// Enable turns on two-factor authentication once the code from the app checks out, and shows the recovery codes
+func (c *TwoFactorController) Enable(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if user.TOTPEnabled {
+		flash.SetMessage(ctx, "Two-factor authentication is already on", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+		return
+	}
+
+	codes, err := c.Service.Enable(user, strings.TrimSpace(ctx.PostForm("code")), time.Now())
+	if errors.Is(err, twofactor.ErrNotEnrolled) {
+		flash.SetMessage(ctx, "Please start setting up two-factor authentication again", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+		return
+	}
+	if errors.Is(err, twofactor.ErrInvalidCode) {
+		c.renderSetup(ctx, user, "Invalid code. Please try again.")
+		return
+	}
+	if err != nil {
+		log.Printf("Error enabling two-factor authentication for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to turn on two-factor authentication"})
+		return
+	}
+
+	component := userviews.RecoveryCodes(codes, "/profile/two-factor")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Disable turns off two-factor authentication after checking the user's password and a code
+func (c *TwoFactorController) Disable(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if c.required(user) {
+		flash.SetMessage(ctx, "Two-factor authentication is required for admin accounts", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(ctx.PostForm("password"))); err != nil {
+		flash.SetMessage(ctx, "Invalid password", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+		return
+	}
+	if !c.verify(ctx, user) {
+		return
+	}
+
+	if err := c.Service.Disable(user); err != nil {
+		log.Printf("Error disabling two-factor authentication for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to turn off two-factor authentication"})
+		return
+	}
+
+	flash.SetMessage(ctx, "Two-factor authentication has been turned off", "success")
+	ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a code, and shows the new ones
+func (c *TwoFactorController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if !c.verify(ctx, user) {
+		return
+	}
+
+	codes, err := c.Service.RegenerateRecoveryCodes(user)
+	if err != nil {
+		log.Printf("Error regenerating recovery codes for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create recovery codes"})
+		return
+	}
+
+	component := userviews.RecoveryCodes(codes, "/profile/two-factor")
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// AdminIndex shows whether admins must use two-factor authentication, and searches users to reset theirs
+func (c *TwoFactorController) AdminIndex(ctx *gin.Context) {
+	query := strings.TrimSpace(ctx.Query("q"))
+
+	var users []models.User
+	if query != "" {
+		if err := c.DB.Where("email LIKE ?", "%"+query+"%").Order("email").Limit(50).Find(&users).Error; err != nil {
+			log.Printf("Error searching users: %v", err)
+			ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to search users"})
+			return
+		}
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.Security(models.AdminTwoFactorRequired(c.DB), query, users, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// AdminSetRequirement turns the requirement for admins to use two-factor authentication on or off
+func (c *TwoFactorController) AdminSetRequirement(ctx *gin.Context) {
+	adminUser, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	requireTwoFactor := ctx.PostForm("require") == "true"
+
+	// Requiring it without having it would lock the acting admin out of the admin pages
+	if requireTwoFactor && !adminUser.TOTPEnabled {
+		flash.SetMessage(ctx, "Turn on two-factor authentication for your own account before requiring it", "error")
+		ctx.Redirect(http.StatusSeeOther, "/admin/security")
+		return
+	}
+
+	if err := models.SetSetting(c.DB, models.SettingRequireAdminTwoFactor, strconv.FormatBool(requireTwoFactor)); err != nil {
+		log.Printf("Error saving two-factor requirement: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to save setting"})
+		return
+	}
+	log.Printf("Admin %s set the admin two-factor requirement to %t", adminUser.Email, requireTwoFactor)
+
+	if requireTwoFactor {
+		flash.SetMessage(ctx, "Admins must now use two-factor authentication", "success")
+	} else {
+		flash.SetMessage(ctx, "Two-factor authentication is now optional for admins", "success")
+	}
+	ctx.Redirect(http.StatusSeeOther, "/admin/security")
+}
+
+// AdminReset turns off a user's two-factor authentication, for when they've lost their app and recovery codes,
+// and signs them out everywhere. If it's required for them, they set it up again when they next log in.
+func (c *TwoFactorController) AdminReset(ctx *gin.Context) {
+	adminUser, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "User not found"})
+		return
+	}
+	var user models.User
+	if err := c.DB.First(&user, id).Error; err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := c.Service.Disable(&user); err != nil {
+		log.Printf("Error resetting two-factor authentication for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+	if _, err := c.Auth.RevokeSessions(ctx, user.ID, false); err != nil {
+		log.Printf("Error revoking sessions for user %d: %v", user.ID, err)
+	}
+	log.Printf("Admin %s reset two-factor authentication for user %d", adminUser.Email, user.ID)
+
+	flash.SetMessage(ctx, fmt.Sprintf("Two-factor authentication has been reset for %s", user.Email), "success")
+	ctx.Redirect(http.StatusSeeOther, "/admin/security?q="+url.QueryEscape(user.Email))
+}
+
+// required reports whether the user isn't allowed to turn two-factor authentication off
+func (c *TwoFactorController) required(user *models.User) bool {
+	return user.IsAdmin && models.AdminTwoFactorRequired(c.DB)
+}
+
+// verify checks the code in the form, redirecting back to the two-factor page if it's wrong
+func (c *TwoFactorController) verify(ctx *gin.Context, user *models.User) bool {
+	_, err := c.Service.Verify(user, strings.TrimSpace(ctx.PostForm("code")), time.Now())
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, twofactor.ErrInvalidCode) && !errors.Is(err, twofactor.ErrNotEnrolled) {
+		log.Printf("Error verifying two-factor code for user %d: %v", user.ID, err)
+	}
+	flash.SetMessage(ctx, "Invalid two-factor code", "error")
+	ctx.Redirect(http.StatusSeeOther, "/profile/two-factor")
+	return false
+}
+
+// renderSetup shows the QR code and secret for the user's authenticator app
+func (c *TwoFactorController) renderSetup(ctx *gin.Context, user *models.User, errorMsg string) {
+	qrCode, err := c.Service.QRCode(user)
+	if err != nil {
+		log.Printf("Error creating QR code for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to set up two-factor authentication"})
+		return
+	}
+	component := userviews.TwoFactorSetup(qrCode, user.TOTPSecret, "/profile/two-factor/enable", false, errorMsg)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
@@ -1,13 +1,13 @@
 package controllers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"crypto/rand"
-	"encoding/hex"
-
 	"github.com/gin-gonic/gin"
 	userviews "github.com/hail2skins/the-virtual-armory/cmd/web/views/user"
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
@@ -22,6 +22,7 @@ import (
 type UserController struct {
 	DB           *gorm.DB
 	EmailService email.EmailService
+	Auth         *auth.Auth // Optional, used to list and revoke login sessions
 }
 
 // NewUserController creates a new user controller
@@ -54,11 +55,90 @@ func (c *UserController) ShowProfile(ctx *gin.Context) {
 		return
 	}
 
+	// Get the user's login sessions, marking the one making this request
+	sessions, err := c.Auth.ListSessions(user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load sessions"})
+		return
+	}
+	var currentSessionID uint
+	if current, err := c.Auth.CurrentSession(ctx); err == nil {
+		currentSessionID = current.ID
+	}
+
+	// Get flash messages from cookies, such as the result of revoking a session
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+	flash.ClearMessage(ctx)
+
 	// Render the profile page using templ
-	component := userviews.Profile(*user)
+	component := userviews.Profile(*user, sessions, currentSessionID, flashMessage, flashType)
 	component.Render(ctx.Request.Context(), ctx.Writer)
 }
 
+// RevokeSession signs the user out of one of their sessions
+func (c *UserController) RevokeSession(ctx *gin.Context) {
+	// Get the current user
+	user, err := c.getCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		flash.SetMessage(ctx, "Session not found", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile")
+		return
+	}
+
+	// Revoking the session making this request is the same as logging out
+	current, _ := c.Auth.CurrentSession(ctx)
+
+	if err := c.Auth.RevokeSession(user.ID, uint(sessionID)); err != nil {
+		if !errors.Is(err, auth.ErrSessionNotFound) {
+			log.Printf("Failed to revoke session %d for user %d: %v", sessionID, user.ID, err)
+		}
+		flash.SetMessage(ctx, "Session not found", "error")
+		ctx.Redirect(http.StatusSeeOther, "/profile")
+		return
+	}
+
+	if current != nil && current.ID == uint(sessionID) {
+		auth.ClearLoginCookies(ctx)
+		flash.SetMessage(ctx, "You have been signed out", "success")
+		ctx.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	flash.SetMessage(ctx, "The session has been signed out", "success")
+	ctx.Redirect(http.StatusSeeOther, "/profile")
+}
+
+// SignOutEverywhere signs the user out of every session, including this one, and forgets remembered logins
+func (c *UserController) SignOutEverywhere(ctx *gin.Context) {
+	// Get the current user
+	user, err := c.getCurrentUser(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	if err := c.DB.Model(user).Update("remember_token", "").Error; err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to sign out everywhere"})
+		return
+	}
+	if _, err := c.Auth.RevokeSessions(ctx, user.ID, false); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to sign out everywhere"})
+		return
+	}
+
+	auth.ClearLoginCookies(ctx)
+	flash.SetMessage(ctx, "You have been signed out everywhere", "success")
+	ctx.Redirect(http.StatusSeeOther, "/login")
+}
+
 // EditProfile displays the form to edit the user's profile
 func (c *UserController) EditProfile(ctx *gin.Context) {
 	// Get the current user
@@ -112,23 +192,17 @@ func (c *UserController) UpdateProfile(ctx *gin.Context) {
 	// If the email has changed, generate a confirmation token and set the user as unconfirmed
 	if emailChanged {
 		// Generate confirmation token
-		bytes := make([]byte, 32)
-		if _, err := rand.Read(bytes); err != nil {
+		token, err := auth.IssueConfirmToken(user, time.Now())
+		if err != nil {
 			ctx.HTML(http.StatusInternalServerError, "user/edit_profile.html", gin.H{
 				"User":  user,
 				"Error": "Failed to update profile: " + err.Error(),
 			})
 			return
 		}
-		token := hex.EncodeToString(bytes)
-
-		// Set token expiry (24 hours from now)
-		tokenExpiry := time.Now().Add(24 * time.Hour)
 
-		// Update the user with the new email, token, and confirmation status
+		// Update the user with the new email and confirmation status
 		user.Email = email
-		user.ConfirmToken = token
-		user.ConfirmTokenExpiry = tokenExpiry
 		user.Confirmed = false
 
 		// Save the user
@@ -154,9 +228,11 @@ func (c *UserController) UpdateProfile(ctx *gin.Context) {
 			// Set a cookie with the email for the verification pending page
 			ctx.SetCookie("pending_email", email, 3600, "/", "", false, true)
 
-			// Log the user out since their email has changed and needs verification
-			ctx.SetCookie("is_logged_in", "", -1, "/", "", false, true)
-			ctx.SetCookie("user_email", "", -1, "/", "", false, true)
+			// Log the user out everywhere since their email has changed and needs verification
+			if _, err := c.Auth.RevokeSessions(ctx, user.ID, false); err != nil {
+				log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+			}
+			auth.ClearLoginCookies(ctx)
 
 			// Redirect to the verification pending page
 			ctx.Redirect(http.StatusFound, "/verification-pending")
@@ -195,8 +271,27 @@ func (c *UserController) ShowSubscription(ctx *gin.Context) {
 		return
 	}
 
+	// Get the plans the user could move to
+	plans, err := models.FindActivePlans(c.DB)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load plans"})
+		return
+	}
+
+	// Get the user's past plan changes
+	history, err := models.FindSubscriptionChangesByUser(c.DB, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to load subscription history"})
+		return
+	}
+
+	// Get flash messages from cookies, such as the result of a plan change
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+	flash.ClearMessage(ctx)
+
 	// Render the subscription page using templ
-	component := userviews.Subscription(*user)
+	component := userviews.Subscription(*user, plans, history, flashMessage, flashType)
 	component.Render(ctx.Request.Context(), ctx.Writer)
 }
 
@@ -271,9 +366,11 @@ func (c *UserController) DeleteAccount(ctx *gin.Context) {
 		return
 	}
 
-	// Clear the session cookies
-	ctx.SetCookie("is_logged_in", "", -1, "/", "", false, true)
-	ctx.SetCookie("user_email", "", -1, "/", "", false, true)
+	// Sign the user out everywhere
+	if _, err := c.Auth.RevokeSessions(ctx, user.ID, false); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+	}
+	auth.ClearLoginCookies(ctx)
 
 	// Set a success message
 	flash.SetMessage(ctx, "Sorry to see you go. Your account has been deleted.", "success")
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
@@ -45,6 +46,36 @@ func (m *UserControllerMockEmailService) SendContactFormEmail(name, email, subje
 	return args.Error(0)
 }
 
+// SendMaintenanceReminderEmail mocks the SendMaintenanceReminderEmail method
+func (m *UserControllerMockEmailService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	args := m.Called(email, overdue)
+	return args.Error(0)
+}
+
+// SendDataExportEmail mocks the SendDataExportEmail method
+func (m *UserControllerMockEmailService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	args := m.Called(email, token, expiresAt)
+	return args.Error(0)
+}
+
+// SendPaymentFailedEmail mocks the SendPaymentFailedEmail method
+func (m *UserControllerMockEmailService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	args := m.Called(email, reminder, graceEndsAt)
+	return args.Error(0)
+}
+
+// SendDataExportFailedEmail mocks the SendDataExportFailedEmail method
+func (m *UserControllerMockEmailService) SendDataExportFailedEmail(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+// SendReceiptEmail mocks the SendReceiptEmail method
+func (m *UserControllerMockEmailService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	args := m.Called(email, payment, pdf)
+	return args.Error(0)
+}
+
 // MockUserController extends UserController with a mock getCurrentUser method
 type MockUserController struct {
 	*UserController
@@ -0,0 +1,143 @@
+package user_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/passkey"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const passkeyOrigin = "http://localhost:8080"
+
+// setupPasskeyRouter sets up a test router with the passkey routes behind RequireAuth
+func setupPasskeyRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.HTMLRender = &mockHTMLRender{}
+
+	service, err := passkey.NewService(testutils.TestDB, passkeyOrigin)
+	require.NoError(t, err)
+	passkeyController := controllers.NewPasskeyController(testutils.TestDB, service)
+
+	protected := router.Group("/profile/passkeys", testAuth.RequireAuth())
+	protected.GET("", passkeyController.Index)
+	protected.POST("/register/begin", passkeyController.BeginRegistration)
+	protected.POST("/register/finish", passkeyController.FinishRegistration)
+	protected.POST("/:id/rename", passkeyController.Rename)
+	protected.POST("/:id/delete", passkeyController.Remove)
+
+	return router
+}
+
+// postJSONAs sends a JSON body as the signed in user, with extra cookies
+func postJSONAs(t *testing.T, router *gin.Engine, user models.User, path string, body []byte, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	signIn(t, req, user)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestPasskeyRegistration tests adding a passkey from the profile with a software authenticator
+func TestPasskeyRegistration(t *testing.T) {
+	db, user := setupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	router := setupPasskeyRouter(t)
+
+	authenticator, err := testutils.NewSoftwareAuthenticator(passkeyOrigin)
+	require.NoError(t, err)
+
+	w := postJSONAs(t, router, user, "/profile/passkeys/register/begin", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(passkey.UserHandle(user.ID)), options.PublicKey.User.ID)
+	cookies := w.Result().Cookies()
+
+	body, err := authenticator.Register(options.PublicKey.Challenge, passkey.UserHandle(user.ID))
+	require.NoError(t, err)
+
+	// The answer only counts for the browser that asked
+	w = postJSONAs(t, router, user, "/profile/passkeys/register/finish?name=Laptop", body, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSONAs(t, router, user, "/profile/passkeys/register/finish?name=Laptop", body, cookies)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"redirect": "/profile/passkeys"}`, w.Body.String())
+
+	var credentials []models.PasskeyCredential
+	require.NoError(t, db.Where("user_id = ?", user.ID).Find(&credentials).Error)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, "Laptop", credentials[0].Name)
+	assert.Equal(t, authenticator.CredentialID, credentials[0].CredentialID)
+
+	// The same authenticator is excluded from being registered again
+	w = postJSONAs(t, router, user, "/profile/passkeys/register/begin", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var again struct {
+		PublicKey struct {
+			ExcludeCredentials []struct {
+				ID string `json:"id"`
+			} `json:"excludeCredentials"`
+		} `json:"publicKey"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &again))
+	assert.Len(t, again.PublicKey.ExcludeCredentials, 1)
+}
+
+// TestPasskeyManagement tests renaming and removing passkeys from the profile
+func TestPasskeyManagement(t *testing.T) {
+	db, user := setupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	router := setupPasskeyRouter(t)
+
+	other := models.User{Email: "other@example.com", Password: "x", Confirmed: true}
+	require.NoError(t, db.Create(&other).Error)
+	credential := models.PasskeyCredential{UserID: user.ID, Name: "Phone", CredentialID: []byte("phone"), PublicKey: []byte("key")}
+	require.NoError(t, db.Create(&credential).Error)
+	renamePath := fmt.Sprintf("/profile/passkeys/%d/rename", credential.ID)
+	deletePath := fmt.Sprintf("/profile/passkeys/%d/delete", credential.ID)
+
+	// Another user can't touch the passkey
+	w := postAs(t, router, other, renamePath, url.Values{"name": {"Mine"}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = postAs(t, router, other, deletePath, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The owner can rename it
+	w = postAs(t, router, user, renamePath, url.Values{"name": {"Work Phone"}})
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/profile/passkeys", w.Header().Get("Location"))
+	require.NoError(t, db.First(&credential, credential.ID).Error)
+	assert.Equal(t, "Work Phone", credential.Name)
+
+	// And remove it
+	w = postAs(t, router, user, deletePath, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	var count int64
+	require.NoError(t, db.Model(&models.PasskeyCredential{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Zero(t, count)
+}
@@ -11,11 +11,13 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/render"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/hail2skins/the-virtual-armory/internal/services/email"
 	"github.com/hail2skins/the-virtual-armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -44,6 +46,16 @@ func setupTestDB(t *testing.T) (*gorm.DB, models.User) {
 	return db, user
 }
 
+// testAuth keeps the login sessions the tests sign in with
+var testAuth = auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+
+// signIn signs the request in to a new session for the user
+func signIn(t *testing.T, req *http.Request, user models.User) {
+	cookie, err := testAuth.SessionCookie(&user)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+}
+
 // setupRouter sets up a test router with the user controller
 func setupRouter(db *gorm.DB) (*gin.Engine, *controllers.UserController) {
 	gin.SetMode(gin.TestMode)
@@ -54,20 +66,10 @@ func setupRouter(db *gorm.DB) (*gin.Engine, *controllers.UserController) {
 
 	userController := controllers.NewUserController(db)
 
-	// Add a middleware to set the user in the context for testing
-	router.Use(func(c *gin.Context) {
-		// Get the user email from the cookie
-		email, err := c.Cookie("user_email")
-		if err == nil && email != "" {
-			// Get the user from the database
-			var user models.User
-			if err := db.Where("email = ?", email).First(&user).Error; err == nil {
-				// Set the user in the context
-				c.Set("user", user)
-			}
-		}
-		c.Next()
-	})
+	userController.Auth = testAuth
+
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Set up routes
 	router.GET("/profile", userController.ShowProfile)
@@ -121,9 +123,8 @@ func TestShowProfile(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile", nil)
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -150,9 +151,8 @@ func TestEditProfile(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/edit", nil)
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -183,9 +183,8 @@ func TestUpdateProfile(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/update", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -213,9 +212,8 @@ func TestShowSubscription(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/subscription", nil)
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -241,9 +239,8 @@ func TestShowDeleteAccount(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/delete", nil)
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -275,9 +272,8 @@ func TestDeleteAccount(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/delete", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -351,9 +347,8 @@ func TestUpdateProfileWithEmailChange(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/update", strings.NewReader(form.Encode()))
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -372,8 +367,18 @@ func TestUpdateProfileWithEmailChange(t *testing.T) {
 	db.First(&updatedUser, user.ID)
 	assert.Equal(t, "newemail@example.com", updatedUser.Email)
 	assert.False(t, updatedUser.Confirmed)
-	assert.NotEmpty(t, updatedUser.ConfirmToken)
 	assert.False(t, updatedUser.ConfirmTokenExpiry.IsZero())
+
+	// The emailed token confirms the new address, but isn't stored as it was sent
+	assert.NotContains(t, mockEmailService.SendVerificationEmailToken, updatedUser.ConfirmVerifier)
+	found, err := auth.FindUserByConfirmToken(db, mockEmailService.SendVerificationEmailToken, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	// The user is signed out until they confirm the new address
+	sessions, err := testAuth.ListSessions(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
 }
 
 // setupRouterWithEmailService sets up a router with a mock email service
@@ -386,6 +391,10 @@ func setupRouterWithEmailService(db *gorm.DB, emailService email.EmailService) (
 
 	// Create user controller with email service
 	userController := controllers.NewUserControllerWithEmailService(db, emailService)
+	userController.Auth = testAuth
+
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Set up routes
 	router.GET("/profile", userController.ShowProfile)
@@ -418,9 +427,8 @@ func TestDeleteAccountSuccess(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/delete", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -455,9 +463,8 @@ func TestDeleteAccountInvalidConfirmation(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/delete", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -494,9 +501,8 @@ func TestDeleteAccountInvalidPassword(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/delete", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -533,9 +539,8 @@ func TestDeleteAccountEmptyFields(t *testing.T) {
 	req, _ := http.NewRequest("POST", "/profile/delete", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/config"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
@@ -68,8 +67,8 @@ func TestRegisterWithDeletedEmail(t *testing.T) {
 	// Create a mock email service
 	mockEmailService := &email.MockEmailService{}
 
-	// Create the auth controller with a minimal Auth struct
-	authController := controllers.NewAuthController(&auth.Auth{}, mockEmailService, &config.Config{})
+	// Create the auth controller
+	authController := controllers.NewAuthController(testAuth, mockEmailService, &config.Config{})
 
 	// Register routes
 	router.POST("/register", authController.ProcessRegister)
@@ -121,5 +120,10 @@ func TestRegisterWithDeletedEmail(t *testing.T) {
 		err := db.Unscoped().First(&reactivatedUser, deletedUser.ID).Error
 		assert.NoError(t, err)
 		assert.True(t, reactivatedUser.DeletedAt.Time.IsZero())
+
+		// Verify the user was signed in
+		sessions, err := testAuth.ListSessions(deletedUser.ID)
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 1)
 	})
 }
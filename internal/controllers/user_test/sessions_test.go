@@ -0,0 +1,131 @@
+package user_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// startSession signs the user in to a new session and returns the cookies the browser would keep
+func startSession(t *testing.T, authInstance *auth.Auth, user models.User) []*http.Cookie {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest("GET", "/login", nil)
+	require.NoError(t, authInstance.StartSession(ctx, &user))
+
+	return w.Result().Cookies()
+}
+
+// setupSessionRouter sets up a test router with the session routes behind RequireAuth
+func setupSessionRouter(t *testing.T) (*gin.Engine, *auth.Auth, *gorm.DB, models.User) {
+	db, user := setupTestDB(t)
+	router, userController := setupRouter(db)
+
+	authInstance := auth.NewWithSessionStore(auth.NewMemorySessionStore(), time.Hour)
+	userController.Auth = authInstance
+	protected := router.Group("/", authInstance.RequireAuth())
+	protected.GET("/owner", func(c *gin.Context) { c.String(http.StatusOK, "armory") })
+	protected.POST("/profile/sessions/:id/revoke", userController.RevokeSession)
+	protected.POST("/profile/sessions/revoke-all", userController.SignOutEverywhere)
+
+	return router, authInstance, db, user
+}
+
+// serve performs a request with the browser's cookies
+func serve(router *gin.Engine, method, path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestRevokeSession tests that a user can sign out one of their other sessions
+func TestRevokeSession(t *testing.T) {
+	router, authInstance, db, user := setupSessionRouter(t)
+	defer testutils.CleanupTestDB(db)
+
+	laptop := startSession(t, authInstance, user)
+	phone := startSession(t, authInstance, user)
+	sessions, err := authInstance.ListSessions(user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	// Find the phone's session
+	req := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range phone {
+		req.AddCookie(cookie)
+	}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = req
+	phoneSession, err := authInstance.CurrentSession(ctx)
+	require.NoError(t, err)
+
+	// Revoke it from the laptop
+	w := serve(router, "POST", fmt.Sprintf("/profile/sessions/%d/revoke", phoneSession.ID), laptop)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/profile", w.Header().Get("Location"))
+
+	// The phone is signed out and the laptop isn't
+	w = serve(router, "GET", "/owner", phone)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, serve(router, "GET", "/owner", laptop).Code)
+
+	// Another user's session can't be revoked
+	other := models.User{Email: "other@example.com", Password: "x", Confirmed: true}
+	require.NoError(t, db.Create(&other).Error)
+	otherCookies := startSession(t, authInstance, other)
+	otherSessions, err := authInstance.ListSessions(other.ID)
+	require.NoError(t, err)
+	require.Len(t, otherSessions, 1)
+	w = serve(router, "POST", fmt.Sprintf("/profile/sessions/%d/revoke", otherSessions[0].ID), laptop)
+	assert.Equal(t, "/profile", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, serve(router, "GET", "/owner", otherCookies).Code)
+
+	// Revoking the laptop's own session signs it out
+	sessions, err = authInstance.ListSessions(user.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	w = serve(router, "POST", fmt.Sprintf("/profile/sessions/%d/revoke", sessions[0].ID), laptop)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	sessions, err = authInstance.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+// TestSignOutEverywhere tests that signing out everywhere ends every session and forgets remembered logins
+func TestSignOutEverywhere(t *testing.T) {
+	router, authInstance, db, user := setupSessionRouter(t)
+	defer testutils.CleanupTestDB(db)
+
+	require.NoError(t, db.Model(&user).Update("remember_token", "remembered").Error)
+
+	laptop := startSession(t, authInstance, user)
+	phone := startSession(t, authInstance, user)
+
+	w := serve(router, "POST", "/profile/sessions/revoke-all", laptop)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	sessions, err := authInstance.ListSessions(user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+	assert.Equal(t, http.StatusFound, serve(router, "GET", "/owner", phone).Code)
+
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.Empty(t, updated.RememberToken)
+}
@@ -0,0 +1,154 @@
+package user_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/twofactor"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTwoFactorRouter sets up a test router with the two-factor routes behind RequireAuth and RequireAdmin
+func setupTwoFactorRouter(t *testing.T) (*gin.Engine, *auth.Auth) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.HTMLRender = &mockHTMLRender{}
+
+	twoFactorController := controllers.NewTwoFactorController(testutils.TestDB, testAuth)
+
+	protected := router.Group("/", testAuth.RequireAuth())
+	protected.GET("/profile/two-factor", twoFactorController.Show)
+	protected.POST("/profile/two-factor/setup", twoFactorController.BeginSetup)
+	protected.POST("/profile/two-factor/enable", twoFactorController.Enable)
+	protected.POST("/profile/two-factor/disable", twoFactorController.Disable)
+	protected.POST("/profile/two-factor/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+
+	adminRoutes := router.Group("/admin", testAuth.RequireAuth(), testAuth.RequireAdmin())
+	adminRoutes.GET("/security", twoFactorController.AdminIndex)
+	adminRoutes.POST("/security/two-factor", twoFactorController.AdminSetRequirement)
+	adminRoutes.POST("/users/:id/two-factor/reset", twoFactorController.AdminReset)
+
+	return router, testAuth
+}
+
+// postAs submits a form as the signed in user
+func postAs(t *testing.T, router *gin.Engine, user models.User, path string, form url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	signIn(t, req, user)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// reload reads the user back from the database
+func reload(t *testing.T, user models.User) models.User {
+	var reloaded models.User
+	require.NoError(t, testutils.TestDB.First(&reloaded, user.ID).Error)
+	return reloaded
+}
+
+// TestTwoFactorSetup tests turning two-factor authentication on and off from the profile
+func TestTwoFactorSetup(t *testing.T) {
+	db, user := setupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	router, _ := setupTwoFactorRouter(t)
+
+	// Setting up gives the user a secret without turning two-factor on
+	w := postAs(t, router, user, "/profile/two-factor/setup", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	user = reload(t, user)
+	require.NotEmpty(t, user.TOTPSecret)
+	assert.False(t, user.TOTPEnabled)
+
+	// A wrong code leaves it off, the app's code turns it on
+	postAs(t, router, user, "/profile/two-factor/enable", url.Values{"code": {"000000"}})
+	assert.False(t, reload(t, user).TOTPEnabled)
+	code, err := totp.GenerateCode(user.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	w = postAs(t, router, user, "/profile/two-factor/enable", url.Values{"code": {code}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	user = reload(t, user)
+	assert.True(t, user.TOTPEnabled)
+	remaining, err := models.CountUnusedRecoveryCodes(db, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(twofactor.RecoveryCodeCount), remaining)
+
+	// Turning it off needs the password as well as a code, which can't be the one just used
+	code, err = totp.GenerateCode(user.TOTPSecret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	w = postAs(t, router, user, "/profile/two-factor/disable", url.Values{"password": {"wrong"}, "code": {code}})
+	assert.Equal(t, "/profile/two-factor", w.Header().Get("Location"))
+	assert.True(t, reload(t, user).TOTPEnabled)
+	postAs(t, router, user, "/profile/two-factor/disable", url.Values{"password": {"password123"}, "code": {code}})
+	user = reload(t, user)
+	assert.False(t, user.TOTPEnabled)
+	assert.Empty(t, user.TOTPSecret)
+}
+
+// TestAdminTwoFactor tests requiring two-factor authentication for admins and resetting it for a user
+func TestAdminTwoFactor(t *testing.T) {
+	db, member := setupTestDB(t)
+	defer testutils.CleanupTestDB(db)
+	router, authInstance := setupTwoFactorRouter(t)
+	service := twofactor.NewService(db)
+
+	adminUser := models.User{Email: "admin@example.com", Password: "x", IsAdmin: true, Confirmed: true}
+	require.NoError(t, db.Create(&adminUser).Error)
+
+	// An admin can't require two-factor authentication without using it
+	postAs(t, router, adminUser, "/admin/security/two-factor", url.Values{"require": {"true"}})
+	assert.False(t, models.AdminTwoFactorRequired(db))
+
+	require.NoError(t, service.BeginEnrollment(&adminUser))
+	code, err := totp.GenerateCode(adminUser.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	_, err = service.Enable(&adminUser, code, time.Now())
+	require.NoError(t, err)
+	w := postAs(t, router, adminUser, "/admin/security/two-factor", url.Values{"require": {"true"}})
+	assert.Equal(t, "/admin/security", w.Header().Get("Location"))
+	assert.True(t, models.AdminTwoFactorRequired(db))
+
+	// Once it's required, admins without it are sent to set it up and admins with it can't turn it off
+	otherAdmin := models.User{Email: "other-admin@example.com", Password: "x", IsAdmin: true, Confirmed: true}
+	require.NoError(t, db.Create(&otherAdmin).Error)
+	w = postAs(t, router, otherAdmin, "/admin/security/two-factor", url.Values{"require": {"false"}})
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/profile/two-factor", w.Header().Get("Location"))
+	assert.True(t, models.AdminTwoFactorRequired(db))
+	postAs(t, router, adminUser, "/profile/two-factor/disable", url.Values{"password": {"x"}, "code": {code}})
+	assert.True(t, reload(t, adminUser).TOTPEnabled)
+
+	// Resetting a user's two-factor authentication turns it off and signs them out everywhere
+	require.NoError(t, service.BeginEnrollment(&member))
+	code, err = totp.GenerateCode(member.TOTPSecret, time.Now())
+	require.NoError(t, err)
+	_, err = service.Enable(&member, code, time.Now())
+	require.NoError(t, err)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/login", nil)
+	require.NoError(t, authInstance.StartSession(ctx, &member))
+
+	w = postAs(t, router, adminUser, fmt.Sprintf("/admin/users/%d/two-factor/reset", member.ID), nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	member = reload(t, member)
+	assert.False(t, member.TOTPEnabled)
+	remaining, err := models.CountUnusedRecoveryCodes(db, member.ID)
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+	sessions, err := authInstance.ListSessions(member.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
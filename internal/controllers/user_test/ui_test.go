@@ -46,13 +46,8 @@ func TestProfilePageUI(t *testing.T) {
 	// Create the user controller
 	userController := controllers.NewUserController(db)
 
-	// Set up auth middleware and cookies
-	router.Use(func(c *gin.Context) {
-		// Set cookies to simulate authentication
-		c.Request.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-		c.Request.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
-		c.Next()
-	})
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Register routes
 	router.GET("/profile", userController.ShowProfile)
@@ -61,9 +56,8 @@ func TestProfilePageUI(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile", nil)
 
-	// Add cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -93,13 +87,8 @@ func TestDeleteAccountPageUI(t *testing.T) {
 	// Create the user controller
 	userController := controllers.NewUserController(db)
 
-	// Set up auth middleware and cookies
-	router.Use(func(c *gin.Context) {
-		// Set cookies to simulate authentication
-		c.Request.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-		c.Request.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
-		c.Next()
-	})
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Register routes
 	router.GET("/profile/delete", userController.ShowDeleteAccount)
@@ -108,9 +97,8 @@ func TestDeleteAccountPageUI(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/delete", nil)
 
-	// Add cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -140,13 +128,8 @@ func TestEditProfilePageUI(t *testing.T) {
 	// Create the user controller
 	userController := controllers.NewUserController(db)
 
-	// Set up auth middleware and cookies
-	router.Use(func(c *gin.Context) {
-		// Set cookies to simulate authentication
-		c.Request.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-		c.Request.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
-		c.Next()
-	})
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Register routes
 	router.GET("/profile/edit", userController.EditProfile)
@@ -155,9 +138,8 @@ func TestEditProfilePageUI(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/edit", nil)
 
-	// Add cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -245,13 +227,8 @@ func TestAuthProfilePageUI(t *testing.T) {
 	// Create the auth controller
 	authController := controllers.NewAuthController(&auth.Auth{}, nil, &config.Config{})
 
-	// Set up auth middleware and cookies
-	router.Use(func(c *gin.Context) {
-		// Set cookies to simulate authentication
-		c.Request.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-		c.Request.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
-		c.Next()
-	})
+	// Find the user signed in to the request's session
+	router.Use(testAuth.LoadUser())
 
 	// Register routes
 	router.GET("/owner", authController.Profile)
@@ -260,9 +237,8 @@ func TestAuthProfilePageUI(t *testing.T) {
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/owner", nil)
 
-	// Add cookies to the request
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: user.Email})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Serve the request
 	router.ServeHTTP(w, req)
@@ -286,20 +262,20 @@ func TestAuthProfilePageUI(t *testing.T) {
 // TestEditProfilePageEmailWarning tests that the edit profile page contains a warning about email verification
 func TestEditProfilePageEmailWarning(t *testing.T) {
 	// Set up test database with a user
-	db, _ := setupTestDB(t)
+	db, user := setupTestDB(t)
 	defer testutils.CleanupTestDB(db)
 
 	// Create a test request
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/profile/edit", nil)
 
-	// Set cookies to simulate authentication
-	req.AddCookie(&http.Cookie{Name: "is_logged_in", Value: "true"})
-	req.AddCookie(&http.Cookie{Name: "user_email", Value: "test@example.com"})
+	// Sign the user in
+	signIn(t, req, user)
 
 	// Set up router
 	router := gin.Default()
 	router.HTMLRender = &mockHTMLRender{}
+	router.Use(testAuth.LoadUser())
 	userController := controllers.NewUserController(db)
 	router.GET("/profile/edit", userController.EditProfile)
 
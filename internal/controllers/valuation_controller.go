@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/valuation"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// ValuationController handles requests related to gun valuations
+type ValuationController struct {
+	DB *gorm.DB
+}
+
+// NewValuationController creates a new ValuationController
+func NewValuationController(db *gorm.DB) *ValuationController {
+	return &ValuationController{
+		DB: db,
+	}
+}
+
+// Index displays the valuation history for a gun
+func (c *ValuationController) Index(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Get the valuations for the gun
+	valuations, err := models.FindValuationsByGun(c.DB, gunItem.ID, user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get valuations"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	// Render the index template
+	component := valuation.Index(*gunItem, valuations, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create records a new valuation for a gun
+func (c *ValuationController) Create(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Parse the date
+	date, err := time.Parse("2006-01-02", ctx.PostForm("date"))
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid date format"})
+		return
+	}
+
+	// Parse the value, which is required
+	valueStr := ctx.PostForm("value")
+	value, err := parseCents(valueStr)
+	if err != nil || strings.TrimSpace(valueStr) == "" {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid value"})
+		return
+	}
+
+	record := models.Valuation{
+		GunID:   gunItem.ID,
+		OwnerID: user.ID,
+		Date:    date,
+		Value:   value,
+		Source:  strings.TrimSpace(ctx.PostForm("source")),
+		Notes:   strings.TrimSpace(ctx.PostForm("notes")),
+	}
+
+	// Save the valuation to the database
+	if err := models.CreateValuation(c.DB, &record); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to create valuation"})
+		return
+	}
+
+	// Redirect to the valuation history
+	flash.SetMessage(ctx, "Valuation recorded successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d/valuations", gunItem.ID))
+}
+
+// Delete deletes a valuation
+func (c *ValuationController) Delete(ctx *gin.Context) {
+	// Get the current user and gun
+	user, gunItem, ok := c.getGun(ctx)
+	if !ok {
+		return
+	}
+
+	// Get the valuation ID from the URL
+	valuationID, err := strconv.ParseUint(ctx.Param("valuationID"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid valuation ID"})
+		return
+	}
+
+	// Delete the valuation
+	if err := models.DeleteValuation(c.DB, uint(valuationID), user.ID); err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to delete valuation"})
+		return
+	}
+
+	// Redirect to the valuation history
+	flash.SetMessage(ctx, "Valuation deleted", "success")
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/owner/guns/%d/valuations", gunItem.ID))
+}
+
+// getGun loads the current user and the gun from the URL, writing an error response if either fails
+func (c *ValuationController) getGun(ctx *gin.Context) (*models.User, *models.Gun, bool) {
+	// Get the gun ID from the URL
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "error.html", gin.H{"error": "Invalid gun ID"})
+		return nil, nil, false
+	}
+
+	// Get the current user
+	user, err := auth.GetCurrentUser(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to get current user"})
+		return nil, nil, false
+	}
+
+	// Get the gun
+	gunItem, err := models.FindGunByID(c.DB, uint(id), user.ID)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Gun not found"})
+		return nil, nil, false
+	}
+
+	return user, gunItem, true
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/cmd/web/views/admin"
+	"github.com/hail2skins/the-virtual-armory/internal/flash"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// webhookEventPageSize is how many events the admin log shows
+const webhookEventPageSize = 100
+
+// WebhookEventController handles the admin pages for the Stripe webhook event log
+type WebhookEventController struct {
+	DB       *gorm.DB
+	Payments *PaymentController
+}
+
+// NewWebhookEventController creates a new WebhookEventController
+func NewWebhookEventController(db *gorm.DB, provider billing.PaymentProvider) *WebhookEventController {
+	return &WebhookEventController{
+		DB:       db,
+		Payments: NewPaymentController(db, provider),
+	}
+}
+
+// Index lists recent webhook events, optionally filtered by status
+func (c *WebhookEventController) Index(ctx *gin.Context) {
+	status := ctx.Query("status")
+
+	events, err := models.FindWebhookEvents(c.DB, status, webhookEventPageSize)
+	if err != nil {
+		log.Printf("Error fetching webhook events: %v", err)
+		ctx.HTML(http.StatusInternalServerError, "error.html", gin.H{"error": "Failed to fetch webhook events"})
+		return
+	}
+
+	// Get flash messages from cookies
+	flashMessage, _ := ctx.Cookie("flash_message")
+	flashType, _ := ctx.Cookie("flash_type")
+
+	// Clear flash cookies if they exist
+	flash.ClearMessage(ctx)
+
+	component := admin.WebhookEventIndex(events, status, flashMessage, flashType)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Show displays a webhook event with its payload
+func (c *WebhookEventController) Show(ctx *gin.Context) {
+	event, err := c.findEvent(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Webhook event not found"})
+		return
+	}
+
+	component := admin.WebhookEventShow(*event)
+	component.Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Replay processes a failed webhook event again
+func (c *WebhookEventController) Replay(ctx *gin.Context) {
+	event, err := c.findEvent(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.html", gin.H{"error": "Webhook event not found"})
+		return
+	}
+
+	redirectURL := "/admin/webhook-events/" + strconv.FormatUint(uint64(event.ID), 10)
+
+	// Events that succeeded, or are still being processed, are never processed twice
+	if !event.CanReplay() {
+		flash.SetMessage(ctx, "Only failed or stuck events can be replayed", "error")
+		ctx.Redirect(http.StatusSeeOther, redirectURL)
+		return
+	}
+
+	if err := c.Payments.ProcessWebhookEvent(event); err != nil {
+		log.Printf("Error replaying webhook event %s: %v", event.EventID, err)
+		flash.SetMessage(ctx, "Replay failed: "+err.Error(), "error")
+		ctx.Redirect(http.StatusSeeOther, redirectURL)
+		return
+	}
+
+	flash.SetMessage(ctx, "Event replayed successfully", "success")
+	ctx.Redirect(http.StatusSeeOther, redirectURL)
+}
+
+// findEvent loads the webhook event named in the URL
+func (c *WebhookEventController) findEvent(ctx *gin.Context) (*models.WebhookEvent, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return models.FindWebhookEventByID(c.DB, uint(id))
+}
@@ -58,6 +58,24 @@ func InitGORM() (*gorm.DB, error) {
 		&models.WeaponType{},
 		&models.Gun{},
 		&models.Payment{},
+		&models.Ammo{},
+		&models.RangeSession{},
+		&models.RangeSessionItem{},
+		&models.MaintenanceRecord{},
+		&models.Attachment{},
+		&models.Valuation{},
+		&models.DataExport{},
+		&models.Plan{},
+		&models.WebhookEvent{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.BillingAdjustment{},
+		&models.SubscriptionChange{},
+		&models.LoginSession{},
+		&models.RecoveryCode{},
+		&models.Setting{},
+		&models.PasskeyCredential{},
+		&models.PasskeyChallenge{},
 	)
 	if err != nil {
 		log.Printf("Failed to migrate database: %v", err)
@@ -15,6 +15,24 @@ func RunMigrations(db *gorm.DB) error {
 		&models.Manufacturer{},
 		&models.Gun{},
 		&models.Payment{},
+		&models.Ammo{},
+		&models.RangeSession{},
+		&models.RangeSessionItem{},
+		&models.MaintenanceRecord{},
+		&models.Attachment{},
+		&models.Valuation{},
+		&models.DataExport{},
+		&models.Plan{},
+		&models.WebhookEvent{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.BillingAdjustment{},
+		&models.SubscriptionChange{},
+		&models.LoginSession{},
+		&models.RecoveryCode{},
+		&models.Setting{},
+		&models.PasskeyCredential{},
+		&models.PasskeyChallenge{},
 	); err != nil {
 		return err
 	}
@@ -33,5 +51,15 @@ func RunMigrations(db *gorm.DB) error {
 		}
 	}
 
+	// Drop the plain text tokens replaced by selectors and hashed verifiers.
+	// Links sent before the change stop working, and new ones can be requested.
+	for _, column := range []string{"confirm_token", "recover_token"} {
+		if db.Migrator().HasColumn(&models.User{}, column) {
+			if err := db.Migrator().DropColumn(&models.User{}, column); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,95 @@
+package seed
+
+import (
+	"log"
+	"os"
+
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// SeedPlans seeds the database with the subscription plans, leaving plans edited by an admin untouched
+func SeedPlans(db *gorm.DB) {
+	// Define the plans sold on the pricing page
+	plans := []models.Plan{
+		{
+			Tier:        models.TierFree,
+			Name:        "Free",
+			Description: "Basic access",
+			Features:    "Limited range days*\nNo maintenance records*",
+			SortOrder:   0,
+		},
+		{
+			Tier:            models.TierMonthly,
+			Name:            "Liking It",
+			Description:     "Flexible option",
+			Features:        "Unlimited range days*\nUnlimited maintenance records*\nCancel anytime",
+			Price:           500, // $5.00
+			Interval:        models.PlanIntervalMonth,
+			StripeProductID: os.Getenv("STRIPE_PRICE_MONTHLY"),
+			SortOrder:       1,
+		},
+		{
+			Tier:            models.TierYearly,
+			Name:            "Loving It",
+			Description:     "Best value",
+			Features:        "Unlimited range days*\nUnlimited maintenance records*\nCancel anytime",
+			Badge:           "Popular",
+			Price:           3000, // $30.00
+			Interval:        models.PlanIntervalYear,
+			StripeProductID: os.Getenv("STRIPE_PRICE_YEARLY"),
+			SortOrder:       2,
+		},
+		{
+			Tier:            models.TierLifetime,
+			Name:            "Supporter",
+			Description:     "Forever access",
+			Features:        "Unlimited range days*\nUnlimited maintenance records*\nFirst to access new features*",
+			Price:           10000, // $100.00
+			StripeProductID: os.Getenv("STRIPE_PRICE_LIFETIME"),
+			SortOrder:       3,
+		},
+		{
+			Tier:        models.TierPremiumLifetime,
+			Name:        "Big Baller",
+			Description: "You shouldn't have, but thanks.",
+			Features: "Everything the site has.\n" +
+				"Christmas cards. Seriously, send your address and they are yours.\n" +
+				"If it grows and makers provide goodies, they go to you first. if we ever get spiff, you get spiff.\n" +
+				"We do not recommend anyone buy this package. But, this investment would help us grow and you get any benefit we can provide.",
+			Price:           100000, // $1000.00
+			StripeProductID: os.Getenv("STRIPE_PRICE_PREMIUM"),
+			SortOrder:       4,
+		},
+	}
+
+	// Loop through each plan
+	for _, plan := range plans {
+		var count int64
+		// Check if the record exists (by Tier)
+		if err := db.Model(&models.Plan{}).Where("tier = ?", plan.Tier).Count(&count).Error; err != nil {
+			log.Printf("Error checking plan %s: %v", plan.Tier, err)
+			continue
+		}
+		if count > 0 {
+			continue
+		}
+
+		// Start from the built-in limits for the tier
+		limits := entitlements.ForTier(plan.Tier)
+		plan.Currency = "usd"
+		plan.Active = true
+		plan.MaxGuns = limits.MaxGuns
+		plan.MaxAmmo = limits.MaxAmmo
+		plan.MaxAttachmentBytes = limits.MaxAttachmentBytes
+		plan.Exports = limits.Exports
+		plan.APIAccess = limits.APIAccess
+
+		if err := db.Create(&plan).Error; err != nil {
+			log.Printf("Error seeding plan %s: %v", plan.Tier, err)
+		} else {
+			log.Printf("Seeded plan: %s", plan.Tier)
+		}
+	}
+}
@@ -22,6 +22,10 @@ func RunSeeds(db *gorm.DB) {
 	log.Println("Seeding weapon types...")
 	SeedWeaponTypes(db)
 
+	// Run subscription plan seeds
+	log.Println("Seeding plans...")
+	SeedPlans(db)
+
 	// Add more seed functions here as needed
 
 	log.Println("Database seeding completed")
@@ -0,0 +1,196 @@
+package entitlements
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// Unlimited marks a count limit with no maximum
+const Unlimited = -1
+
+// ErrLimitReached is matched by every LimitError with errors.Is
+var ErrLimitReached = errors.New("plan limit reached")
+
+// Limits describes what a subscription tier includes
+type Limits struct {
+	Plan               string // Display name of the tier
+	MaxGuns            int
+	MaxAmmo            int   // Rows in the ammo inventory
+	MaxAttachmentBytes int64 // Photos, receipts and manuals, including thumbnails
+	Exports            bool  // Collection value spreadsheets and insurance reports
+	APIAccess          bool  // Listed on the plan; not enforced until there is a public API
+}
+
+// defaults maps each subscription tier to its limits until plans are loaded from the database
+var defaults = map[string]Limits{
+	models.TierFree: {
+		Plan:               "Free",
+		MaxGuns:            2,
+		MaxAmmo:            4,
+		MaxAttachmentBytes: 25 << 20, // 25 MB
+	},
+	models.TierMonthly: {
+		Plan:               "Monthly",
+		MaxGuns:            Unlimited,
+		MaxAmmo:            Unlimited,
+		MaxAttachmentBytes: 1 << 30, // 1 GB
+		Exports:            true,
+	},
+	models.TierYearly: {
+		Plan:               "Yearly",
+		MaxGuns:            Unlimited,
+		MaxAmmo:            Unlimited,
+		MaxAttachmentBytes: 1 << 30, // 1 GB
+		Exports:            true,
+	},
+	models.TierLifetime: {
+		Plan:               "Lifetime",
+		MaxGuns:            Unlimited,
+		MaxAmmo:            Unlimited,
+		MaxAttachmentBytes: 2 << 30, // 2 GB
+		Exports:            true,
+	},
+	models.TierPremiumLifetime: {
+		Plan:               "Premium Lifetime",
+		MaxGuns:            Unlimited,
+		MaxAmmo:            Unlimited,
+		MaxAttachmentBytes: 10 << 30, // 10 GB
+		Exports:            true,
+		APIAccess:          true,
+	},
+}
+
+var (
+	mu    sync.RWMutex
+	tiers = defaults
+)
+
+// Load replaces the limits for each tier with those configured on the plans table
+func Load(db *gorm.DB) error {
+	plans, err := models.FindPlans(db)
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]Limits, len(defaults))
+	for tier, limits := range defaults {
+		loaded[tier] = limits
+	}
+	for _, plan := range plans {
+		loaded[plan.Tier] = FromPlan(plan)
+	}
+
+	mu.Lock()
+	tiers = loaded
+	mu.Unlock()
+	return nil
+}
+
+// FromPlan returns the limits configured on a plan
+func FromPlan(plan models.Plan) Limits {
+	return Limits{
+		Plan:               plan.Name,
+		MaxGuns:            plan.MaxGuns,
+		MaxAmmo:            plan.MaxAmmo,
+		MaxAttachmentBytes: plan.MaxAttachmentBytes,
+		Exports:            plan.Exports,
+		APIAccess:          plan.APIAccess,
+	}
+}
+
+// ForTier returns the limits for a tier, treating unknown tiers as free
+func ForTier(tier string) Limits {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if limits, ok := tiers[tier]; ok {
+		return limits
+	}
+	return tiers[models.TierFree]
+}
+
+// Tier returns the tier whose limits apply to a user, which is the free tier once a subscription lapses
+func Tier(user *models.User) string {
+	if !user.HasActiveSubscription() {
+		return models.TierFree
+	}
+	return user.SubscriptionTier
+}
+
+// For returns the limits that apply to a user
+func For(user *models.User) Limits {
+	return ForTier(Tier(user))
+}
+
+// LimitError is returned when an action isn't included in a user's plan
+type LimitError struct {
+	Plan      string
+	Feature   string // What was limited, e.g. "guns"
+	Allowance string // What the plan includes, e.g. "2 guns", or empty if the feature isn't included at all
+}
+
+// Error returns a message suitable for showing the user, including the upgrade prompt
+func (e *LimitError) Error() string {
+	if e.Allowance == "" {
+		return fmt.Sprintf("The %s plan doesn't include %s. Upgrade your subscription to use them.", e.Plan, e.Feature)
+	}
+	return fmt.Sprintf("The %s plan is limited to %s. Upgrade your subscription to add more %s.", e.Plan, e.Allowance, e.Feature)
+}
+
+// Is reports whether target is ErrLimitReached
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitReached
+}
+
+// CheckGuns returns a LimitError if the user can't add n more guns
+func CheckGuns(db *gorm.DB, user *models.User, n int) error {
+	limits := For(user)
+	return checkCount(db, &models.Gun{}, user, n, limits.MaxGuns, limits.Plan, "guns")
+}
+
+// CheckAmmo returns a LimitError if the user can't add n more ammo inventory rows
+func CheckAmmo(db *gorm.DB, user *models.User, n int) error {
+	limits := For(user)
+	return checkCount(db, &models.Ammo{}, user, n, limits.MaxAmmo, limits.Plan, "ammo entries")
+}
+
+// CheckExports returns a LimitError if the user's plan doesn't include report exports
+func CheckExports(user *models.User) error {
+	limits := For(user)
+	if !limits.Exports {
+		return &LimitError{Plan: limits.Plan, Feature: "report exports"}
+	}
+	return nil
+}
+
+// VisibleGuns trims a gun list to the user's limit, flagging the first gun when some are hidden
+func VisibleGuns(user *models.User, guns []models.Gun) []models.Gun {
+	limit := For(user).MaxGuns
+	if limit == Unlimited || len(guns) <= limit {
+		return guns
+	}
+
+	guns[0].HasMoreGuns = true
+	guns[0].TotalGuns = len(guns)
+	return guns[:limit]
+}
+
+// checkCount compares the owner's current row count plus n against a limit
+func checkCount(db *gorm.DB, model interface{}, user *models.User, n int, limit int, plan, feature string) error {
+	if limit == Unlimited {
+		return nil
+	}
+
+	var count int64
+	if err := db.Model(model).Where("owner_id = ?", user.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if int(count)+n > limit {
+		return &LimitError{Plan: plan, Feature: feature, Allowance: fmt.Sprintf("%d %s", limit, feature)}
+	}
+	return nil
+}
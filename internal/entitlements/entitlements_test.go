@@ -0,0 +1,118 @@
+package entitlements_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFor(t *testing.T) {
+	future := time.Now().AddDate(0, 1, 0)
+	past := time.Now().AddDate(0, -1, 0)
+
+	tests := []struct {
+		name string
+		user models.User
+		want string
+	}{
+		{"free", models.User{SubscriptionTier: models.TierFree}, "Free"},
+		{"active monthly", models.User{SubscriptionTier: models.TierMonthly, SubscriptionExpiresAt: future}, "Monthly"},
+		{"lapsed monthly", models.User{SubscriptionTier: models.TierMonthly, SubscriptionExpiresAt: past}, "Free"},
+		{"lifetime", models.User{SubscriptionTier: models.TierLifetime}, "Lifetime"},
+		{"premium lifetime", models.User{SubscriptionTier: models.TierPremiumLifetime}, "Premium Lifetime"},
+		{"unknown", models.User{SubscriptionTier: "platinum", SubscriptionExpiresAt: future}, "Free"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, entitlements.For(&tt.user).Plan)
+		})
+	}
+
+	assert.True(t, entitlements.ForTier(models.TierPremiumLifetime).APIAccess)
+	assert.False(t, entitlements.ForTier(models.TierLifetime).APIAccess)
+	assert.Greater(t, entitlements.ForTier(models.TierLifetime).MaxAttachmentBytes, entitlements.ForTier(models.TierMonthly).MaxAttachmentBytes)
+}
+
+func TestCheckGunsAndAmmo(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "entitlements@example.com", "password123", false)
+	require.NoError(t, err)
+
+	free := entitlements.ForTier(models.TierFree)
+	for i := 0; i < free.MaxGuns; i++ {
+		require.NoError(t, entitlements.CheckGuns(db, user, 1))
+		require.NoError(t, models.CreateGun(db, &models.Gun{Name: "Entitled Gun", OwnerID: user.ID}))
+	}
+
+	// The free tier is full
+	err = entitlements.CheckGuns(db, user, 1)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, entitlements.ErrLimitReached))
+	assert.Equal(t, "The Free plan is limited to 2 guns. Upgrade your subscription to add more guns.", err.Error())
+
+	// Bulk adds count every row
+	require.NoError(t, entitlements.CheckAmmo(db, user, free.MaxAmmo))
+	assert.ErrorIs(t, entitlements.CheckAmmo(db, user, free.MaxAmmo+1), entitlements.ErrLimitReached)
+
+	// Paid tiers have no count limits
+	user.SubscriptionTier = models.TierYearly
+	user.SubscriptionExpiresAt = time.Now().AddDate(1, 0, 0)
+	assert.NoError(t, entitlements.CheckGuns(db, user, 100))
+	assert.NoError(t, entitlements.CheckAmmo(db, user, 100))
+}
+
+func TestCheckFeatures(t *testing.T) {
+	user := &models.User{SubscriptionTier: models.TierFree}
+	err := entitlements.CheckExports(user)
+	assert.ErrorIs(t, err, entitlements.ErrLimitReached)
+	assert.Equal(t, "The Free plan doesn't include report exports. Upgrade your subscription to use them.", err.Error())
+
+	user.SubscriptionTier = models.TierLifetime
+	assert.NoError(t, entitlements.CheckExports(user))
+}
+
+func TestVisibleGuns(t *testing.T) {
+	guns := []models.Gun{{Name: "One"}, {Name: "Two"}, {Name: "Three"}}
+
+	// Free users only see their first guns
+	visible := entitlements.VisibleGuns(&models.User{SubscriptionTier: models.TierFree}, guns)
+	require.Len(t, visible, 2)
+	assert.True(t, visible[0].HasMoreGuns)
+	assert.Equal(t, 3, visible[0].TotalGuns)
+
+	// Lifetime users see everything
+	guns = []models.Gun{{Name: "One"}, {Name: "Two"}, {Name: "Three"}}
+	visible = entitlements.VisibleGuns(&models.User{SubscriptionTier: models.TierLifetime}, guns)
+	assert.Len(t, visible, 3)
+	assert.False(t, visible[0].HasMoreGuns)
+}
+
+func TestLoad(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	db.Exec("DELETE FROM plans")
+	defer func() {
+		db.Exec("DELETE FROM plans")
+		require.NoError(t, entitlements.Load(db))
+	}()
+
+	// Tiers without a plan keep their built-in limits
+	require.NoError(t, db.Create(&models.Plan{Tier: models.TierFree, Name: "Starter", MaxGuns: 3, MaxAmmo: entitlements.Unlimited, MaxAttachmentBytes: 1 << 20}).Error)
+	require.NoError(t, entitlements.Load(db))
+
+	free := entitlements.ForTier(models.TierFree)
+	assert.Equal(t, "Starter", free.Plan)
+	assert.Equal(t, 3, free.MaxGuns)
+	assert.Equal(t, entitlements.Unlimited, free.MaxAmmo)
+	assert.Equal(t, "Monthly", entitlements.ForTier(models.TierMonthly).Plan)
+	assert.Equal(t, "Starter", entitlements.ForTier("unknown").Plan)
+}
@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/export"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// DataExportJob generates queued personal data exports and removes expired ones
+type DataExportJob struct {
+	DB           *gorm.DB
+	Storage      storage.Storage
+	EmailService email.EmailService
+	Exporter     *export.Exporter
+}
+
+// NewDataExportJob creates a new DataExportJob
+func NewDataExportJob(db *gorm.DB, store storage.Storage, emailService email.EmailService) *DataExportJob {
+	return &DataExportJob{
+		DB:           db,
+		Storage:      store,
+		EmailService: emailService,
+		Exporter:     export.NewExporter(db, store),
+	}
+}
+
+// Run generates every pending export, emails its download link or that it failed, and deletes exports whose link has expired
+func (j *DataExportJob) Run(now time.Time) error {
+	exports, err := models.FindPendingDataExports(j.DB)
+	if err != nil {
+		return err
+	}
+
+	for i := range exports {
+		dataExport := &exports[i]
+
+		// The account was removed after the export was requested
+		if dataExport.User.ID == 0 {
+			if err := models.DeleteDataExport(j.DB, dataExport.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := j.generate(dataExport, now); err != nil {
+			log.Printf("Failed to generate data export %d for user %d: %v", dataExport.ID, dataExport.UserID, err)
+
+			// The failed record is purged along with expired exports, and the user can request another
+			expiresAt := now.Add(export.LinkTTL)
+			dataExport.Status = models.DataExportStatusFailed
+			dataExport.ExpiresAt = &expiresAt
+			if err := models.UpdateDataExport(j.DB, dataExport); err != nil {
+				return err
+			}
+			if err := j.EmailService.SendDataExportFailedEmail(dataExport.User.Email); err != nil {
+				log.Printf("Failed to send data export failed email to user %d: %v", dataExport.UserID, err)
+			}
+			continue
+		}
+
+		if err := j.EmailService.SendDataExportEmail(dataExport.User.Email, dataExport.Token, *dataExport.ExpiresAt); err != nil {
+			log.Printf("Failed to send data export email to user %d: %v", dataExport.UserID, err)
+		}
+	}
+
+	return j.purgeExpired(now)
+}
+
+// generate builds the archive in a temporary file and uploads it to storage
+func (j *DataExportJob) generate(dataExport *models.DataExport, now time.Time) error {
+	tmp, err := os.CreateTemp("", "data-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	ctx := context.Background()
+	if err := j.Exporter.Write(ctx, tmp, &dataExport.User, now); err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/%d/%s.zip", dataExport.UserID, dataExport.Token)
+	if err := j.Storage.Put(ctx, key, tmp, size, "application/zip"); err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(export.LinkTTL)
+	dataExport.Status = models.DataExportStatusReady
+	dataExport.StorageKey = key
+	dataExport.Size = size
+	dataExport.ExpiresAt = &expiresAt
+	return models.UpdateDataExport(j.DB, dataExport)
+}
+
+// purgeExpired deletes expired archives from storage along with their records
+func (j *DataExportJob) purgeExpired(now time.Time) error {
+	expired, err := models.FindExpiredDataExports(j.DB, now)
+	if err != nil {
+		return err
+	}
+
+	for _, dataExport := range expired {
+		if dataExport.StorageKey != "" {
+			if err := j.Storage.Delete(context.Background(), dataExport.StorageKey); err != nil {
+				log.Printf("Failed to delete data export %d from storage: %v", dataExport.ID, err)
+				continue
+			}
+		}
+		if err := models.DeleteDataExport(j.DB, dataExport.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/export"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExportJob(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "export-job@example.com", "password123", false)
+	require.NoError(t, err)
+	require.NoError(t, models.CreateGun(db, &models.Gun{Name: "Exported Gun", OwnerID: user.ID}))
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dataExport := models.DataExport{UserID: user.ID, Status: models.DataExportStatusPending, Token: "export-job-token"}
+	require.NoError(t, models.CreateDataExport(db, &dataExport))
+
+	mockEmail := &email.MockEmailService{}
+	job := NewDataExportJob(db, store, mockEmail)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// The first run generates the archive and emails the link
+	require.NoError(t, job.Run(now))
+
+	ready, err := models.FindDataExportByToken(db, "export-job-token", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataExportStatusReady, ready.Status)
+	require.NotNil(t, ready.ExpiresAt)
+	assert.True(t, ready.ExpiresAt.Equal(now.Add(export.LinkTTL)))
+	assert.Greater(t, ready.Size, int64(0))
+
+	assert.True(t, mockEmail.SendDataExportEmailCalled)
+	assert.Equal(t, user.Email, mockEmail.SendDataExportEmailEmail)
+	assert.Equal(t, "export-job-token", mockEmail.SendDataExportEmailToken)
+
+	rc, err := store.Get(context.Background(), ready.StorageKey)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	require.NoError(t, err)
+	assert.Equal(t, ready.Size, int64(len(data)))
+
+	// A ready export isn't generated or emailed again
+	mockEmail.SendDataExportEmailCalled = false
+	require.NoError(t, job.Run(now.Add(time.Hour)))
+	assert.False(t, mockEmail.SendDataExportEmailCalled)
+
+	// Once the link expires the archive and its record are removed
+	require.NoError(t, job.Run(now.Add(export.LinkTTL)))
+	_, err = models.FindDataExportByToken(db, "export-job-token", user.ID)
+	assert.Error(t, err)
+	_, err = store.Get(context.Background(), ready.StorageKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+// failingStorage is storage that can't save anything
+type failingStorage struct {
+	storage.Storage
+}
+
+func (failingStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	return errors.New("storage unavailable")
+}
+
+func TestDataExportJobFailure(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "export-fail@example.com", "password123", false)
+	require.NoError(t, err)
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dataExport := models.DataExport{UserID: user.ID, Status: models.DataExportStatusPending, Token: "export-fail-token"}
+	require.NoError(t, models.CreateDataExport(db, &dataExport))
+
+	mockEmail := &email.MockEmailService{}
+	job := NewDataExportJob(db, failingStorage{store}, mockEmail)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// A failed export tells the user instead of sending a link
+	require.NoError(t, job.Run(now))
+
+	failed, err := models.FindDataExportByToken(db, "export-fail-token", user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataExportStatusFailed, failed.Status)
+	require.NotNil(t, failed.ExpiresAt)
+	assert.True(t, failed.ExpiresAt.Equal(now.Add(export.LinkTTL)))
+	assert.False(t, mockEmail.SendDataExportEmailCalled)
+	assert.True(t, mockEmail.SendDataExportFailedEmailCalled)
+	assert.Equal(t, user.Email, mockEmail.SendDataExportFailedEmailEmail)
+
+	// It's purged like any other expired export
+	require.NoError(t, job.Run(now.Add(export.LinkTTL)))
+	_, err = models.FindDataExportByToken(db, "export-fail-token", user.ID)
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"gorm.io/gorm"
+)
+
+// DunningReminderSchedule is when each payment failed reminder is sent, measured from the failed payment
+var DunningReminderSchedule = []time.Duration{0, 3 * 24 * time.Hour, 6 * 24 * time.Hour}
+
+// DunningJob reminds users whose renewal payment failed and downgrades them once the grace period runs out
+type DunningJob struct {
+	DB           *gorm.DB
+	Provider     billing.PaymentProvider
+	EmailService email.EmailService
+}
+
+// NewDunningJob creates a new DunningJob
+func NewDunningJob(db *gorm.DB, provider billing.PaymentProvider, emailService email.EmailService) *DunningJob {
+	return &DunningJob{
+		DB:           db,
+		Provider:     provider,
+		EmailService: emailService,
+	}
+}
+
+// Run sends the reminders that have come due and downgrades users whose grace period has ended
+func (j *DunningJob) Run(now time.Time) error {
+	var users []models.User
+	if err := j.DB.Where("grace_period_ends_at IS NOT NULL").Find(&users).Error; err != nil {
+		return err
+	}
+
+	for i := range users {
+		user := &users[i]
+
+		if !now.Before(*user.GracePeriodEndsAt) {
+			if err := j.downgrade(user, now); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Only the latest reminder that is due gets sent, so a missed run doesn't send a burst of emails
+		due := 0
+		for _, offset := range DunningReminderSchedule {
+			if !now.Before(user.PaymentFailedAt.Add(offset)) {
+				due++
+			}
+		}
+		if due <= user.DunningRemindersSent {
+			continue
+		}
+
+		// Leave the count alone on failure so the reminder is retried next run
+		if err := j.EmailService.SendPaymentFailedEmail(user.Email, due, *user.GracePeriodEndsAt); err != nil {
+			log.Printf("Failed to send payment failed reminder to user %d: %v", user.ID, err)
+			continue
+		}
+
+		if err := j.DB.Model(user).Update("dunning_reminders_sent", due).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downgrade moves a user to the free tier and ends the subscription that couldn't be paid
+func (j *DunningJob) downgrade(user *models.User, now time.Time) error {
+	if user.StripeSubscriptionID != "" {
+		if err := j.Provider.CancelSubscription(user.StripeSubscriptionID); err != nil {
+			log.Printf("Failed to cancel subscription %s for user %d: %v", user.StripeSubscriptionID, user.ID, err)
+		}
+	}
+
+	previousTier := user.SubscriptionTier
+	if err := j.DB.Model(user).Updates(map[string]interface{}{
+		"subscription_tier":       models.TierFree,
+		"subscription_expires_at": now,
+		"stripe_subscription_id":  "",
+		"subscription_canceled":   false,
+		"payment_failed_at":       nil,
+		"grace_period_ends_at":    nil,
+		"dunning_reminders_sent":  0,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := models.CreateSubscriptionChange(j.DB, &models.SubscriptionChange{
+		UserID:   user.ID,
+		FromTier: previousTier,
+		ToTier:   models.TierFree,
+		Cause:    models.SubscriptionCauseExpiry,
+		Note:     "Payment grace period ended",
+	}); err != nil {
+		log.Printf("Failed to record subscription change for user %d: %v", user.ID, err)
+	}
+
+	log.Printf("Downgraded user %d to the free tier after the payment grace period ended", user.ID)
+	return nil
+}
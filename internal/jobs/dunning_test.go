@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDunningJob(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "dunning-job@example.com", "password123", false)
+	require.NoError(t, err)
+
+	failedAt := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	graceEndsAt := failedAt.Add(models.PaymentGracePeriod)
+	require.NoError(t, db.Model(user).Updates(map[string]interface{}{
+		"subscription_tier":       models.TierMonthly,
+		"subscription_expires_at": failedAt,
+		"stripe_subscription_id":  "sub_unknown",
+		"payment_failed_at":       failedAt,
+		"grace_period_ends_at":    graceEndsAt,
+	}).Error)
+
+	mockEmail := &email.MockEmailService{}
+	job := NewDunningJob(db, billing.NewFakeProvider(), mockEmail)
+
+	// The first reminder goes out right away
+	require.NoError(t, job.Run(failedAt.Add(time.Hour)))
+	assert.Equal(t, 1, mockEmail.SendPaymentFailedEmailCalls)
+	assert.Equal(t, user.Email, mockEmail.SendPaymentFailedEmailEmail)
+	assert.Equal(t, 1, mockEmail.SendPaymentFailedEmailReminder)
+	assert.True(t, graceEndsAt.Equal(mockEmail.SendPaymentFailedEmailGraceEndsAt))
+
+	// Nothing more is sent until the next reminder is due
+	require.NoError(t, job.Run(failedAt.AddDate(0, 0, 2)))
+	assert.Equal(t, 1, mockEmail.SendPaymentFailedEmailCalls)
+
+	// A failed send is retried on the next run
+	mockEmail.SendPaymentFailedEmailError = assert.AnError
+	require.NoError(t, job.Run(failedAt.AddDate(0, 0, 3)))
+	mockEmail.SendPaymentFailedEmailError = nil
+	require.NoError(t, job.Run(failedAt.AddDate(0, 0, 3).Add(time.Hour)))
+	assert.Equal(t, 3, mockEmail.SendPaymentFailedEmailCalls)
+	assert.Equal(t, 2, mockEmail.SendPaymentFailedEmailReminder)
+
+	// The user keeps their plan during the grace period
+	var updated models.User
+	require.NoError(t, db.First(&updated, user.ID).Error)
+	assert.True(t, updated.InGracePeriod())
+	assert.Equal(t, models.TierMonthly, updated.SubscriptionTier)
+
+	// Once the grace period ends the user is downgraded even if canceling with the provider fails
+	require.NoError(t, job.Run(graceEndsAt))
+	var downgraded models.User
+	require.NoError(t, db.First(&downgraded, user.ID).Error)
+	assert.Equal(t, models.TierFree, downgraded.SubscriptionTier)
+	assert.Empty(t, downgraded.StripeSubscriptionID)
+	assert.False(t, downgraded.InGracePeriod())
+	assert.Nil(t, downgraded.PaymentFailedAt)
+	assert.Equal(t, 0, downgraded.DunningRemindersSent)
+
+	changes, err := models.FindSubscriptionChangesByUser(db, user.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, models.TierMonthly, changes[0].FromTier)
+	assert.Equal(t, models.TierFree, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseExpiry, changes[0].Cause)
+
+	// A downgraded user gets no more reminders
+	require.NoError(t, job.Run(graceEndsAt.AddDate(0, 0, 1)))
+	assert.Equal(t, 3, mockEmail.SendPaymentFailedEmailCalls)
+}
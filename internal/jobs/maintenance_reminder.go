@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"gorm.io/gorm"
+)
+
+// MaintenanceReminderJob emails owners when their guns cross a maintenance interval
+type MaintenanceReminderJob struct {
+	DB           *gorm.DB
+	EmailService email.EmailService
+}
+
+// NewMaintenanceReminderJob creates a new MaintenanceReminderJob
+func NewMaintenanceReminderJob(db *gorm.DB, emailService email.EmailService) *MaintenanceReminderJob {
+	return &MaintenanceReminderJob{
+		DB:           db,
+		EmailService: emailService,
+	}
+}
+
+// Run checks every gun with a maintenance interval and sends one reminder per owner for newly overdue guns
+func (j *MaintenanceReminderJob) Run(now time.Time) error {
+	// Find guns with an interval that haven't already triggered a reminder
+	var guns []models.Gun
+	if err := j.DB.Preload("Owner").
+		Where("(maintenance_interval_days > 0 OR maintenance_interval_rounds > 0) AND maintenance_reminder_sent = ?", false).
+		Find(&guns).Error; err != nil {
+		return err
+	}
+
+	// Group the overdue guns by owner
+	overdueByOwner := make(map[uint][]models.MaintenanceStatus)
+	var ownerOrder []uint
+	for _, gun := range guns {
+		status, err := models.GetMaintenanceStatus(j.DB, gun, now)
+		if err != nil {
+			return err
+		}
+		if !status.IsOverdue() {
+			continue
+		}
+		if _, ok := overdueByOwner[gun.OwnerID]; !ok {
+			ownerOrder = append(ownerOrder, gun.OwnerID)
+		}
+		overdueByOwner[gun.OwnerID] = append(overdueByOwner[gun.OwnerID], status)
+	}
+
+	for _, ownerID := range ownerOrder {
+		statuses := overdueByOwner[ownerID]
+
+		var lines []string
+		var gunIDs []uint
+		for _, status := range statuses {
+			lines = append(lines, status.Gun.Name+": "+status.Reason())
+			gunIDs = append(gunIDs, status.Gun.ID)
+		}
+
+		// Send the reminder, leaving the guns unflagged so a failed send is retried next run
+		if err := j.EmailService.SendMaintenanceReminderEmail(statuses[0].Gun.Owner.Email, lines); err != nil {
+			log.Printf("Failed to send maintenance reminder to user %d: %v", ownerID, err)
+			continue
+		}
+
+		if err := j.DB.Model(&models.Gun{}).Where("id IN ?", gunIDs).Update("maintenance_reminder_sent", true).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceReminderJob(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "maintenance-job@example.com", "password123", false)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	cleaned := now.AddDate(0, 0, -10)
+
+	// A gun due by rounds fired since its last cleaning
+	roundsGun := models.Gun{Name: "Rounds Gun", OwnerID: user.ID, MaintenanceIntervalRounds: 500}
+	require.NoError(t, models.CreateGun(db, &roundsGun))
+	require.NoError(t, models.CreateMaintenanceRecord(db, &models.MaintenanceRecord{
+		GunID: roundsGun.ID, OwnerID: user.ID, Type: models.MaintenanceTypeCleaning, Date: cleaned,
+	}))
+
+	// Rounds fired before the cleaning don't count towards the interval
+	require.NoError(t, models.CreateRangeSession(db, &models.RangeSession{
+		Date: cleaned.AddDate(0, 0, -1), OwnerID: user.ID,
+		Items: []models.RangeSessionItem{{GunID: roundsGun.ID, RoundsFired: 1000}},
+	}, false))
+	require.NoError(t, models.CreateRangeSession(db, &models.RangeSession{
+		Date: cleaned.AddDate(0, 0, 2), OwnerID: user.ID,
+		Items: []models.RangeSessionItem{{GunID: roundsGun.ID, RoundsFired: 600}},
+	}, false))
+
+	// A gun that is not yet due
+	acquired := now.AddDate(0, 0, -30)
+	daysGun := models.Gun{Name: "Days Gun", OwnerID: user.ID, Acquired: &acquired, MaintenanceIntervalDays: 90}
+	require.NoError(t, models.CreateGun(db, &daysGun))
+
+	mockEmail := &email.MockEmailService{}
+	job := NewMaintenanceReminderJob(db, mockEmail)
+
+	// The first run sends a reminder for the overdue gun only
+	require.NoError(t, job.Run(now))
+	assert.True(t, mockEmail.SendMaintenanceReminderEmailCalled)
+	assert.Equal(t, user.Email, mockEmail.SendMaintenanceReminderEmailEmail)
+	require.Len(t, mockEmail.SendMaintenanceReminderEmailOverdue, 1)
+	assert.Contains(t, mockEmail.SendMaintenanceReminderEmailOverdue[0], "Rounds Gun: 600 rounds")
+
+	// A second run does not send the same reminder again
+	mockEmail.SendMaintenanceReminderEmailCalled = false
+	require.NoError(t, job.Run(now))
+	assert.False(t, mockEmail.SendMaintenanceReminderEmailCalled)
+
+	// Once the day interval is crossed the other gun triggers a reminder
+	require.NoError(t, job.Run(now.AddDate(0, 0, 60)))
+	assert.True(t, mockEmail.SendMaintenanceReminderEmailCalled)
+	require.Len(t, mockEmail.SendMaintenanceReminderEmailOverdue, 1)
+	assert.Contains(t, mockEmail.SendMaintenanceReminderEmailOverdue[0], "Days Gun")
+
+	// Logging a cleaning clears the overdue state for the rounds gun
+	require.NoError(t, models.CreateMaintenanceRecord(db, &models.MaintenanceRecord{
+		GunID: roundsGun.ID, OwnerID: user.ID, Type: models.MaintenanceTypeCleaning, Date: now,
+	}))
+	overdue, err := models.FindOverdueGuns(db, user.ID, now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Len(t, overdue, 0)
+}
+
+func TestSchedulerStop(t *testing.T) {
+	scheduler := NewScheduler()
+
+	runs := make(chan struct{}, 10)
+	scheduler.Every("test", time.Hour, func(now time.Time) error {
+		runs <- struct{}{}
+		return nil
+	})
+
+	// The job runs once immediately
+	select {
+	case <-runs:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run")
+	}
+
+	// Stop returns once the job goroutine has exited and is safe to call twice
+	scheduler.Stop()
+	scheduler.Stop()
+}
@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a unit of background work run on a schedule
+type Job func(now time.Time) error
+
+// Scheduler runs jobs at fixed intervals in the background
+type Scheduler struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewScheduler creates a new Scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		stop: make(chan struct{}),
+	}
+}
+
+// Every runs the job immediately and then once per interval until the scheduler is stopped
+func (s *Scheduler) Every(name string, interval time.Duration, job Job) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		run(name, job, time.Now())
+		for {
+			select {
+			case now := <-ticker.C:
+				run(name, job, now)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops all jobs and waits for any running job to finish
+func (s *Scheduler) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+}
+
+// run executes a job, logging any error or panic so one failure doesn't stop the schedule
+func run(name string, job Job, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", name, r)
+		}
+	}()
+
+	if err := job(now); err != nil {
+		log.Printf("Job %s failed: %v", name, err)
+	}
+}
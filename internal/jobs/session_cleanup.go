@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+)
+
+// SessionCleanupJob removes expired login sessions so the session store doesn't grow forever
+type SessionCleanupJob struct {
+	Store auth.SessionStore
+}
+
+// NewSessionCleanupJob creates a new SessionCleanupJob
+func NewSessionCleanupJob(store auth.SessionStore) *SessionCleanupJob {
+	return &SessionCleanupJob{
+		Store: store,
+	}
+}
+
+// Run deletes sessions that expired before now
+func (j *SessionCleanupJob) Run(now time.Time) error {
+	removed, err := j.Store.DeleteExpired(now)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Removed %d expired login sessions", removed)
+	}
+	return nil
+}
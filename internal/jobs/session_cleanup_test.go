@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCleanupJob(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := auth.NewGORMSessionStore(db)
+	require.NoError(t, store.Save(&models.LoginSession{Token: "expired", Data: "{}", LastSeenAt: now.Add(-48 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+	require.NoError(t, store.Save(&models.LoginSession{Token: "active", Data: "{}", LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}))
+
+	require.NoError(t, NewSessionCleanupJob(store).Run(now))
+
+	_, err = store.Get("expired")
+	assert.ErrorIs(t, err, auth.ErrSessionNotFound)
+	_, err = store.Get("active")
+	assert.NoError(t, err)
+}
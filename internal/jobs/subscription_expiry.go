@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// SubscriptionExpiryJob moves users to the free tier once a subscription that won't renew runs out
+type SubscriptionExpiryJob struct {
+	DB *gorm.DB
+}
+
+// NewSubscriptionExpiryJob creates a new SubscriptionExpiryJob
+func NewSubscriptionExpiryJob(db *gorm.DB) *SubscriptionExpiryJob {
+	return &SubscriptionExpiryJob{
+		DB: db,
+	}
+}
+
+// Run downgrades canceled and comped subscriptions that have ended.
+// Subscriptions that renew are left to the payment provider and the dunning job.
+func (j *SubscriptionExpiryJob) Run(now time.Time) error {
+	var users []models.User
+	if err := j.DB.Where("subscription_tier NOT IN ? AND subscription_canceled = ? AND subscription_expires_at <= ? AND grace_period_ends_at IS NULL",
+		[]string{models.TierFree, models.TierLifetime, models.TierPremiumLifetime}, true, now).Find(&users).Error; err != nil {
+		return err
+	}
+
+	for i := range users {
+		user := &users[i]
+		previousTier := user.SubscriptionTier
+		expiredAt := user.SubscriptionExpiresAt
+
+		if err := j.DB.Model(user).Updates(map[string]interface{}{
+			"subscription_tier":      models.TierFree,
+			"stripe_subscription_id": "",
+			"subscription_canceled":  false,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := models.CreateSubscriptionChange(j.DB, &models.SubscriptionChange{
+			UserID:   user.ID,
+			FromTier: previousTier,
+			ToTier:   models.TierFree,
+			Cause:    models.SubscriptionCauseExpiry,
+			Note:     "Subscription ended " + expiredAt.Format("January 2, 2006"),
+		}); err != nil {
+			log.Printf("Failed to record subscription change for user %d: %v", user.ID, err)
+		}
+
+		log.Printf("Moved user %d to the free tier after their %s subscription ended", user.ID, previousTier)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionExpiryJob(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	subscriber := func(email, tier string, expiresAt time.Time, canceled bool) *models.User {
+		user, err := testutils.CreateTestUser(db, email, "password123", false)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(user).Updates(map[string]interface{}{
+			"subscription_tier":       tier,
+			"subscription_expires_at": expiresAt,
+			"subscription_canceled":   canceled,
+			"stripe_subscription_id":  "sub_" + tier,
+		}).Error)
+		return user
+	}
+
+	ended := subscriber("expiry-ended@example.com", models.TierYearly, now.AddDate(0, 0, -1), true)
+	endsLater := subscriber("expiry-later@example.com", models.TierMonthly, now.AddDate(0, 0, 3), true)
+	renews := subscriber("expiry-renews@example.com", models.TierMonthly, now.AddDate(0, 0, -1), false)
+	lifetime := subscriber("expiry-lifetime@example.com", models.TierLifetime, now.AddDate(0, 0, -1), true)
+
+	job := NewSubscriptionExpiryJob(db)
+	require.NoError(t, job.Run(now))
+
+	// The canceled subscription that ran out moves to the free tier
+	var downgraded models.User
+	require.NoError(t, db.First(&downgraded, ended.ID).Error)
+	assert.Equal(t, models.TierFree, downgraded.SubscriptionTier)
+	assert.Empty(t, downgraded.StripeSubscriptionID)
+	assert.False(t, downgraded.SubscriptionCanceled)
+
+	changes, err := models.FindSubscriptionChangesByUser(db, ended.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, models.TierYearly, changes[0].FromTier)
+	assert.Equal(t, models.TierFree, changes[0].ToTier)
+	assert.Equal(t, models.SubscriptionCauseExpiry, changes[0].Cause)
+
+	// Everyone else keeps their plan
+	for _, user := range []*models.User{endsLater, renews, lifetime} {
+		var unchanged models.User
+		require.NoError(t, db.First(&unchanged, user.ID).Error)
+		assert.NotEqual(t, models.TierFree, unchanged.SubscriptionTier, user.Email)
+
+		changes, err := models.FindSubscriptionChangesByUser(db, user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, changes, user.Email)
+	}
+
+	// Running again changes nothing
+	require.NoError(t, job.Run(now.Add(time.Hour)))
+	changes, err = models.FindSubscriptionChangesByUser(db, ended.ID)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Ammo represents a quantity of ammunition owned by a user
+type Ammo struct {
+	gorm.Model
+	Brand           string
+	CaliberID       uint
+	Caliber         Caliber `gorm:"foreignKey:CaliberID"`
+	Grain           int
+	BulletType      string
+	Quantity        int
+	CostPerRound    int64 // Cost per round in cents
+	StorageLocation string
+	OwnerID         uint
+	Owner           User `gorm:"foreignKey:OwnerID"`
+}
+
+// TableName specifies the table name for the Ammo model
+func (Ammo) TableName() string {
+	return "ammo"
+}
+
+// TotalCost returns the total value of the ammo on hand in cents
+func (a *Ammo) TotalCost() int64 {
+	return a.CostPerRound * int64(a.Quantity)
+}
+
+// FormatCostPerRound returns the cost per round formatted in dollars
+func (a *Ammo) FormatCostPerRound() string {
+	return "$" + formatDollars(float64(a.CostPerRound)/100.0)
+}
+
+// FormatTotalCost returns the total value of the ammo on hand formatted in dollars
+func (a *Ammo) FormatTotalCost() string {
+	return "$" + formatDollars(float64(a.TotalCost())/100.0)
+}
+
+// FindAmmoByOwner retrieves all ammo belonging to a specific owner
+func FindAmmoByOwner(db *gorm.DB, ownerID uint) ([]Ammo, error) {
+	var ammo []Ammo
+	if err := db.Preload("Caliber").Where("owner_id = ?", ownerID).Order("brand").Find(&ammo).Error; err != nil {
+		return nil, err
+	}
+	return ammo, nil
+}
+
+// FindAmmoByCaliber retrieves the ammo on hand for an owner in a specific caliber
+func FindAmmoByCaliber(db *gorm.DB, ownerID uint, caliberID uint) ([]Ammo, error) {
+	var ammo []Ammo
+	if err := db.Preload("Caliber").Where("owner_id = ? AND caliber_id = ? AND quantity > 0", ownerID, caliberID).Order("brand").Find(&ammo).Error; err != nil {
+		return nil, err
+	}
+	return ammo, nil
+}
+
+// FindAmmoByID retrieves ammo by its ID, ensuring it belongs to the specified owner
+func FindAmmoByID(db *gorm.DB, id uint, ownerID uint) (*Ammo, error) {
+	var ammo Ammo
+	if err := db.Preload("Caliber").Where("id = ? AND owner_id = ?", id, ownerID).First(&ammo).Error; err != nil {
+		return nil, err
+	}
+	return &ammo, nil
+}
+
+// CreateAmmo creates new ammo in the database
+func CreateAmmo(db *gorm.DB, ammo *Ammo) error {
+	return db.Create(ammo).Error
+}
+
+// UpdateAmmo updates existing ammo in the database
+func UpdateAmmo(db *gorm.DB, ammo *Ammo) error {
+	return db.Save(ammo).Error
+}
+
+// DeleteAmmo deletes ammo from the database
+func DeleteAmmo(db *gorm.DB, id uint, ownerID uint) error {
+	return db.Where("id = ? AND owner_id = ?", id, ownerID).Delete(&Ammo{}).Error
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Attachment kinds
+const (
+	AttachmentKindPhoto   = "photo"
+	AttachmentKindReceipt = "receipt"
+	AttachmentKindManual  = "manual"
+)
+
+// AttachmentKinds lists the supported attachment kinds in display order
+var AttachmentKinds = []string{
+	AttachmentKindPhoto,
+	AttachmentKindReceipt,
+	AttachmentKindManual,
+}
+
+// Attachment represents a photo or document attached to a gun
+type Attachment struct {
+	gorm.Model
+	GunID        uint
+	Gun          Gun `gorm:"foreignKey:GunID"`
+	OwnerID      uint
+	Kind         string
+	FileName     string
+	ContentType  string
+	Size         int64 // Bytes stored, including the thumbnail
+	StorageKey   string
+	ThumbnailKey string
+}
+
+// TableName specifies the table name for the Attachment model
+func (Attachment) TableName() string {
+	return "attachments"
+}
+
+// IsImage returns whether the attachment is an image
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// HasThumbnail returns whether a thumbnail was generated for the attachment
+func (a *Attachment) HasThumbnail() bool {
+	return a.ThumbnailKey != ""
+}
+
+// IsValidAttachmentKind checks if the attachment kind is supported
+func IsValidAttachmentKind(kind string) bool {
+	for _, k := range AttachmentKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatAttachmentKind returns a display name for an attachment kind
+func FormatAttachmentKind(kind string) string {
+	switch kind {
+	case AttachmentKindPhoto:
+		return "Photo"
+	case AttachmentKindReceipt:
+		return "Receipt"
+	case AttachmentKindManual:
+		return "Manual"
+	default:
+		return kind
+	}
+}
+
+// FormatBytes formats a byte count for display
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
+
+// FindAttachmentsByGun retrieves all attachments for a gun, newest first
+func FindAttachmentsByGun(db *gorm.DB, gunID uint, ownerID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	if err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).Order("created_at DESC").Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// FindAttachmentByID retrieves an attachment by its ID, ensuring it belongs to the specified owner
+func FindAttachmentByID(db *gorm.DB, id uint, ownerID uint) (*Attachment, error) {
+	var attachment Attachment
+	if err := db.Where("id = ? AND owner_id = ?", id, ownerID).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// CreateAttachment creates a new attachment in the database
+func CreateAttachment(db *gorm.DB, attachment *Attachment) error {
+	return db.Create(attachment).Error
+}
+
+// CreateAttachmentWithinQuota creates an attachment if the owner's stored bytes stay within quota,
+// reporting whether it did. The owner's row is locked so concurrent uploads can't both fit in the same room.
+func CreateAttachmentWithinQuota(db *gorm.DB, attachment *Attachment, quota int64) (bool, error) {
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var owner User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&owner, attachment.OwnerID).Error; err != nil {
+			return err
+		}
+		used, err := GetStorageUsed(tx, attachment.OwnerID)
+		if err != nil {
+			return err
+		}
+		if used+attachment.Size > quota {
+			return nil
+		}
+		if err := tx.Create(attachment).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// DeleteAttachment deletes an attachment from the database
+func DeleteAttachment(db *gorm.DB, id uint, ownerID uint) error {
+	return db.Unscoped().Where("id = ? AND owner_id = ?", id, ownerID).Delete(&Attachment{}).Error
+}
+
+// GetStorageUsed returns the number of attachment bytes stored by an owner
+func GetStorageUsed(db *gorm.DB, ownerID uint) (int64, error) {
+	var total struct{ Bytes int64 }
+	if err := db.Model(&Attachment{}).Select("COALESCE(SUM(size), 0) AS bytes").Where("owner_id = ?", ownerID).Scan(&total).Error; err != nil {
+		return 0, err
+	}
+	return total.Bytes, nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Billing adjustment actions an admin can take on a user's account
+const (
+	AdjustmentRefund = "refund"
+	AdjustmentComp   = "comp"
+	AdjustmentExtend = "extend"
+	AdjustmentRevoke = "revoke"
+)
+
+// BillingAdjustment records a billing change an admin made by hand, who made it and why
+type BillingAdjustment struct {
+	gorm.Model
+	UserID            uint   `gorm:"index;not null"`
+	AdminID           uint   `gorm:"not null"`
+	Action            string `gorm:"not null"` // "refund", "comp", "extend" or "revoke"
+	Reason            string `gorm:"not null"`
+	PaymentID         *uint  // The payment refunded, refunds only
+	Amount            int64  // Cents refunded, refunds only
+	Currency          string
+	Days              int // Days comped or added to the subscription
+	PreviousTier      string
+	NewTier           string
+	PreviousExpiresAt *time.Time
+	NewExpiresAt      *time.Time
+	StripeID          string // The refund with the payment provider, refunds only
+	User              User   `gorm:"foreignKey:UserID"`
+	Admin             User   `gorm:"foreignKey:AdminID"`
+}
+
+// TableName specifies the table name for the BillingAdjustment model
+func (BillingAdjustment) TableName() string {
+	return "billing_adjustments"
+}
+
+// FormatAmount formats the refunded amount with the currency symbol
+func (a *BillingAdjustment) FormatAmount() string {
+	payment := Payment{Amount: a.Amount, Currency: a.Currency}
+	return payment.FormatAmount()
+}
+
+// CreateBillingAdjustment records an admin's billing change
+func CreateBillingAdjustment(db *gorm.DB, adjustment *BillingAdjustment) error {
+	return db.Create(adjustment).Error
+}
+
+// FindBillingAdjustmentsByUser retrieves the billing changes made to a user's account, newest first
+func FindBillingAdjustmentsByUser(db *gorm.DB, userID uint) ([]BillingAdjustment, error) {
+	var adjustments []BillingAdjustment
+	if err := db.Preload("Admin").Where("user_id = ?", userID).Order("created_at desc").Find(&adjustments).Error; err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+// FindRecentBillingAdjustments retrieves the latest billing changes across all users
+func FindRecentBillingAdjustments(db *gorm.DB, limit int) ([]BillingAdjustment, error) {
+	var adjustments []BillingAdjustment
+	if err := db.Preload("User").Preload("Admin").Order("created_at desc").Limit(limit).Find(&adjustments).Error; err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon durations, matching how long Stripe applies a discount to a subscription
+const (
+	CouponDurationOnce      = "once"
+	CouponDurationRepeating = "repeating"
+	CouponDurationForever   = "forever"
+)
+
+// Coupon redemption statuses
+const (
+	CouponRedemptionPending  = "pending"  // Held for a checkout that hasn't finished yet
+	CouponRedemptionRedeemed = "redeemed" // Used on a completed checkout
+)
+
+// Reasons a coupon can't be used at checkout
+var (
+	ErrCouponInvalid         = errors.New("this promo code isn't valid")
+	ErrCouponExpired         = errors.New("this promo code has expired")
+	ErrCouponExhausted       = errors.New("this promo code has been fully redeemed")
+	ErrCouponAlreadyRedeemed = errors.New("you've already used this promo code")
+)
+
+// Coupon is a promo code that discounts a plan at checkout
+type Coupon struct {
+	gorm.Model
+	Code             string `gorm:"uniqueIndex;not null"` // Stored upper case
+	Description      string
+	PercentOff       int    // 1-100, zero when AmountOff is used
+	AmountOff        int64  // Cents off, zero when PercentOff is used
+	Currency         string `gorm:"default:'usd'"`
+	Duration         string // "once", "repeating" or "forever"
+	DurationInMonths int    // How long a repeating discount lasts
+	MaxRedemptions   int    // Zero for unlimited
+	TimesRedeemed    int    `gorm:"default:0"`
+	ExpiresAt        *time.Time
+	Active           bool
+	StripeCouponID   string // The matching coupon with the payment provider
+}
+
+// CouponRedemption records that a user has used a coupon, so it can't be used again
+type CouponRedemption struct {
+	gorm.Model
+	CouponID uint   `gorm:"uniqueIndex:idx_coupon_redemptions_coupon_user;not null"`
+	UserID   uint   `gorm:"uniqueIndex:idx_coupon_redemptions_coupon_user;not null"`
+	StripeID string // Checkout session the coupon was used on
+	Status   string `gorm:"default:'redeemed'"` // "pending" until the checkout completes
+	Coupon   Coupon
+}
+
+// TableName specifies the table name for the Coupon model
+func (Coupon) TableName() string {
+	return "coupons"
+}
+
+// TableName specifies the table name for the CouponRedemption model
+func (CouponRedemption) TableName() string {
+	return "coupon_redemptions"
+}
+
+// NormalizeCouponCode formats a code the way it's stored
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Discount returns how many cents the coupon takes off a price
+func (c *Coupon) Discount(price int64) int64 {
+	discount := c.AmountOff
+	if c.PercentOff > 0 {
+		discount = price * int64(c.PercentOff) / 100
+	}
+	if discount > price {
+		return price
+	}
+	return discount
+}
+
+// Summary describes the discount, e.g. "20% off for 3 months"
+func (c *Coupon) Summary() string {
+	amount := fmt.Sprintf("%d%% off", c.PercentOff)
+	if c.PercentOff == 0 {
+		payment := Payment{Amount: c.AmountOff, Currency: c.Currency}
+		amount = strings.TrimSuffix(payment.FormatAmount(), ".00") + " off"
+	}
+
+	switch c.Duration {
+	case CouponDurationForever:
+		return amount
+	case CouponDurationRepeating:
+		if c.DurationInMonths == 1 {
+			return amount + " for 1 month"
+		}
+		return fmt.Sprintf("%s for %d months", amount, c.DurationInMonths)
+	default:
+		return amount + " your first payment"
+	}
+}
+
+// CheckAvailable returns the reason the coupon can't be used by anyone, or nil if it can be
+func (c *Coupon) CheckAvailable(now time.Time) error {
+	if !c.Active {
+		return ErrCouponInvalid
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return ErrCouponExpired
+	}
+	if c.MaxRedemptions > 0 && c.TimesRedeemed >= c.MaxRedemptions {
+		return ErrCouponExhausted
+	}
+	return nil
+}
+
+// CheckRedeemable returns the reason a user can't use the coupon, or nil if they can.
+// A zero userID only checks that the coupon is available.
+func (c *Coupon) CheckRedeemable(db *gorm.DB, userID uint, now time.Time) error {
+	if err := c.CheckAvailable(now); err != nil {
+		return err
+	}
+	if userID == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := db.Model(&CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", c.ID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCouponAlreadyRedeemed
+	}
+	return nil
+}
+
+// FindCoupons retrieves every coupon, newest first
+func FindCoupons(db *gorm.DB) ([]Coupon, error) {
+	var coupons []Coupon
+	if err := db.Order("created_at DESC").Find(&coupons).Error; err != nil {
+		return nil, err
+	}
+	return coupons, nil
+}
+
+// FindCouponByID retrieves a coupon by its ID
+func FindCouponByID(db *gorm.DB, id uint) (*Coupon, error) {
+	var coupon Coupon
+	if err := db.First(&coupon, id).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// FindCouponByCode retrieves a coupon by the code a user entered
+func FindCouponByCode(db *gorm.DB, code string) (*Coupon, error) {
+	var coupon Coupon
+	if err := db.Where("code = ?", NormalizeCouponCode(code)).First(&coupon).Error; err != nil {
+		return nil, err
+	}
+	return &coupon, nil
+}
+
+// CreateCoupon creates a new coupon in the database
+func CreateCoupon(db *gorm.DB, coupon *Coupon) error {
+	coupon.Code = NormalizeCouponCode(coupon.Code)
+	return db.Create(coupon).Error
+}
+
+// UpdateCoupon updates an existing coupon in the database
+func UpdateCoupon(db *gorm.DB, coupon *Coupon) error {
+	return db.Save(coupon).Error
+}
+
+// ReserveCoupon holds a use of the coupon for a user starting checkout, counting it against the coupon's limit.
+// The unique (coupon_id, user_id) index stops the same user holding it twice.
+func ReserveCoupon(db *gorm.DB, couponID, userID uint) (*CouponRedemption, error) {
+	redemption := CouponRedemption{CouponID: couponID, UserID: userID, Status: CouponRedemptionPending}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Unscoped().Model(&CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", couponID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrCouponAlreadyRedeemed
+		}
+
+		result := tx.Model(&Coupon{}).
+			Where("id = ? AND (max_redemptions = 0 OR times_redeemed < max_redemptions)", couponID).
+			UpdateColumn("times_redeemed", gorm.Expr("times_redeemed + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrCouponExhausted
+		}
+		return tx.Create(&redemption).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &redemption, nil
+}
+
+// AttachCouponSession records the checkout session a reserved coupon is being used on
+func AttachCouponSession(db *gorm.DB, redemption *CouponRedemption, stripeID string) error {
+	redemption.StripeID = stripeID
+	return db.Model(redemption).Update("stripe_id", stripeID).Error
+}
+
+// ReleaseCoupon gives back a reserved use of a coupon whose checkout never completed.
+// Redemptions that have already been confirmed are left alone.
+func ReleaseCoupon(db *gorm.DB, redemption *CouponRedemption) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id = ? AND status = ?", redemption.ID, CouponRedemptionPending).Delete(&CouponRedemption{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Model(&Coupon{}).Where("id = ? AND times_redeemed > 0", redemption.CouponID).
+			UpdateColumn("times_redeemed", gorm.Expr("times_redeemed - 1")).Error
+	})
+}
+
+// FindPendingCouponRedemption retrieves the coupon reserved for a checkout session
+func FindPendingCouponRedemption(db *gorm.DB, stripeID string) (*CouponRedemption, error) {
+	var redemption CouponRedemption
+	if err := db.Where("stripe_id = ? AND stripe_id <> '' AND status = ?", stripeID, CouponRedemptionPending).First(&redemption).Error; err != nil {
+		return nil, err
+	}
+	return &redemption, nil
+}
+
+// RedeemCoupon confirms a user's use of a coupon once their checkout completes.
+// A use that wasn't reserved at checkout is recorded and counted against the coupon's limit here.
+func RedeemCoupon(db *gorm.DB, couponID, userID uint, stripeID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&CouponRedemption{}).
+			Where("coupon_id = ? AND user_id = ? AND status = ?", couponID, userID, CouponRedemptionPending).
+			Updates(map[string]interface{}{"status": CouponRedemptionRedeemed, "stripe_id": stripeID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			return nil
+		}
+
+		var count int64
+		if err := tx.Unscoped().Model(&CouponRedemption{}).Where("coupon_id = ? AND user_id = ?", couponID, userID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrCouponAlreadyRedeemed
+		}
+
+		redemption := CouponRedemption{CouponID: couponID, UserID: userID, StripeID: stripeID, Status: CouponRedemptionRedeemed}
+		if err := tx.Create(&redemption).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Coupon{}).Where("id = ?", couponID).
+			UpdateColumn("times_redeemed", gorm.Expr("times_redeemed + 1")).Error
+	})
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Data export statuses
+const (
+	DataExportStatusPending = "pending"
+	DataExportStatusReady   = "ready"
+	DataExportStatusFailed  = "failed"
+)
+
+// DataExport represents a personal data export that is generated in the background
+type DataExport struct {
+	gorm.Model
+	UserID     uint
+	User       User   `gorm:"foreignKey:UserID"`
+	Status     string `gorm:"default:'pending'"`
+	Token      string `gorm:"uniqueIndex"` // Random token used in the download link
+	StorageKey string
+	Size       int64      // Size of the archive in bytes
+	ExpiresAt  *time.Time // When the download link stops working, set once the archive is ready
+}
+
+// TableName specifies the table name for the DataExport model
+func (DataExport) TableName() string {
+	return "data_exports"
+}
+
+// IsExpired reports whether the download link has expired
+func (e *DataExport) IsExpired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// CreateDataExport creates a new data export in the database
+func CreateDataExport(db *gorm.DB, export *DataExport) error {
+	return db.Create(export).Error
+}
+
+// UpdateDataExport updates an existing data export in the database
+func UpdateDataExport(db *gorm.DB, export *DataExport) error {
+	return db.Save(export).Error
+}
+
+// DeleteDataExport deletes a data export from the database
+func DeleteDataExport(db *gorm.DB, id uint) error {
+	return db.Unscoped().Delete(&DataExport{}, id).Error
+}
+
+// FindDataExportByToken retrieves a data export by its download token, ensuring it belongs to the specified user
+func FindDataExportByToken(db *gorm.DB, token string, userID uint) (*DataExport, error) {
+	var export DataExport
+	if err := db.Where("token = ? AND user_id = ?", token, userID).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindPendingDataExport retrieves a user's export that hasn't been generated yet, if any
+func FindPendingDataExport(db *gorm.DB, userID uint) (*DataExport, error) {
+	var export DataExport
+	if err := db.Where("user_id = ? AND status = ?", userID, DataExportStatusPending).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindPendingDataExports retrieves every export waiting to be generated, oldest first
+func FindPendingDataExports(db *gorm.DB) ([]DataExport, error) {
+	var exports []DataExport
+	if err := db.Preload("User").Where("status = ?", DataExportStatusPending).Order("created_at ASC").Find(&exports).Error; err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// FindExpiredDataExports retrieves every export whose download link has expired
+func FindExpiredDataExports(db *gorm.DB, now time.Time) ([]DataExport, error) {
+	var exports []DataExport
+	if err := db.Where("expires_at IS NOT NULL AND expires_at <= ?", now).Find(&exports).Error; err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
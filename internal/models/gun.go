@@ -23,6 +23,36 @@ type Gun struct {
 	Owner          User `gorm:"foreignKey:OwnerID"`
 	HasMoreGuns    bool `gorm:"-"` // Indicates if there are more guns not being shown (not stored in DB)
 	TotalGuns      int  `gorm:"-"` // Total number of guns the user has (not stored in DB)
+
+	// Maintenance intervals, zero means no interval is set
+	MaintenanceIntervalDays   int
+	MaintenanceIntervalRounds int
+	MaintenanceReminderSent   bool `gorm:"default:false"`
+
+	// Purchase details, used for collection value reporting
+	PurchasePrice  int64 // Price paid in cents
+	PurchaseSource string
+	Condition      string
+}
+
+// Gun condition grades
+const (
+	GunConditionNew       = "new"
+	GunConditionExcellent = "excellent"
+	GunConditionVeryGood  = "very_good"
+	GunConditionGood      = "good"
+	GunConditionFair      = "fair"
+	GunConditionPoor      = "poor"
+)
+
+// GunConditions lists the supported condition grades from best to worst
+var GunConditions = []string{
+	GunConditionNew,
+	GunConditionExcellent,
+	GunConditionVeryGood,
+	GunConditionGood,
+	GunConditionFair,
+	GunConditionPoor,
 }
 
 // TableName specifies the table name for the Gun model
@@ -30,38 +60,51 @@ func (Gun) TableName() string {
 	return "guns"
 }
 
-// FindGunsByOwner retrieves all guns belonging to a specific owner
-// For free tier users, only returns the first 2 guns
-func FindGunsByOwner(db *gorm.DB, ownerID uint) ([]Gun, error) {
-	// First, get the user to check their subscription status
-	var user User
-	if err := db.First(&user, ownerID).Error; err != nil {
-		return nil, err
-	}
+// FormatPurchasePrice returns the purchase price formatted in dollars
+func (g *Gun) FormatPurchasePrice() string {
+	return "$" + formatDollars(float64(g.PurchasePrice)/100.0)
+}
 
-	// Get all guns for this owner
-	var allGuns []Gun
-	if err := db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").Where("owner_id = ?", ownerID).Find(&allGuns).Error; err != nil {
-		return nil, err
+// FormatGunCondition returns a display name for a condition grade
+func FormatGunCondition(condition string) string {
+	switch condition {
+	case GunConditionNew:
+		return "New"
+	case GunConditionExcellent:
+		return "Excellent"
+	case GunConditionVeryGood:
+		return "Very Good"
+	case GunConditionGood:
+		return "Good"
+	case GunConditionFair:
+		return "Fair"
+	case GunConditionPoor:
+		return "Poor"
+	default:
+		return condition
 	}
+}
 
-	// If the user has an active subscription, return all guns
-	if user.HasActiveSubscription() {
-		return allGuns, nil
+// IsValidGunCondition checks if the condition grade is supported, allowing an empty grade
+func IsValidGunCondition(condition string) bool {
+	if condition == "" {
+		return true
 	}
-
-	// For free tier users, only return the first 2 guns
-	if len(allGuns) <= 2 {
-		return allGuns, nil
+	for _, c := range GunConditions {
+		if c == condition {
+			return true
+		}
 	}
+	return false
+}
 
-	// Set a flag on the first gun to indicate there are more guns
-	if len(allGuns) > 0 {
-		allGuns[0].HasMoreGuns = true
-		allGuns[0].TotalGuns = len(allGuns)
+// FindGunsByOwner retrieves all guns belonging to a specific owner
+func FindGunsByOwner(db *gorm.DB, ownerID uint) ([]Gun, error) {
+	var guns []Gun
+	if err := db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").Where("owner_id = ?", ownerID).Find(&guns).Error; err != nil {
+		return nil, err
 	}
-
-	return allGuns[:2], nil
+	return guns, nil
 }
 
 // FindGunByID retrieves a gun by its ID, ensuring it belongs to the specified owner
@@ -73,6 +116,18 @@ func FindGunByID(db *gorm.DB, id uint, ownerID uint) (*Gun, error) {
 	return &gun, nil
 }
 
+// FindGunsByIDs retrieves the given guns belonging to an owner, ordered by name
+func FindGunsByIDs(db *gorm.DB, ids []uint, ownerID uint) ([]Gun, error) {
+	var guns []Gun
+	if len(ids) == 0 {
+		return guns, nil
+	}
+	if err := db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").Where("id IN ? AND owner_id = ?", ids, ownerID).Order("name").Find(&guns).Error; err != nil {
+		return nil, err
+	}
+	return guns, nil
+}
+
 // CreateGun creates a new gun in the database
 func CreateGun(db *gorm.DB, gun *Gun) error {
 	return db.Create(gun).Error
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginSession is a server-side session; the browser only holds the session ID in a cookie
+type LoginSession struct {
+	gorm.Model
+	Token      string    `gorm:"uniqueIndex;not null"` // SHA-256 of the session ID, so the table can't be used to hijack sessions
+	UserID     uint      `gorm:"index"`                // Zero for sessions that aren't signed in
+	Data       string    `gorm:"type:text"`            // JSON-encoded session values
+	UserAgent  string    // Browser that signed in
+	IPAddress  string    // Address that signed in
+	LastSeenAt time.Time // When the session was last used
+	ExpiresAt  time.Time `gorm:"index"` // Pushed back each time the session is used
+}
+
+// TableName specifies the table name for the LoginSession model
+func (LoginSession) TableName() string {
+	return "login_sessions"
+}
+
+// IsExpired reports whether the session has expired
+func (s *LoginSession) IsExpired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
+
+// Device describes the browser and operating system in the session's user agent, such as "Firefox on Linux"
+func (s *LoginSession) Device() string {
+	ua := s.UserAgent
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	// Order matters: Edge and Opera mention Chrome, and Chrome mentions Safari
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	// Android and iOS user agents also mention Linux and Mac OS X
+	for _, os := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, os.token) {
+			return browser + " on " + os.name
+		}
+	}
+	return browser
+}
+
+// FindLoginSessionByToken retrieves a session by its hashed ID
+func FindLoginSessionByToken(db *gorm.DB, token string) (*LoginSession, error) {
+	var session LoginSession
+	if err := db.Where("token = ?", token).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindLoginSessionsByUser retrieves a user's unexpired sessions, most recently used first
+func FindLoginSessionsByUser(db *gorm.DB, userID uint, now time.Time) ([]LoginSession, error) {
+	var sessions []LoginSession
+	if err := db.Where("user_id = ? AND expires_at > ?", userID, now).Order("last_seen_at desc, id desc").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// SaveLoginSession creates a session, or saves the values and expiry of an existing one
+func SaveLoginSession(db *gorm.DB, session *LoginSession) error {
+	if session.ID == 0 {
+		return db.Create(session).Error
+	}
+	// Only ever update, so a request still using a session can't bring it back after it's revoked
+	return db.Model(session).Select("data", "last_seen_at", "expires_at").Updates(session).Error
+}
+
+// DeleteLoginSession removes a session by its hashed ID
+func DeleteLoginSession(db *gorm.DB, token string) error {
+	return db.Unscoped().Where("token = ?", token).Delete(&LoginSession{}).Error
+}
+
+// DeleteUserLoginSession removes one of a user's sessions and reports whether it existed
+func DeleteUserLoginSession(db *gorm.DB, userID, id uint) (bool, error) {
+	result := db.Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&LoginSession{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteUserLoginSessions removes all of a user's sessions except the one with exceptToken, which may be empty,
+// and returns how many were removed
+func DeleteUserLoginSessions(db *gorm.DB, userID uint, exceptToken string) (int64, error) {
+	result := db.Unscoped().Where("user_id = ? AND token <> ?", userID, exceptToken).Delete(&LoginSession{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteExpiredLoginSessions removes sessions that expired before now and returns how many were removed
+func DeleteExpiredLoginSessions(db *gorm.DB, now time.Time) (int64, error) {
+	result := db.Unscoped().Where("expires_at <= ?", now).Delete(&LoginSession{})
+	return result.RowsAffected, result.Error
+}
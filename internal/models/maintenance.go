@@ -0,0 +1,203 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Maintenance record types
+const (
+	MaintenanceTypeCleaning        = "cleaning"
+	MaintenanceTypePartReplacement = "part_replacement"
+	MaintenanceTypeGunsmith        = "gunsmith"
+)
+
+// MaintenanceTypes lists the supported maintenance record types in display order
+var MaintenanceTypes = []string{
+	MaintenanceTypeCleaning,
+	MaintenanceTypePartReplacement,
+	MaintenanceTypeGunsmith,
+}
+
+// MaintenanceRecord represents a cleaning, part replacement or gunsmith visit for a gun
+type MaintenanceRecord struct {
+	gorm.Model
+	GunID       uint
+	Gun         Gun `gorm:"foreignKey:GunID"`
+	OwnerID     uint
+	Type        string
+	Date        time.Time
+	Description string
+	Cost        int64 // Cost in cents
+	Notes       string
+}
+
+// TableName specifies the table name for the MaintenanceRecord model
+func (MaintenanceRecord) TableName() string {
+	return "maintenance_records"
+}
+
+// FormatCost returns the cost formatted in dollars
+func (m *MaintenanceRecord) FormatCost() string {
+	return "$" + formatDollars(float64(m.Cost)/100.0)
+}
+
+// FormatMaintenanceType returns a display name for a maintenance type
+func FormatMaintenanceType(maintenanceType string) string {
+	switch maintenanceType {
+	case MaintenanceTypeCleaning:
+		return "Cleaning"
+	case MaintenanceTypePartReplacement:
+		return "Part Replacement"
+	case MaintenanceTypeGunsmith:
+		return "Gunsmith Work"
+	default:
+		return maintenanceType
+	}
+}
+
+// IsValidMaintenanceType checks if the maintenance type is supported
+func IsValidMaintenanceType(maintenanceType string) bool {
+	for _, t := range MaintenanceTypes {
+		if t == maintenanceType {
+			return true
+		}
+	}
+	return false
+}
+
+// FindMaintenanceRecordsByGun retrieves all maintenance records for a gun, newest first
+func FindMaintenanceRecordsByGun(db *gorm.DB, gunID uint, ownerID uint) ([]MaintenanceRecord, error) {
+	var records []MaintenanceRecord
+	if err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).Order("date DESC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CreateMaintenanceRecord creates a maintenance record and clears any pending reminder for the gun
+func CreateMaintenanceRecord(db *gorm.DB, record *MaintenanceRecord) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Gun{}).Where("id = ?", record.GunID).Update("maintenance_reminder_sent", false).Error
+	})
+}
+
+// DeleteMaintenanceRecord deletes a maintenance record from the database
+func DeleteMaintenanceRecord(db *gorm.DB, id uint, ownerID uint) error {
+	return db.Where("id = ? AND owner_id = ?", id, ownerID).Delete(&MaintenanceRecord{}).Error
+}
+
+// UpdateMaintenanceIntervals sets the service intervals for a gun and clears any pending reminder
+func UpdateMaintenanceIntervals(db *gorm.DB, gun *Gun, days int, rounds int) error {
+	gun.MaintenanceIntervalDays = days
+	gun.MaintenanceIntervalRounds = rounds
+	gun.MaintenanceReminderSent = false
+	return db.Model(&Gun{}).Where("id = ? AND owner_id = ?", gun.ID, gun.OwnerID).Updates(map[string]interface{}{
+		"maintenance_interval_days":   days,
+		"maintenance_interval_rounds": rounds,
+		"maintenance_reminder_sent":   false,
+	}).Error
+}
+
+// MaintenanceStatus describes how far a gun is from its next scheduled service
+type MaintenanceStatus struct {
+	Gun                Gun
+	LastService        *time.Time
+	DaysSinceService   int
+	RoundsSinceService int
+}
+
+// DaysOverdue reports whether the gun has passed its day-based service interval
+func (s MaintenanceStatus) DaysOverdue() bool {
+	return s.Gun.MaintenanceIntervalDays > 0 && s.DaysSinceService >= s.Gun.MaintenanceIntervalDays
+}
+
+// RoundsOverdue reports whether the gun has passed its round-based service interval
+func (s MaintenanceStatus) RoundsOverdue() bool {
+	return s.Gun.MaintenanceIntervalRounds > 0 && s.RoundsSinceService >= s.Gun.MaintenanceIntervalRounds
+}
+
+// IsOverdue reports whether the gun is due for service
+func (s MaintenanceStatus) IsOverdue() bool {
+	return s.DaysOverdue() || s.RoundsOverdue()
+}
+
+// Reason describes why the gun is due for service
+func (s MaintenanceStatus) Reason() string {
+	var reasons []string
+	if s.RoundsOverdue() {
+		reasons = append(reasons, fmt.Sprintf("%d rounds fired since last service (interval: %d)", s.RoundsSinceService, s.Gun.MaintenanceIntervalRounds))
+	}
+	if s.DaysOverdue() {
+		reasons = append(reasons, fmt.Sprintf("%d days since last service (interval: %d)", s.DaysSinceService, s.Gun.MaintenanceIntervalDays))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// GetMaintenanceStatus calculates the days and rounds since a gun was last serviced
+func GetMaintenanceStatus(db *gorm.DB, gun Gun, now time.Time) (MaintenanceStatus, error) {
+	status := MaintenanceStatus{Gun: gun}
+
+	// Service is measured from the last maintenance record, or from when the gun was acquired
+	since := gun.CreatedAt
+	if gun.Acquired != nil {
+		since = *gun.Acquired
+	}
+
+	var last MaintenanceRecord
+	err := db.Where("gun_id = ?", gun.ID).Order("date DESC").First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return status, err
+	}
+	if err == nil {
+		since = last.Date
+		status.LastService = &last.Date
+	}
+
+	status.DaysSinceService = int(now.Sub(since).Hours() / 24)
+
+	// Count the rounds fired in range sessions after the last service
+	query := db.Model(&RangeSessionItem{}).
+		Select("COALESCE(SUM(range_session_items.rounds_fired), 0) AS rounds").
+		Joins("JOIN range_sessions ON range_sessions.id = range_session_items.range_session_id AND range_sessions.deleted_at IS NULL").
+		Where("range_session_items.gun_id = ?", gun.ID)
+	if status.LastService != nil {
+		query = query.Where("range_sessions.date > ?", since)
+	}
+
+	var total struct{ Rounds int }
+	if err := query.Scan(&total).Error; err != nil {
+		return status, err
+	}
+	status.RoundsSinceService = total.Rounds
+
+	return status, nil
+}
+
+// FindOverdueGuns returns the maintenance status of every gun the owner has that is due for service
+func FindOverdueGuns(db *gorm.DB, ownerID uint, now time.Time) ([]MaintenanceStatus, error) {
+	var guns []Gun
+	if err := db.Where("owner_id = ? AND (maintenance_interval_days > 0 OR maintenance_interval_rounds > 0)", ownerID).Find(&guns).Error; err != nil {
+		return nil, err
+	}
+
+	var overdue []MaintenanceStatus
+	for _, gun := range guns {
+		status, err := GetMaintenanceStatus(db, gun, now)
+		if err != nil {
+			return nil, err
+		}
+		if status.IsOverdue() {
+			overdue = append(overdue, status)
+		}
+	}
+
+	return overdue, nil
+}
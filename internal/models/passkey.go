@@ -0,0 +1,116 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasskeyCredential is a passkey a user can log in with instead of their password.
+// Only the public key is stored; the private key never leaves the user's device.
+type PasskeyCredential struct {
+	gorm.Model
+	UserID          uint   `gorm:"index;not null"`
+	Name            string `gorm:"not null"`
+	CredentialID    []byte `gorm:"uniqueIndex;not null"`
+	PublicKey       []byte `gorm:"not null"` // COSE-encoded public key
+	AttestationType string
+	Transports      string // Comma-separated ways the browser can reach the authenticator, such as "usb,nfc"
+	AAGUID          []byte // Identifies the make and model of the authenticator
+	SignCount       uint32 // Counts up with each use on authenticators that keep a counter, so a cloned key can be spotted
+	BackupEligible  bool
+	BackupState     bool
+	LastUsedAt      *time.Time
+}
+
+// TableName specifies the table name for the PasskeyCredential model
+func (PasskeyCredential) TableName() string {
+	return "passkey_credentials"
+}
+
+// TransportList returns the passkey's transports as a list
+func (p *PasskeyCredential) TransportList() []string {
+	if p.Transports == "" {
+		return nil
+	}
+	return strings.Split(p.Transports, ",")
+}
+
+// FindPasskeyCredentialsByUser retrieves a user's passkeys, oldest first
+func FindPasskeyCredentialsByUser(db *gorm.DB, userID uint) ([]PasskeyCredential, error) {
+	var credentials []PasskeyCredential
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// FindPasskeyCredentialByCredentialID retrieves a passkey by the ID its authenticator gave it
+func FindPasskeyCredentialByCredentialID(db *gorm.DB, credentialID []byte) (*PasskeyCredential, error) {
+	var credential PasskeyCredential
+	if err := db.Where("credential_id = ?", credentialID).First(&credential).Error; err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// RecordPasskeyUse saves the counter and backup state from a login with the passkey
+func RecordPasskeyUse(db *gorm.DB, credential *PasskeyCredential, now time.Time) error {
+	credential.LastUsedAt = &now
+	return db.Model(credential).Updates(map[string]interface{}{
+		"sign_count":   credential.SignCount,
+		"backup_state": credential.BackupState,
+		"last_used_at": now,
+	}).Error
+}
+
+// RenamePasskeyCredential renames one of a user's passkeys and reports whether it existed
+func RenamePasskeyCredential(db *gorm.DB, userID, id uint, name string) (bool, error) {
+	result := db.Model(&PasskeyCredential{}).Where("id = ? AND user_id = ?", id, userID).Update("name", name)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeletePasskeyCredential removes one of a user's passkeys and reports whether it existed
+func DeletePasskeyCredential(db *gorm.DB, userID, id uint) (bool, error) {
+	result := db.Unscoped().Where("id = ? AND user_id = ?", id, userID).Delete(&PasskeyCredential{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// PasskeyChallenge holds the state of a passkey registration or login between the browser asking for it
+// and the authenticator answering. Each challenge can be answered once.
+type PasskeyChallenge struct {
+	gorm.Model
+	Token     string    `gorm:"uniqueIndex;not null"` // SHA-256 of the token in the browser's cookie
+	UserID    uint      // Zero for logins, where the user isn't known until the passkey answers
+	Purpose   string    `gorm:"not null"`  // "registration" or "login"
+	Data      string    `gorm:"type:text"` // JSON-encoded challenge and options
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// TableName specifies the table name for the PasskeyChallenge model
+func (PasskeyChallenge) TableName() string {
+	return "passkey_challenges"
+}
+
+// TakePasskeyChallenge removes and returns an unexpired challenge by its hashed token and purpose,
+// or gorm.ErrRecordNotFound. Removing it is conditional, so two requests can't both answer it.
+func TakePasskeyChallenge(db *gorm.DB, token, purpose string, now time.Time) (*PasskeyChallenge, error) {
+	var challenge PasskeyChallenge
+	if err := db.Where("token = ? AND purpose = ?", token, purpose).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+	result := db.Unscoped().Where("id = ?", challenge.ID).Delete(&PasskeyChallenge{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || !now.Before(challenge.ExpiresAt) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &challenge, nil
+}
+
+// DeleteExpiredPasskeyChallenges removes challenges that expired before now
+func DeleteExpiredPasskeyChallenges(db *gorm.DB, now time.Time) error {
+	return db.Unscoped().Where("expires_at <= ?", now).Delete(&PasskeyChallenge{}).Error
+}
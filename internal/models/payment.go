@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -16,7 +17,15 @@ type Payment struct {
 	PaymentType string // "subscription", "one-time", etc.
 	Status      string // "succeeded", "failed", "pending", etc.
 	Description string
+	Tier        string // Subscription tier paid for, empty for payments not tied to a plan
 	StripeID    string // Stripe payment intent ID
+	TaxAmount   int64  // Portion of Amount that was tax, in cents
+	PeriodStart *time.Time
+	PeriodEnd   *time.Time // Nil for one-time purchases such as lifetime plans
+	Refunded    int64      // Cents refunded so far; Status becomes "refunded" once it's all refunded
+
+	// Subscription a checkout started, so the subscription's first invoice can be matched to its payment
+	StripeSubscriptionID string
 }
 
 // FormatAmount formats the amount as a string with the currency symbol
@@ -37,6 +46,50 @@ func (p *Payment) FormatAmount() string {
 	}
 }
 
+// FormatTax formats the tax included in the amount
+func (p *Payment) FormatTax() string {
+	tax := Payment{Amount: p.TaxAmount, Currency: p.Currency}
+	return tax.FormatAmount()
+}
+
+// FormatSubtotal formats the amount before tax
+func (p *Payment) FormatSubtotal() string {
+	subtotal := Payment{Amount: p.Amount - p.TaxAmount, Currency: p.Currency}
+	return subtotal.FormatAmount()
+}
+
+// FormatRefunded formats the amount refunded so far
+func (p *Payment) FormatRefunded() string {
+	refunded := Payment{Amount: p.Refunded, Currency: p.Currency}
+	return refunded.FormatAmount()
+}
+
+// RefundableAmount returns how many cents of the payment can still be refunded
+func (p *Payment) RefundableAmount() int64 {
+	if p.Status != "succeeded" {
+		return 0
+	}
+	return p.Amount - p.Refunded
+}
+
+// BillingPeriod describes the period the payment covers, or "" if it isn't for a period
+func (p *Payment) BillingPeriod() string {
+	if p.PeriodStart == nil || p.PeriodEnd == nil {
+		return ""
+	}
+	return p.PeriodStart.Format("Jan 2, 2006") + " - " + p.PeriodEnd.Format("Jan 2, 2006")
+}
+
+// ReceiptNumber returns the number printed on the payment's receipt
+func (p *Payment) ReceiptNumber() string {
+	return fmt.Sprintf("VA-%06d", p.ID)
+}
+
+// HasReceipt returns whether a receipt can be issued for the payment
+func (p *Payment) HasReceipt() bool {
+	return p.Status == "succeeded" || p.Status == "refunded"
+}
+
 // formatDollars formats a float as a string with 2 decimal places
 func formatDollars(amount float64) string {
 	return fmt.Sprintf("%.2f", amount)
@@ -70,7 +123,114 @@ func FindPaymentByID(db *gorm.DB, id uint) (*Payment, error) {
 	return &payment, nil
 }
 
+// FindPaymentByIDAndUser retrieves a payment by its ID, scoped to the user who made it
+func FindPaymentByIDAndUser(db *gorm.DB, id, userID uint) (*Payment, error) {
+	var payment Payment
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// FindPaymentByStripeID retrieves a payment by the Stripe object it was recorded from
+func FindPaymentByStripeID(db *gorm.DB, stripeID string) (*Payment, error) {
+	var payment Payment
+	if err := db.Where("stripe_id = ?", stripeID).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// FindPaidPaymentByStripeID retrieves a payment that went through by the Stripe object it was recorded from,
+// skipping failed attempts to pay the same invoice
+func FindPaidPaymentByStripeID(db *gorm.DB, stripeID string) (*Payment, error) {
+	var payment Payment
+	if err := db.Where("stripe_id = ? AND status IN ?", stripeID, []string{"succeeded", "refunded"}).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// FindPaidPaymentByStripeIDs retrieves a payment that went through by any of the Stripe objects it may have
+// been recorded from, such as an invoice and its payment intent and charge
+func FindPaidPaymentByStripeIDs(db *gorm.DB, stripeIDs ...string) (*Payment, error) {
+	var ids []string
+	for _, id := range stripeIDs {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var payment Payment
+	if err := db.Where("stripe_id IN ? AND status IN ?", ids, []string{"succeeded", "refunded"}).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// FindSubscriptionStartPayment retrieves the payment for the checkout that started a Stripe subscription
+func FindSubscriptionStartPayment(db *gorm.DB, subscriptionID string) (*Payment, error) {
+	var payment Payment
+	if err := db.Where("stripe_subscription_id = ? AND stripe_subscription_id <> ''", subscriptionID).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
 // UpdatePayment updates an existing payment in the database
 func UpdatePayment(db *gorm.DB, payment *Payment) error {
 	return db.Save(payment).Error
 }
+
+// SettlePendingPayment sets the outcome of a payment recorded as pending, such as a plan change's prorated invoice,
+// once the provider reports it. It reports false if there was no pending payment for the invoice.
+func SettlePendingPayment(db *gorm.DB, stripeID, status string) (bool, error) {
+	result := db.Model(&Payment{}).
+		Where("stripe_id = ? AND stripe_id <> '' AND status = ?", stripeID, "pending").
+		Update("status", status)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReserveRefund adds amount cents to the payment's refunded total before the provider is asked to refund it.
+// It reports false if the payment has changed since it was loaded or has less than amount left to refund.
+func ReserveRefund(db *gorm.DB, payment *Payment, amount int64) (bool, error) {
+	result := db.Model(&Payment{}).
+		Where("id = ? AND status = ? AND refunded = ? AND refunded + ? <= amount", payment.ID, "succeeded", payment.Refunded, amount).
+		Update("refunded", gorm.Expr("refunded + ?", amount))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+	payment.Refunded += amount
+	return true, nil
+}
+
+// ReleaseRefund gives back a refund reserved with ReserveRefund that the provider didn't make
+func ReleaseRefund(db *gorm.DB, payment *Payment, amount int64) error {
+	if err := db.Model(&Payment{}).
+		Where("id = ? AND refunded >= ?", payment.ID, amount).
+		Update("refunded", gorm.Expr("refunded - ?", amount)).Error; err != nil {
+		return err
+	}
+	payment.Refunded -= amount
+	return nil
+}
+
+// MarkRefundedIfFull sets the payment's status to refunded once all of it has been refunded
+func MarkRefundedIfFull(db *gorm.DB, payment *Payment) error {
+	if payment.Refunded < payment.Amount {
+		return nil
+	}
+	if err := db.Model(&Payment{}).
+		Where("id = ? AND refunded >= amount", payment.ID).
+		Update("status", "refunded").Error; err != nil {
+		return err
+	}
+	payment.Status = "refunded"
+	return nil
+}
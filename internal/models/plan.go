@@ -0,0 +1,142 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Plan intervals, an empty interval is a one-time purchase
+const (
+	PlanIntervalMonth = "month"
+	PlanIntervalYear  = "year"
+)
+
+// Plan represents a subscription tier as sold on the pricing page
+type Plan struct {
+	gorm.Model
+	Tier            string `gorm:"uniqueIndex;not null"` // Stored on users as their subscription tier
+	Name            string // Display name, e.g. "Liking It"
+	Description     string // Short tagline shown under the name
+	Features        string // Pricing page bullet points, one per line
+	Badge           string // Optional highlight such as "Popular"
+	Price           int64  // Price in cents, zero for the free tier
+	Currency        string `gorm:"default:'usd'"`
+	Interval        string // "month", "year" or empty for a one-time purchase
+	TrialDays       int    // Free days before the first charge, recurring plans only
+	StripeProductID string
+	Active          bool
+	SortOrder       int
+
+	// Entitlements
+	MaxGuns            int   // -1 for unlimited
+	MaxAmmo            int   // -1 for unlimited
+	MaxAttachmentBytes int64 // Photos, receipts and manuals, including thumbnails
+	Exports            bool
+	APIAccess          bool
+}
+
+// TableName specifies the table name for the Plan model
+func (Plan) TableName() string {
+	return "plans"
+}
+
+// IsFree reports whether the plan costs nothing
+func (p *Plan) IsFree() bool {
+	return p.Price == 0
+}
+
+// IsRecurring reports whether the plan is billed on an interval
+func (p *Plan) IsRecurring() bool {
+	return p.Interval != ""
+}
+
+// ExpiresAfter returns when a term of the plan starting at start runs out. One-time purchases
+// last 100 years, which is effectively forever.
+func (p *Plan) ExpiresAfter(start time.Time) time.Time {
+	switch p.Interval {
+	case PlanIntervalMonth:
+		return start.AddDate(0, 1, 0)
+	case PlanIntervalYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(100, 0, 0)
+	}
+}
+
+// FormatPrice formats the price with the currency symbol, leaving off cents for whole amounts
+func (p *Plan) FormatPrice() string {
+	payment := Payment{Amount: p.Price, Currency: p.Currency}
+	return strings.TrimSuffix(payment.FormatAmount(), ".00")
+}
+
+// FeatureList returns the pricing page bullet points
+func (p *Plan) FeatureList() []string {
+	var features []string
+	for _, line := range strings.Split(p.Features, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			features = append(features, line)
+		}
+	}
+	return features
+}
+
+// FindPlans retrieves every plan in display order
+func FindPlans(db *gorm.DB) ([]Plan, error) {
+	var plans []Plan
+	if err := db.Order("sort_order, price").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// FindActivePlans retrieves the plans currently offered, in display order
+func FindActivePlans(db *gorm.DB) ([]Plan, error) {
+	var plans []Plan
+	if err := db.Where("active = ?", true).Order("sort_order, price").Find(&plans).Error; err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// FindPlanByID retrieves a plan by its ID
+func FindPlanByID(db *gorm.DB, id uint) (*Plan, error) {
+	var plan Plan
+	if err := db.First(&plan, id).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// FindActivePlanByTier retrieves a plan that is currently offered by its tier
+func FindActivePlanByTier(db *gorm.DB, tier string) (*Plan, error) {
+	var plan Plan
+	if err := db.Where("tier = ? AND active = ?", tier, true).First(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// FindPlanByTier retrieves a plan by its tier, whether or not it's still offered
+func FindPlanByTier(db *gorm.DB, tier string) (*Plan, error) {
+	var plan Plan
+	if err := db.Where("tier = ?", tier).First(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// FindPlanByStripeID retrieves a plan by the Stripe price or product it's sold as, whether or not it's still offered
+func FindPlanByStripeID(db *gorm.DB, stripeID string) (*Plan, error) {
+	var plan Plan
+	if err := db.Where("stripe_product_id = ? AND stripe_product_id <> ''", stripeID).First(&plan).Error; err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// UpdatePlan updates an existing plan in the database
+func UpdatePlan(db *gorm.DB, plan *Plan) error {
+	return db.Save(plan).Error
+}
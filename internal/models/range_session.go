@@ -0,0 +1,152 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInsufficientAmmo is returned when a range session uses more ammo than is on hand
+var ErrInsufficientAmmo = errors.New("not enough ammo on hand")
+
+// RangeSession represents a trip to the range
+type RangeSession struct {
+	gorm.Model
+	Date     time.Time
+	Location string
+	Notes    string
+	OwnerID  uint
+	Owner    User               `gorm:"foreignKey:OwnerID"`
+	Items    []RangeSessionItem `gorm:"foreignKey:RangeSessionID"`
+}
+
+// TableName specifies the table name for the RangeSession model
+func (RangeSession) TableName() string {
+	return "range_sessions"
+}
+
+// TotalRounds returns the number of rounds fired across all guns in the session
+func (s *RangeSession) TotalRounds() int {
+	total := 0
+	for _, item := range s.Items {
+		total += item.RoundsFired
+	}
+	return total
+}
+
+// RangeSessionItem records the rounds fired through a single gun during a range session
+type RangeSessionItem struct {
+	gorm.Model
+	RangeSessionID uint
+	GunID          uint
+	Gun            Gun `gorm:"foreignKey:GunID"`
+	AmmoID         *uint
+	Ammo           *Ammo `gorm:"foreignKey:AmmoID"`
+	RoundsFired    int
+}
+
+// TableName specifies the table name for the RangeSessionItem model
+func (RangeSessionItem) TableName() string {
+	return "range_session_items"
+}
+
+// GunRangeStats summarizes the range history of a single gun
+type GunRangeStats struct {
+	RoundsFired int
+	LastFired   *time.Time
+}
+
+// FindRangeSessionsByOwner retrieves all range sessions belonging to a specific owner, newest first
+func FindRangeSessionsByOwner(db *gorm.DB, ownerID uint) ([]RangeSession, error) {
+	var sessions []RangeSession
+	if err := db.Preload("Items").Where("owner_id = ?", ownerID).Order("date DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// FindRangeSessionByID retrieves a range session by its ID, ensuring it belongs to the specified owner
+func FindRangeSessionByID(db *gorm.DB, id uint, ownerID uint) (*RangeSession, error) {
+	var session RangeSession
+	if err := db.Preload("Items.Gun").Preload("Items.Ammo").Preload("Items.Ammo.Caliber").Where("id = ? AND owner_id = ?", id, ownerID).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreateRangeSession creates a range session and its items, optionally deducting the rounds fired from ammo stock
+func CreateRangeSession(db *gorm.DB, session *RangeSession, decrementAmmo bool) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+
+		if !decrementAmmo {
+			return nil
+		}
+
+		for _, item := range session.Items {
+			if item.AmmoID == nil || item.RoundsFired == 0 {
+				continue
+			}
+
+			result := tx.Model(&Ammo{}).
+				Where("id = ? AND owner_id = ? AND quantity >= ?", *item.AmmoID, session.OwnerID, item.RoundsFired).
+				Update("quantity", gorm.Expr("quantity - ?", item.RoundsFired))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInsufficientAmmo
+			}
+		}
+
+		return nil
+	})
+}
+
+// DeleteRangeSession deletes a range session and its items from the database
+func DeleteRangeSession(db *gorm.DB, id uint, ownerID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var session RangeSession
+		if err := tx.Where("id = ? AND owner_id = ?", id, ownerID).First(&session).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("range_session_id = ?", session.ID).Delete(&RangeSessionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&session).Error
+	})
+}
+
+// FindGunRangeStats returns the lifetime round count and last fired date for a gun
+func FindGunRangeStats(db *gorm.DB, gunID uint) (GunRangeStats, error) {
+	var stats GunRangeStats
+
+	// Sum the rounds fired across all sessions
+	var total struct{ Rounds int }
+	if err := db.Model(&RangeSessionItem{}).
+		Select("COALESCE(SUM(range_session_items.rounds_fired), 0) AS rounds").
+		Joins("JOIN range_sessions ON range_sessions.id = range_session_items.range_session_id AND range_sessions.deleted_at IS NULL").
+		Where("range_session_items.gun_id = ?", gunID).
+		Scan(&total).Error; err != nil {
+		return stats, err
+	}
+	stats.RoundsFired = total.Rounds
+
+	// Find the most recent session the gun was fired in
+	var last RangeSession
+	err := db.Joins("JOIN range_session_items ON range_session_items.range_session_id = range_sessions.id AND range_session_items.deleted_at IS NULL").
+		Where("range_session_items.gun_id = ?", gunID).
+		Order("range_sessions.date DESC").
+		First(&last).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return stats, err
+	}
+	if err == nil {
+		stats.LastFired = &last.Date
+	}
+
+	return stats, nil
+}
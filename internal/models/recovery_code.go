@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code that can stand in for a two-factor code when the authenticator app is lost.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"not null"`
+	UsedAt   *time.Time
+}
+
+// TableName specifies the table name for the RecoveryCode model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// ReplaceRecoveryCodes removes a user's recovery codes and stores new ones from their hashes
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteRecoveryCodes(tx, userID); err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used and reports whether there was one.
+// The update is conditional, so two requests can't both use the same code.
+func UseRecoveryCode(db *gorm.DB, userID uint, hash string, now time.Time) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes a user has left
+func CountUnusedRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// DeleteRecoveryCodes removes all of a user's recovery codes
+func DeleteRecoveryCodes(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
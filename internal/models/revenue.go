@@ -0,0 +1,327 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// averageMonth is the mean length of a month, used to turn a billing period into monthly revenue
+const averageMonth = time.Duration(365.2425 * 24 * float64(time.Hour) / 12)
+
+// RevenueMonth is one month of a revenue report
+type RevenueMonth struct {
+	Month        time.Time      // First day of the month
+	Revenue      int64          // Net revenue collected during the month, in cents
+	MRR          int64          // Monthly recurring revenue at the end of the month, in cents
+	NewCustomers int            // Users whose first payment was during the month
+	Churned      int            // Subscribers whose paid period lapsed during the month
+	Tiers        map[string]int // Paying customers by tier at the end of the month
+}
+
+// RevenueReport summarizes revenue and paying customers over a date range.
+// Everything is derived from payments: a customer is paying while a payment's
+// billing period covers them, and lifetime purchases count from the day they're made.
+type RevenueReport struct {
+	Start    time.Time
+	End      time.Time // Exclusive
+	Currency string
+
+	MRR      int64 // Monthly recurring revenue at the end of the range, in cents
+	StartMRR int64 // Monthly recurring revenue at the start of the range, in cents
+	ARR      int64 // MRR annualized
+
+	Revenue         int64 // Net of tax and refunds, collected during the range
+	LifetimeRevenue int64 // Net of tax and refunds, collected ever
+	PayingCustomers int   // Users who have ever paid
+	LTV             int64 // Lifetime revenue per paying customer
+
+	SubscribersAtStart int     // Recurring subscribers at the start of the range
+	Churned            int     // Subscribers at the start who weren't paying by the end
+	ChurnRate          float64 // Percentage of subscribers at the start who churned
+
+	Conversions    int     // Users whose first payment was during the range
+	ConversionRate float64 // Percentage of users who hadn't paid before the range that converted
+
+	Months []RevenueMonth
+	Tiers  []string // Tiers appearing in Months, in plan order
+}
+
+// FormatCents formats an amount in the report's currency
+func (r *RevenueReport) FormatCents(cents int64) string {
+	payment := Payment{Amount: cents, Currency: r.Currency}
+	return payment.FormatAmount()
+}
+
+// MRRGrowth returns the percentage change in MRR over the range
+func (r *RevenueReport) MRRGrowth() float64 {
+	if r.StartMRR == 0 {
+		if r.MRR > 0 {
+			return 100
+		}
+		return 0
+	}
+	return float64(r.MRR-r.StartMRR) / float64(r.StartMRR) * 100
+}
+
+// TierMix returns each tier's share of paying customers in a month, as a percentage
+func (m RevenueMonth) TierMix(tier string) float64 {
+	total := 0
+	for _, count := range m.Tiers {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Tiers[tier]) / float64(total) * 100
+}
+
+// revenuePayment is a paid payment with its tier and billing period worked out
+type revenuePayment struct {
+	Payment
+	tier      string
+	recurring bool
+	start     time.Time
+	end       time.Time // Zero for lifetime purchases
+	monthly   int64     // Contribution to MRR while in force
+}
+
+// inForce reports whether the payment keeps its customer paying at t
+func (p revenuePayment) inForce(t time.Time) bool {
+	if p.CreatedAt.After(t) || p.Status != "succeeded" {
+		return false
+	}
+	if !p.recurring {
+		return true
+	}
+	return !p.start.After(t) && t.Before(p.end)
+}
+
+// BuildRevenueReport calculates revenue and subscription metrics for the range [start, end)
+func BuildRevenueReport(db *gorm.DB, start, end time.Time) (*RevenueReport, error) {
+	plans, err := FindPlans(db)
+	if err != nil {
+		return nil, err
+	}
+	planByTier := make(map[string]Plan)
+	for _, plan := range plans {
+		planByTier[plan.Tier] = plan
+	}
+
+	// Refunded payments still count towards revenue for whatever wasn't refunded
+	var rows []Payment
+	if err := db.Where("status IN ?", []string{"succeeded", "refunded"}).Order("created_at, id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var usersBeforeEnd int64
+	if err := db.Model(&User{}).Where("created_at < ?", end).Count(&usersBeforeEnd).Error; err != nil {
+		return nil, err
+	}
+
+	report := &RevenueReport{Start: start, End: end, Currency: "usd"}
+	if len(plans) > 0 && plans[0].Currency != "" {
+		report.Currency = plans[0].Currency
+	}
+
+	var payments []revenuePayment
+	firstPaid := make(map[uint]time.Time)
+	for _, row := range rows {
+		net := netRevenue(row)
+		report.LifetimeRevenue += net
+		if !row.CreatedAt.Before(start) && row.CreatedAt.Before(end) {
+			report.Revenue += net
+		}
+
+		// Free trials and other zero amount checkouts don't make a paying customer
+		if row.Amount <= 0 && row.PaymentType != "subscription_change" {
+			continue
+		}
+		if _, ok := firstPaid[row.UserID]; !ok && row.Amount > 0 {
+			firstPaid[row.UserID] = row.CreatedAt
+		}
+		payments = append(payments, newRevenuePayment(row, planByTier))
+	}
+
+	report.PayingCustomers = len(firstPaid)
+	if report.PayingCustomers > 0 {
+		report.LTV = report.LifetimeRevenue / int64(report.PayingCustomers)
+	}
+
+	// Churn compares recurring subscribers at the start with who's still paying at the end
+	atStart := customersAt(payments, start)
+	atEnd := customersAt(payments, end)
+	report.StartMRR = recurringRevenue(atStart)
+	report.MRR = recurringRevenue(atEnd)
+	report.ARR = report.MRR * 12
+	for userID, payment := range atStart {
+		if !payment.recurring {
+			continue
+		}
+		report.SubscribersAtStart++
+		if _, ok := atEnd[userID]; !ok {
+			report.Churned++
+		}
+	}
+	report.ChurnRate = percentage(report.Churned, report.SubscribersAtStart)
+
+	// Conversions are first payments made during the range
+	paidBeforeStart := 0
+	for _, paidAt := range firstPaid {
+		switch {
+		case paidAt.Before(start):
+			paidBeforeStart++
+		case paidAt.Before(end):
+			report.Conversions++
+		}
+	}
+	report.ConversionRate = percentage(report.Conversions, int(usersBeforeEnd)-paidBeforeStart)
+
+	// Break the range down by calendar month
+	seenTiers := make(map[string]bool)
+	for month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, start.Location()); month.Before(end); month = month.AddDate(0, 1, 0) {
+		from, to := month, month.AddDate(0, 1, 0)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+
+		row := RevenueMonth{Month: month, Tiers: make(map[string]int)}
+		for _, payment := range payments {
+			if !payment.CreatedAt.Before(from) && payment.CreatedAt.Before(to) {
+				row.Revenue += netRevenue(payment.Payment)
+			}
+		}
+		for _, paidAt := range firstPaid {
+			if !paidAt.Before(from) && paidAt.Before(to) {
+				row.NewCustomers++
+			}
+		}
+
+		monthStart, monthEnd := customersAt(payments, from), customersAt(payments, to)
+		for userID, payment := range monthStart {
+			if _, ok := monthEnd[userID]; payment.recurring && !ok {
+				row.Churned++
+			}
+		}
+		for _, payment := range monthEnd {
+			row.Tiers[payment.tier]++
+			seenTiers[payment.tier] = true
+		}
+		row.MRR = recurringRevenue(monthEnd)
+		report.Months = append(report.Months, row)
+	}
+
+	// List tiers in plan order, with any that no longer have a plan at the end
+	for _, plan := range plans {
+		if seenTiers[plan.Tier] {
+			report.Tiers = append(report.Tiers, plan.Tier)
+			delete(seenTiers, plan.Tier)
+		}
+	}
+	var others []string
+	for tier := range seenTiers {
+		others = append(others, tier)
+	}
+	sort.Strings(others)
+	report.Tiers = append(report.Tiers, others...)
+
+	return report, nil
+}
+
+// newRevenuePayment works out what a payment paid for and how much it adds to MRR
+func newRevenuePayment(payment Payment, planByTier map[string]Plan) revenuePayment {
+	p := revenuePayment{Payment: payment, tier: paymentTier(payment), start: payment.CreatedAt}
+
+	switch {
+	case payment.PeriodStart != nil && payment.PeriodEnd != nil:
+		p.recurring = true
+		p.start, p.end = *payment.PeriodStart, *payment.PeriodEnd
+	case p.tier == TierMonthly:
+		p.recurring = true
+		p.end = p.start.AddDate(0, 1, 0)
+	case p.tier == TierYearly:
+		p.recurring = true
+		p.end = p.start.AddDate(1, 0, 0)
+	}
+	if !p.recurring {
+		return p
+	}
+
+	// A plan change only pays the prorated difference, so use the new plan's price instead
+	if payment.PaymentType == "subscription_change" {
+		if plan, ok := planByTier[p.tier]; ok {
+			switch plan.Interval {
+			case PlanIntervalMonth:
+				p.monthly = plan.Price
+			case PlanIntervalYear:
+				p.monthly = plan.Price / 12
+			}
+		}
+		return p
+	}
+
+	months := math.Round(float64(p.end.Sub(p.start)) / float64(averageMonth))
+	if months < 1 {
+		months = 1
+	}
+	p.monthly = int64(math.Round(float64(payment.Amount-payment.TaxAmount) / months))
+	return p
+}
+
+// paymentTier returns the tier a payment was for, falling back to its description for older payments
+func paymentTier(payment Payment) string {
+	if payment.Tier != "" {
+		return payment.Tier
+	}
+	if fields := strings.Fields(payment.Description); len(fields) > 0 {
+		switch tier := strings.ToLower(fields[0]); tier {
+		case TierMonthly, TierYearly, TierLifetime, TierPremiumLifetime:
+			return tier
+		}
+	}
+	return "unknown"
+}
+
+// customersAt returns each paying customer's most recent payment in force at t
+func customersAt(payments []revenuePayment, t time.Time) map[uint]revenuePayment {
+	customers := make(map[uint]revenuePayment)
+	for _, payment := range payments {
+		// Payments are in order, so a later payment such as a plan change replaces an earlier one
+		if payment.inForce(t) {
+			customers[payment.UserID] = payment
+		}
+	}
+	return customers
+}
+
+// recurringRevenue totals the monthly revenue of the given customers
+func recurringRevenue(customers map[uint]revenuePayment) int64 {
+	var total int64
+	for _, payment := range customers {
+		total += payment.monthly
+	}
+	return total
+}
+
+// netRevenue returns what was kept from a payment after tax and refunds
+func netRevenue(payment Payment) int64 {
+	net := payment.Amount - payment.TaxAmount - payment.Refunded
+	if net < 0 {
+		return 0
+	}
+	return net
+}
+
+// percentage returns part as a percentage of whole, or zero if whole is zero
+func percentage(part, whole int) float64 {
+	if whole <= 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Settings admins can change while the application is running
+const (
+	// SettingRequireAdminTwoFactor makes two-factor authentication mandatory for admin accounts
+	SettingRequireAdminTwoFactor = "require_admin_two_factor"
+)
+
+// Setting is an application setting stored as a string
+type Setting struct {
+	gorm.Model
+	Key   string `gorm:"uniqueIndex;not null"`
+	Value string
+}
+
+// TableName specifies the table name for the Setting model
+func (Setting) TableName() string {
+	return "settings"
+}
+
+// GetSetting returns a setting's value, or an empty string if it has never been set
+func GetSetting(db *gorm.DB, key string) (string, error) {
+	var setting Setting
+	err := db.Where("key = ?", key).First(&setting).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return setting.Value, nil
+}
+
+// SetSetting creates or updates a setting
+func SetSetting(db *gorm.DB, key, value string) error {
+	setting := Setting{Key: key, Value: value}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"}),
+	}).Create(&setting).Error
+}
+
+// AdminTwoFactorRequired reports whether admins must use two-factor authentication
+func AdminTwoFactorRequired(db *gorm.DB) bool {
+	value, err := GetSetting(db, SettingRequireAdminTwoFactor)
+	return err == nil && value == "true"
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// What caused a subscription change
+const (
+	SubscriptionCauseWebhook   = "webhook"   // The payment provider told us, such as a completed checkout
+	SubscriptionCauseUser      = "user"      // The user changed their own plan
+	SubscriptionCauseAdmin     = "admin"     // An admin comped or revoked the subscription
+	SubscriptionCauseExpiry    = "expiry"    // The subscription ran out or a failed payment's grace period ended
+	SubscriptionCauseReconcile = "reconcile" // Billing reconciliation brought the tier in line with the payment provider
+)
+
+// SubscriptionChange records a user moving from one subscription tier to another
+type SubscriptionChange struct {
+	gorm.Model
+	UserID    uint `gorm:"index;not null"`
+	FromTier  string
+	ToTier    string     `gorm:"not null"`
+	Cause     string     `gorm:"not null"` // "webhook", "user", "admin", "expiry" or "reconcile"
+	ExpiresAt *time.Time // When the new tier ends, nil when moving to the free tier
+	PaymentID *uint      // The payment that paid for the change, if any
+	Note      string     // What happened, or the admin's reason
+	Payment   *Payment   `gorm:"foreignKey:PaymentID"`
+}
+
+// TableName specifies the table name for the SubscriptionChange model
+func (SubscriptionChange) TableName() string {
+	return "subscription_changes"
+}
+
+// IsUpgrade reports whether the change moved the user from the free tier to a paid one
+func (c *SubscriptionChange) IsUpgrade() bool {
+	return (c.FromTier == "" || c.FromTier == TierFree) && c.ToTier != TierFree
+}
+
+// IsLapse reports whether the change moved the user from a paid tier back to the free tier
+func (c *SubscriptionChange) IsLapse() bool {
+	return c.FromTier != "" && c.FromTier != TierFree && c.ToTier == TierFree
+}
+
+// CreateSubscriptionChange records a change to a user's subscription tier
+func CreateSubscriptionChange(db *gorm.DB, change *SubscriptionChange) error {
+	return db.Create(change).Error
+}
+
+// FindSubscriptionChangesByUser retrieves a user's subscription history, newest first
+func FindSubscriptionChangesByUser(db *gorm.DB, userID uint) ([]SubscriptionChange, error) {
+	var changes []SubscriptionChange
+	if err := db.Preload("Payment").Where("user_id = ?", userID).Order("created_at desc, id desc").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
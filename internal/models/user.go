@@ -6,6 +6,18 @@ import (
 	"gorm.io/gorm"
 )
 
+// Subscription tiers
+const (
+	TierFree            = "free"
+	TierMonthly         = "monthly"
+	TierYearly          = "yearly"
+	TierLifetime        = "lifetime"
+	TierPremiumLifetime = "premium_lifetime"
+)
+
+// PaymentGracePeriod is how long a user keeps their plan after a renewal payment fails
+const PaymentGracePeriod = 7 * 24 * time.Hour
+
 // User represents a user in the system
 type User struct {
 	gorm.Model
@@ -20,15 +32,23 @@ type User struct {
 	StripeSubscriptionID  string
 	SubscriptionCanceled  bool `gorm:"default:false"`
 
-	// Authboss required fields
-	RecoverToken       string
+	// Dunning fields, set while a renewal payment is failing
+	PaymentFailedAt      *time.Time
+	GracePeriodEndsAt    *time.Time
+	DunningRemindersSent int `gorm:"default:0"`
+
+	// Authboss required fields. Recover and confirm tokens are split: the selector finds the user
+	// and only a hash of the verifier is stored, so the table can't be used to reset passwords.
+	RecoverSelector    string `gorm:"index"`
+	RecoverVerifier    string // SHA-256 of the verifier
 	RecoverTokenExpiry time.Time
 
 	// For remember me functionality
 	RememberToken string
 
 	// For confirm functionality
-	ConfirmToken       string
+	ConfirmSelector    string `gorm:"index"`
+	ConfirmVerifier    string // SHA-256 of the verifier
 	ConfirmTokenExpiry time.Time
 	Confirmed          bool `gorm:"default:false"`
 
@@ -37,6 +57,14 @@ type User struct {
 	LastAttempt  time.Time
 	Locked       time.Time
 
+	// For two-factor authentication
+	TOTPSecret           string // Base32 secret shared with the authenticator app, set when enrollment starts
+	TOTPEnabled          bool   `gorm:"default:false"`
+	TOTPLastStep         int64  // Time step of the last code accepted, so a code can't be used twice
+	TwoFactorToken       string // Hash of the token for a login waiting on a second factor
+	TwoFactorTokenExpiry time.Time
+	TwoFactorAttempts    int // Wrong codes entered for the waiting login
+
 	// For soft deletion
 	SoftDeleted bool `gorm:"default:false"`
 }
@@ -66,14 +94,14 @@ func (u *User) GetConfirmed() bool {
 	return u.Confirmed
 }
 
-// GetConfirmSelector gets the user's confirm token
+// GetConfirmSelector gets the selector the user's confirm token is looked up by
 func (u *User) GetConfirmSelector() string {
-	return u.ConfirmToken
+	return u.ConfirmSelector
 }
 
-// GetConfirmVerifier gets the user's confirm token
+// GetConfirmVerifier gets the hash of the user's confirm token verifier
 func (u *User) GetConfirmVerifier() string {
-	return u.ConfirmToken
+	return u.ConfirmVerifier
 }
 
 // GetLocked gets the user's locked status
@@ -91,14 +119,14 @@ func (u *User) GetLastAttempt() time.Time {
 	return u.LastAttempt
 }
 
-// GetRecoverSelector gets the user's recover token
+// GetRecoverSelector gets the selector the user's recover token is looked up by
 func (u *User) GetRecoverSelector() string {
-	return u.RecoverToken
+	return u.RecoverSelector
 }
 
-// GetRecoverVerifier gets the user's recover token
+// GetRecoverVerifier gets the hash of the user's recover token verifier
 func (u *User) GetRecoverVerifier() string {
-	return u.RecoverToken
+	return u.RecoverVerifier
 }
 
 // GetRecoverExpiry gets the user's recover token expiry
@@ -131,14 +159,14 @@ func (u *User) PutConfirmed(confirmed bool) {
 	u.Confirmed = confirmed
 }
 
-// PutConfirmSelector sets the user's confirm token
+// PutConfirmSelector sets the selector the user's confirm token is looked up by
 func (u *User) PutConfirmSelector(selector string) {
-	u.ConfirmToken = selector
+	u.ConfirmSelector = selector
 }
 
-// PutConfirmVerifier sets the user's confirm token
+// PutConfirmVerifier sets the hash of the user's confirm token verifier
 func (u *User) PutConfirmVerifier(verifier string) {
-	u.ConfirmToken = verifier
+	u.ConfirmVerifier = verifier
 }
 
 // PutLocked sets the user's locked status
@@ -156,14 +184,14 @@ func (u *User) PutLastAttempt(last time.Time) {
 	u.LastAttempt = last
 }
 
-// PutRecoverSelector sets the user's recover token
+// PutRecoverSelector sets the selector the user's recover token is looked up by
 func (u *User) PutRecoverSelector(selector string) {
-	u.RecoverToken = selector
+	u.RecoverSelector = selector
 }
 
-// PutRecoverVerifier sets the user's recover token
+// PutRecoverVerifier sets the hash of the user's recover token verifier
 func (u *User) PutRecoverVerifier(verifier string) {
-	u.RecoverToken = verifier
+	u.RecoverVerifier = verifier
 }
 
 // PutRecoverExpiry sets the user's recover token expiry
@@ -184,7 +212,7 @@ func (u *User) PutAdminStatus(isAdmin bool) {
 // HasActiveSubscription checks if the user has an active subscription
 func (u *User) HasActiveSubscription() bool {
 	// If the subscription tier is free, return false
-	if u.SubscriptionTier == "free" {
+	if u.SubscriptionTier == TierFree {
 		return false
 	}
 
@@ -193,13 +221,23 @@ func (u *User) HasActiveSubscription() bool {
 		return true
 	}
 
+	// A failed renewal keeps the plan until the grace period runs out
+	if u.InGracePeriod() && time.Now().Before(*u.GracePeriodEndsAt) {
+		return true
+	}
+
 	// Otherwise, check if the subscription is expired
 	return time.Now().Before(u.SubscriptionExpiresAt)
 }
 
+// InGracePeriod checks if the user's last renewal payment failed and hasn't been resolved
+func (u *User) InGracePeriod() bool {
+	return u.GracePeriodEndsAt != nil
+}
+
 // IsLifetimeSubscriber checks if the user has a lifetime subscription
 func (u *User) IsLifetimeSubscriber() bool {
-	return u.SubscriptionTier == "lifetime" || u.SubscriptionTier == "premium_lifetime"
+	return u.SubscriptionTier == TierLifetime || u.SubscriptionTier == TierPremiumLifetime
 }
 
 // IsSoftDeleted checks if the user has been soft deleted
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Valuation represents an appraisal of a gun's value on a given date
+type Valuation struct {
+	gorm.Model
+	GunID   uint
+	Gun     Gun `gorm:"foreignKey:GunID"`
+	OwnerID uint
+	Date    time.Time
+	Value   int64 // Value in cents
+	Source  string
+	Notes   string
+}
+
+// TableName specifies the table name for the Valuation model
+func (Valuation) TableName() string {
+	return "valuations"
+}
+
+// FormatValue returns the value formatted in dollars
+func (v *Valuation) FormatValue() string {
+	return "$" + formatDollars(float64(v.Value)/100.0)
+}
+
+// FindValuationsByGun retrieves the valuation history for a gun, newest first
+func FindValuationsByGun(db *gorm.DB, gunID uint, ownerID uint) ([]Valuation, error) {
+	var valuations []Valuation
+	if err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).Order("date DESC, id DESC").Find(&valuations).Error; err != nil {
+		return nil, err
+	}
+	return valuations, nil
+}
+
+// CreateValuation creates a new valuation in the database
+func CreateValuation(db *gorm.DB, valuation *Valuation) error {
+	return db.Create(valuation).Error
+}
+
+// DeleteValuation deletes a valuation from the database
+func DeleteValuation(db *gorm.DB, id uint, ownerID uint) error {
+	return db.Where("id = ? AND owner_id = ?", id, ownerID).Delete(&Valuation{}).Error
+}
+
+// GunValue is a gun with its purchase cost and most recent valuation
+type GunValue struct {
+	Gun           Gun
+	Cost          int64      // Purchase price in cents
+	Value         int64      // Latest appraised value in cents, or the purchase price if never appraised
+	LastAppraised *time.Time // Nil if the gun has never been appraised
+}
+
+// ValueGroup totals cost and value for a group of guns
+type ValueGroup struct {
+	Name  string
+	Count int
+	Cost  int64
+	Value int64
+}
+
+// Gain returns the change in value over cost in cents
+func (g ValueGroup) Gain() int64 {
+	return g.Value - g.Cost
+}
+
+// ValueReport summarizes the cost and current value of an owner's collection
+type ValueReport struct {
+	Guns           []GunValue
+	ByWeaponType   []ValueGroup
+	ByManufacturer []ValueGroup
+	Total          ValueGroup
+}
+
+// BuildValueReport calculates the cost and current value of every gun an owner has
+func BuildValueReport(db *gorm.DB, ownerID uint) (*ValueReport, error) {
+	// Get all of the owner's guns, regardless of subscription tier
+	var guns []Gun
+	if err := db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").Where("owner_id = ?", ownerID).Order("name").Find(&guns).Error; err != nil {
+		return nil, err
+	}
+
+	// Get the valuations, newest first, so the first seen per gun is the latest
+	var valuations []Valuation
+	if err := db.Where("owner_id = ?", ownerID).Order("date DESC, id DESC").Find(&valuations).Error; err != nil {
+		return nil, err
+	}
+	latest := make(map[uint]Valuation)
+	for _, v := range valuations {
+		if _, ok := latest[v.GunID]; !ok {
+			latest[v.GunID] = v
+		}
+	}
+
+	report := &ValueReport{Total: ValueGroup{Name: "Total"}}
+	byWeaponType := make(map[string]*ValueGroup)
+	byManufacturer := make(map[string]*ValueGroup)
+
+	for _, gun := range guns {
+		item := GunValue{Gun: gun, Cost: gun.PurchasePrice, Value: gun.PurchasePrice}
+		if v, ok := latest[gun.ID]; ok {
+			date := v.Date
+			item.Value = v.Value
+			item.LastAppraised = &date
+		}
+		report.Guns = append(report.Guns, item)
+
+		addToGroup(&report.Total, item)
+		addToGroup(groupFor(byWeaponType, gun.WeaponType.Type), item)
+		addToGroup(groupFor(byManufacturer, gun.Manufacturer.Name), item)
+	}
+
+	report.ByWeaponType = sortedGroups(byWeaponType)
+	report.ByManufacturer = sortedGroups(byManufacturer)
+
+	return report, nil
+}
+
+// groupFor returns the group with the given name, creating it if needed
+func groupFor(groups map[string]*ValueGroup, name string) *ValueGroup {
+	if name == "" {
+		name = "Unknown"
+	}
+	group, ok := groups[name]
+	if !ok {
+		group = &ValueGroup{Name: name}
+		groups[name] = group
+	}
+	return group
+}
+
+// addToGroup adds a gun's cost and value to a group
+func addToGroup(group *ValueGroup, item GunValue) {
+	group.Count++
+	group.Cost += item.Cost
+	group.Value += item.Value
+}
+
+// sortedGroups returns the groups ordered by value, highest first
+func sortedGroups(groups map[string]*ValueGroup) []ValueGroup {
+	result := make([]ValueGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, *group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Value != result[j].Value {
+			return result[i].Value > result[j].Value
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// FormatCents formats an amount in cents as dollars, with a leading minus sign for losses
+func FormatCents(cents int64) string {
+	if cents < 0 {
+		return "-$" + formatDollars(float64(-cents)/100.0)
+	}
+	return "$" + formatDollars(float64(cents)/100.0)
+}
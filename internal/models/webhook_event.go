@@ -0,0 +1,118 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook event statuses
+const (
+	WebhookEventStatusReceived   = "received" // Recorded but not yet picked up
+	WebhookEventStatusProcessing = "processing"
+	WebhookEventStatusProcessed  = "processed"
+	WebhookEventStatusFailed     = "failed"
+	WebhookEventStatusIgnored    = "ignored" // Event types we don't act on
+)
+
+// WebhookEvent records a Stripe webhook event so retries aren't processed twice
+type WebhookEvent struct {
+	gorm.Model
+	EventID     string `gorm:"uniqueIndex;not null"` // Stripe event ID, e.g. "evt_..."
+	Type        string `gorm:"index"`
+	Payload     string `gorm:"type:text"` // Raw request body as received
+	Status      string `gorm:"index"`
+	Error       string `gorm:"type:text"` // Error from the most recent attempt
+	Attempts    int
+	ProcessedAt *time.Time // When the event was last processed successfully
+}
+
+// TableName specifies the table name for the WebhookEvent model
+func (WebhookEvent) TableName() string {
+	return "webhook_events"
+}
+
+// WebhookEventStaleAfter is how long an event can sit in processing before it's assumed the attempt died
+const WebhookEventStaleAfter = 15 * time.Minute
+
+// CanReplay reports whether an admin may process the event again: it failed, or an attempt died mid-processing
+func (e *WebhookEvent) CanReplay() bool {
+	switch e.Status {
+	case WebhookEventStatusFailed:
+		return true
+	case WebhookEventStatusProcessing:
+		return e.UpdatedAt.Before(time.Now().Add(-WebhookEventStaleAfter))
+	default:
+		return false
+	}
+}
+
+// ClaimWebhookEvent marks the event as processing, reporting false if another attempt has it or it's already done.
+// Only received and failed events, or ones stuck in processing past WebhookEventStaleAfter, can be claimed.
+func ClaimWebhookEvent(db *gorm.DB, event *WebhookEvent) (bool, error) {
+	now := time.Now()
+	result := db.Model(&WebhookEvent{}).
+		Where("id = ?", event.ID).
+		Where("status IN ? OR (status = ? AND updated_at < ?)",
+			[]string{WebhookEventStatusReceived, WebhookEventStatusFailed},
+			WebhookEventStatusProcessing, now.Add(-WebhookEventStaleAfter)).
+		Updates(map[string]interface{}{
+			"status":     WebhookEventStatusProcessing,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"error":      "",
+			"updated_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+
+	event.Status = WebhookEventStatusProcessing
+	event.Attempts++
+	event.Error = ""
+	event.UpdatedAt = now
+	return true, nil
+}
+
+// CreateWebhookEvent creates a new webhook event in the database
+func CreateWebhookEvent(db *gorm.DB, event *WebhookEvent) error {
+	return db.Create(event).Error
+}
+
+// UpdateWebhookEvent updates an existing webhook event in the database
+func UpdateWebhookEvent(db *gorm.DB, event *WebhookEvent) error {
+	return db.Save(event).Error
+}
+
+// FindWebhookEventByID retrieves a webhook event by its ID
+func FindWebhookEventByID(db *gorm.DB, id uint) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := db.First(&event, id).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FindWebhookEventByEventID retrieves a webhook event by its Stripe event ID
+func FindWebhookEventByEventID(db *gorm.DB, eventID string) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := db.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FindWebhookEvents retrieves the most recent webhook events, optionally filtered by status
+func FindWebhookEvents(db *gorm.DB, status string, limit int) ([]WebhookEvent, error) {
+	var events []WebhookEvent
+	query := db.Order("created_at desc").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
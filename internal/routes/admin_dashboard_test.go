@@ -58,6 +58,14 @@ func setupAdminDashboardTestRouter(t *testing.T) (*gin.Engine, *gorm.DB, *testut
 	return r, db, testUsers
 }
 
+// signIn adds the cookie for a new login session for the user to the request
+func signIn(t *testing.T, req *http.Request, user models.User) {
+	sessions := auth.NewWithSessionStore(auth.NewGORMSessionStore(nil), time.Hour)
+	cookie, err := sessions.SessionCookie(&user)
+	require.NoError(t, err, "Failed to start login session")
+	req.AddCookie(cookie)
+}
+
 // TestAdminDashboardEndpointWithGuestUser tests that a guest user is redirected to login with a flash message
 func TestAdminDashboardEndpointWithGuestUser(t *testing.T) {
 	r, db, _ := setupAdminDashboardTestRouter(t)
@@ -107,14 +115,7 @@ func TestAdminDashboardEndpointWithRegularUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the regular user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Unsubscribed.Email,
-	})
+	signIn(t, req, testUsers.Unsubscribed)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -156,18 +157,7 @@ func TestAdminDashboardEndpointWithAdminUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -210,18 +200,7 @@ func TestAdminDashboardTotalUsersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -259,18 +238,7 @@ func TestAdminDashboardUserGrowthPercentage(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -312,18 +280,7 @@ func TestAdminDashboardSubscribedUsersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -364,18 +321,7 @@ func TestAdminDashboardNewRegistrationsMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -415,18 +361,7 @@ func TestAdminDashboardNewSubscriptionsMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -466,18 +401,7 @@ func TestAdminDashboardMonthlySubscribersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -516,18 +440,7 @@ func TestAdminDashboardYearlySubscribersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -566,18 +479,7 @@ func TestAdminDashboardLifetimeSubscribersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -616,18 +518,7 @@ func TestAdminDashboardPremiumSubscribersMetrics(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -699,18 +590,7 @@ func TestAdminDashboardRecentUsersTable(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -755,18 +635,7 @@ func TestAdminDashboardRecentUsersTable(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr = httptest.NewRecorder()
@@ -786,18 +655,7 @@ func TestAdminDashboardRecentUsersTable(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr = httptest.NewRecorder()
@@ -813,18 +671,7 @@ func TestAdminDashboardRecentUsersTable(t *testing.T) {
 	require.NoError(t, err)
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr = httptest.NewRecorder()
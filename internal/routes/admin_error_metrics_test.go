@@ -105,14 +105,7 @@ func TestAdminErrorMetricsEndpointWithRegularUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the regular user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Unsubscribed.Email,
-	})
+	signIn(t, req, testUsers.Unsubscribed)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -154,18 +147,7 @@ func TestAdminErrorMetricsEndpointWithAdminUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -107,14 +107,7 @@ func TestAdminDetailedHealthEndpointWithRegularUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the regular user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Unsubscribed.Email,
-	})
+	signIn(t, req, testUsers.Unsubscribed)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
@@ -156,18 +149,7 @@ func TestAdminDetailedHealthEndpointWithAdminUser(t *testing.T) {
 	require.NoError(t, err, "Failed to create request")
 
 	// Set up session for the admin user
-	req.AddCookie(&http.Cookie{
-		Name:  "is_logged_in",
-		Value: "true",
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "user_email",
-		Value: testUsers.Admin.Email,
-	})
-	req.AddCookie(&http.Cookie{
-		Name:  "is_admin",
-		Value: "true",
-	})
+	signIn(t, req, testUsers.Admin)
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
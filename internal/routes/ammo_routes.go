@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterAmmoRoutes registers all ammo-related routes
+func RegisterAmmoRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth) {
+	// Create the ammo controller
+	ammoController := controllers.NewAmmoController(db)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Ammo routes nested under owner
+		ammoGroup := ownerGroup.Group("/ammo")
+		{
+			// List all ammo for the owner
+			ammoGroup.GET("", ammoController.Index)
+
+			// Create new ammo
+			ammoGroup.GET("/new", ammoController.New)
+			ammoGroup.POST("", ammoController.Create)
+
+			// Show specific ammo
+			ammoGroup.GET("/:id", ammoController.Show)
+
+			// Edit ammo
+			ammoGroup.GET("/:id/edit", ammoController.Edit)
+			ammoGroup.POST("/:id", ammoController.Update)
+
+			// Delete ammo
+			ammoGroup.POST("/:id/delete", ammoController.Delete)
+		}
+	}
+}
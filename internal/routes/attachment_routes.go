@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// RegisterAttachmentRoutes registers all gun attachment routes
+func RegisterAttachmentRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth, store storage.Storage) {
+	// Create the attachment controller
+	attachmentController := controllers.NewAttachmentController(db, store)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Upload an attachment for a gun
+		ownerGroup.POST("/guns/:id/attachments", attachmentController.Create)
+
+		// Files are only served through these routes, never as public static files
+		attachmentGroup := ownerGroup.Group("/attachments")
+		{
+			// Download an attachment
+			attachmentGroup.GET("/:id", attachmentController.Show)
+
+			// Download an attachment's thumbnail
+			attachmentGroup.GET("/:id/thumbnail", attachmentController.Thumbnail)
+
+			// Delete an attachment
+			attachmentGroup.POST("/:id/delete", attachmentController.Delete)
+		}
+	}
+}
@@ -19,10 +19,16 @@ func RegisterAuthRoutes(router *gin.Engine, auth *auth.Auth, emailService email.
 	// Create rate limiters
 	loginLimiter := middleware.NewRateLimiter()
 	passwordResetLimiter := middleware.NewRateLimiter()
+	twoFactorLimiter := middleware.NewRateLimiter()
+	passkeyLimiter := middleware.NewRateLimiter()
 
 	// Auth routes - all without /auth prefix
 	router.GET("/login", authController.Login)
 	router.POST("/login", loginLimiter.RateLimit(5, time.Minute), authController.ProcessLogin)
+	router.GET("/login/two-factor", authController.TwoFactorLogin)
+	router.POST("/login/two-factor", twoFactorLimiter.RateLimit(5, time.Minute), authController.ProcessTwoFactorLogin)
+	router.POST("/login/passkey/begin", passkeyLimiter.RateLimit(10, time.Minute), authController.BeginPasskeyLogin)
+	router.POST("/login/passkey/finish", authController.FinishPasskeyLogin)
 	router.GET("/register", authController.Register)
 	router.POST("/register", authController.ProcessRegister)
 	router.GET("/recover", authController.Recover)
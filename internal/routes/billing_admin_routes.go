@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// RegisterBillingAdminRoutes registers the admin routes for refunds and subscription adjustments
+func RegisterBillingAdminRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth, provider billing.PaymentProvider) {
+	// Create billing admin controller
+	billingAdminController := controllers.NewBillingAdminController(db, provider)
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Billing routes
+	adminRoutes.GET("/billing", billingAdminController.Index)
+	adminRoutes.GET("/billing/users/:id", billingAdminController.Show)
+	adminRoutes.POST("/billing/users/:id/comp", billingAdminController.Comp)
+	adminRoutes.POST("/billing/users/:id/extend", billingAdminController.Extend)
+	adminRoutes.POST("/billing/users/:id/revoke", billingAdminController.Revoke)
+	adminRoutes.POST("/billing/payments/:id/refund", billingAdminController.Refund)
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// RegisterCouponRoutes registers the admin routes for managing promo codes
+func RegisterCouponRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth, provider billing.PaymentProvider) {
+	// Create coupon controller
+	couponController := controllers.NewCouponController(db, provider)
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Coupon routes
+	adminRoutes.GET("/coupons", couponController.Index)
+	adminRoutes.GET("/coupons/new", couponController.New)
+	adminRoutes.POST("/coupons", couponController.Create)
+	adminRoutes.POST("/coupons/:id/toggle", couponController.Toggle)
+}
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// RegisterExportRoutes registers the personal data export routes
+func RegisterExportRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth, store storage.Storage) {
+	// Create the export controller
+	exportController := controllers.NewExportController(db, store)
+
+	// Protected routes (require authentication)
+	protected := router.Group("/")
+	protected.Use(auth.RequireAuth())
+	{
+		// Download the user's data, or queue a large export to be emailed
+		protected.POST("/profile/export", exportController.Create)
+
+		// Download an export using the emailed link
+		protected.GET("/profile/export/:token", exportController.Download)
+	}
+}
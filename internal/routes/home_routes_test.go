@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -38,6 +40,36 @@ func (m *MockHomeRoutesEmailService) SendContactFormEmail(name, email, subject,
 	return args.Error(0)
 }
 
+// SendMaintenanceReminderEmail sends a maintenance reminder email
+func (m *MockHomeRoutesEmailService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	args := m.Called(email, overdue)
+	return args.Error(0)
+}
+
+// SendDataExportEmail mocks the SendDataExportEmail method
+func (m *MockHomeRoutesEmailService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	args := m.Called(email, token, expiresAt)
+	return args.Error(0)
+}
+
+// SendPaymentFailedEmail mocks the SendPaymentFailedEmail method
+func (m *MockHomeRoutesEmailService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	args := m.Called(email, reminder, graceEndsAt)
+	return args.Error(0)
+}
+
+// SendDataExportFailedEmail mocks the SendDataExportFailedEmail method
+func (m *MockHomeRoutesEmailService) SendDataExportFailedEmail(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+// SendReceiptEmail mocks the SendReceiptEmail method
+func (m *MockHomeRoutesEmailService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	args := m.Called(email, payment, pdf)
+	return args.Error(0)
+}
+
 func TestHomeRoutes(t *testing.T) {
 	// Set Gin to test mode
 	gin.SetMode(gin.TestMode)
@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterImportRoutes registers the gun import routes
+func RegisterImportRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth) {
+	// Create the import controller
+	importController := controllers.NewImportController(db)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Import routes nested under guns
+		importGroup := ownerGroup.Group("/guns/import")
+		{
+			// Show the upload form
+			importGroup.GET("", importController.New)
+
+			// Upload a file and map its columns
+			importGroup.POST("", importController.Upload)
+
+			// Change the column mapping
+			importGroup.POST("/mapping", importController.Mapping)
+
+			// Preview the import without saving
+			importGroup.POST("/preview", importController.Preview)
+
+			// Save the imported guns
+			importGroup.POST("/commit", importController.Commit)
+		}
+	}
+}
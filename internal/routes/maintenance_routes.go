@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterMaintenanceRoutes registers all gun maintenance routes
+func RegisterMaintenanceRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth) {
+	// Create the maintenance controller
+	maintenanceController := controllers.NewMaintenanceController(db)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Maintenance routes nested under a gun
+		maintenanceGroup := ownerGroup.Group("/guns/:id/maintenance")
+		{
+			// Show the maintenance log for a gun
+			maintenanceGroup.GET("", maintenanceController.Index)
+
+			// Log maintenance
+			maintenanceGroup.POST("", maintenanceController.Create)
+
+			// Update the service intervals
+			maintenanceGroup.POST("/intervals", maintenanceController.UpdateIntervals)
+
+			// Delete a maintenance record
+			maintenanceGroup.POST("/:recordID/delete", maintenanceController.Delete)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/services/passkey"
+	"gorm.io/gorm"
+)
+
+// RegisterPasskeyRoutes registers the routes for managing passkeys from the profile page
+func RegisterPasskeyRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth, cfg *config.Config) {
+	// Passkeys are tied to the site's address, so they need a valid base URL
+	service, err := passkey.NewService(db, cfg.AppBaseURL)
+	if err != nil {
+		log.Printf("Passkeys not configured, they will be disabled: %v", err)
+		return
+	}
+
+	// Create passkey controller
+	passkeyController := controllers.NewPasskeyController(db, service)
+
+	// Profile routes (require authentication)
+	protected := r.Group("/profile/passkeys")
+	protected.Use(authInstance.RequireAuth())
+	{
+		protected.GET("", passkeyController.Index)
+		protected.POST("/register/begin", passkeyController.BeginRegistration)
+		protected.POST("/register/finish", passkeyController.FinishRegistration)
+		protected.POST("/:id/rename", passkeyController.Rename)
+		protected.POST("/:id/delete", passkeyController.Remove)
+	}
+}
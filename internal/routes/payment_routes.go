@@ -7,13 +7,16 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
 	"github.com/hail2skins/the-virtual-armory/internal/middleware"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/receipt"
 	"gorm.io/gorm"
 )
 
 // RegisterPaymentRoutes registers all payment related routes
-func RegisterPaymentRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth) {
+func RegisterPaymentRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth, provider billing.PaymentProvider, emailService email.EmailService, business receipt.Business) {
 	// Create payment controller
-	paymentController := controllers.NewPaymentController(db)
+	paymentController := controllers.NewPaymentControllerWithEmailService(db, provider, emailService, business)
 
 	// Create rate limiter for webhooks
 	webhookLimiter := middleware.NewRateLimiter()
@@ -50,9 +53,14 @@ func RegisterPaymentRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth)
 
 		// Payment history route
 		authorized.GET("/owner/payment-history", paymentController.ShowPaymentHistory)
+		authorized.GET("/owner/payment-history/:id/receipt", paymentController.ShowReceipt)
+		authorized.GET("/owner/payment-history/:id/receipt.pdf", paymentController.DownloadReceipt)
 
 		// Subscription cancellation routes
 		authorized.GET("/subscription/cancel/confirm", paymentController.ShowCancelConfirmation)
 		authorized.POST("/subscription/cancel", paymentController.CancelSubscription)
+
+		// Plan change route
+		authorized.POST("/subscription/change", paymentController.ChangeSubscription)
 	}
 }
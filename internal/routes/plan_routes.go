@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterPlanRoutes registers the admin routes for editing the pricing catalog
+func RegisterPlanRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth) {
+	// Create plan controller
+	planController := controllers.NewPlanController(db)
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Plan routes
+	adminRoutes.GET("/plans", planController.Index)
+	adminRoutes.GET("/plans/:id/edit", planController.Edit)
+	adminRoutes.POST("/plans/:id", planController.Update)
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterRangeSessionRoutes registers all range session-related routes
+func RegisterRangeSessionRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth) {
+	// Create the range session controller
+	rangeSessionController := controllers.NewRangeSessionController(db)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Range session routes nested under owner
+		rangeGroup := ownerGroup.Group("/range")
+		{
+			// List all range sessions for the owner
+			rangeGroup.GET("", rangeSessionController.Index)
+
+			// Log a new range session
+			rangeGroup.GET("/new", rangeSessionController.New)
+			rangeGroup.POST("", rangeSessionController.Create)
+
+			// Show a specific range session
+			rangeGroup.GET("/:id", rangeSessionController.Show)
+
+			// Delete a range session
+			rangeGroup.POST("/:id/delete", rangeSessionController.Delete)
+		}
+	}
+}
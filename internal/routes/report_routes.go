@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// RegisterReportRoutes registers all owner report routes
+func RegisterReportRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth, store storage.Storage) {
+	// Create the report controller
+	reportController := controllers.NewReportController(db, store)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		reportGroup := ownerGroup.Group("/reports")
+		{
+			// Show the collection value report
+			reportGroup.GET("/value", reportController.Value)
+
+			// Export the collection value report as CSV
+			reportGroup.GET("/value.csv", reportController.ValueCSV)
+
+			// Generate an insurance or theft report PDF for the selected guns
+			reportGroup.POST("/insurance", reportController.InsurancePDF)
+		}
+	}
+}
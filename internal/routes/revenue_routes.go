@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterRevenueRoutes registers the admin revenue analytics routes
+func RegisterRevenueRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth) {
+	// Create revenue controller
+	revenueController := controllers.NewRevenueController(db)
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Revenue routes
+	adminRoutes.GET("/revenue", revenueController.Index)
+	adminRoutes.GET("/revenue.csv", revenueController.CSV)
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"log"
 	"net/http"
 
 	"github.com/gin-contrib/cors"
@@ -8,7 +9,10 @@ import (
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/config"
 	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
 	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/services/receipt"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
 	"gorm.io/gorm"
 )
 
@@ -46,6 +50,9 @@ func RegisterRoutes(r *gin.Engine, authInstance *auth.Auth, db *gorm.DB, cfg *co
 	// Add Authboss middleware
 	r.Use(authInstance.Middleware())
 
+	// Find the user signed in to the browser's session
+	r.Use(authInstance.LoadUser())
+
 	// Register health check route
 	RegisterHealthRoute(r)
 
@@ -58,6 +65,12 @@ func RegisterRoutes(r *gin.Engine, authInstance *auth.Auth, db *gorm.DB, cfg *co
 	// Register user routes
 	RegisterUserRoutes(r, db, authInstance, emailService)
 
+	// Register two-factor authentication routes
+	RegisterTwoFactorRoutes(r, db, authInstance)
+
+	// Register passkey routes
+	RegisterPasskeyRoutes(r, db, authInstance, cfg)
+
 	// Register manufacturer routes
 	RegisterManufacturerRoutes(r, authInstance)
 
@@ -70,8 +83,46 @@ func RegisterRoutes(r *gin.Engine, authInstance *auth.Auth, db *gorm.DB, cfg *co
 	// Register gun routes
 	RegisterGunRoutes(r, db, authInstance)
 
+	// Register gun import routes
+	RegisterImportRoutes(r, db, authInstance)
+
+	// Register maintenance routes
+	RegisterMaintenanceRoutes(r, db, authInstance)
+
+	// Register valuation routes
+	RegisterValuationRoutes(r, db, authInstance)
+
+	// Initialize file storage, which is optional
+	store, err := storage.New(cfg)
+	if err != nil {
+		log.Printf("Attachment storage not configured, uploads will be disabled: %v", err)
+	}
+
+	// Register report routes
+	RegisterReportRoutes(r, db, authInstance, store)
+
+	// Register attachment routes if file storage is available
+	if store != nil {
+		RegisterAttachmentRoutes(r, db, authInstance, store)
+	}
+
+	// Register data export routes
+	RegisterExportRoutes(r, db, authInstance, store)
+
+	// Register ammo routes
+	RegisterAmmoRoutes(r, db, authInstance)
+
+	// Register range session routes
+	RegisterRangeSessionRoutes(r, db, authInstance)
+
+	// Initialize the payment provider
+	paymentProvider, err := billing.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up payment provider: %v", err)
+	}
+
 	// Register payment routes
-	RegisterPaymentRoutes(r, db, authInstance)
+	RegisterPaymentRoutes(r, db, authInstance, paymentProvider, emailService, receipt.NewBusiness(cfg))
 
 	// Register admin routes
 	adminController := controllers.NewAdminController()
@@ -80,4 +131,19 @@ func RegisterRoutes(r *gin.Engine, authInstance *auth.Auth, db *gorm.DB, cfg *co
 	// Register admin health routes
 	adminHealthController := controllers.NewAdminHealthController(db)
 	RegisterAdminHealthRoutes(r, adminHealthController, authInstance)
+
+	// Register admin plan routes
+	RegisterPlanRoutes(r, db, authInstance)
+
+	// Register admin coupon routes
+	RegisterCouponRoutes(r, db, authInstance, paymentProvider)
+
+	// Register admin billing routes
+	RegisterBillingAdminRoutes(r, db, authInstance, paymentProvider)
+
+	// Register admin revenue routes
+	RegisterRevenueRoutes(r, db, authInstance)
+
+	// Register admin webhook event routes
+	RegisterWebhookEventRoutes(r, db, authInstance, paymentProvider)
 }
@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterTwoFactorRoutes registers the routes for setting up two-factor authentication and the admin security page
+func RegisterTwoFactorRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth) {
+	// Create two-factor controller
+	twoFactorController := controllers.NewTwoFactorController(db, authInstance)
+
+	// Profile routes (require authentication)
+	protected := r.Group("/profile/two-factor")
+	protected.Use(authInstance.RequireAuth())
+	{
+		protected.GET("", twoFactorController.Show)
+		protected.POST("/setup", twoFactorController.BeginSetup)
+		protected.POST("/enable", twoFactorController.Enable)
+		protected.POST("/disable", twoFactorController.Disable)
+		protected.POST("/recovery-codes", twoFactorController.RegenerateRecoveryCodes)
+	}
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Security routes
+	adminRoutes.GET("/security", twoFactorController.AdminIndex)
+	adminRoutes.POST("/security/two-factor", twoFactorController.AdminSetRequirement)
+	adminRoutes.POST("/users/:id/two-factor/reset", twoFactorController.AdminReset)
+}
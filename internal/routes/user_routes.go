@@ -12,6 +12,7 @@ import (
 func RegisterUserRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth, emailService email.EmailService) {
 	// Create user controller with email service
 	userController := controllers.NewUserControllerWithEmailService(db, emailService)
+	userController.Auth = auth
 
 	// Protected routes (require authentication)
 	protected := router.Group("/")
@@ -25,5 +26,9 @@ func RegisterUserRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth, emailS
 		protected.GET("/profile/delete", userController.ShowDeleteAccount)
 		protected.POST("/profile/delete", userController.DeleteAccount)
 		protected.POST("/profile/reactivate", userController.ReactivateAccount)
+
+		// Login session routes
+		protected.POST("/profile/sessions/:id/revoke", userController.RevokeSession)
+		protected.POST("/profile/sessions/revoke-all", userController.SignOutEverywhere)
 	}
 }
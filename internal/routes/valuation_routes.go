@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"gorm.io/gorm"
+)
+
+// RegisterValuationRoutes registers all gun valuation routes
+func RegisterValuationRoutes(router *gin.Engine, db *gorm.DB, auth *auth.Auth) {
+	// Create the valuation controller
+	valuationController := controllers.NewValuationController(db)
+
+	// Owner routes (require authentication)
+	ownerGroup := router.Group("/owner")
+	ownerGroup.Use(auth.RequireAuth())
+	{
+		// Valuation routes nested under a gun
+		valuationGroup := ownerGroup.Group("/guns/:id/valuations")
+		{
+			// Show the valuation history for a gun
+			valuationGroup.GET("", valuationController.Index)
+
+			// Record a valuation
+			valuationGroup.POST("", valuationController.Create)
+
+			// Delete a valuation
+			valuationGroup.POST("/:valuationID/delete", valuationController.Delete)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/the-virtual-armory/internal/auth"
+	"github.com/hail2skins/the-virtual-armory/internal/controllers"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// RegisterWebhookEventRoutes registers the admin routes for the Stripe webhook event log
+func RegisterWebhookEventRoutes(r *gin.Engine, db *gorm.DB, authInstance *auth.Auth, provider billing.PaymentProvider) {
+	// Create webhook event controller
+	webhookEventController := controllers.NewWebhookEventController(db, provider)
+
+	// Create an admin group with authentication and admin middleware
+	adminRoutes := r.Group("/admin")
+	adminRoutes.Use(authInstance.RequireAuth())
+	adminRoutes.Use(authInstance.RequireAdmin())
+
+	// Webhook event routes
+	adminRoutes.GET("/webhook-events", webhookEventController.Index)
+	adminRoutes.GET("/webhook-events/:id", webhookEventController.Show)
+	adminRoutes.POST("/webhook-events/:id/replay", webhookEventController.Replay)
+}
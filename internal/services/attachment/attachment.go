@@ -0,0 +1,262 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// MaxFileSize is the largest file that can be uploaded
+const MaxFileSize = 20 << 20 // 20 MB
+
+// ThumbnailSize is the maximum width and height of generated thumbnails
+const ThumbnailSize = 300
+
+// MaxImagePixels is the most pixels an uploaded image can have. A small file can declare huge
+// dimensions, and decoding allocates 4 bytes per pixel, so this keeps a decode to about 200 MB.
+const MaxImagePixels = 50_000_000
+
+var (
+	// ErrFileTooLarge is returned when an upload exceeds MaxFileSize
+	ErrFileTooLarge = errors.New("file is too large")
+
+	// ErrImageTooLarge is returned when an image has more than MaxImagePixels
+	ErrImageTooLarge = errors.New("image dimensions are too large")
+
+	// ErrUnsupportedType is returned when an upload is not a JPEG, PNG or PDF
+	ErrUnsupportedType = errors.New("unsupported file type")
+
+	// ErrQuotaExceeded is returned when an upload would exceed the owner's storage quota
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Service stores gun attachments and their thumbnails
+type Service struct {
+	DB      *gorm.DB
+	Storage storage.Storage
+}
+
+// NewService creates a new attachment Service
+func NewService(db *gorm.DB, store storage.Storage) *Service {
+	return &Service{
+		DB:      db,
+		Storage: store,
+	}
+}
+
+// Upload validates, sanitizes and stores a file for a gun
+func (s *Service) Upload(ctx context.Context, user *models.User, gun *models.Gun, kind, fileName string, r io.Reader) (*models.Attachment, error) {
+	// Read the file, refusing anything over the size limit
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	// Detect the type from the content rather than trusting the client
+	contentType := http.DetectContentType(data)
+
+	var thumbnail []byte
+	switch contentType {
+	case "image/jpeg", "image/png":
+		// Re-encoding the image drops EXIF metadata, including GPS coordinates
+		data, thumbnail, err = processImage(data, contentType)
+		if err != nil {
+			return nil, err
+		}
+	case "application/pdf":
+		// Documents are stored as uploaded
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	size := int64(len(data) + len(thumbnail))
+
+	// Check the owner's plan has room for the file before storing anything
+	quota := entitlements.For(user).MaxAttachmentBytes
+	used, err := models.GetStorageUsed(s.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if used+size > quota {
+		return nil, ErrQuotaExceeded
+	}
+
+	// Store the file and thumbnail under unguessable keys
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("users/%d/guns/%d/%s", user.ID, gun.ID, id)
+
+	attachment := &models.Attachment{
+		GunID:       gun.ID,
+		OwnerID:     user.ID,
+		Kind:        kind,
+		FileName:    sanitizeFileName(fileName),
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  prefix + extensionFor(contentType),
+	}
+
+	if err := s.Storage.Put(ctx, attachment.StorageKey, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return nil, err
+	}
+
+	if thumbnail != nil {
+		attachment.ThumbnailKey = prefix + "_thumb.jpg"
+		if err := s.Storage.Put(ctx, attachment.ThumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+			s.removeObjects(ctx, attachment)
+			return nil, err
+		}
+	}
+
+	// Record the attachment, checking the quota again in case another upload used the room meanwhile,
+	// and clean up the stored objects if it isn't recorded
+	created, err := models.CreateAttachmentWithinQuota(s.DB, attachment, quota)
+	if err != nil {
+		s.removeObjects(ctx, attachment)
+		return nil, err
+	}
+	if !created {
+		s.removeObjects(ctx, attachment)
+		return nil, ErrQuotaExceeded
+	}
+
+	return attachment, nil
+}
+
+// Open returns the contents of an attachment or its thumbnail
+func (s *Service) Open(ctx context.Context, attachment *models.Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if thumbnail {
+		if !attachment.HasThumbnail() {
+			return nil, storage.ErrNotFound
+		}
+		return s.Storage.Get(ctx, attachment.ThumbnailKey)
+	}
+	return s.Storage.Get(ctx, attachment.StorageKey)
+}
+
+// Delete removes an attachment and its stored objects
+func (s *Service) Delete(ctx context.Context, attachment *models.Attachment) error {
+	if err := models.DeleteAttachment(s.DB, attachment.ID, attachment.OwnerID); err != nil {
+		return err
+	}
+	s.removeObjects(ctx, attachment)
+	return nil
+}
+
+// removeObjects deletes an attachment's stored objects, logging failures
+func (s *Service) removeObjects(ctx context.Context, attachment *models.Attachment) {
+	for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.Storage.Delete(ctx, key); err != nil {
+			log.Printf("Failed to delete stored object %s: %v", key, err)
+		}
+	}
+}
+
+// processImage strips metadata from an image and generates a JPEG thumbnail
+func processImage(data []byte, contentType string) ([]byte, []byte, error) {
+	// Check the dimensions from the header before decoding the whole image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrUnsupportedType
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, nil, ErrImageTooLarge
+	}
+
+	// Apply the EXIF orientation before it is discarded
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, nil, ErrUnsupportedType
+	}
+
+	var clean bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&clean, img)
+	} else {
+		err = jpeg.Encode(&clean, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	thumbnail, err := makeThumbnail(img)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clean.Bytes(), thumbnail, nil
+}
+
+// makeThumbnail scales an image to fit within ThumbnailSize and encodes it as JPEG
+func makeThumbnail(img image.Image) ([]byte, error) {
+	thumb := imaging.Fit(img, ThumbnailSize, ThumbnailSize, imaging.Lanczos)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extensionFor returns the file extension for a supported content type
+func extensionFor(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "application/pdf":
+		return ".pdf"
+	default:
+		return ""
+	}
+}
+
+// sanitizeFileName strips any path and control characters from an uploaded file name
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || r == '"' || r == 127 {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// randomID generates a random hex identifier for storage keys
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
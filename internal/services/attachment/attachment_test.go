@@ -0,0 +1,169 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jpegWithEXIF encodes a test image and inserts an EXIF segment carrying a GPS marker
+func jpegWithEXIF(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+	encoded := buf.Bytes()
+
+	// APP1 segment: "Exif\0\0" followed by an empty little-endian TIFF header and a GPS marker
+	payload := append([]byte("Exif\x00\x00II*\x00\x08\x00\x00\x00\x00\x00"), []byte("GPSLatitude 37.7749N")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	// Insert the segment straight after the SOI marker
+	result := append([]byte{}, encoded[:2]...)
+	result = append(result, segment...)
+	return append(result, encoded[2:]...)
+}
+
+func setupServiceTest(t *testing.T, email string) (*Service, *models.User, *models.Gun) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, email, "password123", false)
+	require.NoError(t, err)
+
+	gun := models.Gun{Name: "Attachment Gun", OwnerID: user.ID}
+	require.NoError(t, models.CreateGun(db, &gun))
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	return NewService(db, store), user, &gun
+}
+
+func TestUploadStripsEXIFAndCreatesThumbnail(t *testing.T) {
+	service, user, gun := setupServiceTest(t, "attachment-exif@example.com")
+	ctx := context.Background()
+
+	original := jpegWithEXIF(t, 800, 600)
+	require.Contains(t, string(original), "GPSLatitude")
+
+	item, err := service.Upload(ctx, user, gun, models.AttachmentKindPhoto, `C:\photos\my "gun".jpg`, bytes.NewReader(original))
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", item.ContentType)
+	assert.Equal(t, "my gun.jpg", item.FileName)
+	assert.True(t, item.HasThumbnail())
+
+	// The stored image no longer carries EXIF metadata
+	reader, err := service.Open(ctx, item, false)
+	require.NoError(t, err)
+	stored, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "Exif")
+	assert.NotContains(t, string(stored), "GPSLatitude")
+
+	// The thumbnail fits within the thumbnail size
+	reader, err = service.Open(ctx, item, true)
+	require.NoError(t, err)
+	thumb, _, err := image.Decode(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, ThumbnailSize, thumb.Bounds().Dx())
+	assert.LessOrEqual(t, thumb.Bounds().Dy(), ThumbnailSize)
+
+	// Deleting the attachment removes the stored objects
+	require.NoError(t, service.Delete(ctx, item))
+	_, err = service.Open(ctx, item, false)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = service.Open(ctx, item, true)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestUploadStoresPDFWithoutThumbnail(t *testing.T) {
+	service, user, gun := setupServiceTest(t, "attachment-pdf@example.com")
+
+	item, err := service.Upload(context.Background(), user, gun, models.AttachmentKindManual, "manual.pdf", strings.NewReader("%PDF-1.4\n%test document"))
+	require.NoError(t, err)
+	assert.Equal(t, "application/pdf", item.ContentType)
+	assert.False(t, item.HasThumbnail())
+}
+
+func TestUploadRejectsUnsupportedType(t *testing.T) {
+	service, user, gun := setupServiceTest(t, "attachment-type@example.com")
+
+	_, err := service.Upload(context.Background(), user, gun, models.AttachmentKindReceipt, "script.sh", strings.NewReader("#!/bin/sh\necho hi"))
+	assert.ErrorIs(t, err, ErrUnsupportedType)
+}
+
+func TestUploadRejectsOversizedImage(t *testing.T) {
+	service, user, gun := setupServiceTest(t, "attachment-pixels@example.com")
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+
+	// Claim 10000x10000 pixels in the IHDR chunk that follows the signature, fixing up its checksum
+	binary.BigEndian.PutUint32(data[16:], 10000)
+	binary.BigEndian.PutUint32(data[20:], 10000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err := service.Upload(context.Background(), user, gun, models.AttachmentKindPhoto, "huge.png", bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+}
+
+func TestUploadEnforcesQuota(t *testing.T) {
+	service, user, gun := setupServiceTest(t, "attachment-quota@example.com")
+
+	// Fill the free tier quota, leaving less room than the next upload needs
+	require.NoError(t, models.CreateAttachment(service.DB, &models.Attachment{
+		GunID:      gun.ID,
+		OwnerID:    user.ID,
+		Kind:       models.AttachmentKindManual,
+		FileName:   "big.pdf",
+		Size:       entitlements.For(user).MaxAttachmentBytes - 10,
+		StorageKey: "existing.pdf",
+	}))
+
+	_, err := service.Upload(context.Background(), user, gun, models.AttachmentKindManual, "manual.pdf", strings.NewReader("%PDF-1.4\n%test document"))
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// The quota is checked again as the attachment is recorded
+	created, err := models.CreateAttachmentWithinQuota(service.DB, &models.Attachment{
+		GunID:      gun.ID,
+		OwnerID:    user.ID,
+		Kind:       models.AttachmentKindManual,
+		FileName:   "late.pdf",
+		Size:       20,
+		StorageKey: "late.pdf",
+	}, entitlements.For(user).MaxAttachmentBytes)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	// Paid tiers get a larger quota
+	user.SubscriptionTier = "monthly"
+	user.SubscriptionExpiresAt = time.Now().AddDate(0, 1, 0)
+	assert.Greater(t, entitlements.For(user).MaxAttachmentBytes, int64(25<<20))
+}
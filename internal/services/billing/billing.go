@@ -0,0 +1,155 @@
+package billing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stripe/stripe-go/v72"
+)
+
+// ErrSubscriptionNotFound is returned when a customer has no subscription with the provider
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// ErrCustomerNotFound is returned when the provider has no customer with a given email
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// PaymentProvider is an interface for the payment processor behind checkout and webhooks
+type PaymentProvider interface {
+	// CreateCheckoutSession starts a hosted checkout for a plan
+	CreateCheckoutSession(params CheckoutParams) (*CheckoutSession, error)
+
+	// GetCheckoutSession looks up a checkout session by ID
+	GetCheckoutSession(sessionID string) (*CheckoutSession, error)
+
+	// FindSubscriptionID returns the ID of a customer's subscription
+	FindSubscriptionID(customerID string) (string, error)
+
+	// FindCustomerID returns the ID of the customer with the given email, or ErrCustomerNotFound
+	FindCustomerID(email string) (string, error)
+
+	// GetCustomerSubscription returns the customer's live subscription, or ErrSubscriptionNotFound if none is active
+	GetCustomerSubscription(customerID string) (*Subscription, error)
+
+	// ListCustomerCharges returns the successful charges made to a customer, newest first
+	ListCustomerCharges(customerID string) ([]Charge, error)
+
+	// CancelSubscriptionAtPeriodEnd stops a subscription from renewing
+	CancelSubscriptionAtPeriodEnd(subscriptionID string) error
+
+	// CancelSubscription ends a subscription immediately
+	CancelSubscription(subscriptionID string) error
+
+	// ChangeSubscriptionPlan moves a subscription to another recurring plan, invoicing the prorated difference now
+	ChangeSubscriptionPlan(subscriptionID string, plan *models.Plan) (*SubscriptionChange, error)
+
+	// ExtendSubscription pushes a subscription's next renewal out to until without charging for the extra time
+	ExtendSubscription(subscriptionID string, until time.Time) error
+
+	// RefundPayment refunds amount cents of the payment recorded with stripeID and returns the refund's ID.
+	// stripeID may be a checkout session, invoice, payment intent or charge. Repeating a call with the
+	// same idempotencyKey returns the first refund instead of refunding again.
+	RefundPayment(stripeID string, amount int64, idempotencyKey string) (string, error)
+
+	// CreateCoupon registers a coupon with the provider and returns the provider's ID for it
+	CreateCoupon(coupon *models.Coupon) (string, error)
+
+	// ConstructEvent verifies a webhook signature and parses the event
+	ConstructEvent(payload []byte, signature string) (stripe.Event, error)
+}
+
+// CheckoutParams describes a plan purchase
+type CheckoutParams struct {
+	UserID     uint
+	Email      string
+	Plan       *models.Plan
+	Coupon     *models.Coupon // Optional discount, already checked as redeemable
+	TrialDays  int            // Free days before the first charge, recurring plans only
+	SuccessURL string         // May contain the {CHECKOUT_SESSION_ID} placeholder
+	CancelURL  string
+}
+
+// CheckoutSession is a checkout started with the provider
+type CheckoutSession struct {
+	ID       string
+	URL      string // Where to send the user to pay
+	Metadata map[string]string
+}
+
+// SubscriptionChange is the outcome of moving a subscription to another plan
+type SubscriptionChange struct {
+	SubscriptionID   string
+	InvoiceID        string // Invoice for the proration, empty if nothing was invoiced
+	AmountDue        int64  // Prorated amount in cents, zero when the change only left a credit
+	Currency         string
+	CurrentPeriodEnd time.Time
+}
+
+// Subscription is a customer's subscription as the provider sees it
+type Subscription struct {
+	ID                string
+	CustomerID        string
+	Tier              string
+	Status            string // "active", "trialing" or "past_due"
+	CurrentPeriodEnd  time.Time
+	CancelAtPeriodEnd bool
+}
+
+// Charge is money the provider collected from a customer.
+// A local payment may have been recorded against any of its IDs.
+type Charge struct {
+	ID                string
+	InvoiceID         string // Empty for one-time purchases
+	PaymentIntentID   string
+	CheckoutSessionID string // Set when the charge paid for a one-time checkout
+	Amount            int64  // Cents, including tax
+	Refunded          int64  // Cents refunded so far
+	Currency          string
+	Description       string
+	Created           time.Time
+}
+
+// StripeIDs returns the IDs a local payment for the charge may have been recorded with
+func (c Charge) StripeIDs() []string {
+	var ids []string
+	for _, id := range []string{c.CheckoutSessionID, c.InvoiceID, c.PaymentIntentID, c.ID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// checkoutMetadata is attached to every checkout so webhooks can find the user and plan
+func checkoutMetadata(params CheckoutParams) map[string]string {
+	metadata := map[string]string{
+		"user_id":           fmt.Sprintf("%d", params.UserID),
+		"subscription_tier": params.Plan.Tier,
+		"product_id":        params.Plan.StripeProductID,
+	}
+	if params.Coupon != nil {
+		metadata["coupon_code"] = params.Coupon.Code
+	}
+	if params.TrialDays > 0 {
+		metadata["trial_days"] = fmt.Sprintf("%d", params.TrialDays)
+	}
+	return metadata
+}
+
+// New creates the payment provider selected in the configuration
+func New(cfg *config.Config) (PaymentProvider, error) {
+	switch cfg.PaymentBackend {
+	case "", "stripe":
+		return NewStripeProvider(cfg.StripeSecretKey, cfg.StripeWebhookSecret, cfg.StripeTaxEnabled), nil
+	case "fake":
+		// The fake accepts webhooks signed with a well-known secret, so it must never take real traffic
+		if !cfg.IsDevelopment() && !cfg.IsTest() {
+			return nil, fmt.Errorf("the fake payment backend can't be used in the %s environment", cfg.Environment)
+		}
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown payment backend: %s", cfg.PaymentBackend)
+	}
+}
@@ -0,0 +1,473 @@
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// fakeWebhookSecret signs the events emitted by FakeProvider
+const fakeWebhookSecret = "whsec_fake"
+
+// FakeProvider is an in-memory PaymentProvider for tests and offline development.
+// Checkouts complete instantly, and the webhook events a real provider would send
+// can be emitted with a valid signature.
+type FakeProvider struct {
+	mu            sync.Mutex
+	nextID        int
+	sessions      map[string]*fakeSession
+	subscriptions map[string]*FakeSubscription
+	refunds       map[string][]FakeRefund
+	refundKeys    map[string]string   // Refund IDs by idempotency key
+	customers     map[string]string   // Customer IDs by email
+	charges       map[string][]Charge // Charges by customer ID
+}
+
+// FakeRefund is a refund issued by FakeProvider
+type FakeRefund struct {
+	ID     string
+	Amount int64
+}
+
+// FakeSubscription is a subscription held by FakeProvider
+type FakeSubscription struct {
+	ID                 string
+	CustomerID         string
+	Tier               string
+	Price              int64
+	Interval           string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	TrialEnd           time.Time // Zero unless the subscription started with a trial
+	CancelAtPeriodEnd  bool
+	Canceled           bool
+}
+
+// Webhook is a signed webhook delivery
+type Webhook struct {
+	Payload   []byte
+	Signature string // Value for the Stripe-Signature header
+}
+
+// fakeSession is a checkout started with FakeProvider
+type fakeSession struct {
+	CheckoutSession
+	params CheckoutParams
+}
+
+// Ensure FakeProvider implements PaymentProvider
+var _ PaymentProvider = (*FakeProvider)(nil)
+
+// NewFakeProvider creates a new FakeProvider
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		sessions:      make(map[string]*fakeSession),
+		subscriptions: make(map[string]*FakeSubscription),
+		refunds:       make(map[string][]FakeRefund),
+		refundKeys:    make(map[string]string),
+		customers:     make(map[string]string),
+		charges:       make(map[string][]Charge),
+	}
+}
+
+// newID generates a unique ID with the given prefix
+func (p *FakeProvider) newID(prefix string) string {
+	p.nextID++
+	return fmt.Sprintf("%s_fake_%d", prefix, p.nextID)
+}
+
+// CreateCheckoutSession records the checkout and returns its success URL as the payment page
+func (p *FakeProvider) CreateCheckoutSession(params CheckoutParams) (*CheckoutSession, error) {
+	if params.Plan == nil {
+		return nil, errors.New("plan is required")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.newID("cs")
+	s := &fakeSession{
+		CheckoutSession: CheckoutSession{
+			ID:       id,
+			URL:      strings.ReplaceAll(params.SuccessURL, "{CHECKOUT_SESSION_ID}", id),
+			Metadata: checkoutMetadata(params),
+		},
+		params: params,
+	}
+	p.sessions[id] = s
+
+	session := s.CheckoutSession
+	return &session, nil
+}
+
+// GetCheckoutSession looks up a recorded checkout
+func (p *FakeProvider) GetCheckoutSession(sessionID string) (*CheckoutSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.sessions[sessionID]
+	if !ok {
+		return nil, fmt.Errorf("no such checkout session: %s", sessionID)
+	}
+	session := s.CheckoutSession
+	return &session, nil
+}
+
+// FindSubscriptionID returns the ID of the customer's subscription
+func (p *FakeProvider) FindSubscriptionID(customerID string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, subscription := range p.subscriptions {
+		if subscription.CustomerID == customerID && !subscription.Canceled {
+			return subscription.ID, nil
+		}
+	}
+	return "", ErrSubscriptionNotFound
+}
+
+// FindCustomerID returns the customer that completed a checkout with the email
+func (p *FakeProvider) FindCustomerID(email string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if customerID, ok := p.customers[email]; ok {
+		return customerID, nil
+	}
+	return "", ErrCustomerNotFound
+}
+
+// GetCustomerSubscription returns the customer's subscription unless it has been canceled
+func (p *FakeProvider) GetCustomerSubscription(customerID string) (*Subscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, subscription := range p.subscriptions {
+		if subscription.CustomerID != customerID || subscription.Canceled {
+			continue
+		}
+		status := "active"
+		if subscription.TrialEnd.After(time.Now()) {
+			status = "trialing"
+		}
+		return &Subscription{
+			ID:                subscription.ID,
+			CustomerID:        customerID,
+			Tier:              subscription.Tier,
+			Status:            status,
+			CurrentPeriodEnd:  subscription.CurrentPeriodEnd,
+			CancelAtPeriodEnd: subscription.CancelAtPeriodEnd,
+		}, nil
+	}
+	return nil, ErrSubscriptionNotFound
+}
+
+// ListCustomerCharges returns the customer's charges with any refunds made against them
+func (p *FakeProvider) ListCustomerCharges(customerID string) ([]Charge, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	charges := make([]Charge, 0, len(p.charges[customerID]))
+	for i := len(p.charges[customerID]) - 1; i >= 0; i-- {
+		charge := p.charges[customerID][i]
+		for _, id := range charge.StripeIDs() {
+			for _, refund := range p.refunds[id] {
+				charge.Refunded += refund.Amount
+			}
+		}
+		charges = append(charges, charge)
+	}
+	return charges, nil
+}
+
+// AddCharge records a charge made outside checkout, such as a subscription renewal,
+// and returns it with its ID filled in
+func (p *FakeProvider) AddCharge(customerID string, charge Charge) Charge {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.addCharge(customerID, charge)
+}
+
+// addCharge records a charge; the caller must hold the lock
+func (p *FakeProvider) addCharge(customerID string, charge Charge) Charge {
+	if charge.ID == "" {
+		charge.ID = p.newID("ch")
+	}
+	if charge.Currency == "" {
+		charge.Currency = "usd"
+	}
+	if charge.Created.IsZero() {
+		charge.Created = time.Now()
+	}
+	p.charges[customerID] = append(p.charges[customerID], charge)
+	return charge
+}
+
+// CancelSubscriptionAtPeriodEnd marks a subscription as not renewing
+func (p *FakeProvider) CancelSubscriptionAtPeriodEnd(subscriptionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription, ok := p.subscriptions[subscriptionID]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	subscription.CancelAtPeriodEnd = true
+	return nil
+}
+
+// CancelSubscription ends a subscription immediately
+func (p *FakeProvider) CancelSubscription(subscriptionID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription, ok := p.subscriptions[subscriptionID]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	subscription.Canceled = true
+	return nil
+}
+
+// ChangeSubscriptionPlan moves a subscription to another plan, prorating the way Stripe does:
+// the unused part of the current period is credited, a change of interval starts a new period
+// billed in full, and a change within the same interval bills only the rest of the period.
+func (p *FakeProvider) ChangeSubscriptionPlan(subscriptionID string, plan *models.Plan) (*SubscriptionChange, error) {
+	if !plan.IsRecurring() {
+		return nil, errors.New("subscriptions can only move to recurring plans")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription, ok := p.subscriptions[subscriptionID]
+	if !ok || subscription.Canceled {
+		return nil, ErrSubscriptionNotFound
+	}
+
+	now := time.Now()
+	unused := 0.0
+	if period := subscription.CurrentPeriodEnd.Sub(subscription.CurrentPeriodStart); period > 0 && now.Before(subscription.CurrentPeriodEnd) {
+		unused = float64(subscription.CurrentPeriodEnd.Sub(now)) / float64(period)
+	}
+	credit := float64(subscription.Price) * unused
+
+	var due float64
+	if plan.Interval == subscription.Interval {
+		due = float64(plan.Price)*unused - credit
+	} else {
+		due = float64(plan.Price) - credit
+		subscription.CurrentPeriodStart = now
+		subscription.CurrentPeriodEnd = addInterval(now, plan.Interval)
+	}
+
+	subscription.Tier = plan.Tier
+	subscription.Price = plan.Price
+	subscription.Interval = plan.Interval
+	subscription.CancelAtPeriodEnd = false
+
+	change := &SubscriptionChange{
+		SubscriptionID:   subscription.ID,
+		InvoiceID:        p.newID("in"),
+		AmountDue:        int64(math.Max(0, math.Round(due))),
+		Currency:         plan.Currency,
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+	}
+	if change.AmountDue > 0 {
+		p.addCharge(subscription.CustomerID, Charge{
+			InvoiceID:   change.InvoiceID,
+			Amount:      change.AmountDue,
+			Currency:    plan.Currency,
+			Description: "Subscription update",
+		})
+	}
+	return change, nil
+}
+
+// addInterval returns the end of a billing period starting at t
+func addInterval(t time.Time, interval string) time.Time {
+	if interval == models.PlanIntervalYear {
+		return t.AddDate(1, 0, 0)
+	}
+	return t.AddDate(0, 1, 0)
+}
+
+// ExtendSubscription moves the end of the subscription's current period
+func (p *FakeProvider) ExtendSubscription(subscriptionID string, until time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription, ok := p.subscriptions[subscriptionID]
+	if !ok || subscription.Canceled {
+		return ErrSubscriptionNotFound
+	}
+	subscription.TrialEnd = until
+	subscription.CurrentPeriodEnd = until
+	return nil
+}
+
+// RefundPayment records a refund against the payment, once per idempotency key
+func (p *FakeProvider) RefundPayment(stripeID string, amount int64, idempotencyKey string) (string, error) {
+	if stripeID == "" {
+		return "", errors.New("payment has no provider ID to refund")
+	}
+	if amount <= 0 {
+		return "", errors.New("refund amount must be positive")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if refundID, ok := p.refundKeys[idempotencyKey]; ok && idempotencyKey != "" {
+		return refundID, nil
+	}
+	refund := FakeRefund{ID: p.newID("re"), Amount: amount}
+	p.refunds[stripeID] = append(p.refunds[stripeID], refund)
+	p.refundKeys[idempotencyKey] = refund.ID
+	return refund.ID, nil
+}
+
+// Refunds returns the refunds issued against a payment
+func (p *FakeProvider) Refunds(stripeID string) []FakeRefund {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]FakeRefund(nil), p.refunds[stripeID]...)
+}
+
+// CreateCoupon hands out an ID for a coupon; discounts are applied when a checkout completes
+func (p *FakeProvider) CreateCoupon(coupon *models.Coupon) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.newID("coupon"), nil
+}
+
+// ConstructEvent verifies a signature made by Sign and parses the event
+func (p *FakeProvider) ConstructEvent(payload []byte, signature string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, signature, fakeWebhookSecret)
+}
+
+// Subscription returns a copy of a subscription created by a completed checkout
+func (p *FakeProvider) Subscription(subscriptionID string) (FakeSubscription, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription, ok := p.subscriptions[subscriptionID]
+	if !ok {
+		return FakeSubscription{}, false
+	}
+	return *subscription, true
+}
+
+// CustomerID returns the customer ID the provider uses for a user
+func (p *FakeProvider) CustomerID(userID uint) string {
+	return fmt.Sprintf("cus_fake_%d", userID)
+}
+
+// CompleteCheckout pays for a checkout, applying its coupon and creating a subscription
+// for recurring plans, and returns the signed checkout.session.completed event
+func (p *FakeProvider) CompleteCheckout(sessionID string) (*Webhook, error) {
+	p.mu.Lock()
+	s, ok := p.sessions[sessionID]
+	if !ok {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no such checkout session: %s", sessionID)
+	}
+
+	plan := s.params.Plan
+	customerID := p.CustomerID(s.params.UserID)
+	amount := plan.Price
+	if s.params.Coupon != nil {
+		amount -= s.params.Coupon.Discount(plan.Price)
+	}
+	trial := plan.IsRecurring() && s.params.TrialDays > 0
+	if trial {
+		// Nothing is charged until the trial ends
+		amount = 0
+	}
+
+	object := map[string]interface{}{
+		"id":                  s.ID,
+		"object":              "checkout.session",
+		"amount_subtotal":     plan.Price,
+		"amount_total":        amount,
+		"currency":            plan.Currency,
+		"customer":            customerID,
+		"client_reference_id": fmt.Sprintf("%d", s.params.UserID),
+		"metadata":            s.Metadata,
+		"mode":                string(stripe.CheckoutSessionModePayment),
+		"payment_status":      "paid",
+		"status":              "complete",
+	}
+
+	if plan.IsRecurring() {
+		now := time.Now()
+		subscription := &FakeSubscription{
+			ID:                 p.newID("sub"),
+			CustomerID:         customerID,
+			Tier:               plan.Tier,
+			Price:              plan.Price,
+			Interval:           plan.Interval,
+			CurrentPeriodStart: now,
+			CurrentPeriodEnd:   addInterval(now, plan.Interval),
+		}
+		if trial {
+			subscription.TrialEnd = now.AddDate(0, 0, s.params.TrialDays)
+			subscription.CurrentPeriodEnd = subscription.TrialEnd
+		}
+		p.subscriptions[subscription.ID] = subscription
+		object["mode"] = string(stripe.CheckoutSessionModeSubscription)
+		object["subscription"] = subscription.ID
+	}
+
+	p.customers[s.params.Email] = customerID
+	if amount > 0 {
+		p.addCharge(customerID, Charge{
+			CheckoutSessionID: s.ID,
+			Amount:            amount,
+			Currency:          plan.Currency,
+			Description:       plan.Name,
+		})
+	}
+	p.mu.Unlock()
+
+	return p.Sign("checkout.session.completed", object)
+}
+
+// Sign builds an event of the given type around object and signs it the way Stripe does
+func (p *FakeProvider) Sign(eventType string, object interface{}) (*Webhook, error) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	eventID := p.newID("evt")
+	p.mu.Unlock()
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          eventID,
+		"object":      "event",
+		"type":        eventType,
+		"created":     now.Unix(),
+		"api_version": stripe.APIVersion,
+		"data":        map[string]json.RawMessage{"object": raw},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signature := fmt.Sprintf("t=%d,v1=%x", now.Unix(), webhook.ComputeSignature(now, payload, fakeWebhookSecret))
+	return &Webhook{Payload: payload, Signature: signature}, nil
+}
@@ -0,0 +1,134 @@
+package billing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/stripe-go/v72"
+)
+
+func TestFakeProviderCheckoutEmitsSignedEvent(t *testing.T) {
+	provider := NewFakeProvider()
+	plan := &models.Plan{Tier: models.TierMonthly, Name: "Liking It", Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth}
+
+	session, err := provider.CreateCheckoutSession(CheckoutParams{
+		UserID:     7,
+		Email:      "owner@example.com",
+		Plan:       plan,
+		SuccessURL: "http://localhost/payment/success?session_id={CHECKOUT_SESSION_ID}",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost/payment/success?session_id="+session.ID, session.URL)
+	assert.Equal(t, "7", session.Metadata["user_id"])
+	assert.Equal(t, models.TierMonthly, session.Metadata["subscription_tier"])
+
+	hook, err := provider.CompleteCheckout(session.ID)
+	require.NoError(t, err)
+
+	event, err := provider.ConstructEvent(hook.Payload, hook.Signature)
+	require.NoError(t, err)
+	assert.Equal(t, "checkout.session.completed", string(event.Type))
+
+	var completed stripe.CheckoutSession
+	require.NoError(t, json.Unmarshal(event.Data.Raw, &completed))
+	assert.Equal(t, session.ID, completed.ID)
+	assert.Equal(t, int64(500), completed.AmountTotal)
+	assert.Equal(t, provider.CustomerID(7), completed.Customer.ID)
+	require.NotNil(t, completed.Subscription)
+
+	// The recurring plan created a subscription that can be cancelled
+	subscriptionID, err := provider.FindSubscriptionID(provider.CustomerID(7))
+	require.NoError(t, err)
+	assert.Equal(t, completed.Subscription.ID, subscriptionID)
+	require.NoError(t, provider.CancelSubscriptionAtPeriodEnd(subscriptionID))
+
+	subscription, ok := provider.Subscription(subscriptionID)
+	require.True(t, ok)
+	assert.True(t, subscription.CancelAtPeriodEnd)
+}
+
+func TestFakeProviderRejectsBadSignature(t *testing.T) {
+	provider := NewFakeProvider()
+
+	hook, err := provider.Sign("invoice.paid", map[string]interface{}{"id": "in_1", "object": "invoice"})
+	require.NoError(t, err)
+
+	_, err = provider.ConstructEvent(hook.Payload, "t=1,v1=deadbeef")
+	assert.Error(t, err)
+
+	tampered := append([]byte{}, hook.Payload...)
+	tampered[len(tampered)-2] = ' '
+	_, err = provider.ConstructEvent(tampered, hook.Signature)
+	assert.Error(t, err)
+}
+
+func TestFakeProviderOneTimePlanHasNoSubscription(t *testing.T) {
+	provider := NewFakeProvider()
+	plan := &models.Plan{Tier: models.TierLifetime, Price: 10000, Currency: "usd"}
+
+	session, err := provider.CreateCheckoutSession(CheckoutParams{UserID: 1, Plan: plan})
+	require.NoError(t, err)
+	_, err = provider.CompleteCheckout(session.ID)
+	require.NoError(t, err)
+
+	_, err = provider.FindSubscriptionID(provider.CustomerID(1))
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+}
+
+func TestFakeProviderChangeSubscriptionPlanProrates(t *testing.T) {
+	provider := NewFakeProvider()
+	monthly := &models.Plan{Tier: models.TierMonthly, Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth}
+	yearly := &models.Plan{Tier: models.TierYearly, Price: 3000, Currency: "usd", Interval: models.PlanIntervalYear}
+
+	session, err := provider.CreateCheckoutSession(CheckoutParams{UserID: 3, Plan: monthly})
+	require.NoError(t, err)
+	_, err = provider.CompleteCheckout(session.ID)
+	require.NoError(t, err)
+	subscriptionID, err := provider.FindSubscriptionID(provider.CustomerID(3))
+	require.NoError(t, err)
+
+	// Moving to a yearly plan starts a new period, less the unused monthly time
+	change, err := provider.ChangeSubscriptionPlan(subscriptionID, yearly)
+	require.NoError(t, err)
+	assert.NotEmpty(t, change.InvoiceID)
+	assert.InDelta(t, 2500, change.AmountDue, 1)
+	assert.True(t, change.CurrentPeriodEnd.After(time.Now().AddDate(0, 11, 0)))
+
+	subscription, _ := provider.Subscription(subscriptionID)
+	assert.Equal(t, models.TierYearly, subscription.Tier)
+
+	// Moving back leaves only a credit
+	change, err = provider.ChangeSubscriptionPlan(subscriptionID, monthly)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), change.AmountDue)
+
+	// One-time plans need a checkout instead
+	_, err = provider.ChangeSubscriptionPlan(subscriptionID, &models.Plan{Tier: models.TierLifetime, Price: 10000})
+	assert.Error(t, err)
+
+	// A cancelled subscription can't be changed or found again
+	require.NoError(t, provider.CancelSubscription(subscriptionID))
+	_, err = provider.ChangeSubscriptionPlan(subscriptionID, yearly)
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+	_, err = provider.FindSubscriptionID(provider.CustomerID(3))
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+}
+
+func TestNewOnlyAllowsFakeBackendOutsideProduction(t *testing.T) {
+	for _, env := range []string{"development", "test"} {
+		provider, err := New(&config.Config{PaymentBackend: "fake", Environment: env})
+		require.NoError(t, err, env)
+		assert.IsType(t, &FakeProvider{}, provider, env)
+	}
+
+	_, err := New(&config.Config{PaymentBackend: "fake", Environment: "production"})
+	assert.Error(t, err)
+
+	_, err = New(&config.Config{PaymentBackend: "fakee", Environment: "development"})
+	assert.Error(t, err)
+}
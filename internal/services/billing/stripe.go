@@ -0,0 +1,394 @@
+package billing
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// StripeProvider processes payments with Stripe
+type StripeProvider struct {
+	api           *client.API
+	webhookSecret string
+	taxEnabled    bool
+}
+
+// Ensure StripeProvider implements PaymentProvider
+var _ PaymentProvider = (*StripeProvider)(nil)
+
+// NewStripeProvider creates a new StripeProvider
+func NewStripeProvider(secretKey, webhookSecret string, taxEnabled bool) *StripeProvider {
+	return &StripeProvider{
+		api:           client.New(secretKey, nil),
+		webhookSecret: webhookSecret,
+		taxEnabled:    taxEnabled,
+	}
+}
+
+// CreateCheckoutSession starts a Stripe Checkout session for a plan
+func (p *StripeProvider) CreateCheckoutSession(params CheckoutParams) (*CheckoutSession, error) {
+	plan := params.Plan
+
+	lineItem := &stripe.CheckoutSessionLineItemParams{Quantity: stripe.Int64(1)}
+	if strings.HasPrefix(plan.StripeProductID, "price_") {
+		// The catalog may point straight at a Stripe price
+		lineItem.Price = stripe.String(plan.StripeProductID)
+	} else {
+		lineItem.PriceData = &stripe.CheckoutSessionLineItemPriceDataParams{
+			Currency:    stripe.String(plan.Currency),
+			UnitAmount:  stripe.Int64(plan.Price),
+			TaxBehavior: stripe.String("exclusive"), // Add tax on top of the price
+		}
+		if plan.StripeProductID != "" {
+			// Charge against the product configured in Stripe
+			lineItem.PriceData.Product = stripe.String(plan.StripeProductID)
+		} else {
+			lineItem.PriceData.ProductData = &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
+				Name:    stripe.String(plan.Name),
+				TaxCode: stripe.String("txcd_10000000"), // Standard tax code
+			}
+		}
+		if plan.IsRecurring() {
+			lineItem.PriceData.Recurring = &stripe.CheckoutSessionLineItemPriceDataRecurringParams{
+				Interval:      stripe.String(plan.Interval),
+				IntervalCount: stripe.Int64(1),
+			}
+		}
+	}
+
+	mode := stripe.CheckoutSessionModePayment
+	if plan.IsRecurring() {
+		mode = stripe.CheckoutSessionModeSubscription
+	}
+
+	sessionParams := &stripe.CheckoutSessionParams{
+		PaymentMethodTypes:       stripe.StringSlice([]string{"card"}),
+		LineItems:                []*stripe.CheckoutSessionLineItemParams{lineItem},
+		Mode:                     stripe.String(string(mode)),
+		SuccessURL:               stripe.String(params.SuccessURL),
+		CancelURL:                stripe.String(params.CancelURL),
+		CustomerEmail:            stripe.String(params.Email),
+		AutomaticTax:             &stripe.CheckoutSessionAutomaticTaxParams{Enabled: stripe.Bool(p.taxEnabled)},
+		BillingAddressCollection: stripe.String(string(stripe.CheckoutSessionBillingAddressCollectionRequired)),
+	}
+
+	if params.Coupon != nil {
+		sessionParams.Discounts = []*stripe.CheckoutSessionDiscountParams{
+			{Coupon: stripe.String(params.Coupon.StripeCouponID)},
+		}
+	}
+	if plan.IsRecurring() {
+		// The subscription carries the plan's tier so its renewal invoices can be matched to the plan
+		sessionParams.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{"subscription_tier": plan.Tier},
+		}
+		if params.TrialDays > 0 {
+			sessionParams.SubscriptionData.TrialPeriodDays = stripe.Int64(int64(params.TrialDays))
+		}
+	}
+
+	for key, value := range checkoutMetadata(params) {
+		sessionParams.AddMetadata(key, value)
+	}
+
+	s, err := p.api.CheckoutSessions.New(sessionParams)
+	if err != nil && p.taxEnabled && strings.Contains(err.Error(), "tax") {
+		// Automatic tax isn't set up on every account, so try again without it
+		log.Printf("Retrying checkout session creation without automatic tax: %v", err)
+		sessionParams.AutomaticTax.Enabled = stripe.Bool(false)
+		s, err = p.api.CheckoutSessions.New(sessionParams)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckoutSession{ID: s.ID, URL: s.URL, Metadata: s.Metadata}, nil
+}
+
+// GetCheckoutSession retrieves a Stripe Checkout session
+func (p *StripeProvider) GetCheckoutSession(sessionID string) (*CheckoutSession, error) {
+	s, err := p.api.CheckoutSessions.Get(sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckoutSession{ID: s.ID, URL: s.URL, Metadata: s.Metadata}, nil
+}
+
+// FindSubscriptionID returns the ID of the customer's first Stripe subscription
+func (p *StripeProvider) FindSubscriptionID(customerID string) (string, error) {
+	params := &stripe.SubscriptionListParams{Customer: customerID}
+	iter := p.api.Subscriptions.List(params)
+	if iter.Next() {
+		return iter.Subscription().ID, nil
+	}
+	if err := iter.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrSubscriptionNotFound
+}
+
+// FindCustomerID returns the ID of the first Stripe customer with the email
+func (p *StripeProvider) FindCustomerID(email string) (string, error) {
+	iter := p.api.Customers.List(&stripe.CustomerListParams{Email: stripe.String(email)})
+	if iter.Next() {
+		return iter.Customer().ID, nil
+	}
+	if err := iter.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrCustomerNotFound
+}
+
+// GetCustomerSubscription returns the customer's first Stripe subscription that's still billing
+func (p *StripeProvider) GetCustomerSubscription(customerID string) (*Subscription, error) {
+	iter := p.api.Subscriptions.List(&stripe.SubscriptionListParams{Customer: customerID})
+	for iter.Next() {
+		s := iter.Subscription()
+		switch s.Status {
+		case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing, stripe.SubscriptionStatusPastDue:
+		default:
+			continue
+		}
+		return &Subscription{
+			ID:                s.ID,
+			CustomerID:        customerID,
+			Tier:              stripeSubscriptionTier(s),
+			Status:            string(s.Status),
+			CurrentPeriodEnd:  time.Unix(s.CurrentPeriodEnd, 0),
+			CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		}, nil
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrSubscriptionNotFound
+}
+
+// stripeSubscriptionTier works out a subscription's tier from the metadata set on plan changes,
+// falling back to the billing interval of its price
+func stripeSubscriptionTier(s *stripe.Subscription) string {
+	if tier := s.Metadata["subscription_tier"]; tier != "" {
+		return tier
+	}
+	if s.Items != nil && len(s.Items.Data) > 0 {
+		if price := s.Items.Data[0].Price; price != nil && price.Recurring != nil && price.Recurring.Interval == stripe.PriceRecurringIntervalYear {
+			return models.TierYearly
+		}
+	}
+	return models.TierMonthly
+}
+
+// ListCustomerCharges returns the customer's successful Stripe charges, linking one-time
+// charges to the checkout sessions that made them
+func (p *StripeProvider) ListCustomerCharges(customerID string) ([]Charge, error) {
+	sessions := make(map[string]string)
+	sessionIter := p.api.CheckoutSessions.List(&stripe.CheckoutSessionListParams{Customer: stripe.String(customerID)})
+	for sessionIter.Next() {
+		s := sessionIter.CheckoutSession()
+		if s.PaymentIntent != nil {
+			sessions[s.PaymentIntent.ID] = s.ID
+		}
+	}
+	if err := sessionIter.Err(); err != nil {
+		return nil, err
+	}
+
+	var charges []Charge
+	iter := p.api.Charges.List(&stripe.ChargeListParams{Customer: stripe.String(customerID)})
+	for iter.Next() {
+		ch := iter.Charge()
+		if ch.Status != stripe.ChargeStatusSucceeded {
+			continue
+		}
+		charge := Charge{
+			ID:          ch.ID,
+			Amount:      ch.Amount,
+			Refunded:    ch.AmountRefunded,
+			Currency:    string(ch.Currency),
+			Description: ch.Description,
+			Created:     time.Unix(ch.Created, 0),
+		}
+		if ch.Invoice != nil {
+			charge.InvoiceID = ch.Invoice.ID
+		}
+		if ch.PaymentIntent != nil {
+			charge.PaymentIntentID = ch.PaymentIntent.ID
+			charge.CheckoutSessionID = sessions[ch.PaymentIntent.ID]
+		}
+		charges = append(charges, charge)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return charges, nil
+}
+
+// CancelSubscriptionAtPeriodEnd stops a Stripe subscription from renewing
+func (p *StripeProvider) CancelSubscriptionAtPeriodEnd(subscriptionID string) error {
+	_, err := p.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(true),
+	})
+	return err
+}
+
+// CancelSubscription cancels a Stripe subscription immediately
+func (p *StripeProvider) CancelSubscription(subscriptionID string) error {
+	_, err := p.api.Subscriptions.Cancel(subscriptionID, nil)
+	return err
+}
+
+// ExtendSubscription moves the next renewal of a Stripe subscription by giving it a trial until then
+func (p *StripeProvider) ExtendSubscription(subscriptionID string, until time.Time) error {
+	_, err := p.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		TrialEnd:          stripe.Int64(until.Unix()),
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorNone)),
+	})
+	return err
+}
+
+// RefundPayment refunds a Stripe payment, finding the payment intent behind the recorded object
+func (p *StripeProvider) RefundPayment(stripeID string, amount int64, idempotencyKey string) (string, error) {
+	params := &stripe.RefundParams{Amount: stripe.Int64(amount)}
+	params.SetIdempotencyKey(idempotencyKey)
+	if strings.HasPrefix(stripeID, "ch_") {
+		params.Charge = stripe.String(stripeID)
+	} else {
+		paymentIntentID, err := p.findPaymentIntentID(stripeID)
+		if err != nil {
+			return "", err
+		}
+		params.PaymentIntent = stripe.String(paymentIntentID)
+	}
+
+	r, err := p.api.Refunds.New(params)
+	if err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
+
+// findPaymentIntentID returns the payment intent that paid for a checkout session or invoice
+func (p *StripeProvider) findPaymentIntentID(stripeID string) (string, error) {
+	switch {
+	case strings.HasPrefix(stripeID, "pi_"):
+		return stripeID, nil
+	case strings.HasPrefix(stripeID, "in_"):
+		inv, err := p.api.Invoices.Get(stripeID, nil)
+		if err != nil {
+			return "", err
+		}
+		if inv.PaymentIntent == nil {
+			return "", fmt.Errorf("invoice %s has no payment to refund", stripeID)
+		}
+		return inv.PaymentIntent.ID, nil
+	case strings.HasPrefix(stripeID, "cs_"):
+		// Subscription checkouts are paid by the subscription's first invoice
+		params := &stripe.CheckoutSessionParams{}
+		params.AddExpand("subscription.latest_invoice")
+		s, err := p.api.CheckoutSessions.Get(stripeID, params)
+		if err != nil {
+			return "", err
+		}
+		if s.PaymentIntent != nil {
+			return s.PaymentIntent.ID, nil
+		}
+		if s.Subscription != nil && s.Subscription.LatestInvoice != nil && s.Subscription.LatestInvoice.PaymentIntent != nil {
+			return s.Subscription.LatestInvoice.PaymentIntent.ID, nil
+		}
+		return "", fmt.Errorf("checkout session %s has no payment to refund", stripeID)
+	default:
+		return "", fmt.Errorf("can't refund %q: not a Stripe payment", stripeID)
+	}
+}
+
+// ChangeSubscriptionPlan swaps the price on a Stripe subscription and invoices the proration right away
+func (p *StripeProvider) ChangeSubscriptionPlan(subscriptionID string, plan *models.Plan) (*SubscriptionChange, error) {
+	current, err := p.api.Subscriptions.Get(subscriptionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if current.Items == nil || len(current.Items.Data) == 0 {
+		return nil, fmt.Errorf("subscription %s has no items", subscriptionID)
+	}
+
+	item := &stripe.SubscriptionItemsParams{ID: stripe.String(current.Items.Data[0].ID)}
+	if strings.HasPrefix(plan.StripeProductID, "price_") {
+		// The catalog may point straight at a Stripe price
+		item.Price = stripe.String(plan.StripeProductID)
+	} else {
+		item.PriceData = &stripe.SubscriptionItemPriceDataParams{
+			Currency:    stripe.String(plan.Currency),
+			Product:     stripe.String(plan.StripeProductID),
+			UnitAmount:  stripe.Int64(plan.Price),
+			TaxBehavior: stripe.String("exclusive"),
+			Recurring: &stripe.SubscriptionItemPriceDataRecurringParams{
+				Interval:      stripe.String(plan.Interval),
+				IntervalCount: stripe.Int64(1),
+			},
+		}
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items:             []*stripe.SubscriptionItemsParams{item},
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorAlwaysInvoice)),
+		CancelAtPeriodEnd: stripe.Bool(false),
+	}
+	params.AddMetadata("subscription_tier", plan.Tier)
+	params.AddExpand("latest_invoice")
+
+	updated, err := p.api.Subscriptions.Update(subscriptionID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &SubscriptionChange{
+		SubscriptionID:   updated.ID,
+		Currency:         plan.Currency,
+		CurrentPeriodEnd: time.Unix(updated.CurrentPeriodEnd, 0),
+	}
+	if updated.LatestInvoice != nil {
+		change.InvoiceID = updated.LatestInvoice.ID
+		change.AmountDue = updated.LatestInvoice.AmountDue
+	}
+	return change, nil
+}
+
+// CreateCoupon creates the Stripe coupon applied at checkout for a promo code
+func (p *StripeProvider) CreateCoupon(coupon *models.Coupon) (string, error) {
+	params := &stripe.CouponParams{
+		Name:     stripe.String(coupon.Code),
+		Duration: stripe.String(coupon.Duration),
+	}
+	if coupon.PercentOff > 0 {
+		params.PercentOff = stripe.Float64(float64(coupon.PercentOff))
+	} else {
+		params.AmountOff = stripe.Int64(coupon.AmountOff)
+		params.Currency = stripe.String(coupon.Currency)
+	}
+	if coupon.Duration == models.CouponDurationRepeating {
+		params.DurationInMonths = stripe.Int64(int64(coupon.DurationInMonths))
+	}
+	if coupon.MaxRedemptions > 0 {
+		params.MaxRedemptions = stripe.Int64(int64(coupon.MaxRedemptions))
+	}
+	if coupon.ExpiresAt != nil {
+		params.RedeemBy = stripe.Int64(coupon.ExpiresAt.Unix())
+	}
+
+	c, err := p.api.Coupons.New(params)
+	if err != nil {
+		return "", err
+	}
+	return c.ID, nil
+}
+
+// ConstructEvent verifies the Stripe-Signature header and parses the event
+func (p *StripeProvider) ConstructEvent(payload []byte, signature string) (stripe.Event, error) {
+	return webhook.ConstructEvent(payload, signature, p.webhookSecret)
+}
@@ -1,5 +1,11 @@
 package email
 
+import (
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+)
+
 // EmailService is an interface for email services
 type EmailService interface {
 	// IsConfigured returns whether the email service is configured
@@ -13,4 +19,19 @@ type EmailService interface {
 
 	// SendContactFormEmail sends a contact form submission to the admin
 	SendContactFormEmail(name, email, subject, message string) error
+
+	// SendMaintenanceReminderEmail notifies an owner that guns are due for service
+	SendMaintenanceReminderEmail(email string, overdue []string) error
+
+	// SendDataExportEmail sends a link to download a personal data export
+	SendDataExportEmail(email, token string, expiresAt time.Time) error
+
+	// SendDataExportFailedEmail tells a user their personal data export couldn't be generated
+	SendDataExportFailedEmail(email string) error
+
+	// SendPaymentFailedEmail reminds a user that their renewal failed and when their plan will be downgraded
+	SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error
+
+	// SendReceiptEmail sends a receipt for a payment with the PDF receipt attached
+	SendReceiptEmail(email string, payment models.Payment, pdf []byte) error
 }
@@ -1,10 +1,15 @@
 package email
 
 import (
+	"encoding/base64"
 	"fmt"
+	"html"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
 	mailjet "github.com/mailjet/mailjet-apiv3-go/v3"
 )
 
@@ -202,3 +207,247 @@ To reply, simply respond to this email or send a new email to: %s
 	log.Printf("Contact form email sent to admin from %s <%s>", name, email)
 	return nil
 }
+
+// SendMaintenanceReminderEmail notifies an owner that guns are due for service
+func (s *MailJetService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	if !s.isConfigured {
+		log.Println("MailJet not configured. Skipping maintenance reminder email.")
+		return nil
+	}
+
+	dashboardLink := fmt.Sprintf("%s/owner", s.appBaseURL)
+
+	// Build the list of overdue guns
+	var htmlItems strings.Builder
+	for _, item := range overdue {
+		htmlItems.WriteString(fmt.Sprintf("<li>%s</li>", html.EscapeString(item)))
+	}
+
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: s.senderEmail,
+				Name:  s.senderName,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: email,
+				},
+			},
+			Subject:  "Maintenance Reminder - The Virtual Armory",
+			TextPart: fmt.Sprintf("The following guns are due for service:\n\n- %s\n\nView your armory: %s", strings.Join(overdue, "\n- "), dashboardLink),
+			HTMLPart: fmt.Sprintf(`
+				<h3>Maintenance Reminder</h3>
+				<p>The following guns are due for service:</p>
+				<ul>%s</ul>
+				<p><a href="%s">View Your Armory</a></p>
+				<p>Thank you,<br>The Virtual Armory Team</p>
+			`, htmlItems.String(), dashboardLink),
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := s.client.SendMailV31(&messages)
+	if err != nil {
+		log.Printf("Error sending maintenance reminder email: %v", err)
+		return err
+	}
+
+	log.Printf("Maintenance reminder email sent to %s", email)
+	return nil
+}
+
+// SendDataExportEmail sends a link to download a personal data export
+func (s *MailJetService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	if !s.isConfigured {
+		log.Println("MailJet not configured. Skipping data export email.")
+		return nil
+	}
+
+	downloadLink := fmt.Sprintf("%s/profile/export/%s", s.appBaseURL, token)
+	expires := expiresAt.Format("January 2, 2006 at 3:04 PM MST")
+
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: s.senderEmail,
+				Name:  s.senderName,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: email,
+				},
+			},
+			Subject:  "Your Data Export Is Ready - The Virtual Armory",
+			TextPart: fmt.Sprintf("Your data export is ready. Download it while signed in to your account: %s\n\nThis link expires %s.", downloadLink, expires),
+			HTMLPart: fmt.Sprintf(`
+				<h3>Your Data Export Is Ready</h3>
+				<p>You'll need to be signed in to your account to download it.</p>
+				<p><a href="%s">Download Your Data</a></p>
+				<p>This link expires %s. If you didn't request an export, please change your password.</p>
+				<p>Thank you,<br>The Virtual Armory Team</p>
+			`, downloadLink, html.EscapeString(expires)),
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := s.client.SendMailV31(&messages)
+	if err != nil {
+		log.Printf("Error sending data export email: %v", err)
+		return err
+	}
+
+	log.Printf("Data export email sent to %s", email)
+	return nil
+}
+
+// SendDataExportFailedEmail tells a user their personal data export couldn't be generated
+func (s *MailJetService) SendDataExportFailedEmail(email string) error {
+	if !s.isConfigured {
+		log.Println("MailJet not configured. Skipping data export failed email.")
+		return nil
+	}
+
+	profileLink := fmt.Sprintf("%s/profile", s.appBaseURL)
+
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: s.senderEmail,
+				Name:  s.senderName,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: email,
+				},
+			},
+			Subject:  "Your Data Export Couldn't Be Created - The Virtual Armory",
+			TextPart: fmt.Sprintf("We weren't able to create the data export you requested. Please request a new one from your profile: %s", profileLink),
+			HTMLPart: fmt.Sprintf(`
+				<h3>Your Data Export Couldn't Be Created</h3>
+				<p>We weren't able to create the data export you requested. Please request a new one from your profile.</p>
+				<p><a href="%s">Go to Your Profile</a></p>
+				<p>Thank you,<br>The Virtual Armory Team</p>
+			`, profileLink),
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := s.client.SendMailV31(&messages)
+	if err != nil {
+		log.Printf("Error sending data export failed email: %v", err)
+		return err
+	}
+
+	log.Printf("Data export failed email sent to %s", email)
+	return nil
+}
+
+// SendPaymentFailedEmail reminds a user that their renewal failed and when their plan will be downgraded
+func (s *MailJetService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	if !s.isConfigured {
+		log.Println("MailJet not configured. Skipping payment failed email.")
+		return nil
+	}
+
+	subscriptionLink := fmt.Sprintf("%s/profile/subscription", s.appBaseURL)
+	deadline := graceEndsAt.Format("January 2, 2006")
+
+	subject := "Payment Failed - The Virtual Armory"
+	if reminder > 1 {
+		subject = fmt.Sprintf("Reminder %d: Payment Failed - The Virtual Armory", reminder)
+	}
+
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: s.senderEmail,
+				Name:  s.senderName,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: email,
+				},
+			},
+			Subject:  subject,
+			TextPart: fmt.Sprintf("We couldn't collect the payment for your subscription. Please update your payment details before %s or your account will move to the free plan.\n\nManage your subscription: %s", deadline, subscriptionLink),
+			HTMLPart: fmt.Sprintf(`
+				<h3>Your Payment Failed</h3>
+				<p>We couldn't collect the payment for your subscription.</p>
+				<p>Please update your payment details before <strong>%s</strong> or your account will move to the free plan.</p>
+				<p><a href="%s">Manage Your Subscription</a></p>
+				<p>Thank you,<br>The Virtual Armory Team</p>
+			`, html.EscapeString(deadline), subscriptionLink),
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := s.client.SendMailV31(&messages)
+	if err != nil {
+		log.Printf("Error sending payment failed email: %v", err)
+		return err
+	}
+
+	log.Printf("Payment failed email %d sent to %s", reminder, email)
+	return nil
+}
+
+// SendReceiptEmail sends a receipt for a payment with the PDF receipt attached
+func (s *MailJetService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	if !s.isConfigured {
+		log.Println("MailJet not configured. Skipping receipt email.")
+		return nil
+	}
+
+	historyLink := fmt.Sprintf("%s/owner/payment-history", s.appBaseURL)
+	receiptNumber := payment.ReceiptNumber()
+
+	details := fmt.Sprintf("Receipt: %s\nDescription: %s\nAmount paid: %s (includes %s tax)", receiptNumber, payment.Description, payment.FormatAmount(), payment.FormatTax())
+	htmlDetails := fmt.Sprintf("<p><strong>Receipt:</strong> %s<br><strong>Description:</strong> %s<br><strong>Amount paid:</strong> %s (includes %s tax)",
+		receiptNumber, html.EscapeString(payment.Description), html.EscapeString(payment.FormatAmount()), html.EscapeString(payment.FormatTax()))
+	if period := payment.BillingPeriod(); period != "" {
+		details += "\nBilling period: " + period
+		htmlDetails += "<br><strong>Billing period:</strong> " + html.EscapeString(period)
+	}
+	htmlDetails += "</p>"
+
+	messagesInfo := []mailjet.InfoMessagesV31{
+		{
+			From: &mailjet.RecipientV31{
+				Email: s.senderEmail,
+				Name:  s.senderName,
+			},
+			To: &mailjet.RecipientsV31{
+				mailjet.RecipientV31{
+					Email: email,
+				},
+			},
+			Subject:  "Your Receipt " + receiptNumber + " - The Virtual Armory",
+			TextPart: fmt.Sprintf("Thank you for your payment. Your receipt is attached.\n\n%s\n\nView your payment history: %s", details, historyLink),
+			HTMLPart: fmt.Sprintf(`
+				<h3>Thank You for Your Payment</h3>
+				<p>Your receipt is attached.</p>
+				%s
+				<p><a href="%s">View Your Payment History</a></p>
+				<p>Thank you,<br>The Virtual Armory Team</p>
+			`, htmlDetails, historyLink),
+			Attachments: &mailjet.AttachmentsV31{
+				mailjet.AttachmentV31{
+					ContentType:   "application/pdf",
+					Filename:      "receipt-" + receiptNumber + ".pdf",
+					Base64Content: base64.StdEncoding.EncodeToString(pdf),
+				},
+			},
+		},
+	}
+
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	_, err := s.client.SendMailV31(&messages)
+	if err != nil {
+		log.Printf("Error sending receipt email: %v", err)
+		return err
+	}
+
+	log.Printf("Receipt %s sent to %s", receiptNumber, email)
+	return nil
+}
@@ -1,5 +1,11 @@
 package email
 
+import (
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+)
+
 // MockEmailService is a mock implementation of the EmailService interface for testing
 type MockEmailService struct {
 	SendVerificationEmailCalled bool
@@ -19,6 +25,33 @@ type MockEmailService struct {
 	SendContactFormEmailMessage string
 	SendContactFormEmailError   error
 
+	SendMaintenanceReminderEmailCalled  bool
+	SendMaintenanceReminderEmailEmail   string
+	SendMaintenanceReminderEmailOverdue []string
+	SendMaintenanceReminderEmailError   error
+
+	SendDataExportEmailCalled    bool
+	SendDataExportEmailEmail     string
+	SendDataExportEmailToken     string
+	SendDataExportEmailExpiresAt time.Time
+	SendDataExportEmailError     error
+
+	SendDataExportFailedEmailCalled bool
+	SendDataExportFailedEmailEmail  string
+	SendDataExportFailedEmailError  error
+
+	SendPaymentFailedEmailCalls       int
+	SendPaymentFailedEmailEmail       string
+	SendPaymentFailedEmailReminder    int
+	SendPaymentFailedEmailGraceEndsAt time.Time
+	SendPaymentFailedEmailError       error
+
+	SendReceiptEmailCalls   int
+	SendReceiptEmailEmail   string
+	SendReceiptEmailPayment models.Payment
+	SendReceiptEmailPDF     []byte
+	SendReceiptEmailError   error
+
 	IsConfiguredCalled bool
 	IsConfiguredResult bool
 }
@@ -49,6 +82,48 @@ func (m *MockEmailService) SendContactFormEmail(name, email, subject, message st
 	return m.SendContactFormEmailError
 }
 
+// SendMaintenanceReminderEmail is a mock implementation that records the call
+func (m *MockEmailService) SendMaintenanceReminderEmail(email string, overdue []string) error {
+	m.SendMaintenanceReminderEmailCalled = true
+	m.SendMaintenanceReminderEmailEmail = email
+	m.SendMaintenanceReminderEmailOverdue = overdue
+	return m.SendMaintenanceReminderEmailError
+}
+
+// SendDataExportEmail is a mock implementation that records the call
+func (m *MockEmailService) SendDataExportEmail(email, token string, expiresAt time.Time) error {
+	m.SendDataExportEmailCalled = true
+	m.SendDataExportEmailEmail = email
+	m.SendDataExportEmailToken = token
+	m.SendDataExportEmailExpiresAt = expiresAt
+	return m.SendDataExportEmailError
+}
+
+// SendDataExportFailedEmail is a mock implementation that records the call
+func (m *MockEmailService) SendDataExportFailedEmail(email string) error {
+	m.SendDataExportFailedEmailCalled = true
+	m.SendDataExportFailedEmailEmail = email
+	return m.SendDataExportFailedEmailError
+}
+
+// SendPaymentFailedEmail is a mock implementation that records the call
+func (m *MockEmailService) SendPaymentFailedEmail(email string, reminder int, graceEndsAt time.Time) error {
+	m.SendPaymentFailedEmailCalls++
+	m.SendPaymentFailedEmailEmail = email
+	m.SendPaymentFailedEmailReminder = reminder
+	m.SendPaymentFailedEmailGraceEndsAt = graceEndsAt
+	return m.SendPaymentFailedEmailError
+}
+
+// SendReceiptEmail is a mock implementation that records the call
+func (m *MockEmailService) SendReceiptEmail(email string, payment models.Payment, pdf []byte) error {
+	m.SendReceiptEmailCalls++
+	m.SendReceiptEmailEmail = email
+	m.SendReceiptEmailPayment = payment
+	m.SendReceiptEmailPDF = pdf
+	return m.SendReceiptEmailError
+}
+
 // IsConfigured is a mock implementation that returns a predefined result
 func (m *MockEmailService) IsConfigured() bool {
 	m.IsConfiguredCalled = true
@@ -0,0 +1,449 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"gorm.io/gorm"
+)
+
+// LargeExportSize is the estimated archive size above which exports are generated in the background
+const LargeExportSize = 5 << 20 // 5 MB
+
+// LinkTTL is how long the emailed download link for a background export works
+const LinkTTL = 7 * 24 * time.Hour
+
+// estimatedRecordSize is the rough number of bytes one record adds to an archive
+const estimatedRecordSize = 512
+
+// dataset is one kind of record in an export, written as both JSON and CSV
+type dataset struct {
+	name    string
+	columns []string
+	rows    [][]interface{}
+}
+
+// add appends a row, which must have one value per column
+func (d *dataset) add(values ...interface{}) {
+	d.rows = append(d.rows, values)
+}
+
+// Exporter builds a ZIP archive of everything stored about a user
+type Exporter struct {
+	DB      *gorm.DB
+	Storage storage.Storage // Optional, attachment files are left out without it
+}
+
+// NewExporter creates a new Exporter
+func NewExporter(db *gorm.DB, store storage.Storage) *Exporter {
+	return &Exporter{
+		DB:      db,
+		Storage: store,
+	}
+}
+
+// EstimateSize returns a rough size for a user's archive without building it
+func (e *Exporter) EstimateSize(userID uint) (int64, error) {
+	var records int64
+	for _, model := range []interface{}{&models.Gun{}, &models.Ammo{}, &models.RangeSession{}, &models.MaintenanceRecord{}, &models.Valuation{}, &models.Attachment{}} {
+		var count int64
+		if err := e.DB.Model(model).Where("owner_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		records += count
+	}
+
+	for _, model := range []interface{}{&models.Payment{}, &models.SubscriptionChange{}, &models.CouponRedemption{}, &models.BillingAdjustment{},
+		&models.LoginSession{}, &models.PasskeyCredential{}, &models.RecoveryCode{}} {
+		var count int64
+		if err := e.DB.Model(model).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		records += count
+	}
+
+	size := records * estimatedRecordSize
+	if e.Storage != nil {
+		used, err := models.GetStorageUsed(e.DB, userID)
+		if err != nil {
+			return 0, err
+		}
+		size += used
+	}
+	return size, nil
+}
+
+// IsLarge reports whether a user's archive should be generated in the background
+func (e *Exporter) IsLarge(userID uint) (bool, error) {
+	size, err := e.EstimateSize(userID)
+	if err != nil {
+		return false, err
+	}
+	return size > LargeExportSize, nil
+}
+
+// Write builds the archive for a user, writing it to w
+func (e *Exporter) Write(ctx context.Context, w io.Writer, user *models.User, now time.Time) error {
+	datasets, attachments, err := e.collect(user)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+
+	readme := fmt.Sprintf("The Virtual Armory data export for %s\nGenerated %s\n\n"+
+		"Each kind of record is included as JSON and as CSV. Amounts are in cents and times are in RFC 3339 format.\n",
+		user.Email, now.UTC().Format(time.RFC3339))
+	if err := writeFile(archive, "README.txt", now, []byte(readme)); err != nil {
+		return err
+	}
+
+	for _, d := range datasets {
+		data, err := d.json()
+		if err != nil {
+			return err
+		}
+		if err := writeFile(archive, d.name+".json", now, data); err != nil {
+			return err
+		}
+		data, err = d.csv()
+		if err != nil {
+			return err
+		}
+		if err := writeFile(archive, d.name+".csv", now, data); err != nil {
+			return err
+		}
+	}
+
+	// Include the uploaded files themselves, skipping any that can't be read
+	if e.Storage != nil {
+		for _, a := range attachments {
+			if err := e.writeAttachment(ctx, archive, a); err != nil {
+				log.Printf("Skipping attachment %d in export for user %d: %v", a.ID, user.ID, err)
+			}
+		}
+	}
+
+	return archive.Close()
+}
+
+// collect loads every owner-scoped record for the user
+func (e *Exporter) collect(user *models.User) ([]*dataset, []models.Attachment, error) {
+	profile := &dataset{
+		name: "profile",
+		columns: []string{"id", "email", "subscription_tier", "subscription_expires_at", "subscription_canceled",
+			"stripe_customer_id", "stripe_subscription_id", "confirmed", "is_admin", "two_factor_enabled", "created_at", "updated_at"},
+	}
+	profile.add(user.ID, user.Email, user.SubscriptionTier, user.SubscriptionExpiresAt, user.SubscriptionCanceled,
+		user.StripeCustomerID, user.StripeSubscriptionID, user.Confirmed, user.IsAdmin, user.TOTPEnabled, user.CreatedAt, user.UpdatedAt)
+
+	// All guns, not just the ones a free tier user can see
+	var guns []models.Gun
+	if err := e.DB.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").
+		Where("owner_id = ?", user.ID).Order("id").Find(&guns).Error; err != nil {
+		return nil, nil, err
+	}
+	gunData := &dataset{
+		name: "guns",
+		columns: []string{"id", "name", "description", "serial_number", "acquired", "weapon_type", "caliber", "manufacturer",
+			"purchase_price_cents", "purchase_source", "condition", "maintenance_interval_days", "maintenance_interval_rounds",
+			"created_at", "updated_at"},
+	}
+	for _, g := range guns {
+		gunData.add(g.ID, g.Name, g.Description, g.SerialNumber, g.Acquired, g.WeaponType.Type, g.Caliber.Caliber, g.Manufacturer.Name,
+			g.PurchasePrice, g.PurchaseSource, g.Condition, g.MaintenanceIntervalDays, g.MaintenanceIntervalRounds,
+			g.CreatedAt, g.UpdatedAt)
+	}
+
+	payments, err := models.GetPaymentsByUserID(e.DB, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	paymentData := &dataset{
+		name:    "payments",
+		columns: []string{"id", "amount_cents", "refunded_cents", "currency", "payment_type", "status", "description", "stripe_id", "created_at"},
+	}
+	for _, p := range payments {
+		paymentData.add(p.ID, p.Amount, p.Refunded, p.Currency, p.PaymentType, p.Status, p.Description, p.StripeID, p.CreatedAt)
+	}
+
+	var changes []models.SubscriptionChange
+	if err := e.DB.Where("user_id = ?", user.ID).Order("id").Find(&changes).Error; err != nil {
+		return nil, nil, err
+	}
+	changeData := &dataset{
+		name:    "subscription_changes",
+		columns: []string{"id", "from_tier", "to_tier", "cause", "expires_at", "payment_id", "note", "created_at"},
+	}
+	for _, c := range changes {
+		changeData.add(c.ID, c.FromTier, c.ToTier, c.Cause, c.ExpiresAt, c.PaymentID, c.Note, c.CreatedAt)
+	}
+
+	var redemptions []models.CouponRedemption
+	if err := e.DB.Preload("Coupon").Where("user_id = ?", user.ID).Order("id").Find(&redemptions).Error; err != nil {
+		return nil, nil, err
+	}
+	redemptionData := &dataset{
+		name:    "coupon_redemptions",
+		columns: []string{"id", "code", "status", "stripe_id", "created_at"},
+	}
+	for _, r := range redemptions {
+		redemptionData.add(r.ID, r.Coupon.Code, r.Status, r.StripeID, r.CreatedAt)
+	}
+
+	// Refunds, comps and other changes an admin made to the user's billing
+	var adjustments []models.BillingAdjustment
+	if err := e.DB.Where("user_id = ?", user.ID).Order("id").Find(&adjustments).Error; err != nil {
+		return nil, nil, err
+	}
+	adjustmentData := &dataset{
+		name: "billing_adjustments",
+		columns: []string{"id", "action", "reason", "payment_id", "amount_cents", "currency", "days", "previous_tier", "new_tier",
+			"previous_expires_at", "new_expires_at", "stripe_id", "created_at"},
+	}
+	for _, a := range adjustments {
+		adjustmentData.add(a.ID, a.Action, a.Reason, a.PaymentID, a.Amount, a.Currency, a.Days, a.PreviousTier, a.NewTier,
+			a.PreviousExpiresAt, a.NewExpiresAt, a.StripeID, a.CreatedAt)
+	}
+
+	// Sign-in records leave out session tokens, passkey keys and recovery code hashes
+	var logins []models.LoginSession
+	if err := e.DB.Where("user_id = ?", user.ID).Order("id").Find(&logins).Error; err != nil {
+		return nil, nil, err
+	}
+	loginData := &dataset{
+		name:    "login_sessions",
+		columns: []string{"id", "device", "user_agent", "ip_address", "last_seen_at", "expires_at", "created_at"},
+	}
+	for _, l := range logins {
+		loginData.add(l.ID, l.Device(), l.UserAgent, l.IPAddress, l.LastSeenAt, l.ExpiresAt, l.CreatedAt)
+	}
+
+	credentials, err := models.FindPasskeyCredentialsByUser(e.DB, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	passkeyData := &dataset{
+		name:    "passkeys",
+		columns: []string{"id", "name", "transports", "backup_eligible", "backup_state", "last_used_at", "created_at"},
+	}
+	for _, c := range credentials {
+		passkeyData.add(c.ID, c.Name, c.Transports, c.BackupEligible, c.BackupState, c.LastUsedAt, c.CreatedAt)
+	}
+
+	var codes []models.RecoveryCode
+	if err := e.DB.Where("user_id = ?", user.ID).Order("id").Find(&codes).Error; err != nil {
+		return nil, nil, err
+	}
+	recoveryData := &dataset{
+		name:    "recovery_codes",
+		columns: []string{"id", "used_at", "created_at"},
+	}
+	for _, c := range codes {
+		recoveryData.add(c.ID, c.UsedAt, c.CreatedAt)
+	}
+
+	ammo, err := models.FindAmmoByOwner(e.DB, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	ammoData := &dataset{
+		name:    "ammo",
+		columns: []string{"id", "brand", "caliber", "grain", "bullet_type", "quantity", "cost_per_round_cents", "storage_location", "created_at"},
+	}
+	for _, a := range ammo {
+		ammoData.add(a.ID, a.Brand, a.Caliber.Caliber, a.Grain, a.BulletType, a.Quantity, a.CostPerRound, a.StorageLocation, a.CreatedAt)
+	}
+
+	sessions, err := models.FindRangeSessionsByOwner(e.DB, user.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessionData := &dataset{
+		name:    "range_sessions",
+		columns: []string{"id", "date", "location", "notes", "created_at"},
+	}
+	itemData := &dataset{
+		name:    "range_session_items",
+		columns: []string{"id", "range_session_id", "gun_id", "ammo_id", "rounds_fired"},
+	}
+	for _, s := range sessions {
+		sessionData.add(s.ID, s.Date, s.Location, s.Notes, s.CreatedAt)
+		for _, item := range s.Items {
+			itemData.add(item.ID, item.RangeSessionID, item.GunID, item.AmmoID, item.RoundsFired)
+		}
+	}
+
+	var records []models.MaintenanceRecord
+	if err := e.DB.Where("owner_id = ?", user.ID).Order("date, id").Find(&records).Error; err != nil {
+		return nil, nil, err
+	}
+	maintenanceData := &dataset{
+		name:    "maintenance_records",
+		columns: []string{"id", "gun_id", "type", "date", "description", "cost_cents", "notes", "created_at"},
+	}
+	for _, r := range records {
+		maintenanceData.add(r.ID, r.GunID, r.Type, r.Date, r.Description, r.Cost, r.Notes, r.CreatedAt)
+	}
+
+	var valuations []models.Valuation
+	if err := e.DB.Where("owner_id = ?", user.ID).Order("date, id").Find(&valuations).Error; err != nil {
+		return nil, nil, err
+	}
+	valuationData := &dataset{
+		name:    "valuations",
+		columns: []string{"id", "gun_id", "date", "value_cents", "source", "notes", "created_at"},
+	}
+	for _, v := range valuations {
+		valuationData.add(v.ID, v.GunID, v.Date, v.Value, v.Source, v.Notes, v.CreatedAt)
+	}
+
+	var attachments []models.Attachment
+	if err := e.DB.Where("owner_id = ?", user.ID).Order("id").Find(&attachments).Error; err != nil {
+		return nil, nil, err
+	}
+	attachmentData := &dataset{
+		name:    "attachments",
+		columns: []string{"id", "gun_id", "kind", "file_name", "content_type", "archive_path", "created_at"},
+	}
+	for _, a := range attachments {
+		archivePath := ""
+		if e.Storage != nil {
+			archivePath = attachmentPath(a)
+		}
+		attachmentData.add(a.ID, a.GunID, a.Kind, a.FileName, a.ContentType, archivePath, a.CreatedAt)
+	}
+
+	return []*dataset{profile, gunData, paymentData, changeData, redemptionData, adjustmentData, loginData, passkeyData, recoveryData,
+		ammoData, sessionData, itemData, maintenanceData, valuationData, attachmentData}, attachments, nil
+}
+
+// writeAttachment copies an attachment's file from storage into the archive
+func (e *Exporter) writeAttachment(ctx context.Context, archive *zip.Writer, a models.Attachment) error {
+	rc, err := e.Storage.Get(ctx, a.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := archive.CreateHeader(&zip.FileHeader{Name: attachmentPath(a), Method: zip.Store, Modified: a.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, rc)
+	return err
+}
+
+// attachmentPath returns where an attachment's file is stored in the archive
+func attachmentPath(a models.Attachment) string {
+	return fmt.Sprintf("attachments/%d-%s", a.ID, path.Base(a.FileName))
+}
+
+// writeFile adds a compressed file to the archive
+func writeFile(archive *zip.Writer, name string, modified time.Time, data []byte) error {
+	fw, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// json encodes the dataset as an array of objects, keeping the column order
+func (d *dataset) json() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, row := range d.rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n  {")
+		for j, column := range d.columns {
+			if j > 0 {
+				buf.WriteString(", ")
+			}
+			key, _ := json.Marshal(column)
+			value, err := json.Marshal(jsonValue(row[j]))
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(key)
+			buf.WriteString(": ")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	if len(d.rows) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("]\n")
+	return buf.Bytes(), nil
+}
+
+// csv encodes the dataset with a header row
+func (d *dataset) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(d.columns); err != nil {
+		return nil, err
+	}
+	for _, row := range d.rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = csvValue(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+// jsonValue converts times to UTC so exports don't depend on the server's time zone
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC()
+	case *time.Time:
+		if v == nil || v.IsZero() {
+			return nil
+		}
+		return v.UTC()
+	default:
+		return value
+	}
+}
+
+// csvValue formats a value for a CSV cell
+func csvValue(value interface{}) string {
+	switch v := jsonValue(value).(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case *uint:
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatUint(uint64(*v), 10)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,238 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive returns the contents of every file in a ZIP archive
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	files := make(map[string][]byte)
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		contents, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = contents
+	}
+	return files
+}
+
+func TestWrite(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	owner, err := testutils.CreateTestUser(db, "export-owner@example.com", "password123", false)
+	require.NoError(t, err)
+	other, err := testutils.CreateTestUser(db, "export-other@example.com", "password123", false)
+	require.NoError(t, err)
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// A free tier user's export includes guns over the display limit
+	acquired := time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"Export One", "Export Two", "Export, \"Three\""} {
+		gun := models.Gun{Name: name, OwnerID: owner.ID, Acquired: &acquired, PurchasePrice: 50000}
+		require.NoError(t, models.CreateGun(db, &gun))
+	}
+	var gun models.Gun
+	require.NoError(t, db.Where("owner_id = ?", owner.ID).First(&gun).Error)
+
+	require.NoError(t, models.CreatePayment(db, &models.Payment{UserID: owner.ID, Amount: 500, Currency: "usd", Status: "succeeded"}))
+	require.NoError(t, models.CreateValuation(db, &models.Valuation{GunID: gun.ID, OwnerID: owner.ID, Date: acquired, Value: 60000}))
+	require.NoError(t, db.Create(&models.Ammo{Brand: "Export Ammo", Quantity: 50, OwnerID: owner.ID}).Error)
+
+	require.NoError(t, store.Put(ctx, "receipt.pdf", strings.NewReader("%PDF-1.4 receipt"), 16, "application/pdf"))
+	require.NoError(t, models.CreateAttachment(db, &models.Attachment{
+		GunID: gun.ID, OwnerID: owner.ID, Kind: models.AttachmentKindReceipt, FileName: "receipt.pdf", ContentType: "application/pdf", StorageKey: "receipt.pdf",
+	}))
+
+	// Another owner's records are never included
+	require.NoError(t, models.CreateGun(db, &models.Gun{Name: "Not Mine", OwnerID: other.ID}))
+
+	var out bytes.Buffer
+	require.NoError(t, NewExporter(db, store).Write(ctx, &out, owner, time.Now()))
+	files := readArchive(t, out.Bytes())
+
+	for _, name := range []string{"README.txt", "profile.json", "profile.csv", "guns.json", "guns.csv", "payments.json", "payments.csv",
+		"ammo.json", "range_sessions.json", "range_session_items.json", "maintenance_records.json", "valuations.json", "attachments.json"} {
+		assert.Contains(t, files, name)
+	}
+
+	// The profile leaves out secrets
+	var profile []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+	require.Len(t, profile, 1)
+	assert.Equal(t, owner.Email, profile[0]["email"])
+	assert.NotContains(t, string(files["profile.json"]), owner.Password)
+	assert.NotContains(t, profile[0], "password")
+
+	var guns []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["guns.json"], &guns))
+	assert.Len(t, guns, 3)
+	assert.Equal(t, "2020-01-15T00:00:00Z", guns[0]["acquired"])
+	assert.NotContains(t, string(files["guns.json"]), "Not Mine")
+
+	records, err := csv.NewReader(bytes.NewReader(files["guns.csv"])).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, "name", records[0][1])
+	assert.Equal(t, "Export, \"Three\"", records[3][1])
+	assert.Equal(t, "50000", records[3][8])
+
+	var payments []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["payments.json"], &payments))
+	require.Len(t, payments, 1)
+	assert.Equal(t, float64(500), payments[0]["amount_cents"])
+
+	// Uploaded files are included alongside their metadata
+	var attachments []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["attachments.json"], &attachments))
+	require.Len(t, attachments, 1)
+	archivePath := attachments[0]["archive_path"].(string)
+	assert.Equal(t, "%PDF-1.4 receipt", string(files[archivePath]))
+
+	// Empty datasets are still valid JSON
+	var sessions []map[string]interface{}
+	require.NoError(t, json.Unmarshal(files["range_sessions.json"], &sessions))
+	assert.Empty(t, sessions)
+}
+
+func TestWriteAccountRecords(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	owner, err := testutils.CreateTestUser(db, "export-account@example.com", "password123", false)
+	require.NoError(t, err)
+	other, err := testutils.CreateTestUser(db, "export-account-other@example.com", "password123", false)
+	require.NoError(t, err)
+	now := time.Now()
+
+	require.NoError(t, db.Model(owner).Updates(map[string]interface{}{"totp_secret": "JBSWY3DPEHPK3PXPSECRET", "totp_enabled": true}).Error)
+	require.NoError(t, db.First(owner, owner.ID).Error)
+
+	payment := models.Payment{UserID: owner.ID, Amount: 3000, Refunded: 1000, Currency: "usd", Status: "succeeded"}
+	require.NoError(t, models.CreatePayment(db, &payment))
+	require.NoError(t, db.Create(&models.SubscriptionChange{UserID: owner.ID, FromTier: models.TierFree, ToTier: models.TierYearly,
+		Cause: models.SubscriptionCauseWebhook, PaymentID: &payment.ID, Note: "Checkout completed"}).Error)
+
+	coupon := models.Coupon{Code: "EXPORT10", PercentOff: 10, Duration: models.CouponDurationOnce, Active: true}
+	require.NoError(t, models.CreateCoupon(db, &coupon))
+	require.NoError(t, models.RedeemCoupon(db, coupon.ID, owner.ID, "cs_export"))
+
+	require.NoError(t, models.CreateBillingAdjustment(db, &models.BillingAdjustment{UserID: owner.ID, AdminID: other.ID,
+		Action: models.AdjustmentRefund, Reason: "Billed twice", PaymentID: &payment.ID, Amount: 1000, Currency: "usd", StripeID: "re_export"}))
+
+	require.NoError(t, models.SaveLoginSession(db, &models.LoginSession{Token: "session-token-hash", UserID: owner.ID, Data: `{"secret":"value"}`,
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", IPAddress: "203.0.113.7", LastSeenAt: now, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, db.Create(&models.PasskeyCredential{UserID: owner.ID, Name: "Work Key", CredentialID: []byte("export-credential"),
+		PublicKey: []byte("export-public-key"), Transports: "usb,nfc"}).Error)
+	require.NoError(t, models.ReplaceRecoveryCodes(db, owner.ID, []string{"recovery-code-hash"}))
+
+	// Another user's records are never included
+	require.NoError(t, models.SaveLoginSession(db, &models.LoginSession{Token: "other-token", UserID: other.ID, UserAgent: "Other Agent", ExpiresAt: now.Add(time.Hour)}))
+
+	var out bytes.Buffer
+	require.NoError(t, NewExporter(db, nil).Write(context.Background(), &out, owner, now))
+	files := readArchive(t, out.Bytes())
+
+	rows := func(name string) []map[string]interface{} {
+		var decoded []map[string]interface{}
+		require.NoError(t, json.Unmarshal(files[name+".json"], &decoded), name)
+		assert.Contains(t, files, name+".csv")
+		return decoded
+	}
+
+	payments := rows("payments")
+	require.Len(t, payments, 1)
+	assert.Equal(t, float64(1000), payments[0]["refunded_cents"])
+
+	changes := rows("subscription_changes")
+	require.Len(t, changes, 1)
+	assert.Equal(t, models.TierYearly, changes[0]["to_tier"])
+	assert.Equal(t, float64(payment.ID), changes[0]["payment_id"])
+
+	redemptions := rows("coupon_redemptions")
+	require.Len(t, redemptions, 1)
+	assert.Equal(t, "EXPORT10", redemptions[0]["code"])
+
+	adjustments := rows("billing_adjustments")
+	require.Len(t, adjustments, 1)
+	assert.Equal(t, models.AdjustmentRefund, adjustments[0]["action"])
+	assert.Equal(t, "re_export", adjustments[0]["stripe_id"])
+
+	logins := rows("login_sessions")
+	require.Len(t, logins, 1)
+	assert.Equal(t, "Firefox on Linux", logins[0]["device"])
+	assert.Equal(t, "203.0.113.7", logins[0]["ip_address"])
+
+	passkeys := rows("passkeys")
+	require.Len(t, passkeys, 1)
+	assert.Equal(t, "Work Key", passkeys[0]["name"])
+
+	codes := rows("recovery_codes")
+	require.Len(t, codes, 1)
+	assert.Nil(t, codes[0]["used_at"])
+
+	profile := rows("profile")
+	assert.Equal(t, true, profile[0]["two_factor_enabled"])
+
+	// Secrets are left out of every file
+	for name, data := range files {
+		for _, secret := range []string{"JBSWY3DPEHPK3PXPSECRET", "session-token-hash", `"secret"`, "export-public-key",
+			"export-credential", "recovery-code-hash", "Other Agent", owner.Password} {
+			assert.NotContains(t, string(data), secret, name)
+		}
+	}
+}
+
+func TestIsLarge(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	user, err := testutils.CreateTestUser(db, "export-large@example.com", "password123", false)
+	require.NoError(t, err)
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	exporter := NewExporter(db, store)
+
+	large, err := exporter.IsLarge(user.ID)
+	require.NoError(t, err)
+	assert.False(t, large)
+
+	// Stored files count towards the size of the archive
+	gun := models.Gun{Name: "Large Export Gun", OwnerID: user.ID}
+	require.NoError(t, models.CreateGun(db, &gun))
+	require.NoError(t, models.CreateAttachment(db, &models.Attachment{
+		GunID: gun.ID, OwnerID: user.ID, Kind: models.AttachmentKindPhoto, FileName: "big.jpg", StorageKey: "big.jpg", Size: LargeExportSize + 1,
+	}))
+
+	large, err = exporter.IsLarge(user.ID)
+	require.NoError(t, err)
+	assert.True(t, large)
+
+	// Files aren't included without storage, so they don't count
+	large, err = NewExporter(db, nil).IsLarge(user.ID)
+	require.NoError(t, err)
+	assert.False(t, large)
+}
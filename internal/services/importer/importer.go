@@ -0,0 +1,295 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidRows is returned when committing an import that still has row errors
+var ErrInvalidRows = errors.New("import has rows with errors")
+
+// Gun fields that columns can be mapped to
+const (
+	FieldName           = "name"
+	FieldWeaponType     = "weapon_type"
+	FieldCaliber        = "caliber"
+	FieldManufacturer   = "manufacturer"
+	FieldSerialNumber   = "serial_number"
+	FieldDescription    = "description"
+	FieldAcquired       = "acquired"
+	FieldPurchasePrice  = "purchase_price"
+	FieldPurchaseSource = "purchase_source"
+	FieldCondition      = "condition"
+)
+
+// Field describes a gun field that a column can be mapped to
+type Field struct {
+	Key      string
+	Label    string
+	Required bool
+	aliases  []string // Normalized header names that map to the field automatically
+}
+
+// Fields lists the mappable gun fields in display order
+var Fields = []Field{
+	{Key: FieldName, Label: "Name", Required: true, aliases: []string{"name", "gun", "firearm", "model", "gunname", "title"}},
+	{Key: FieldWeaponType, Label: "Weapon Type", Required: true, aliases: []string{"weapontype", "type", "category", "action"}},
+	{Key: FieldCaliber, Label: "Caliber", Required: true, aliases: []string{"caliber", "calibre", "cartridge", "chambering", "gauge"}},
+	{Key: FieldManufacturer, Label: "Manufacturer", Required: true, aliases: []string{"manufacturer", "make", "brand", "maker", "mfg", "mfr"}},
+	{Key: FieldSerialNumber, Label: "Serial Number", aliases: []string{"serialnumber", "serial", "serialno", "sn"}},
+	{Key: FieldDescription, Label: "Description", aliases: []string{"description", "notes", "details", "comments"}},
+	{Key: FieldAcquired, Label: "Acquired Date", aliases: []string{"acquired", "acquireddate", "purchasedate", "datepurchased", "dateacquired", "purchased"}},
+	{Key: FieldPurchasePrice, Label: "Purchase Price", aliases: []string{"purchaseprice", "price", "cost", "paid", "pricepaid"}},
+	{Key: FieldPurchaseSource, Label: "Purchased From", aliases: []string{"purchasesource", "purchasedfrom", "source", "seller", "dealer", "vendor", "store"}},
+	{Key: FieldCondition, Label: "Condition", aliases: []string{"condition", "grade"}},
+}
+
+// Mapping maps gun field keys to column indexes
+type Mapping map[string]int
+
+// Column returns the column mapped to a field, or -1 if the field isn't mapped
+func (m Mapping) Column(field string) int {
+	if col, ok := m[field]; ok {
+		return col
+	}
+	return -1
+}
+
+// GuessMapping maps columns to fields by matching header names
+func GuessMapping(headers []string) Mapping {
+	mapping := Mapping{}
+	used := make(map[int]bool)
+	for _, field := range Fields {
+		for _, alias := range field.aliases {
+			for col, header := range headers {
+				if !used[col] && normalize(header) == alias {
+					mapping[field.Key] = col
+					used[col] = true
+					break
+				}
+			}
+			if _, ok := mapping[field.Key]; ok {
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// RowResult is the outcome of converting one row into a gun
+type RowResult struct {
+	Line   int // Line number in the file, counting the header as line 1
+	Gun    models.Gun
+	Errors []string
+}
+
+// Valid reports whether the row can be imported
+func (r *RowResult) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Preview is the dry-run result of an import
+type Preview struct {
+	Rows        []RowResult
+	ValidRows   int
+	InvalidRows int
+}
+
+// HasErrors reports whether any row has errors
+func (p *Preview) HasErrors() bool {
+	return p.InvalidRows > 0
+}
+
+// BuildPreview converts every row into a gun for the owner without saving anything
+func BuildPreview(db *gorm.DB, table *Table, mapping Mapping, ownerID uint) (*Preview, error) {
+	// Every required field must be mapped to a column
+	for _, field := range Fields {
+		col := mapping.Column(field.Key)
+		if col >= len(table.Headers) {
+			return nil, fmt.Errorf("%s is mapped to a column that doesn't exist", field.Label)
+		}
+		if field.Required && col < 0 {
+			return nil, fmt.Errorf("%s must be mapped to a column", field.Label)
+		}
+	}
+
+	resolver, err := NewResolver(db)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &Preview{}
+	for i, row := range table.Rows {
+		result := convertRow(row, mapping, resolver, ownerID)
+		result.Line = i + 2
+		if result.Valid() {
+			preview.ValidRows++
+		} else {
+			preview.InvalidRows++
+		}
+		preview.Rows = append(preview.Rows, result)
+	}
+
+	return preview, nil
+}
+
+// Commit saves every gun in the preview in a single transaction, enforcing the plan's gun limit
+func Commit(db *gorm.DB, user *models.User, preview *Preview) (int, error) {
+	if preview.HasErrors() {
+		return 0, ErrInvalidRows
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Check the plan's gun limit inside the transaction so concurrent imports can't both pass
+		if err := entitlements.CheckGuns(tx, user, len(preview.Rows)); err != nil {
+			return err
+		}
+
+		for i := range preview.Rows {
+			gun := preview.Rows[i].Gun
+			gun.OwnerID = user.ID
+
+			// The associations are only filled in for display, so don't let GORM save them
+			gun.WeaponType = models.WeaponType{}
+			gun.Caliber = models.Caliber{}
+			gun.Manufacturer = models.Manufacturer{}
+			if err := models.CreateGun(tx, &gun); err != nil {
+				return fmt.Errorf("line %d: %w", preview.Rows[i].Line, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(preview.Rows), nil
+}
+
+// convertRow builds a gun from a row, collecting every problem rather than stopping at the first
+func convertRow(row []string, mapping Mapping, resolver *Resolver, ownerID uint) RowResult {
+	result := RowResult{Gun: models.Gun{OwnerID: ownerID}}
+	value := func(field string) string {
+		col := mapping.Column(field)
+		if col < 0 || col >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[col])
+	}
+
+	result.Gun.Name = value(FieldName)
+	if result.Gun.Name == "" {
+		result.Errors = append(result.Errors, "Name is required")
+	}
+
+	if text := value(FieldWeaponType); text == "" {
+		result.Errors = append(result.Errors, "Weapon type is required")
+	} else if id, name, ok := resolver.WeaponType(text); ok {
+		result.Gun.WeaponTypeID = id
+		result.Gun.WeaponType = models.WeaponType{ID: id, Type: name}
+	} else {
+		result.Errors = append(result.Errors, fmt.Sprintf("Unknown weapon type %q", text))
+	}
+
+	if text := value(FieldCaliber); text == "" {
+		result.Errors = append(result.Errors, "Caliber is required")
+	} else if id, name, ok := resolver.Caliber(text); ok {
+		result.Gun.CaliberID = id
+		result.Gun.Caliber = models.Caliber{Caliber: name}
+		result.Gun.Caliber.ID = id
+	} else {
+		result.Errors = append(result.Errors, fmt.Sprintf("Unknown caliber %q", text))
+	}
+
+	if text := value(FieldManufacturer); text == "" {
+		result.Errors = append(result.Errors, "Manufacturer is required")
+	} else if id, name, ok := resolver.Manufacturer(text); ok {
+		result.Gun.ManufacturerID = id
+		result.Gun.Manufacturer = models.Manufacturer{Name: name}
+		result.Gun.Manufacturer.ID = id
+	} else {
+		result.Errors = append(result.Errors, fmt.Sprintf("Unknown manufacturer %q", text))
+	}
+
+	result.Gun.SerialNumber = value(FieldSerialNumber)
+	result.Gun.Description = value(FieldDescription)
+	result.Gun.PurchaseSource = value(FieldPurchaseSource)
+
+	if text := value(FieldAcquired); text != "" {
+		if acquired, err := parseDate(text); err == nil {
+			result.Gun.Acquired = &acquired
+		} else {
+			result.Errors = append(result.Errors, fmt.Sprintf("Invalid acquired date %q", text))
+		}
+	}
+
+	if text := value(FieldPurchasePrice); text != "" {
+		if price, err := parsePrice(text); err == nil {
+			result.Gun.PurchasePrice = price
+		} else {
+			result.Errors = append(result.Errors, fmt.Sprintf("Invalid purchase price %q", text))
+		}
+	}
+
+	if text := value(FieldCondition); text != "" {
+		if condition, ok := parseCondition(text); ok {
+			result.Gun.Condition = condition
+		} else {
+			result.Errors = append(result.Errors, fmt.Sprintf("Unknown condition %q", text))
+		}
+	}
+
+	return result
+}
+
+// dateLayouts lists the date formats accepted for the acquired date
+var dateLayouts = []string{
+	"2006-01-02",
+	"01/02/2006",
+	"1/2/2006",
+	"01/02/06",
+	"1/2/06",
+	"2006/01/02",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	time.RFC3339,
+}
+
+// parseDate parses a date in any of the accepted formats
+func parseDate(text string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", text)
+}
+
+// parsePrice parses a dollar amount such as "$1,299.99" into cents
+func parsePrice(text string) (int64, error) {
+	cleaned := strings.NewReplacer("$", "", ",", "", " ", "").Replace(text)
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid amount: %s", text)
+	}
+	return int64(math.Round(amount * 100)), nil
+}
+
+// parseCondition matches a condition grade by key or display name
+func parseCondition(text string) (string, bool) {
+	query := normalize(text)
+	for _, condition := range models.GunConditions {
+		if query == normalize(condition) || query == normalize(models.FormatGunCondition(condition)) {
+			return condition, true
+		}
+	}
+	return "", false
+}
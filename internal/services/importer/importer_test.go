@@ -0,0 +1,209 @@
+package importer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// setupReferenceData creates a clean database with a small set of reference data
+func setupReferenceData(t *testing.T) *gorm.DB {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	testutils.CleanupTestDB(db)
+
+	require.NoError(t, db.Create(&[]models.Manufacturer{
+		{Name: "Glock", Country: "Austria"},
+		{Name: "Smith & Wesson", Nickname: "S&W", Country: "USA"},
+		{Name: "Sturm, Ruger & Co.", Nickname: "Ruger", Country: "USA"},
+	}).Error)
+	require.NoError(t, db.Create(&[]models.Caliber{
+		{Caliber: "9mm Luger", Nickname: "9mm"},
+		{Caliber: ".45 ACP"},
+		{Caliber: ".22 LR"},
+	}).Error)
+	require.NoError(t, db.Create(&[]models.WeaponType{
+		{Type: "Pistol"},
+		{Type: "Rifle"},
+		{Type: "Shotgun"},
+	}).Error)
+
+	return db
+}
+
+func TestParse(t *testing.T) {
+	// CSV with a byte order mark, padding for short rows and blank lines skipped
+	table, err := Parse("guns.csv", []byte("\xef\xbb\xbfName, Make,Caliber\nG19,Glock,9mm\n\nSP101,Ruger\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Name", "Make", "Caliber"}, table.Headers)
+	assert.Equal(t, [][]string{{"G19", "Glock", "9mm"}, {"SP101", "Ruger", ""}}, table.Rows)
+
+	// JSON arrays use the union of object keys as headers
+	table, err = Parse("guns.json", []byte(`[{"name":"G19","price":499.99},{"name":"10/22","serial":"ABC"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name", "price", "serial"}, table.Headers)
+	assert.Equal(t, [][]string{{"G19", "499.99", ""}, {"10/22", "", "ABC"}}, table.Rows)
+
+	// JSON is detected by content when the extension doesn't say
+	table, err = Parse("export.txt", []byte(`[{"name":"G19"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name"}, table.Headers)
+
+	_, err = Parse("guns.csv", []byte("Name,Make\n"))
+	assert.ErrorIs(t, err, ErrEmptyFile)
+
+	_, err = Parse("guns.json", []byte(`{"name":"G19"}`))
+	assert.Error(t, err)
+}
+
+func TestGuessMapping(t *testing.T) {
+	mapping := GuessMapping([]string{"Gun", "Make", "Calibre", "Type", "Serial #", "Price Paid", "Date Purchased", "Notes"})
+
+	assert.Equal(t, 0, mapping.Column(FieldName))
+	assert.Equal(t, 1, mapping.Column(FieldManufacturer))
+	assert.Equal(t, 2, mapping.Column(FieldCaliber))
+	assert.Equal(t, 3, mapping.Column(FieldWeaponType))
+	assert.Equal(t, 4, mapping.Column(FieldSerialNumber))
+	assert.Equal(t, 5, mapping.Column(FieldPurchasePrice))
+	assert.Equal(t, 6, mapping.Column(FieldAcquired))
+	assert.Equal(t, 7, mapping.Column(FieldDescription))
+	assert.Equal(t, -1, mapping.Column(FieldCondition))
+}
+
+func TestResolver(t *testing.T) {
+	db := setupReferenceData(t)
+	resolver, err := NewResolver(db)
+	require.NoError(t, err)
+
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"glock", "Glock", true},
+		{"S&W", "Smith & Wesson", true},
+		{"Ruger", "Sturm, Ruger & Co.", true},
+		{"Glok", "Glock", true},
+		{"Smith & Weson", "Smith & Wesson", true},
+		{"Colt", "", false},
+	}
+	for _, tt := range tests {
+		_, name, ok := resolver.Manufacturer(tt.text)
+		assert.Equal(t, tt.ok, ok, tt.text)
+		assert.Equal(t, tt.want, name, tt.text)
+	}
+
+	_, name, ok := resolver.Caliber("9mm")
+	assert.True(t, ok)
+	assert.Equal(t, "9mm Luger", name)
+
+	_, name, ok = resolver.Caliber("45acp")
+	assert.True(t, ok)
+	assert.Equal(t, ".45 ACP", name)
+
+	_, name, ok = resolver.WeaponType("pistols")
+	assert.True(t, ok)
+	assert.Equal(t, "Pistol", name)
+}
+
+func TestBuildPreviewAndCommit(t *testing.T) {
+	db := setupReferenceData(t)
+
+	user, err := testutils.CreateTestUser(db, "importer@example.com", "password123", false)
+	require.NoError(t, err)
+	user.SubscriptionTier = "monthly"
+
+	table := &Table{
+		Headers: []string{"Name", "Type", "Caliber", "Make", "Price", "Acquired", "Condition"},
+		Rows: [][]string{
+			{"Carry Gun", "pistol", "9mm", "Glok", "$549.99", "03/15/2021", "Very Good"},
+			{"Plinker", "Rifle", ".22 LR", "Ruger", "", "", ""},
+			{"", "Cannon", ".22 LR", "Colt", "cheap", "someday", "Mint-ish"},
+		},
+	}
+	mapping := GuessMapping(table.Headers)
+
+	// Every required field has to be mapped
+	_, err = BuildPreview(db, table, Mapping{FieldName: 0}, user.ID)
+	assert.Error(t, err)
+
+	preview, err := BuildPreview(db, table, mapping, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, preview.ValidRows)
+	assert.Equal(t, 1, preview.InvalidRows)
+
+	first := preview.Rows[0]
+	assert.Equal(t, 2, first.Line)
+	assert.Equal(t, "Glock", first.Gun.Manufacturer.Name)
+	assert.Equal(t, "9mm Luger", first.Gun.Caliber.Caliber)
+	assert.Equal(t, "Pistol", first.Gun.WeaponType.Type)
+	assert.Equal(t, int64(54999), first.Gun.PurchasePrice)
+	assert.Equal(t, models.GunConditionVeryGood, first.Gun.Condition)
+	require.NotNil(t, first.Gun.Acquired)
+	assert.Equal(t, time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC), *first.Gun.Acquired)
+
+	// Every problem in a row is reported
+	assert.Len(t, preview.Rows[2].Errors, 6)
+
+	// Nothing is saved while any row has errors
+	_, err = Commit(db, user, preview)
+	assert.ErrorIs(t, err, ErrInvalidRows)
+
+	// Dropping the bad row lets the import go through
+	table.Rows = table.Rows[:2]
+	preview, err = BuildPreview(db, table, mapping, user.ID)
+	require.NoError(t, err)
+	count, err := Commit(db, user, preview)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	guns, err := models.FindGunsByOwner(db, user.ID)
+	require.NoError(t, err)
+	assert.Len(t, guns, 2)
+
+	// The reference tables aren't touched by the import
+	var manufacturers int64
+	db.Model(&models.Manufacturer{}).Count(&manufacturers)
+	assert.Equal(t, int64(3), manufacturers)
+}
+
+func TestCommitFreeTierLimit(t *testing.T) {
+	db := setupReferenceData(t)
+
+	user, err := testutils.CreateTestUser(db, "importer-free@example.com", "password123", false)
+	require.NoError(t, err)
+	require.Equal(t, "free", user.SubscriptionTier)
+
+	table := &Table{
+		Headers: []string{"Name", "Type", "Caliber", "Make"},
+		Rows: [][]string{
+			{"One", "Pistol", "9mm", "Glock"},
+			{"Two", "Pistol", "9mm", "Glock"},
+			{"Three", "Pistol", "9mm", "Glock"},
+		},
+	}
+	preview, err := BuildPreview(db, table, GuessMapping(table.Headers), user.ID)
+	require.NoError(t, err)
+
+	// The whole import is rejected rather than saving the first few guns
+	_, err = Commit(db, user, preview)
+	assert.ErrorIs(t, err, entitlements.ErrLimitReached)
+
+	var count int64
+	db.Model(&models.Gun{}).Where("owner_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// Importing up to the limit is allowed
+	table.Rows = table.Rows[:2]
+	preview, err = BuildPreview(db, table, GuessMapping(table.Headers), user.ID)
+	require.NoError(t, err)
+	imported, err := Commit(db, user, preview)
+	require.NoError(t, err)
+	assert.Equal(t, 2, imported)
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxFileSize is the largest import file that will be parsed
+const MaxFileSize = 1 << 20 // 1 MB
+
+// MaxRows is the largest number of rows that can be imported at once
+const MaxRows = 1000
+
+var (
+	// ErrEmptyFile is returned when an import file has no data rows
+	ErrEmptyFile = errors.New("the file doesn't contain any rows")
+
+	// ErrTooManyRows is returned when an import file has more than MaxRows rows
+	ErrTooManyRows = fmt.Errorf("files can contain at most %d rows", MaxRows)
+)
+
+// Table is the parsed contents of an import file
+type Table struct {
+	Headers []string   `json:"headers"`
+	Rows    [][]string `json:"rows"`
+}
+
+// Parse reads a CSV or JSON import file, using the file name and content to pick the format
+func Parse(fileName string, data []byte) (*Table, error) {
+	if len(data) > MaxFileSize {
+		return nil, fmt.Errorf("files can be at most %d KB", MaxFileSize>>10)
+	}
+
+	// Strip a UTF-8 byte order mark, which spreadsheet exports often include
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	trimmed := bytes.TrimSpace(data)
+	if ext == ".json" || (ext != ".csv" && len(trimmed) > 0 && trimmed[0] == '[') {
+		return ParseJSON(data)
+	}
+	return ParseCSV(data)
+}
+
+// ParseCSV reads a CSV file whose first row holds the column headers
+func ParseCSV(data []byte) (*Table, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, ErrEmptyFile
+	}
+
+	table := &Table{Headers: trimAll(records[0])}
+	for _, record := range records[1:] {
+		if isBlank(record) {
+			continue
+		}
+		// Pad short rows so every row has a value for every header
+		row := make([]string, len(table.Headers))
+		copy(row, trimAll(record))
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table.validate()
+}
+
+// ParseJSON reads a JSON array of objects, using the object keys as column headers
+func ParseJSON(data []byte) (*Table, error) {
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, fmt.Errorf("invalid JSON, expected an array of objects: %w", err)
+	}
+
+	// Collect the keys used by any object
+	seen := make(map[string]bool)
+	table := &Table{}
+	for _, object := range objects {
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				table.Headers = append(table.Headers, key)
+			}
+		}
+	}
+	sort.Strings(table.Headers)
+
+	for _, object := range objects {
+		row := make([]string, len(table.Headers))
+		for i, header := range table.Headers {
+			row[i] = jsonValueString(object[header])
+		}
+		if isBlank(row) {
+			continue
+		}
+		table.Rows = append(table.Rows, row)
+	}
+
+	return table.validate()
+}
+
+// validate checks the table has a usable number of rows
+func (t *Table) validate() (*Table, error) {
+	if len(t.Rows) == 0 {
+		return nil, ErrEmptyFile
+	}
+	if len(t.Rows) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+	return t, nil
+}
+
+// Sample returns up to n rows for display
+func (t *Table) Sample(n int) [][]string {
+	if len(t.Rows) < n {
+		return t.Rows
+	}
+	return t.Rows[:n]
+}
+
+// jsonValueString converts a JSON value to the text form used for mapping
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// trimAll trims whitespace from every value
+func trimAll(values []string) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = strings.TrimSpace(v)
+	}
+	return result
+}
+
+// isBlank reports whether every value is empty
+func isBlank(values []string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
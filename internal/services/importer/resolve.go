@@ -0,0 +1,173 @@
+package importer
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// candidate is a reference table entry that free text can resolve to
+type candidate struct {
+	id    uint
+	name  string
+	names []string // Normalized name and nickname
+}
+
+// matcher resolves free text to a reference table entry
+type matcher struct {
+	candidates []candidate
+}
+
+// Resolver fuzzy-matches manufacturer, caliber and weapon type text against the reference tables
+type Resolver struct {
+	manufacturers matcher
+	calibers      matcher
+	weaponTypes   matcher
+}
+
+// NewResolver loads the reference tables into a Resolver
+func NewResolver(db *gorm.DB) (*Resolver, error) {
+	var manufacturers []models.Manufacturer
+	if err := db.Find(&manufacturers).Error; err != nil {
+		return nil, err
+	}
+	var calibers []models.Caliber
+	if err := db.Find(&calibers).Error; err != nil {
+		return nil, err
+	}
+	var weaponTypes []models.WeaponType
+	if err := db.Find(&weaponTypes).Error; err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{}
+	for _, m := range manufacturers {
+		r.manufacturers.add(m.ID, m.Name, m.Nickname)
+	}
+	for _, c := range calibers {
+		r.calibers.add(c.ID, c.Caliber, c.Nickname)
+	}
+	for _, w := range weaponTypes {
+		r.weaponTypes.add(w.ID, w.Type, w.Nickname)
+	}
+	return r, nil
+}
+
+// Manufacturer resolves manufacturer text, returning the ID and canonical name
+func (r *Resolver) Manufacturer(text string) (uint, string, bool) {
+	return r.manufacturers.resolve(text)
+}
+
+// Caliber resolves caliber text, returning the ID and canonical name
+func (r *Resolver) Caliber(text string) (uint, string, bool) {
+	return r.calibers.resolve(text)
+}
+
+// WeaponType resolves weapon type text, returning the ID and canonical name
+func (r *Resolver) WeaponType(text string) (uint, string, bool) {
+	return r.weaponTypes.resolve(text)
+}
+
+// add registers a reference table entry
+func (m *matcher) add(id uint, name, nickname string) {
+	c := candidate{id: id, name: name}
+	for _, n := range []string{name, nickname} {
+		if normalized := normalize(n); normalized != "" {
+			c.names = append(c.names, normalized)
+		}
+	}
+	m.candidates = append(m.candidates, c)
+}
+
+// resolve finds the best match for text, trying an exact match, then a unique
+// prefix match, then the closest spelling within a small edit distance
+func (m *matcher) resolve(text string) (uint, string, bool) {
+	query := normalize(text)
+	if query == "" {
+		return 0, "", false
+	}
+
+	// Exact match on the name or nickname
+	for _, c := range m.candidates {
+		for _, n := range c.names {
+			if n == query {
+				return c.id, c.name, true
+			}
+		}
+	}
+
+	// A unique entry whose name starts with the text, or the text starts with the name,
+	// e.g. "Glock" for "Glock Inc" or "Smith & Wesson M&P" for "Smith & Wesson"
+	var prefixMatch *candidate
+	matches := 0
+	for i, c := range m.candidates {
+		for _, n := range c.names {
+			if len(n) >= 3 && len(query) >= 3 && (strings.HasPrefix(n, query) || strings.HasPrefix(query, n)) {
+				prefixMatch = &m.candidates[i]
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 1 {
+		return prefixMatch.id, prefixMatch.name, true
+	}
+
+	// The closest spelling, allowing roughly one typo per five characters
+	maxDistance := len(query) / 5
+	if maxDistance == 0 && len(query) >= 4 {
+		maxDistance = 1
+	}
+	best, bestDistance := -1, maxDistance+1
+	tied := false
+	for i, c := range m.candidates {
+		for _, n := range c.names {
+			d := levenshtein(query, n)
+			if d < bestDistance {
+				best, bestDistance, tied = i, d, false
+			} else if d == bestDistance && best != i {
+				tied = true
+			}
+		}
+	}
+	if best >= 0 && !tied {
+		return m.candidates[best].id, m.candidates[best].name, true
+	}
+
+	return 0, "", false
+}
+
+// normalize lowercases text and drops everything but letters and digits
+func normalize(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,309 @@
+package insurance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/jung-kurt/gofpdf"
+	"gorm.io/gorm"
+)
+
+// MaxPhotosPerGun limits how many photos are printed for each gun
+const MaxPhotosPerGun = 4
+
+// ErrNoGuns is returned when none of the selected guns belong to the owner
+var ErrNoGuns = errors.New("no guns selected")
+
+// Item is a gun with the details printed in the report
+type Item struct {
+	Gun       models.Gun
+	Valuation *models.Valuation // Most recent valuation, nil if never appraised
+	Photos    []models.Attachment
+}
+
+// Generator renders printable PDF schedules of guns for insurers and theft or loss reports
+type Generator struct {
+	DB      *gorm.DB
+	Storage storage.Storage // Optional, photos are skipped when nil
+}
+
+// NewGenerator creates a new Generator
+func NewGenerator(db *gorm.DB, store storage.Storage) *Generator {
+	return &Generator{
+		DB:      db,
+		Storage: store,
+	}
+}
+
+// Generate writes a PDF report of the selected guns belonging to owner to w
+func (g *Generator) Generate(ctx context.Context, w io.Writer, owner *models.User, gunIDs []uint, now time.Time) error {
+	// Load the selected guns, scoped to the owner
+	guns, err := models.FindGunsByIDs(g.DB, gunIDs, owner.ID)
+	if err != nil {
+		return err
+	}
+	if len(guns) == 0 {
+		return ErrNoGuns
+	}
+
+	// Gather the valuation and photos for each gun
+	items := make([]Item, 0, len(guns))
+	for _, gun := range guns {
+		item := Item{Gun: gun}
+
+		valuations, err := models.FindValuationsByGun(g.DB, gun.ID, owner.ID)
+		if err != nil {
+			return err
+		}
+		if len(valuations) > 0 {
+			item.Valuation = &valuations[0]
+		}
+
+		if g.Storage != nil {
+			attachments, err := models.FindAttachmentsByGun(g.DB, gun.ID, owner.ID)
+			if err != nil {
+				return err
+			}
+			for _, attachment := range attachments {
+				if attachment.Kind == models.AttachmentKindPhoto && attachment.IsImage() && len(item.Photos) < MaxPhotosPerGun {
+					item.Photos = append(item.Photos, attachment)
+				}
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return g.render(ctx, w, owner, items, now)
+}
+
+// render lays out the report pages
+func (g *Generator) render(ctx context.Context, w io.Writer, owner *models.User, items []Item, now time.Time) error {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle("Firearm Schedule", true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AliasNbPages("")
+
+	// The core fonts use Windows-1252, so translate user entered text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 10, fmt.Sprintf("Generated %s by The Virtual Armory - Page %d of {nb}", now.Format("January 2, 2006"), pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	contentWidth := pageWidth - left - right
+
+	// Summary page
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, "Firearm Schedule", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, tr("Owner: "+owner.Email), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, "Date: "+now.Format("January 2, 2006"), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	columns := []struct {
+		title string
+		width float64
+	}{
+		{"Name", 0.26},
+		{"Manufacturer", 0.18},
+		{"Caliber", 0.14},
+		{"Serial Number", 0.2},
+		{"Purchase Price", 0.11},
+		{"Value", 0.11},
+	}
+
+	pdf.SetFont("Helvetica", "B", 9)
+	pdf.SetFillColor(230, 230, 230)
+	for _, col := range columns {
+		pdf.CellFormat(contentWidth*col.width, 7, col.title, "1", 0, "L", true, 0, "")
+	}
+	pdf.Ln(-1)
+
+	pdf.SetFont("Helvetica", "", 9)
+	var totalCost, totalValue int64
+	for _, item := range items {
+		value := currentValue(item)
+		totalCost += item.Gun.PurchasePrice
+		totalValue += value
+
+		cells := []string{
+			item.Gun.Name,
+			item.Gun.Manufacturer.Name,
+			item.Gun.Caliber.Caliber,
+			item.Gun.SerialNumber,
+			models.FormatCents(item.Gun.PurchasePrice),
+			models.FormatCents(value),
+		}
+		for i, col := range columns {
+			pdf.CellFormat(contentWidth*col.width, 7, truncate(pdf, tr(cells[i]), contentWidth*col.width-2), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.SetFont("Helvetica", "B", 9)
+	labelWidth := contentWidth * (columns[0].width + columns[1].width + columns[2].width + columns[3].width)
+	pdf.CellFormat(labelWidth, 7, fmt.Sprintf("Total (%d guns)", len(items)), "1", 0, "R", false, 0, "")
+	pdf.CellFormat(contentWidth*columns[4].width, 7, models.FormatCents(totalCost), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(contentWidth*columns[5].width, 7, models.FormatCents(totalValue), "1", 1, "L", false, 0, "")
+
+	// One page per gun with full details and photos
+	for _, item := range items {
+		pdf.AddPage()
+		pdf.SetFont("Helvetica", "B", 16)
+		pdf.MultiCell(0, 8, tr(item.Gun.Name), "", "L", false)
+		pdf.Ln(2)
+
+		details := [][2]string{
+			{"Manufacturer", item.Gun.Manufacturer.Name},
+			{"Type", item.Gun.WeaponType.Type},
+			{"Caliber", item.Gun.Caliber.Caliber},
+			{"Serial Number", item.Gun.SerialNumber},
+			{"Acquired", formatDate(item.Gun.Acquired)},
+			{"Purchase Price", models.FormatCents(item.Gun.PurchasePrice)},
+			{"Purchased From", item.Gun.PurchaseSource},
+			{"Condition", models.FormatGunCondition(item.Gun.Condition)},
+		}
+		if item.Valuation != nil {
+			appraisal := fmt.Sprintf("%s as of %s", item.Valuation.FormatValue(), item.Valuation.Date.Format("January 2, 2006"))
+			if item.Valuation.Source != "" {
+				appraisal += " (" + item.Valuation.Source + ")"
+			}
+			details = append(details, [2]string{"Appraised Value", appraisal})
+		}
+		if item.Gun.Description != "" {
+			details = append(details, [2]string{"Description", item.Gun.Description})
+		}
+
+		for _, detail := range details {
+			value := detail[1]
+			if value == "" {
+				value = "-"
+			}
+			pdf.SetFont("Helvetica", "B", 10)
+			pdf.CellFormat(40, 7, detail[0], "", 0, "L", false, 0, "")
+			pdf.SetFont("Helvetica", "", 10)
+			pdf.MultiCell(contentWidth-40, 7, tr(value), "", "L", false)
+		}
+
+		if len(item.Photos) > 0 {
+			pdf.Ln(4)
+			pdf.SetFont("Helvetica", "B", 12)
+			pdf.CellFormat(0, 8, "Photos", "", 1, "L", false, 0, "")
+			g.renderPhotos(ctx, pdf, item.Photos, left, contentWidth)
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// renderPhotos places photos in a two column grid, skipping any that can't be loaded
+func (g *Generator) renderPhotos(ctx context.Context, pdf *gofpdf.Fpdf, photos []models.Attachment, left, contentWidth float64) {
+	const gap = 5.0
+	cellWidth := (contentWidth - gap) / 2
+	cellHeight := 75.0
+
+	column := 0
+	for _, photo := range photos {
+		name := fmt.Sprintf("attachment-%d", photo.ID)
+		if !g.registerPhoto(ctx, pdf, name, photo) {
+			continue
+		}
+
+		// Start a new page if the next row doesn't fit
+		_, pageHeight := pdf.GetPageSize()
+		if column == 0 && pdf.GetY()+cellHeight > pageHeight-20 {
+			pdf.AddPage()
+		}
+
+		// Scale the image to fit its cell while keeping the aspect ratio
+		info := pdf.GetImageInfo(name)
+		width, height := cellWidth, cellWidth*info.Height()/info.Width()
+		if height > cellHeight {
+			width, height = cellHeight*info.Width()/info.Height(), cellHeight
+		}
+
+		x := left + float64(column)*(cellWidth+gap)
+		pdf.ImageOptions(name, x, pdf.GetY(), width, height, false, gofpdf.ImageOptions{}, 0, "")
+
+		column++
+		if column == 2 {
+			column = 0
+			pdf.SetY(pdf.GetY() + cellHeight + gap)
+		}
+	}
+	if column != 0 {
+		pdf.SetY(pdf.GetY() + cellHeight + gap)
+	}
+}
+
+// registerPhoto loads a photo from storage into the PDF, returning whether it succeeded
+func (g *Generator) registerPhoto(ctx context.Context, pdf *gofpdf.Fpdf, name string, photo models.Attachment) bool {
+	imageType := ""
+	switch photo.ContentType {
+	case "image/jpeg":
+		imageType = "JPG"
+	case "image/png":
+		imageType = "PNG"
+	default:
+		return false
+	}
+
+	reader, err := g.Storage.Get(ctx, photo.StorageKey)
+	if err != nil {
+		log.Printf("Failed to load photo %d for insurance report: %v", photo.ID, err)
+		return false
+	}
+	defer reader.Close()
+
+	info := pdf.RegisterImageOptionsReader(name, gofpdf.ImageOptions{ImageType: imageType}, reader)
+	if info == nil || pdf.Err() {
+		log.Printf("Failed to add photo %d to insurance report: %v", photo.ID, pdf.Error())
+		pdf.ClearError()
+		return false
+	}
+	return true
+}
+
+// currentValue returns the appraised value of a gun, or its purchase price if never appraised
+func currentValue(item Item) int64 {
+	if item.Valuation != nil {
+		return item.Valuation.Value
+	}
+	return item.Gun.PurchasePrice
+}
+
+// truncate shortens translated, single byte encoded text to fit within a table cell
+func truncate(pdf *gofpdf.Fpdf, text string, width float64) string {
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	for len(text) > 0 && pdf.GetStringWidth(text+"...") > width {
+		text = text[:len(text)-1]
+	}
+	return text + "..."
+}
+
+// formatDate formats an optional date for the report
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("January 2, 2006")
+}
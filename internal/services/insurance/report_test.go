@@ -0,0 +1,91 @@
+package insurance
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+
+	owner, err := testutils.CreateTestUser(db, "insurance-owner@example.com", "password123", false)
+	require.NoError(t, err)
+	other, err := testutils.CreateTestUser(db, "insurance-other@example.com", "password123", false)
+	require.NoError(t, err)
+
+	store, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// A gun with purchase details, an appraisal and a photo
+	acquired := time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC)
+	rifle := models.Gun{
+		Name:           "Insured Rifle – Engraved",
+		SerialNumber:   "ABC12345",
+		Acquired:       &acquired,
+		OwnerID:        owner.ID,
+		PurchasePrice:  125000,
+		PurchaseSource: "Gun Show",
+		Condition:      models.GunConditionVeryGood,
+	}
+	require.NoError(t, models.CreateGun(db, &rifle))
+	require.NoError(t, models.CreateValuation(db, &models.Valuation{
+		GunID: rifle.ID, OwnerID: owner.ID, Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Value: 150000, Source: "Appraiser",
+	}))
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	img.Set(1, 1, color.RGBA{200, 0, 0, 255})
+	var photo bytes.Buffer
+	require.NoError(t, jpeg.Encode(&photo, img, nil))
+	require.NoError(t, store.Put(ctx, "photo.jpg", bytes.NewReader(photo.Bytes()), int64(photo.Len()), "image/jpeg"))
+	require.NoError(t, models.CreateAttachment(db, &models.Attachment{
+		GunID: rifle.ID, OwnerID: owner.ID, Kind: models.AttachmentKindPhoto, FileName: "photo.jpg", ContentType: "image/jpeg", StorageKey: "photo.jpg",
+	}))
+
+	// A photo whose file is missing is skipped rather than failing the report
+	require.NoError(t, models.CreateAttachment(db, &models.Attachment{
+		GunID: rifle.ID, OwnerID: owner.ID, Kind: models.AttachmentKindPhoto, FileName: "missing.jpg", ContentType: "image/jpeg", StorageKey: "missing.jpg",
+	}))
+
+	// A second gun with no extra details
+	pistol := models.Gun{Name: "Insured Pistol", OwnerID: owner.ID}
+	require.NoError(t, models.CreateGun(db, &pistol))
+
+	// Another owner's gun is never included
+	otherGun := models.Gun{Name: "Someone Else's Gun", OwnerID: other.ID}
+	require.NoError(t, models.CreateGun(db, &otherGun))
+
+	generator := NewGenerator(db, store)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	var out bytes.Buffer
+	require.NoError(t, generator.Generate(ctx, &out, owner, []uint{rifle.ID, pistol.ID, otherGun.ID}, now))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF-")))
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("/Subtype /Image")), "the stored photo should be embedded once")
+
+	// Reports still render without file storage
+	out.Reset()
+	require.NoError(t, NewGenerator(db, nil).Generate(ctx, &out, owner, []uint{rifle.ID}, now))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF-")))
+
+	// Selecting only guns owned by someone else is an error
+	out.Reset()
+	err = generator.Generate(ctx, &out, owner, []uint{otherGun.ID}, now)
+	assert.ErrorIs(t, err, ErrNoGuns)
+	assert.Equal(t, 0, out.Len())
+
+	err = generator.Generate(ctx, &out, owner, nil, now)
+	assert.ErrorIs(t, err, ErrNoGuns)
+}
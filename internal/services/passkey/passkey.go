@@ -0,0 +1,374 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// DisplayName names the application when the browser asks the user to use a passkey
+const DisplayName = "The Virtual Armory"
+
+// ChallengeTimeout is how long the user has to use their authenticator after the browser asks
+const ChallengeTimeout = 5 * time.Minute
+
+// MaxNameLength is the longest name a passkey can be given
+const MaxNameLength = 64
+
+// Purposes of a challenge, so one can't be answered in place of the other
+const (
+	purposeRegistration = "registration"
+	purposeLogin        = "login"
+)
+
+// Errors returned when a passkey can't be registered or used
+var (
+	ErrChallengeExpired = errors.New("passkey request expired, please try again")
+	ErrInvalidPasskey   = errors.New("passkey could not be verified")
+	ErrNotFound         = errors.New("passkey not found")
+)
+
+// Service registers users' passkeys and logs them in with them
+type Service struct {
+	DB       *gorm.DB
+	WebAuthn *webauthn.WebAuthn
+}
+
+// NewService creates a new Service for the site at baseURL, which passkeys are tied to
+func NewService(db *gorm.DB, baseURL string) (*Service, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid base URL for passkeys: %q", baseURL)
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: DisplayName,
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		DB:       db,
+		WebAuthn: w,
+	}, nil
+}
+
+// Credentials returns the user's passkeys
+func (s *Service) Credentials(user *models.User) ([]models.PasskeyCredential, error) {
+	return models.FindPasskeyCredentialsByUser(s.DB, user.ID)
+}
+
+// BeginRegistration returns the options for the browser to create a new passkey for the user,
+// and the token that identifies the registration until the browser answers
+func (s *Service) BeginRegistration(user *models.User, now time.Time) (*protocol.CredentialCreation, string, error) {
+	webAuthnUser, err := s.webAuthnUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Don't let the same authenticator be registered twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(webAuthnUser.credentials))
+	for _, credential := range webAuthnUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := s.WebAuthn.BeginRegistration(webAuthnUser, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := s.saveChallenge(user.ID, purposeRegistration, session, now)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, token, nil
+}
+
+// FinishRegistration checks the browser's answer to a registration and saves the new passkey under name
+func (s *Service) FinishRegistration(user *models.User, token, name string, body io.Reader, now time.Time) (*models.PasskeyCredential, error) {
+	session, err := s.takeChallenge(token, purposeRegistration, now)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	webAuthnUser, err := s.webAuthnUser(user)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := s.WebAuthn.CreateCredential(webAuthnUser, *session, response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, transport := range credential.Transport {
+		transports[i] = string(transport)
+	}
+	passkey := &models.PasskeyCredential{
+		UserID:          user.ID,
+		Name:            CleanName(name),
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if err := s.DB.Create(passkey).Error; err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// BeginLogin returns the options for the browser to ask for any of the site's passkeys,
+// and the token that identifies the login until the browser answers
+func (s *Service) BeginLogin(now time.Time) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := s.WebAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := s.saveChallenge(0, purposeLogin, session, now)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, token, nil
+}
+
+// FinishLogin checks the browser's answer to a login and returns the user whose passkey it was.
+// The authenticator must have verified the user, with a PIN or biometric, so a passkey counts as two factors.
+func (s *Service) FinishLogin(token string, body io.Reader, now time.Time) (*models.User, error) {
+	session, err := s.takeChallenge(token, purposeLogin, now)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	var found *user
+	credential, err := s.WebAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		passkey, err := models.FindPasskeyCredentialByCredentialID(s.DB, rawID)
+		if err != nil {
+			return nil, err
+		}
+		var owner models.User
+		if err := s.DB.First(&owner, passkey.UserID).Error; err != nil {
+			return nil, err
+		}
+		found, err = s.webAuthnUser(&owner)
+		if err != nil {
+			return nil, err
+		}
+		return found, nil
+	}, *session, response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	// A counter that didn't go up means the passkey may have been copied
+	if credential.Authenticator.CloneWarning {
+		return nil, fmt.Errorf("%w: signature counter did not increase", ErrInvalidPasskey)
+	}
+
+	for i := range found.credentials {
+		passkey := &found.credentials[i]
+		if !bytes.Equal(passkey.CredentialID, credential.ID) {
+			continue
+		}
+		passkey.SignCount = credential.Authenticator.SignCount
+		passkey.BackupState = credential.Flags.BackupState
+		if err := models.RecordPasskeyUse(s.DB, passkey, now); err != nil {
+			return nil, err
+		}
+	}
+	return found.user, nil
+}
+
+// Rename renames one of the user's passkeys
+func (s *Service) Rename(user *models.User, id uint, name string) error {
+	renamed, err := models.RenamePasskeyCredential(s.DB, user.ID, id, CleanName(name))
+	if err == nil && !renamed {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Remove removes one of the user's passkeys, so it can't be used to log in
+func (s *Service) Remove(user *models.User, id uint) error {
+	removed, err := models.DeletePasskeyCredential(s.DB, user.ID, id)
+	if err == nil && !removed {
+		return ErrNotFound
+	}
+	return err
+}
+
+// CleanName trims a passkey's name to MaxNameLength, naming it "Passkey" if it's blank
+func CleanName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if runes := []rune(name); len(runes) > MaxNameLength {
+		name = strings.TrimSpace(string(runes[:MaxNameLength]))
+	}
+	return name
+}
+
+// saveChallenge stores the state of a registration or login and returns the token for the browser's cookie
+func (s *Service) saveChallenge(userID uint, purpose string, session *webauthn.SessionData, now time.Time) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	// Challenges that were never answered are cleared out as new ones are made
+	if err := models.DeleteExpiredPasskeyChallenges(s.DB, now); err != nil {
+		return "", err
+	}
+	if err := s.DB.Create(&models.PasskeyChallenge{
+		Token:     hashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      string(data),
+		ExpiresAt: now.Add(ChallengeTimeout),
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// takeChallenge loads the state of a registration or login, which can't be used again afterwards
+func (s *Service) takeChallenge(token, purpose string, now time.Time) (*webauthn.SessionData, error) {
+	if token == "" {
+		return nil, ErrChallengeExpired
+	}
+	challenge, err := models.TakePasskeyChallenge(s.DB, hashToken(token), purpose, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChallengeExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(challenge.Data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// webAuthnUser loads the user's passkeys for the WebAuthn library
+func (s *Service) webAuthnUser(u *models.User) (*user, error) {
+	credentials, err := s.Credentials(u)
+	if err != nil {
+		return nil, err
+	}
+	return &user{user: u, credentials: credentials}, nil
+}
+
+// user is a models.User with their passkeys, as the WebAuthn library sees them
+type user struct {
+	user        *models.User
+	credentials []models.PasskeyCredential
+}
+
+// Ensure user implements webauthn.User
+var _ webauthn.User = (*user)(nil)
+
+// WebAuthnID returns the user handle passkeys store for the user, which is their ID
+func (u *user) WebAuthnID() []byte {
+	return UserHandle(u.user.ID)
+}
+
+// WebAuthnName returns the name the browser shows for the user's passkeys
+func (u *user) WebAuthnName() string {
+	return u.user.Email
+}
+
+// WebAuthnDisplayName returns the name the browser shows for the user's passkeys
+func (u *user) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+// WebAuthnCredentials returns the user's passkeys
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, passkey := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0)
+		for _, transport := range passkey.TransportList() {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials[i] = webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.AAGUID,
+				SignCount: passkey.SignCount,
+			},
+		}
+	}
+	return credentials
+}
+
+// WebAuthnIcon is no longer part of the WebAuthn standard
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
+
+// UserHandle returns the user handle the user's passkeys are created with
+func UserHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// hashToken returns the SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,133 @@
+package passkey
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOrigin = "http://localhost:8080"
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	service, err := NewService(db, testOrigin)
+	require.NoError(t, err)
+	user, err := testutils.CreateTestUser(db, "passkey@example.com", "password123", false)
+	require.NoError(t, err)
+	authenticator, err := testutils.NewSoftwareAuthenticator(testOrigin)
+	require.NoError(t, err)
+	now := time.Now()
+
+	// Registering saves the passkey under its name
+	creation, token, err := service.BeginRegistration(user, now)
+	require.NoError(t, err)
+	assert.Equal(t, protocol.URLEncodedBase64(UserHandle(user.ID)), creation.Response.User.ID)
+	body, err := authenticator.Register(creation.Response.Challenge.String(), UserHandle(user.ID))
+	require.NoError(t, err)
+	passkey, err := service.FinishRegistration(user, token, "  My Laptop  ", bytes.NewReader(body), now)
+	require.NoError(t, err)
+	assert.Equal(t, "My Laptop", passkey.Name)
+	assert.Equal(t, authenticator.CredentialID, passkey.CredentialID)
+	assert.Equal(t, []string{"internal"}, passkey.TransportList())
+
+	// The registration can't be answered twice
+	_, err = service.FinishRegistration(user, token, "Again", bytes.NewReader(body), now)
+	assert.ErrorIs(t, err, ErrChallengeExpired)
+
+	// Logging in finds the user from the passkey alone and records the use
+	assertion, token, err := service.BeginLogin(now)
+	require.NoError(t, err)
+	body, err = authenticator.Login(assertion.Response.Challenge.String())
+	require.NoError(t, err)
+	loggedIn, err := service.FinishLogin(token, bytes.NewReader(body), now)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, loggedIn.ID)
+	var stored models.PasskeyCredential
+	require.NoError(t, db.First(&stored, passkey.ID).Error)
+	assert.Equal(t, uint32(1), stored.SignCount)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// A login can't be replayed
+	_, err = service.FinishLogin(token, bytes.NewReader(body), now)
+	assert.ErrorIs(t, err, ErrChallengeExpired)
+
+	// An answer to a different challenge is rejected
+	_, token, err = service.BeginLogin(now)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(token, bytes.NewReader(body), now)
+	assert.ErrorIs(t, err, ErrInvalidPasskey)
+
+	// A counter that goes backwards looks like a copied passkey
+	assertion, token, err = service.BeginLogin(now)
+	require.NoError(t, err)
+	authenticator.SignCount = 0
+	body, err = authenticator.Login(assertion.Response.Challenge.String())
+	require.NoError(t, err)
+	_, err = service.FinishLogin(token, bytes.NewReader(body), now)
+	assert.ErrorIs(t, err, ErrInvalidPasskey)
+
+	// Challenges expire
+	assertion, token, err = service.BeginLogin(now)
+	require.NoError(t, err)
+	authenticator.SignCount = 5
+	body, err = authenticator.Login(assertion.Response.Challenge.String())
+	require.NoError(t, err)
+	_, err = service.FinishLogin(token, bytes.NewReader(body), now.Add(ChallengeTimeout))
+	assert.ErrorIs(t, err, ErrChallengeExpired)
+
+	// A passkey from another site is rejected
+	other, err := testutils.NewSoftwareAuthenticator("https://example.com")
+	require.NoError(t, err)
+	creation, token, err = service.BeginRegistration(user, now)
+	require.NoError(t, err)
+	body, err = other.Register(creation.Response.Challenge.String(), UserHandle(user.ID))
+	require.NoError(t, err)
+	_, err = service.FinishRegistration(user, token, "Other", bytes.NewReader(body), now)
+	assert.ErrorIs(t, err, ErrInvalidPasskey)
+}
+
+func TestPasskeyManagement(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	service, err := NewService(db, testOrigin)
+	require.NoError(t, err)
+	user, err := testutils.CreateTestUser(db, "owner@example.com", "password123", false)
+	require.NoError(t, err)
+	otherUser, err := testutils.CreateTestUser(db, "other@example.com", "password123", false)
+	require.NoError(t, err)
+	passkey := models.PasskeyCredential{UserID: user.ID, Name: "Phone", CredentialID: []byte("credential"), PublicKey: []byte("key")}
+	require.NoError(t, db.Create(&passkey).Error)
+
+	// Only the owner can rename or remove a passkey
+	assert.ErrorIs(t, service.Rename(otherUser, passkey.ID, "Mine"), ErrNotFound)
+	assert.ErrorIs(t, service.Remove(otherUser, passkey.ID), ErrNotFound)
+
+	require.NoError(t, service.Rename(user, passkey.ID, "Work Phone"))
+	credentials, err := service.Credentials(user)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, "Work Phone", credentials[0].Name)
+
+	require.NoError(t, service.Remove(user, passkey.ID))
+	credentials, err = service.Credentials(user)
+	require.NoError(t, err)
+	assert.Empty(t, credentials)
+	assert.ErrorIs(t, service.Remove(user, passkey.ID), ErrNotFound)
+}
+
+func TestCleanName(t *testing.T) {
+	assert.Equal(t, "Passkey", CleanName("   "))
+	assert.Equal(t, "YubiKey", CleanName(" YubiKey "))
+	assert.Len(t, []rune(CleanName(string(bytes.Repeat([]byte("a"), 100)))), MaxNameLength)
+}
@@ -0,0 +1,160 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// DefaultBusinessName is printed when no business name is configured
+const DefaultBusinessName = "The Virtual Armory"
+
+// Business is the seller printed at the top of every receipt
+type Business struct {
+	Name    string
+	Address string // May span several lines
+	TaxID   string
+}
+
+// NewBusiness reads the business details from the application config
+func NewBusiness(cfg *config.Config) Business {
+	return Business{
+		Name:    cfg.BusinessName,
+		Address: cfg.BusinessAddress,
+		TaxID:   cfg.BusinessTaxID,
+	}
+}
+
+// DisplayName returns the name printed on receipts
+func (b Business) DisplayName() string {
+	if b.Name == "" {
+		return DefaultBusinessName
+	}
+	return b.Name
+}
+
+// AddressLines splits the address into the lines printed on a receipt.
+// A comma separated address from a single line environment variable is split on commas.
+func (b Business) AddressLines() []string {
+	address := strings.ReplaceAll(b.Address, `\n`, "\n")
+	separator := "\n"
+	if !strings.Contains(address, "\n") {
+		separator = ","
+	}
+
+	var lines []string
+	for _, line := range strings.Split(address, separator) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Filename returns the name a payment's PDF receipt is downloaded as
+func Filename(payment *models.Payment) string {
+	return "receipt-" + payment.ReceiptNumber() + ".pdf"
+}
+
+// RenderPDF returns a payment's PDF receipt
+func RenderPDF(business Business, customer *models.User, payment *models.Payment) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WritePDF(&buf, business, customer, payment); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WritePDF writes a payment's receipt to w
+func WritePDF(w io.Writer, business Business, customer *models.User, payment *models.Payment) error {
+	pdf := gofpdf.New("P", "mm", "Letter", "")
+	pdf.SetTitle("Receipt "+payment.ReceiptNumber(), true)
+	pdf.SetMargins(20, 20, 20)
+	pdf.SetAutoPageBreak(true, 20)
+
+	// The core fonts use Windows-1252, so translate configured and user entered text
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	contentWidth := pageWidth - left - right
+
+	pdf.AddPage()
+
+	// Seller
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(contentWidth/2, 8, tr(business.DisplayName()), "", 0, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(contentWidth/2, 8, "RECEIPT", "", 1, "R", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	for _, line := range business.AddressLines() {
+		pdf.CellFormat(0, 5, tr(line), "", 1, "L", false, 0, "")
+	}
+	if business.TaxID != "" {
+		pdf.CellFormat(0, 5, tr("Tax ID: "+business.TaxID), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	// Receipt details
+	details := [][2]string{
+		{"Receipt Number", payment.ReceiptNumber()},
+		{"Date Paid", payment.CreatedAt.Format("January 2, 2006")},
+		{"Billed To", customer.Email},
+	}
+	if period := payment.BillingPeriod(); period != "" {
+		details = append(details, [2]string{"Billing Period", period})
+	}
+	if payment.StripeID != "" {
+		details = append(details, [2]string{"Reference", payment.StripeID})
+	}
+	for _, detail := range details {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.CellFormat(40, 6, detail[0], "", 0, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(contentWidth-40, 6, tr(detail[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(8)
+
+	// Line item and totals
+	amountWidth := 40.0
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(230, 230, 230)
+	pdf.CellFormat(contentWidth-amountWidth, 8, "Description", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(amountWidth, 8, "Amount", "1", 1, "R", true, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(contentWidth-amountWidth, 8, tr(payment.Description), "1", 0, "L", false, 0, "")
+	pdf.CellFormat(amountWidth, 8, tr(payment.FormatSubtotal()), "1", 1, "R", false, 0, "")
+
+	totals := [][2]string{
+		{"Subtotal", payment.FormatSubtotal()},
+		{"Tax", payment.FormatTax()},
+	}
+	for _, total := range totals {
+		pdf.CellFormat(contentWidth-amountWidth, 7, total[0], "", 0, "R", false, 0, "")
+		pdf.CellFormat(amountWidth, 7, tr(total[1]), "", 1, "R", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(contentWidth-amountWidth, 8, "Total Paid", "", 0, "R", false, 0, "")
+	pdf.CellFormat(amountWidth, 8, tr(payment.FormatAmount()), "T", 1, "R", false, 0, "")
+	if payment.Refunded > 0 {
+		pdf.SetFont("Helvetica", "", 10)
+		pdf.CellFormat(contentWidth-amountWidth, 7, "Refunded", "", 0, "R", false, 0, "")
+		pdf.CellFormat(amountWidth, 7, tr("-"+payment.FormatRefunded()), "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(12)
+
+	pdf.SetFont("Helvetica", "I", 9)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.MultiCell(0, 5, tr(fmt.Sprintf("Thank you for supporting %s. Keep this receipt for your records.", business.DisplayName())), "", "C", false)
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
@@ -0,0 +1,62 @@
+package receipt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBusinessAddressLines(t *testing.T) {
+	business := NewBusiness(&config.Config{BusinessName: "The Virtual Armory", BusinessAddress: "123 Main St, Suite 4 ,Springfield, IL 62701"})
+	assert.Equal(t, []string{"123 Main St", "Suite 4", "Springfield", "IL 62701"}, business.AddressLines())
+
+	business.Address = `123 Main St\nSpringfield, IL 62701`
+	assert.Equal(t, []string{"123 Main St", "Springfield, IL 62701"}, business.AddressLines())
+
+	business.Address = ""
+	assert.Empty(t, business.AddressLines())
+	assert.Equal(t, "The Virtual Armory", Business{}.DisplayName())
+}
+
+func TestRenderPDF(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	payment := &models.Payment{
+		Amount:      540,
+		TaxAmount:   40,
+		Currency:    "usd",
+		Status:      "succeeded",
+		Description: "Monthly Subscription – Liking It",
+		StripeID:    "in_123",
+		PeriodStart: &start,
+		PeriodEnd:   &end,
+	}
+	payment.ID = 42
+	payment.CreatedAt = start
+
+	assert.Equal(t, "VA-000042", payment.ReceiptNumber())
+	assert.Equal(t, "receipt-VA-000042.pdf", Filename(payment))
+	assert.Equal(t, "$5.00", payment.FormatSubtotal())
+	assert.Equal(t, "$0.40", payment.FormatTax())
+	assert.Equal(t, "Jun 1, 2024 - Jul 1, 2024", payment.BillingPeriod())
+
+	business := Business{Name: "The Virtual Armory", Address: "123 Main St, Springfield", TaxID: "12-3456789"}
+	customer := &models.User{Email: "receipt@example.com"}
+
+	pdf, err := RenderPDF(business, customer, payment)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+
+	// Lifetime purchases have no billing period, and refunded payments show the refund
+	payment.PeriodStart, payment.PeriodEnd = nil, nil
+	payment.Status, payment.Refunded = "refunded", 540
+	assert.Equal(t, "", payment.BillingPeriod())
+	var out bytes.Buffer
+	require.NoError(t, WritePDF(&out, Business{Name: "The Virtual Armory"}, customer, payment))
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("%PDF-")))
+}
@@ -0,0 +1,364 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"gorm.io/gorm"
+)
+
+// Kinds of drift between local billing records and the payment provider
+const (
+	KindMissingCustomer      = "missing-customer"      // The provider has a customer for the user's email that isn't recorded locally
+	KindInactiveLocally      = "inactive-locally"      // The provider is billing a subscription but the user is free or expired locally
+	KindSubscriptionMismatch = "subscription-mismatch" // Both sides have a subscription but the tier, ID, expiry or cancellation differ
+	KindSubscriptionEnded    = "subscription-ended"    // The user still has a subscription ID locally but nothing is billing at the provider
+	KindMissingPayment       = "missing-payment"       // The provider charged the customer but no payment was recorded
+	KindRefundMismatch       = "refund-mismatch"       // A payment's refunded amount differs from the provider's
+	KindUnknownPayment       = "unknown-payment"       // A local payment has no matching charge at the provider
+	KindError                = "error"                 // The provider couldn't be asked about the user
+)
+
+// chargeMatchWindow is how far apart a charge and a payment with no ID in common can be and still be the same payment
+const chargeMatchWindow = 24 * time.Hour
+
+// expiryTolerance allows for the few seconds between the provider starting a period and the webhook recording it
+const expiryTolerance = 24 * time.Hour
+
+// Drift is one difference found between a user's billing records and the payment provider
+type Drift struct {
+	UserID uint
+	Email  string
+	Kind   string
+	Detail string
+	Fixed  bool // Whether the local records were corrected
+}
+
+// Report is the outcome of a reconciliation run
+type Report struct {
+	Checked int // Users compared against the provider
+	Drifts  []Drift
+}
+
+// Fixed returns how many drifts were corrected
+func (r *Report) Fixed() int {
+	fixed := 0
+	for _, drift := range r.Drifts {
+		if drift.Fixed {
+			fixed++
+		}
+	}
+	return fixed
+}
+
+// Reconciler compares users' subscriptions and payments with the payment provider
+type Reconciler struct {
+	DB       *gorm.DB
+	Provider billing.PaymentProvider
+}
+
+// NewReconciler creates a new Reconciler
+func NewReconciler(db *gorm.DB, provider billing.PaymentProvider) *Reconciler {
+	return &Reconciler{
+		DB:       db,
+		Provider: provider,
+	}
+}
+
+// Run reconciles every user, or only the user with email if it's set.
+// With fix, local records are corrected to match the provider; drift the provider
+// can't settle, such as a payment it has no charge for, is only reported.
+func (r *Reconciler) Run(email string, fix bool, now time.Time) (*Report, error) {
+	query := r.DB.Order("id")
+	if email != "" {
+		query = query.Where("email = ?", email)
+	}
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if email != "" && len(users) == 0 {
+		return nil, fmt.Errorf("no user with email %s", email)
+	}
+
+	report := &Report{}
+	for i := range users {
+		checked, err := r.reconcileUser(report, &users[i], fix, now)
+		if err != nil {
+			return report, fmt.Errorf("failed to reconcile user %d: %w", users[i].ID, err)
+		}
+		if checked {
+			report.Checked++
+		}
+	}
+	return report, nil
+}
+
+// reconcileUser adds the user's drift to the report, returning false if the provider doesn't know the user
+func (r *Reconciler) reconcileUser(report *Report, user *models.User, fix bool, now time.Time) (bool, error) {
+	add := func(kind, detail string, fixed bool) {
+		report.Drifts = append(report.Drifts, Drift{UserID: user.ID, Email: user.Email, Kind: kind, Detail: detail, Fixed: fixed})
+	}
+
+	// Users whose checkout webhook never arrived have no customer ID yet
+	customerID := user.StripeCustomerID
+	if customerID == "" {
+		found, err := r.Provider.FindCustomerID(user.Email)
+		if errors.Is(err, billing.ErrCustomerNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			add(KindError, fmt.Sprintf("Failed to look up customer: %v", err), false)
+			return false, nil
+		}
+		customerID = found
+		if fix {
+			if err := r.DB.Model(user).Update("stripe_customer_id", customerID).Error; err != nil {
+				return false, err
+			}
+		}
+		add(KindMissingCustomer, fmt.Sprintf("Provider has customer %s for this email", customerID), fix)
+	}
+
+	subscription, err := r.Provider.GetCustomerSubscription(customerID)
+	if errors.Is(err, billing.ErrSubscriptionNotFound) {
+		subscription = nil
+	} else if err != nil {
+		add(KindError, fmt.Sprintf("Failed to look up subscription: %v", err), false)
+		return true, nil
+	}
+	if err := r.reconcileSubscription(user, subscription, fix, now, add); err != nil {
+		return true, err
+	}
+
+	charges, err := r.Provider.ListCustomerCharges(customerID)
+	if err != nil {
+		add(KindError, fmt.Sprintf("Failed to list charges: %v", err), false)
+		return true, nil
+	}
+	return true, r.reconcilePayments(user, subscription, charges, fix, add)
+}
+
+// reconcileSubscription compares the user's tier and subscription with the provider's live subscription, which may be nil
+func (r *Reconciler) reconcileSubscription(user *models.User, subscription *billing.Subscription, fix bool, now time.Time, add func(kind, detail string, fixed bool)) error {
+	if subscription == nil {
+		if user.StripeSubscriptionID == "" {
+			return nil
+		}
+		detail := fmt.Sprintf("Subscription %s is no longer billing at the provider", user.StripeSubscriptionID)
+		updates := map[string]interface{}{"stripe_subscription_id": ""}
+		toTier := ""
+		if user.SubscriptionTier != models.TierFree && !user.IsLifetimeSubscriber() {
+			detail += fmt.Sprintf(" but the user is still on the %s tier", user.SubscriptionTier)
+			updates["subscription_tier"] = models.TierFree
+			updates["subscription_expires_at"] = now
+			updates["subscription_canceled"] = false
+			updates["grace_period_ends_at"] = nil
+			toTier = models.TierFree
+		}
+		if !fix {
+			add(KindSubscriptionEnded, detail, false)
+			return nil
+		}
+		if err := r.apply(user, updates, toTier, nil); err != nil {
+			return err
+		}
+		add(KindSubscriptionEnded, detail, true)
+		return nil
+	}
+
+	// Lifetime members shouldn't be billed at all, which needs the subscription canceled rather than a local fix
+	if user.IsLifetimeSubscriber() {
+		add(KindSubscriptionMismatch, fmt.Sprintf("Lifetime member is still billed by %s subscription %s", subscription.Status, subscription.ID), false)
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"subscription_tier":       subscription.Tier,
+		"subscription_expires_at": subscription.CurrentPeriodEnd,
+		"stripe_subscription_id":  subscription.ID,
+		"subscription_canceled":   subscription.CancelAtPeriodEnd,
+	}
+	expiresAt := subscription.CurrentPeriodEnd
+
+	kind := KindInactiveLocally
+	var problems []string
+	if !user.HasActiveSubscription() {
+		if user.SubscriptionTier == models.TierFree {
+			problems = append(problems, fmt.Sprintf("Subscription %s is %s at the provider but the user is on the free tier", subscription.ID, subscription.Status))
+		} else {
+			problems = append(problems, fmt.Sprintf("Subscription %s is %s at the provider but the user's %s tier expired %s", subscription.ID, subscription.Status, user.SubscriptionTier, user.SubscriptionExpiresAt.Format("2006-01-02")))
+		}
+	} else {
+		kind = KindSubscriptionMismatch
+		if user.SubscriptionTier != subscription.Tier {
+			problems = append(problems, fmt.Sprintf("tier is %s locally but %s at the provider", user.SubscriptionTier, subscription.Tier))
+		}
+		if user.StripeSubscriptionID != subscription.ID {
+			problems = append(problems, fmt.Sprintf("subscription ID is %q locally but %s at the provider", user.StripeSubscriptionID, subscription.ID))
+		}
+		// Time stacked onto a subscription at checkout can leave the local expiry later, which isn't drift
+		if user.SubscriptionExpiresAt.Before(subscription.CurrentPeriodEnd.Add(-expiryTolerance)) {
+			problems = append(problems, fmt.Sprintf("expires %s locally but the provider has paid through %s", user.SubscriptionExpiresAt.Format("2006-01-02"), subscription.CurrentPeriodEnd.Format("2006-01-02")))
+		} else {
+			delete(updates, "subscription_expires_at")
+			expiresAt = user.SubscriptionExpiresAt
+		}
+		if user.SubscriptionCanceled != subscription.CancelAtPeriodEnd {
+			problems = append(problems, fmt.Sprintf("canceled is %t locally but %t at the provider", user.SubscriptionCanceled, subscription.CancelAtPeriodEnd))
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		problems[0] = strings.ToUpper(problems[0][:1]) + problems[0][1:]
+	}
+
+	detail := strings.Join(problems, "; ")
+	if !fix {
+		add(kind, detail, false)
+		return nil
+	}
+	toTier := ""
+	if user.SubscriptionTier != subscription.Tier || kind == KindInactiveLocally {
+		toTier = subscription.Tier
+	}
+	if err := r.apply(user, updates, toTier, &expiresAt); err != nil {
+		return err
+	}
+	add(kind, detail, true)
+	return nil
+}
+
+// apply updates the user and, when toTier is set, records the tier change
+func (r *Reconciler) apply(user *models.User, updates map[string]interface{}, toTier string, expiresAt *time.Time) error {
+	fromTier := user.SubscriptionTier
+	if err := r.DB.Model(user).Updates(updates).Error; err != nil {
+		return err
+	}
+	if toTier == "" {
+		return nil
+	}
+	return models.CreateSubscriptionChange(r.DB, &models.SubscriptionChange{
+		UserID:    user.ID,
+		FromTier:  fromTier,
+		ToTier:    toTier,
+		Cause:     models.SubscriptionCauseReconcile,
+		ExpiresAt: expiresAt,
+		Note:      "Matched the payment provider",
+	})
+}
+
+// reconcilePayments matches the provider's charges with the user's payments
+func (r *Reconciler) reconcilePayments(user *models.User, subscription *billing.Subscription, charges []billing.Charge, fix bool, add func(kind, detail string, fixed bool)) error {
+	payments, err := models.GetPaymentsByUserID(r.DB, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// Match on the provider's IDs first, so a payment recorded against one charge isn't taken by another with the same amount
+	matches := make([]*models.Payment, len(charges))
+	matched := make(map[uint]bool)
+	for i, charge := range charges {
+		for _, id := range charge.StripeIDs() {
+			for j := range payments {
+				if payments[j].StripeID == id && !matched[payments[j].ID] {
+					matches[i] = &payments[j]
+					matched[payments[j].ID] = true
+					break
+				}
+			}
+			if matches[i] != nil {
+				break
+			}
+		}
+	}
+	for i, charge := range charges {
+		if matches[i] != nil {
+			continue
+		}
+		for j := range payments {
+			payment := &payments[j]
+			gap := payment.CreatedAt.Sub(charge.Created)
+			if !matched[payment.ID] && payment.Amount == charge.Amount && gap <= chargeMatchWindow && gap >= -chargeMatchWindow {
+				matches[i] = payment
+				matched[payment.ID] = true
+				break
+			}
+		}
+	}
+
+	for i, charge := range charges {
+		amount := models.Payment{Amount: charge.Amount, Currency: charge.Currency}
+		payment := matches[i]
+		if payment == nil {
+			detail := fmt.Sprintf("Charge %s for %s on %s has no payment recorded", charge.ID, amount.FormatAmount(), charge.Created.Format("2006-01-02"))
+			if fix {
+				if err := models.CreatePayment(r.DB, recoveredPayment(user, subscription, charge)); err != nil {
+					return err
+				}
+			}
+			add(KindMissingPayment, detail, fix)
+			continue
+		}
+
+		if payment.Refunded != charge.Refunded {
+			refunded := models.Payment{Amount: charge.Refunded, Currency: charge.Currency}
+			detail := fmt.Sprintf("Payment %s shows %s refunded but the provider refunded %s", payment.ReceiptNumber(), payment.FormatRefunded(), refunded.FormatAmount())
+			if fix {
+				if err := r.DB.Model(payment).Updates(map[string]interface{}{
+					"refunded": charge.Refunded,
+					"status":   paymentStatus(charge),
+				}).Error; err != nil {
+					return err
+				}
+			}
+			add(KindRefundMismatch, detail, fix)
+		}
+	}
+
+	// Free trials and comps have nothing to charge, and test checkouts never reach the provider
+	for _, payment := range payments {
+		if matched[payment.ID] || payment.Amount == 0 || payment.StripeID == "" || !payment.HasReceipt() {
+			continue
+		}
+		add(KindUnknownPayment, fmt.Sprintf("Payment %s for %s on %s has no matching charge at the provider", payment.ReceiptNumber(), payment.FormatAmount(), payment.CreatedAt.Format("2006-01-02")), false)
+	}
+	return nil
+}
+
+// recoveredPayment builds the payment record for a charge that was never recorded
+func recoveredPayment(user *models.User, subscription *billing.Subscription, charge billing.Charge) *models.Payment {
+	payment := &models.Payment{
+		UserID:      user.ID,
+		Amount:      charge.Amount,
+		Currency:    charge.Currency,
+		PaymentType: "one-time",
+		Status:      paymentStatus(charge),
+		Description: charge.Description,
+		StripeID:    charge.StripeIDs()[0],
+		Refunded:    charge.Refunded,
+	}
+	if charge.InvoiceID != "" {
+		payment.PaymentType = "subscription_renewal"
+		if subscription != nil {
+			payment.Tier = subscription.Tier
+		}
+	}
+	if payment.Description == "" {
+		payment.Description = "Payment recovered from the payment provider"
+	}
+	payment.CreatedAt = charge.Created
+	return payment
+}
+
+// paymentStatus returns the local status of a payment for the charge
+func paymentStatus(charge billing.Charge) string {
+	if charge.Refunded >= charge.Amount {
+		return "refunded"
+	}
+	return "succeeded"
+}
@@ -0,0 +1,140 @@
+package reconcile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	provider := billing.NewFakeProvider()
+	monthly := &models.Plan{Name: "Monthly", Tier: models.TierMonthly, Price: 500, Currency: "usd", Interval: models.PlanIntervalMonth}
+	checkout := func(user *models.User) string {
+		session, err := provider.CreateCheckoutSession(billing.CheckoutParams{UserID: user.ID, Email: user.Email, Plan: monthly, SuccessURL: "/success"})
+		require.NoError(t, err)
+		_, err = provider.CompleteCheckout(session.ID)
+		require.NoError(t, err)
+		return session.ID
+	}
+	user := func(email string) *models.User {
+		u, err := testutils.CreateTestUser(db, email, "password123", false)
+		require.NoError(t, err)
+		return u
+	}
+	now := time.Now()
+
+	// The checkout webhook never arrived, so the user is still free with no customer ID
+	missed := user("missed@example.com")
+	missedSession := checkout(missed)
+
+	// Everything was recorded, but a renewal payment was missed and a refund was made in the provider's dashboard
+	renewed := user("renewed@example.com")
+	renewedSession := checkout(renewed)
+	subscriptionID, err := provider.FindSubscriptionID(provider.CustomerID(renewed.ID))
+	require.NoError(t, err)
+	subscription, _ := provider.Subscription(subscriptionID)
+	require.NoError(t, db.Model(renewed).Updates(map[string]interface{}{
+		"subscription_tier":       models.TierMonthly,
+		"subscription_expires_at": subscription.CurrentPeriodEnd,
+		"stripe_customer_id":      provider.CustomerID(renewed.ID),
+		"stripe_subscription_id":  subscriptionID,
+	}).Error)
+	require.NoError(t, models.CreatePayment(db, &models.Payment{UserID: renewed.ID, Amount: 500, Currency: "usd", Status: "succeeded", Tier: models.TierMonthly, StripeID: renewedSession}))
+	provider.AddCharge(provider.CustomerID(renewed.ID), billing.Charge{InvoiceID: "in_renewal", Amount: 500, Created: now.Add(time.Hour)})
+	_, err = provider.RefundPayment(renewedSession, 200, "refund-renewal")
+	require.NoError(t, err)
+
+	// The provider ended the subscription, and a payment it never took is on record
+	ended := user("ended@example.com")
+	require.NoError(t, db.Model(ended).Updates(map[string]interface{}{
+		"subscription_tier":       models.TierMonthly,
+		"subscription_expires_at": now.AddDate(0, 0, 10),
+		"stripe_customer_id":      provider.CustomerID(ended.ID),
+		"stripe_subscription_id":  "sub_gone",
+	}).Error)
+	require.NoError(t, models.CreatePayment(db, &models.Payment{UserID: ended.ID, Amount: 500, Currency: "usd", Status: "succeeded", StripeID: "in_unknown"}))
+
+	// Never a customer
+	user("free@example.com")
+
+	kinds := func(report *Report, userID uint) []string {
+		var found []string
+		for _, drift := range report.Drifts {
+			if drift.UserID == userID {
+				found = append(found, drift.Kind)
+			}
+		}
+		return found
+	}
+
+	// A dry run reports drift without touching anything
+	report, err := NewReconciler(db, provider).Run("", false, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Checked)
+	assert.Equal(t, 0, report.Fixed())
+	assert.Equal(t, []string{KindMissingCustomer, KindInactiveLocally, KindMissingPayment}, kinds(report, missed.ID))
+	assert.Equal(t, []string{KindMissingPayment, KindRefundMismatch}, kinds(report, renewed.ID))
+	assert.Equal(t, []string{KindSubscriptionEnded, KindUnknownPayment}, kinds(report, ended.ID))
+
+	var unchanged models.User
+	require.NoError(t, db.First(&unchanged, missed.ID).Error)
+	assert.Equal(t, models.TierFree, unchanged.SubscriptionTier)
+	assert.Empty(t, unchanged.StripeCustomerID)
+
+	// Fixing brings everything but the unknown payment in line with the provider
+	report, err = NewReconciler(db, provider).Run("", true, now)
+	require.NoError(t, err)
+	assert.Equal(t, 6, report.Fixed())
+	require.Len(t, report.Drifts, 7)
+
+	var fixed models.User
+	require.NoError(t, db.First(&fixed, missed.ID).Error)
+	assert.Equal(t, models.TierMonthly, fixed.SubscriptionTier)
+	assert.Equal(t, provider.CustomerID(missed.ID), fixed.StripeCustomerID)
+	assert.NotEmpty(t, fixed.StripeSubscriptionID)
+	assert.True(t, fixed.HasActiveSubscription())
+	payment, err := models.FindPaymentByStripeID(db, missedSession)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), payment.Amount)
+	changes, err := models.FindSubscriptionChangesByUser(db, missed.ID)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, models.SubscriptionCauseReconcile, changes[0].Cause)
+	assert.True(t, changes[0].IsUpgrade())
+
+	renewal, err := models.FindPaymentByStripeID(db, "in_renewal")
+	require.NoError(t, err)
+	assert.Equal(t, models.TierMonthly, renewal.Tier)
+	assert.Equal(t, "succeeded", renewal.Status)
+	refunded, err := models.FindPaymentByStripeID(db, renewedSession)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), refunded.Refunded)
+
+	var lapsed models.User
+	require.NoError(t, db.First(&lapsed, ended.ID).Error)
+	assert.Equal(t, models.TierFree, lapsed.SubscriptionTier)
+	assert.Empty(t, lapsed.StripeSubscriptionID)
+
+	// Only the drift that can't be fixed locally remains
+	report, err = NewReconciler(db, provider).Run("", false, now)
+	require.NoError(t, err)
+	require.Len(t, report.Drifts, 1)
+	assert.Equal(t, KindUnknownPayment, report.Drifts[0].Kind)
+
+	// A run can be limited to one user
+	report, err = NewReconciler(db, provider).Run("renewed@example.com", false, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Empty(t, report.Drifts)
+	_, err = NewReconciler(db, provider).Run("nobody@example.com", false, now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,340 @@
+package twofactor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// Issuer names the application in authenticator apps
+const Issuer = "The Virtual Armory"
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time
+const RecoveryCodeCount = 10
+
+// LoginTimeout is how long a user has to enter their code after their password
+const LoginTimeout = 5 * time.Minute
+
+// MaxLoginAttempts is how many wrong codes end a login waiting on a second factor
+const MaxLoginAttempts = 5
+
+// period is how many seconds each TOTP code is valid for
+const period = 30
+
+// Errors returned when a second factor can't be set up or checked
+var (
+	ErrInvalidCode     = errors.New("invalid two-factor code")
+	ErrNotEnrolled     = errors.New("two-factor authentication is not set up")
+	ErrLoginExpired    = errors.New("login expired, please log in again")
+	ErrTooManyAttempts = errors.New("too many wrong codes, please log in again")
+)
+
+// Service sets up and checks users' authenticator app codes and recovery codes
+type Service struct {
+	DB *gorm.DB
+}
+
+// NewService creates a new Service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		DB: db,
+	}
+}
+
+// BeginEnrollment gives the user a new secret for their authenticator app.
+// Two-factor authentication isn't turned on until Enable confirms a code from the app.
+func (s *Service) BeginEnrollment(user *models.User) error {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: Issuer, AccountName: user.Email})
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = key.Secret()
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return s.DB.Model(user).Updates(map[string]interface{}{
+		"totp_secret":    user.TOTPSecret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+}
+
+// Enable turns on two-factor authentication once the user proves their app has the secret,
+// and returns their first recovery codes
+func (s *Service) Enable(user *models.User, code string, now time.Time) ([]string, error) {
+	if user.TOTPSecret == "" {
+		return nil, ErrNotEnrolled
+	}
+	if err := s.useTOTP(user, code, now); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(user).Update("totp_enabled", true).Error; err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	return s.RegenerateRecoveryCodes(user)
+}
+
+// Disable turns off two-factor authentication and removes the user's secret and recovery codes
+func (s *Service) Disable(user *models.User) error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":             "",
+			"totp_enabled":            false,
+			"totp_last_step":          0,
+			"two_factor_token":        "",
+			"two_factor_token_expiry": time.Time{},
+			"two_factor_attempts":     0,
+		}).Error; err != nil {
+			return err
+		}
+		return models.DeleteRecoveryCodes(tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.TwoFactorToken = ""
+	user.TwoFactorTokenExpiry = time.Time{}
+	user.TwoFactorAttempts = 0
+	return nil
+}
+
+// Verify checks a code from the user's authenticator app, or one of their recovery codes,
+// and reports whether a recovery code was used
+func (s *Service) Verify(user *models.User, code string, now time.Time) (bool, error) {
+	if !user.TOTPEnabled || user.TOTPSecret == "" {
+		return false, ErrNotEnrolled
+	}
+
+	err := s.useTOTP(user, code, now)
+	if !errors.Is(err, ErrInvalidCode) {
+		return false, err
+	}
+
+	used, err := models.UseRecoveryCode(s.DB, user.ID, HashRecoveryCode(code), now)
+	if err != nil {
+		return false, err
+	}
+	if !used {
+		return false, ErrInvalidCode
+	}
+	return true, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, returning the new codes.
+// Only their hashes are stored, so this is the only time they can be shown.
+func (s *Service) RegenerateRecoveryCodes(user *models.User) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = HashRecoveryCode(code)
+	}
+	if err := models.ReplaceRecoveryCodes(s.DB, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RemainingRecoveryCodes returns how many unused recovery codes the user has
+func (s *Service) RemainingRecoveryCodes(user *models.User) (int64, error) {
+	return models.CountUnusedRecoveryCodes(s.DB, user.ID)
+}
+
+// StartLogin records that the user has entered their password and returns the token
+// that identifies the login until they enter their code
+func (s *Service) StartLogin(user *models.User, now time.Time) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	user.TwoFactorToken = hashToken(token)
+	user.TwoFactorTokenExpiry = now.Add(LoginTimeout)
+	user.TwoFactorAttempts = 0
+	if err := s.DB.Model(user).Updates(map[string]interface{}{
+		"two_factor_token":        user.TwoFactorToken,
+		"two_factor_token_expiry": user.TwoFactorTokenExpiry,
+		"two_factor_attempts":     0,
+	}).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PendingLogin finds the user whose login is waiting on a second factor, or returns ErrLoginExpired
+func (s *Service) PendingLogin(token string, now time.Time) (*models.User, error) {
+	if token == "" {
+		return nil, ErrLoginExpired
+	}
+	var user models.User
+	err := s.DB.Where("two_factor_token = ?", hashToken(token)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLoginExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !now.Before(user.TwoFactorTokenExpiry) || user.TwoFactorAttempts >= MaxLoginAttempts {
+		return nil, ErrLoginExpired
+	}
+	return &user, nil
+}
+
+// FinishLogin checks the code for a login waiting on a second factor. A right code ends the wait,
+// and too many wrong ones cancel the login. Each try is counted before the code is checked,
+// so guesses sent at the same time can't get past the limit.
+func (s *Service) FinishLogin(user *models.User, code string, now time.Time) (bool, error) {
+	result := s.DB.Model(&models.User{}).
+		Where("id = ? AND two_factor_token = ? AND two_factor_token <> '' AND two_factor_attempts < ?",
+			user.ID, user.TwoFactorToken, MaxLoginAttempts).
+		Update("two_factor_attempts", gorm.Expr("two_factor_attempts + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, ErrTooManyAttempts
+	}
+
+	usedRecoveryCode, err := s.Verify(user, code, now)
+	if errors.Is(err, ErrInvalidCode) {
+		// Cancel the login once the last try has been used
+		result := s.DB.Model(&models.User{}).
+			Where("id = ? AND two_factor_attempts >= ?", user.ID, MaxLoginAttempts).
+			Update("two_factor_token", "")
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected > 0 {
+			user.TwoFactorToken = ""
+			return false, ErrTooManyAttempts
+		}
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	return usedRecoveryCode, s.CancelLogin(user)
+}
+
+// CancelLogin ends a login waiting on a second factor
+func (s *Service) CancelLogin(user *models.User) error {
+	user.TwoFactorToken = ""
+	user.TwoFactorAttempts = 0
+	return s.DB.Model(user).Updates(map[string]interface{}{
+		"two_factor_token":    "",
+		"two_factor_attempts": 0,
+	}).Error
+}
+
+// QRCode returns the user's secret as a QR code for their authenticator app to scan, as a PNG data URL
+func (s *Service) QRCode(user *models.User) (string, error) {
+	key, err := s.key(user)
+	if err != nil {
+		return "", err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// key rebuilds the authenticator app key from the user's stored secret
+func (s *Service) key(user *models.User) (*otp.Key, error) {
+	if user.TOTPSecret == "" {
+		return nil, ErrNotEnrolled
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(user.TOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	return totp.Generate(totp.GenerateOpts{Issuer: Issuer, AccountName: user.Email, Secret: secret})
+}
+
+// useTOTP accepts a code from the user's app for the current time step or the ones either side of it,
+// allowing for clock drift. Each step is only accepted once, even by concurrent requests.
+func (s *Service) useTOTP(user *models.User, code string, now time.Time) error {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != otp.DigitsSix.Length() {
+		return ErrInvalidCode
+	}
+
+	current := now.Unix() / period
+	for step := current - 1; step <= current+1; step++ {
+		if step <= user.TOTPLastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(user.TOTPSecret, time.Unix(step*period, 0), totp.ValidateOpts{
+			Period:    period,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) != 1 {
+			continue
+		}
+
+		result := s.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+	return ErrInvalidCode
+}
+
+// HashRecoveryCode returns the hash a recovery code is stored under, ignoring case, spaces and dashes
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// newRecoveryCode generates a random recovery code like "abcde-fghij"
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashToken returns the SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
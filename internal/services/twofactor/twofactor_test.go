@@ -0,0 +1,179 @@
+package twofactor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/the-virtual-armory/internal/models"
+	"github.com/hail2skins/the-virtual-armory/internal/testutils"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	service := NewService(db)
+	user, err := testutils.CreateTestUser(db, "twofactor@example.com", "password123", false)
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	code := func(at time.Time) string {
+		c, err := totp.GenerateCode(user.TOTPSecret, at)
+		require.NoError(t, err)
+		return c
+	}
+
+	// Nothing can be verified before enrollment
+	_, err = service.Verify(user, "123456", now)
+	assert.ErrorIs(t, err, ErrNotEnrolled)
+
+	// Enrollment gives the user a secret and a QR code, but doesn't turn two-factor on
+	require.NoError(t, service.BeginEnrollment(user))
+	require.NotEmpty(t, user.TOTPSecret)
+	qr, err := service.QRCode(user)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(qr, "data:image/png;base64,"))
+	_, err = service.Verify(user, code(now), now)
+	assert.ErrorIs(t, err, ErrNotEnrolled)
+
+	// A wrong code doesn't turn it on, a right one does and returns recovery codes
+	_, err = service.Enable(user, "000000", now)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	codes, err := service.Enable(user, code(now), now)
+	require.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	var stored models.User
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	assert.True(t, stored.TOTPEnabled)
+
+	// Recovery codes are stored hashed
+	var recovery models.RecoveryCode
+	require.NoError(t, db.Where("user_id = ?", user.ID).First(&recovery).Error)
+	for _, c := range codes {
+		assert.NotEqual(t, c, recovery.CodeHash)
+	}
+
+	// A code can't be used twice, but the next one works and so does one from a clock a step behind
+	_, err = service.Verify(user, code(now), now)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	now = now.Add(30 * time.Second)
+	usedRecoveryCode, err := service.Verify(user, code(now), now)
+	require.NoError(t, err)
+	assert.False(t, usedRecoveryCode)
+	now = now.Add(60 * time.Second)
+	_, err = service.Verify(user, code(now.Add(-30*time.Second)), now)
+	assert.NoError(t, err)
+
+	// Codes from too far away are rejected
+	_, err = service.Verify(user, code(now.Add(5*time.Minute)), now)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+
+	// A recovery code works once, however it's typed
+	usedRecoveryCode, err = service.Verify(user, strings.ToUpper(strings.ReplaceAll(codes[0], "-", " ")), now)
+	require.NoError(t, err)
+	assert.True(t, usedRecoveryCode)
+	_, err = service.Verify(user, codes[0], now)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	remaining, err := service.RemainingRecoveryCodes(user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(RecoveryCodeCount-1), remaining)
+
+	// Regenerating replaces the old codes
+	newCodes, err := service.RegenerateRecoveryCodes(user)
+	require.NoError(t, err)
+	_, err = service.Verify(user, codes[1], now)
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	_, err = service.Verify(user, newCodes[1], now)
+	assert.NoError(t, err)
+
+	// Disabling removes the secret and the recovery codes
+	require.NoError(t, service.Disable(user))
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	assert.False(t, stored.TOTPEnabled)
+	assert.Empty(t, stored.TOTPSecret)
+	remaining, err = service.RemainingRecoveryCodes(user)
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	service := NewService(db)
+	user, err := testutils.CreateTestUser(db, "login@example.com", "password123", false)
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, service.BeginEnrollment(user))
+	code, err := totp.GenerateCode(user.TOTPSecret, now)
+	require.NoError(t, err)
+	_, err = service.Enable(user, code, now)
+	require.NoError(t, err)
+
+	// The login token finds the user until it expires
+	token, err := service.StartLogin(user, now)
+	require.NoError(t, err)
+	pending, err := service.PendingLogin(token, now)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, pending.ID)
+	_, err = service.PendingLogin(token, now.Add(LoginTimeout))
+	assert.ErrorIs(t, err, ErrLoginExpired)
+	_, err = service.PendingLogin("wrong", now)
+	assert.ErrorIs(t, err, ErrLoginExpired)
+
+	// A right code finishes the login and the token stops working
+	now = now.Add(time.Minute)
+	code, err = totp.GenerateCode(user.TOTPSecret, now)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(pending, code, now)
+	require.NoError(t, err)
+	_, err = service.PendingLogin(token, now)
+	assert.ErrorIs(t, err, ErrLoginExpired)
+
+	// Too many wrong codes cancel the login
+	token, err = service.StartLogin(user, now)
+	require.NoError(t, err)
+	for i := 1; i < MaxLoginAttempts; i++ {
+		pending, err = service.PendingLogin(token, now)
+		require.NoError(t, err)
+		_, err = service.FinishLogin(pending, "000000", now)
+		assert.ErrorIs(t, err, ErrInvalidCode)
+	}
+	pending, err = service.PendingLogin(token, now)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(pending, "000000", now)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	_, err = service.PendingLogin(token, now)
+	assert.ErrorIs(t, err, ErrLoginExpired)
+
+	// Guesses sent at the same time, each looking up the login before the others count, still use up tries
+	token, err = service.StartLogin(user, now)
+	require.NoError(t, err)
+	pending, err = service.PendingLogin(token, now)
+	require.NoError(t, err)
+	for i := 0; i < MaxLoginAttempts; i++ {
+		guess := *pending
+		_, err = service.FinishLogin(&guess, "000000", now)
+		assert.Error(t, err)
+	}
+	guess := *pending
+	_, err = service.FinishLogin(&guess, code, now)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestAdminTwoFactorRequired(t *testing.T) {
+	db, err := testutils.SetupTestDB()
+	require.NoError(t, err)
+	defer testutils.CleanupTestDB(db)
+
+	assert.False(t, models.AdminTwoFactorRequired(db))
+	require.NoError(t, models.SetSetting(db, models.SettingRequireAdminTwoFactor, "true"))
+	assert.True(t, models.AdminTwoFactorRequired(db))
+	require.NoError(t, models.SetSetting(db, models.SettingRequireAdminTwoFactor, "false"))
+	assert.False(t, models.AdminTwoFactorRequired(db))
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	key := "users/1/guns/2/file.pdf"
+
+	// Put and Get round trip
+	require.NoError(t, store.Put(ctx, key, strings.NewReader("hello"), 5, "application/pdf"))
+
+	reader, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// Delete removes the object and is safe to repeat
+	require.NoError(t, store.Delete(ctx, key))
+	require.NoError(t, store.Delete(ctx, key))
+
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLocalStorageRejectsTraversal(t *testing.T) {
+	store, err := NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, key := range []string{"", "../outside", "users/../../outside"} {
+		err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain")
+		assert.Error(t, err, "key %q should be rejected", key)
+	}
+}
+
+func TestNewLocalStorageRequiresPath(t *testing.T) {
+	_, err := NewLocalStorage("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hail2skins/the-virtual-armory/internal/config"
+)
+
+// ErrNotFound is returned when an object does not exist in storage
+var ErrNotFound = errors.New("object not found")
+
+// Storage is an interface for storing uploaded files
+type Storage interface {
+	// Put stores the contents of r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the object stored under key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under key, ignoring objects that don't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the storage backend selected in the configuration
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageBackend {
+	case "", "local":
+		store, err := NewLocalStorage(cfg.StorageLocalPath)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "s3":
+		store, err := NewS3Storage(S3Config{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			Bucket:          cfg.S3Bucket,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
+	}
+}
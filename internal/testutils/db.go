@@ -39,6 +39,24 @@ func SetupTestDB() (*gorm.DB, error) {
 		&models.WeaponType{},
 		&models.Gun{},
 		&models.Payment{},
+		&models.Ammo{},
+		&models.RangeSession{},
+		&models.RangeSessionItem{},
+		&models.MaintenanceRecord{},
+		&models.Attachment{},
+		&models.Valuation{},
+		&models.DataExport{},
+		&models.Plan{},
+		&models.WebhookEvent{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.BillingAdjustment{},
+		&models.SubscriptionChange{},
+		&models.LoginSession{},
+		&models.RecoveryCode{},
+		&models.Setting{},
+		&models.PasskeyCredential{},
+		&models.PasskeyChallenge{},
 	)
 	if err != nil {
 		log.Printf("Failed to migrate test database: %v", err)
@@ -58,6 +76,24 @@ func CleanupTestDB(db *gorm.DB) {
 	db.Exec("DELETE FROM weapon_types")
 	db.Exec("DELETE FROM guns")
 	db.Exec("DELETE FROM payments")
+	db.Exec("DELETE FROM ammo")
+	db.Exec("DELETE FROM range_sessions")
+	db.Exec("DELETE FROM range_session_items")
+	db.Exec("DELETE FROM maintenance_records")
+	db.Exec("DELETE FROM attachments")
+	db.Exec("DELETE FROM valuations")
+	db.Exec("DELETE FROM data_exports")
+	db.Exec("DELETE FROM plans")
+	db.Exec("DELETE FROM webhook_events")
+	db.Exec("DELETE FROM coupons")
+	db.Exec("DELETE FROM coupon_redemptions")
+	db.Exec("DELETE FROM billing_adjustments")
+	db.Exec("DELETE FROM subscription_changes")
+	db.Exec("DELETE FROM login_sessions")
+	db.Exec("DELETE FROM recovery_codes")
+	db.Exec("DELETE FROM settings")
+	db.Exec("DELETE FROM passkey_credentials")
+	db.Exec("DELETE FROM passkey_challenges")
 }
 
 // CreateTestUser creates a test user in the database
@@ -0,0 +1,154 @@
+package testutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// SoftwareAuthenticator is a passkey authenticator that lives in memory, so tests can do what a browser
+// and a security key or phone would: create a passkey and log in with it. It always reports that the user
+// was present and verified.
+type SoftwareAuthenticator struct {
+	Origin       string // Origin the browser reports, such as "http://localhost:8080"
+	CredentialID []byte
+	UserHandle   []byte // Set when the passkey is created
+	SignCount    uint32 // Goes up with each login
+	key          *ecdsa.PrivateKey
+}
+
+// NewSoftwareAuthenticator creates an authenticator with a new key for the site at origin
+func NewSoftwareAuthenticator(origin string) (*SoftwareAuthenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &SoftwareAuthenticator{
+		Origin:       origin,
+		CredentialID: credentialID,
+		key:          key,
+	}, nil
+}
+
+// Register answers the challenge from the options for creating a passkey, returning the JSON
+// the browser would send back
+func (a *SoftwareAuthenticator) Register(challenge string, userHandle []byte) ([]byte, error) {
+	a.UserHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Attested credential data: an all-zero AAGUID, then the credential ID and public key
+	authData, err := a.authenticatorData(0x01 | 0x04 | 0x40)
+	if err != nil {
+		return nil, err
+	}
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(struct {
+		Format   string                 `cbor:"fmt"`
+		AttStmt  map[string]interface{} `cbor:"attStmt"`
+		AuthData []byte                 `cbor:"authData"`
+	}{"none", map[string]interface{}{}, authData})
+	if err != nil {
+		return nil, err
+	}
+
+	clientData, err := a.clientData("webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":    encode(a.CredentialID),
+		"rawId": encode(a.CredentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    encode(clientData),
+			"attestationObject": encode(attestationObject),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Login answers the challenge from the options for logging in, returning the JSON the browser would send back
+func (a *SoftwareAuthenticator) Login(challenge string) ([]byte, error) {
+	a.SignCount++
+	authData, err := a.authenticatorData(0x01 | 0x04)
+	if err != nil {
+		return nil, err
+	}
+	clientData, err := a.clientData("webauthn.get", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":    encode(a.CredentialID),
+		"rawId": encode(a.CredentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(a.UserHandle),
+		},
+	})
+}
+
+// authenticatorData starts the authenticator data with the hash of the site's domain, the flags and the counter
+func (a *SoftwareAuthenticator) authenticatorData(flags byte) ([]byte, error) {
+	u, err := url.Parse(a.Origin)
+	if err != nil {
+		return nil, err
+	}
+	rpIDHash := sha256.Sum256([]byte(u.Hostname()))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.SignCount), nil
+}
+
+// clientData returns the JSON the browser signs over, naming the ceremony, challenge and origin
+func (a *SoftwareAuthenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.Origin,
+	})
+}
+
+// encode base64url-encodes data the way browsers send it
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -5,11 +5,17 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/hail2skins/the-virtual-armory/internal/auth"
 	"github.com/hail2skins/the-virtual-armory/internal/config"
 	"github.com/hail2skins/the-virtual-armory/internal/database"
+	"github.com/hail2skins/the-virtual-armory/internal/entitlements"
+	"github.com/hail2skins/the-virtual-armory/internal/jobs"
 	"github.com/hail2skins/the-virtual-armory/internal/server"
+	"github.com/hail2skins/the-virtual-armory/internal/services/billing"
+	"github.com/hail2skins/the-virtual-armory/internal/services/email"
+	"github.com/hail2skins/the-virtual-armory/internal/storage"
 )
 
 func main() {
@@ -22,6 +28,11 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Apply the plan limits configured in the pricing catalog
+	if err := entitlements.Load(db); err != nil {
+		log.Printf("Failed to load plan limits, using defaults: %v", err)
+	}
+
 	// Initialize Authboss
 	authInstance, err := auth.New()
 	if err != nil {
@@ -40,10 +51,37 @@ func main() {
 
 	log.Printf("Server started on port %d", cfg.Port)
 
+	// Start background jobs
+	emailService := email.NewMailJetService(cfg)
+	scheduler := jobs.NewScheduler()
+	scheduler.Every("maintenance-reminders", time.Hour, jobs.NewMaintenanceReminderJob(db, emailService).Run)
+
+	// Failed renewals get reminder emails and are downgraded when the grace period ends
+	paymentProvider, err := billing.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up payment provider: %v", err)
+	}
+	scheduler.Every("dunning", time.Hour, jobs.NewDunningJob(db, paymentProvider, emailService).Run)
+
+	// Canceled and comped subscriptions move to the free tier when they run out
+	scheduler.Every("subscription-expiry", time.Hour, jobs.NewSubscriptionExpiryJob(db).Run)
+
+	// Expired login sessions are cleared out of the session store
+	scheduler.Every("session-cleanup", time.Hour, jobs.NewSessionCleanupJob(authInstance.Sessions).Run)
+
+	// Pick up plan changes made on other instances
+	scheduler.Every("plan-limits", 5*time.Minute, func(time.Time) error { return entitlements.Load(db) })
+
+	// Large data exports are generated in the background and kept in file storage
+	if store, err := storage.New(cfg); err == nil {
+		scheduler.Every("data-exports", time.Minute, jobs.NewDataExportJob(db, store, emailService).Run)
+	}
+
 	// Wait for interrupt signal to gracefully shut down the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	log.Println("Shutting down server...")
+	scheduler.Stop()
 }